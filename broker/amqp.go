@@ -0,0 +1,213 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+)
+
+// An AMQPMessage is a message that is exchanged with an AMQP 1.0 endpoint. The
+// MQTT topic is carried in the subject of the message.
+type AMQPMessage struct {
+	Subject string
+	Data    []byte
+}
+
+// An AMQPSender sends messages to an AMQP 1.0 address. It is usually
+// implemented by a small adapter around a sender link of an AMQP library.
+type AMQPSender interface {
+	// Send should transfer the message. If settled is true the message should
+	// be sent pre-settled and Send may return once the message has been
+	// written. Otherwise, Send should block until the peer has settled the
+	// delivery and return an error if it has been rejected or released.
+	Send(ctx context.Context, msg *AMQPMessage, settled bool) error
+}
+
+// An AMQPReceiver receives messages from an AMQP 1.0 address. It is usually
+// implemented by a small adapter around a receiver link of an AMQP library
+// that uses the second receiver settle mode.
+type AMQPReceiver interface {
+	// Receive should block until the next message is available.
+	Receive(ctx context.Context) (*AMQPMessage, error)
+
+	// Accept should settle the delivery of the message as accepted.
+	Accept(ctx context.Context, msg *AMQPMessage) error
+
+	// Reject should settle the delivery of the message as rejected.
+	Reject(ctx context.Context, msg *AMQPMessage, err error) error
+}
+
+// An AMQPBridge is a Backend that forwards messages between the broker and
+// AMQP 1.0 endpoints like Azure Service Bus or ActiveMQ. It wraps another
+// backend that handles all other operations.
+//
+// Messages published on the broker that match a route are sent to the routes
+// sender before they are published to the wrapped backend. QOS 0 messages are
+// sent pre-settled while QOS 1 and 2 messages are sent unsettled and the
+// publish fails without publishing the message to the wrapped backend if the
+// delivery is not accepted. The client will then redeliver the message as it
+// has not been acknowledged. As AMQP 1.0 does not provide exactly once
+// delivery, QOS 2 messages are delivered at least once.
+//
+// Messages received from a source are published on the broker with the
+// sources topic (or the subject of the message if empty) and QOS. QOS 0
+// messages are accepted before they are published while QOS 1 and 2 messages
+// are only accepted once they have been published successfully and rejected
+// otherwise.
+type AMQPBridge struct {
+	Backend
+
+	// The ErrorCallback is called with errors that occur while sending or
+	// receiving messages.
+	ErrorCallback func(error)
+
+	// The maximum time a message may take to be settled. A zero value disables
+	// the timeout.
+	Timeout time.Duration
+
+	routes *topic.Tree
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  sync.WaitGroup
+}
+
+// NewAMQPBridge returns a new AMQPBridge that wraps the specified backend.
+func NewAMQPBridge(backend Backend) *AMQPBridge {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &AMQPBridge{
+		Backend: backend,
+		Timeout: 10 * time.Second,
+		routes:  topic.NewTree(),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Route will send all messages that match the specified topic filter to the
+// sender.
+func (b *AMQPBridge) Route(filter string, sender AMQPSender) {
+	b.routes.Add(filter, sender)
+}
+
+// Source will publish all messages received from the receiver on the broker
+// until the bridge is closed. If topic is empty, the subject of the message is
+// used as the topic.
+func (b *AMQPBridge) Source(receiver AMQPReceiver, topic string, qos uint8) {
+	b.group.Add(1)
+
+	go func() {
+		defer b.group.Done()
+
+		for {
+			// receive message
+			msg, err := receiver.Receive(b.ctx)
+			if b.ctx.Err() != nil {
+				return
+			} else if err != nil {
+				b.error(err)
+				return
+			}
+
+			// handle message
+			err = b.receive(receiver, msg, topic, qos)
+			if err != nil {
+				b.error(err)
+			}
+		}
+	}()
+}
+
+// Publish will send the message to the matching routes and publish it to the
+// wrapped backend.
+func (b *AMQPBridge) Publish(client *Client, msg *packet.Message) error {
+	// prepare message
+	amqpMsg := &AMQPMessage{
+		Subject: msg.Topic,
+		Data:    msg.Payload,
+	}
+
+	// send to matching routes
+	for _, value := range b.routes.Match(msg.Topic) {
+		err := b.send(value.(AMQPSender), amqpMsg, msg.QOS == 0)
+		if err != nil && msg.QOS > 0 {
+			return err
+		} else if err != nil {
+			b.error(err)
+		}
+	}
+
+	// publish message
+	return b.Backend.Publish(client, msg)
+}
+
+// Close will stop all sources and wait until they returned.
+func (b *AMQPBridge) Close() {
+	b.cancel()
+	b.group.Wait()
+}
+
+func (b *AMQPBridge) send(sender AMQPSender, msg *AMQPMessage, settled bool) error {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	return sender.Send(ctx, msg, settled)
+}
+
+func (b *AMQPBridge) receive(receiver AMQPReceiver, amqpMsg *AMQPMessage, topic string, qos uint8) error {
+	ctx, cancel := b.context()
+	defer cancel()
+
+	// prepare message
+	msg := &packet.Message{
+		Topic:   topic,
+		Payload: amqpMsg.Data,
+		QOS:     qos,
+	}
+
+	// use subject if no topic is configured
+	if msg.Topic == "" {
+		msg.Topic = amqpMsg.Subject
+	}
+
+	// accept unreliable messages upfront
+	if qos == 0 {
+		err := receiver.Accept(ctx, amqpMsg)
+		if err != nil {
+			return err
+		}
+
+		return b.Backend.Publish(nil, msg)
+	}
+
+	// publish message
+	err := b.Backend.Publish(nil, msg)
+	if err != nil {
+		rejectErr := receiver.Reject(ctx, amqpMsg, err)
+		if rejectErr != nil {
+			return rejectErr
+		}
+
+		return err
+	}
+
+	return receiver.Accept(ctx, amqpMsg)
+}
+
+func (b *AMQPBridge) context() (context.Context, context.CancelFunc) {
+	if b.Timeout > 0 {
+		return context.WithTimeout(b.ctx, b.Timeout)
+	}
+
+	return context.WithCancel(b.ctx)
+}
+
+func (b *AMQPBridge) error(err error) {
+	if b.ErrorCallback != nil {
+		b.ErrorCallback(err)
+	}
+}
@@ -0,0 +1,153 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+type testAMQPSender struct {
+	err     error
+	sent    []*AMQPMessage
+	settled []bool
+	mutex   sync.Mutex
+}
+
+func (s *testAMQPSender) Send(ctx context.Context, msg *AMQPMessage, settled bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sent = append(s.sent, msg)
+	s.settled = append(s.settled, settled)
+
+	return s.err
+}
+
+type testAMQPReceiver struct {
+	messages chan *AMQPMessage
+	settled  chan bool
+}
+
+func (r *testAMQPReceiver) Receive(ctx context.Context) (*AMQPMessage, error) {
+	select {
+	case msg := <-r.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *testAMQPReceiver) Accept(ctx context.Context, msg *AMQPMessage) error {
+	r.settled <- true
+	return nil
+}
+
+func (r *testAMQPReceiver) Reject(ctx context.Context, msg *AMQPMessage, err error) error {
+	r.settled <- false
+	return nil
+}
+
+type testPublishBackend struct {
+	Backend
+	published []string
+}
+
+func (b *testPublishBackend) Publish(client *Client, msg *packet.Message) error {
+	b.published = append(b.published, msg.Topic)
+	return b.Backend.Publish(client, msg)
+}
+
+func TestAMQPBridgeRoute(t *testing.T) {
+	sender := &testAMQPSender{}
+	failing := &testAMQPSender{err: errors.New("rejected")}
+
+	var errs []error
+
+	backend := &testPublishBackend{Backend: NewMemoryBackend()}
+
+	bridge := NewAMQPBridge(backend)
+	bridge.ErrorCallback = func(err error) {
+		errs = append(errs, err)
+	}
+	bridge.Route("amqp/+", sender)
+	bridge.Route("fail", failing)
+
+	msg := &packet.Message{Topic: "amqp/foo", Payload: []byte("foo"), QOS: 1}
+	assert.NoError(t, bridge.Publish(nil, msg))
+
+	msg = &packet.Message{Topic: "amqp/bar", Payload: []byte("bar")}
+	assert.NoError(t, bridge.Publish(nil, msg))
+
+	msg = &packet.Message{Topic: "other", Payload: []byte("other"), QOS: 1}
+	assert.NoError(t, bridge.Publish(nil, msg))
+
+	assert.Equal(t, []*AMQPMessage{
+		{Subject: "amqp/foo", Data: []byte("foo")},
+		{Subject: "amqp/bar", Data: []byte("bar")},
+	}, sender.sent)
+	assert.Equal(t, []bool{false, true}, sender.settled)
+
+	msg = &packet.Message{Topic: "fail", Payload: []byte("fail"), QOS: 2}
+	assert.Equal(t, failing.err, bridge.Publish(nil, msg))
+	assert.Empty(t, errs)
+
+	msg = &packet.Message{Topic: "fail", Payload: []byte("fail")}
+	assert.NoError(t, bridge.Publish(nil, msg))
+	assert.Equal(t, []error{failing.err}, errs)
+
+	// rejected messages are not published locally
+	assert.Equal(t, []string{"amqp/foo", "amqp/bar", "other", "fail"}, backend.published)
+
+	bridge.Close()
+}
+
+func TestAMQPBridgeSource(t *testing.T) {
+	receiver := &testAMQPReceiver{
+		messages: make(chan *AMQPMessage),
+		settled:  make(chan bool, 1),
+	}
+
+	bridge := NewAMQPBridge(NewMemoryBackend())
+	bridge.Source(receiver, "", 1)
+
+	engine := NewEngineWithBackend(bridge)
+	port, quit, done := Run(engine, "tcp")
+
+	wait := make(chan struct{})
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "amqp/in", msg.Topic)
+		assert.Equal(t, []byte("test"), msg.Payload)
+		assert.Equal(t, uint8(1), msg.QOS)
+		close(wait)
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("amqp/in", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	receiver.messages <- &AMQPMessage{Subject: "amqp/in", Data: []byte("test")}
+	assert.True(t, <-receiver.settled)
+
+	safeReceive(wait)
+
+	assert.NoError(t, c.Disconnect())
+
+	close(quit)
+	safeReceive(done)
+
+	bridge.Close()
+}
@@ -0,0 +1,142 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// An AuditEventType describes the kind of an AuditEvent.
+type AuditEventType string
+
+// All available AuditEventTypes.
+const (
+	// AuditConnect is emitted when a client has been authenticated.
+	AuditConnect AuditEventType = "connect"
+
+	// AuditDisconnect is emitted when an authenticated client goes offline.
+	AuditDisconnect AuditEventType = "disconnect"
+
+	// AuditAuthFailure is emitted when the backend rejects the credentials of
+	// a client.
+	AuditAuthFailure AuditEventType = "auth_failure"
+
+	// AuditDenied is emitted when a will, publish or subscription is outside of
+	// the namespace of a client.
+	AuditDenied AuditEventType = "denied"
+)
+
+// An AuditEvent describes a security relevant event.
+type AuditEvent struct {
+	Time       time.Time      `json:"time"`
+	Type       AuditEventType `json:"type"`
+	ClientID   string         `json:"client_id,omitempty"`
+	Username   string         `json:"username,omitempty"`
+	RemoteAddr string         `json:"remote_addr,omitempty"`
+	Topic      string         `json:"topic,omitempty"`
+}
+
+// An AuditSink receives audit events from the engine.
+//
+// Note: Audit is called synchronously from the client goroutines and should
+// therefore not block.
+type AuditSink interface {
+	Audit(event *AuditEvent)
+}
+
+// A WriterAuditSink writes audit events as JSON lines to a writer. It can be
+// used with files as well as syslog writers.
+type WriterAuditSink struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+// NewWriterAuditSink returns a new WriterAuditSink that writes to the
+// specified writer.
+func NewWriterAuditSink(writer io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{
+		writer: writer,
+	}
+}
+
+// Audit writes the event to the writer. Write errors are ignored.
+func (s *WriterAuditSink) Audit(event *AuditEvent) {
+	// encode event
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	// acquire mutex
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// write line
+	_, _ = s.writer.Write(append(buf, '\n'))
+}
+
+// A WebhookAuditSink posts audit events as JSON to a URL. The events are
+// queued and posted in the background. Events are dropped if the queue is
+// full.
+type WebhookAuditSink struct {
+	url    string
+	client *http.Client
+	queue  chan *AuditEvent
+	done   chan struct{}
+}
+
+// NewWebhookAuditSink returns a new WebhookAuditSink that posts to the
+// specified URL and queues up to queueSize events.
+func NewWebhookAuditSink(url string, queueSize int) *WebhookAuditSink {
+	s := &WebhookAuditSink{
+		url: url,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		queue: make(chan *AuditEvent, queueSize),
+		done:  make(chan struct{}),
+	}
+
+	// run poster
+	go s.poster()
+
+	return s
+}
+
+// Audit queues the event.
+func (s *WebhookAuditSink) Audit(event *AuditEvent) {
+	select {
+	case s.queue <- event:
+	default:
+	}
+}
+
+// Close will post the remaining queued events and stop the sink. Audit must
+// not be called after Close.
+func (s *WebhookAuditSink) Close() {
+	close(s.queue)
+	<-s.done
+}
+
+func (s *WebhookAuditSink) poster() {
+	defer close(s.done)
+
+	for event := range s.queue {
+		// encode event
+		buf, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+
+		// post event
+		res, err := s.client.Post(s.url, "application/json", bytes.NewReader(buf))
+		if err != nil {
+			continue
+		}
+
+		res.Body.Close()
+	}
+}
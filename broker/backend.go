@@ -1,7 +1,10 @@
 package broker
 
 import (
+	"crypto/cipher"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/256dpi/gomqtt/session"
@@ -60,6 +63,19 @@ type Session interface {
 	Reset() error
 }
 
+// ErrSessionActive is returned by ExportSession if a client is connected
+// with the requested client id.
+var ErrSessionActive = errors.New("session active")
+
+// A SessionState holds the transferable state of a stored session.
+type SessionState struct {
+	// The stored subscriptions of the session.
+	Subscriptions []*packet.Subscription
+
+	// The messages queued while the client was offline.
+	Messages []*packet.Message
+}
+
 // A Backend provides the effective brokering functionality to its clients.
 type Backend interface {
 	// Authenticate should authenticate the client using the user and password
@@ -103,7 +119,13 @@ type Backend interface {
 
 	// Publish should forward the passed message to all other clients that hold
 	// a subscription that matches the messages topic. It should also add the
-	// message to all sessions that have a matching offline subscription.
+	// message to all sessions that have a matching offline subscription. The
+	// backend should skip the publishing client if its subscription requests no
+	// local messages.
+	//
+	// Note: The retain flag of the message is cleared before it is passed.
+	// Backends that implement RetainAsPublishedBackend receive the message with
+	// its original retain flag using PublishAsPublished instead.
 	Publish(*Client, *packet.Message) error
 
 	// Terminate is called when the client goes offline. Terminate should
@@ -116,33 +138,104 @@ type Backend interface {
 	Terminate(*Client) error
 }
 
+// A RetainAsPublishedBackend is a Backend that supports the retain as published
+// subscription option.
+type RetainAsPublishedBackend interface {
+	Backend
+
+	// PublishAsPublished is called instead of Publish with the message and the
+	// retain flag it has been published with. The backend should clear the
+	// flag unless the matching subscription requests retain as published.
+	PublishAsPublished(*Client, *packet.Message) error
+}
+
+var _ RetainAsPublishedBackend = (*MemoryBackend)(nil)
+
+// a clientSubscription is stored in the subscription tree to resolve the
+// options of the subscription that matched a message
+type clientSubscription struct {
+	client *Client
+	sub    packet.Subscription
+}
+
 // A MemoryBackend stores everything in memory.
 type MemoryBackend struct {
 	Credentials map[string]string
 
+	// The topic prefixes that restrict authenticated users to their namespace.
+	// Users without an entry may use all topics.
+	Namespaces map[string]string
+
+	// The maximum duration a message is kept in an offline queue. A zero value
+	// keeps messages until they are delivered or their expiry elapses.
+	QueueTTL time.Duration
+
+	// The directory used to spill messages of full offline queues to disk. If
+	// set, the oldest messages of a full queue are moved to a segment file
+	// instead of being dropped.
+	SpillDirectory string
+
+	// The cipher used to encrypt spilled messages at rest. NewSpillCipher may
+	// be used to create an AES-GCM cipher from a key.
+	SpillCipher cipher.AEAD
+
+	// The callback that is called with the error if the oldest message of a
+	// full offline queue could not be spilled to disk and has been dropped.
+	SpillErrorCallback func(err error)
+
+	// FetchSession is called by Setup if no stored session is available for a
+	// client that requested a persistent session. It should return the state
+	// of the session from the node that previously owned it (e.g. by calling
+	// ExportSession on that node) or nil if there is none. The call is made
+	// without holding the backends mutex and the session is only stored if
+	// the call succeeds.
+	FetchSession func(id string) (*SessionState, error)
+
 	subscribedClients    *topic.Tree
+	clientSubscriptions  map[*Client]map[string]*clientSubscription
 	retainedMessages     *topic.Tree
 	storedSessions       sync.Map
 	activeClients        map[string]*Client
 	offlineQueues        sync.Map
 	offlineSubscriptions *topic.Tree
 	mutex                sync.Mutex
+	subscriptionMutex    sync.Mutex
 }
 
 // NewMemoryBackend returns a new MemoryBackend.
 func NewMemoryBackend() *MemoryBackend {
 	return &MemoryBackend{
 		subscribedClients:    topic.NewTree(),
+		clientSubscriptions:  make(map[*Client]map[string]*clientSubscription),
 		retainedMessages:     topic.NewTree(),
 		activeClients:        make(map[string]*Client),
 		offlineSubscriptions: topic.NewTree(),
 	}
 }
 
+// Reload will replace the credentials and namespaces while the backend is in
+// use. Already connected clients are not affected and keep the namespace they
+// have been authenticated with.
+func (m *MemoryBackend) Reload(credentials, namespaces map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// set maps
+	m.Credentials = credentials
+	m.Namespaces = namespaces
+}
+
 // Authenticate authenticates a clients credentials by matching them to the
-// saved Credentials map.
+// saved Credentials map and restricts the client to its namespace if one is
+// configured in the Namespaces map.
 func (m *MemoryBackend) Authenticate(client *Client, user, password string) (bool, error) {
-	// mutex locking not needed
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// set namespace if available
+	if prefix, ok := m.Namespaces[user]; ok {
+		client.SetNamespace(prefix)
+	}
 
 	// allow all if there are no credentials
 	if m.Credentials == nil {
@@ -162,12 +255,47 @@ func (m *MemoryBackend) Authenticate(client *Client, user, password string) (boo
 // returned that is not stored further. Furthermore, it will disconnect any client
 // connected with the same client id.
 func (m *MemoryBackend) Setup(client *Client, id string) (Session, bool, error) {
+	// get or create session
+	s, present, fetch := m.setup(client, id)
+	if !fetch {
+		return s, present, nil
+	}
+
+	// fetch session from previous owner without holding the mutex
+	s, queue, present, err := m.fetch(id)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// acquire mutex
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// return session if it has been stored in the meantime
+	if stored, ok := m.storedSessions.Load(id); ok {
+		return stored.(Session), true, nil
+	}
+
+	// save session
+	m.storedSessions.Store(id, s)
+
+	// store offline queue
+	if queue != nil {
+		m.offlineQueues.Store(id, queue)
+	}
+
+	return s, present, nil
+}
+
+// returns the stored or a new session and whether the session should be
+// fetched from the previous owner
+func (m *MemoryBackend) setup(client *Client, id string) (Session, bool, bool) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	// return a new temporary session if id is zero
 	if len(id) == 0 {
-		return session.NewMemorySession(), false, nil
+		return session.NewMemorySession(), false, false
 	}
 
 	// client id is available
@@ -199,18 +327,134 @@ func (m *MemoryBackend) Setup(client *Client, id string) (Session, bool, error)
 			m.offlineSubscriptions.Clear(queue)
 		}
 
-		return s.(Session), true, nil
+		return s.(Session), true, false
 	}
 
 	// create fresh session
 	s = session.NewMemorySession()
 
-	// save session if not clean
-	if !client.CleanSession() {
-		m.storedSessions.Store(id, s)
+	// return a new session if clean is true
+	if client.CleanSession() {
+		return s.(Session), false, false
+	}
+
+	// fetch session if possible
+	if m.FetchSession != nil {
+		return nil, false, true
+	}
+
+	// save session
+	m.storedSessions.Store(id, s)
+
+	return s.(Session), false, false
+}
+
+// fetches the session from the previous owner and returns the restored session
+// and offline queue
+func (m *MemoryBackend) fetch(id string) (Session, *MessageQueue, bool, error) {
+	// create fresh session
+	s := session.NewMemorySession()
+
+	// fetch session from previous owner
+	state, err := m.FetchSession(id)
+	if err != nil {
+		return nil, nil, false, err
+	} else if state == nil {
+		return s, nil, false, nil
+	}
+
+	// restore subscriptions
+	for _, sub := range state.Subscriptions {
+		err = s.SaveSubscription(sub)
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	// check messages
+	if len(state.Messages) == 0 {
+		return s, nil, true, nil
+	}
+
+	// restore offline queue
+	queue, err := m.newOfflineQueue()
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	// add messages
+	for _, msg := range state.Messages {
+		err = queue.Push(msg)
+		if err != nil {
+			_ = queue.Close()
+			return nil, nil, false, err
+		}
+	}
+
+	return s, queue, true, nil
+}
+
+// ExportSession removes the stored session and offline queue for the supplied
+// id and returns its state. It returns nil if no session is stored and
+// ErrSessionActive if a client is currently connected with the id. The
+// returned state can be passed to another node using FetchSession to migrate
+// the session.
+func (m *MemoryBackend) ExportSession(id string) (*SessionState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// check active clients
+	if _, ok := m.activeClients[id]; ok {
+		return nil, ErrSessionActive
 	}
 
-	return s.(Session), false, nil
+	// retrieve stored session
+	s, ok := m.storedSessions.Load(id)
+	if !ok {
+		return nil, nil
+	}
+
+	// get stored subscriptions
+	subscriptions, err := s.(Session).AllSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare state
+	state := &SessionState{
+		Subscriptions: subscriptions,
+	}
+
+	// get offline queue
+	if val, ok := m.offlineQueues.Load(id); ok {
+		// clear offline subscriptions
+		queue := val.(*MessageQueue)
+		m.offlineSubscriptions.Clear(queue)
+
+		// get queued messages
+		for msg := queue.Pop(); msg != nil; msg = queue.Pop() {
+			state.Messages = append(state.Messages, msg)
+		}
+
+		// remove queue
+		m.offlineQueues.Delete(id)
+		queue.Close()
+	}
+
+	// remove session
+	m.storedSessions.Delete(id)
+
+	return state, nil
+}
+
+// SubscriptionCount returns the number of active subscriptions.
+func (m *MemoryBackend) SubscriptionCount() int {
+	return m.subscribedClients.Count()
+}
+
+// RetainedCount returns the number of retained messages.
+func (m *MemoryBackend) RetainedCount() int {
+	return m.retainedMessages.Count()
 }
 
 // QueueOffline will begin with forwarding all missed messages in a separate
@@ -238,7 +482,7 @@ func (m *MemoryBackend) QueueOffline(client *Client) error {
 
 			// publish or add back to queue
 			if !client.Publish(msg) {
-				queue.Push(msg)
+				m.pushOffline(queue, msg)
 				return
 			}
 		}
@@ -250,20 +494,39 @@ func (m *MemoryBackend) QueueOffline(client *Client) error {
 // Subscribe will subscribe the passed client to the specified topic and
 // begin to forward messages by calling the clients Publish method.
 func (m *MemoryBackend) Subscribe(client *Client, sub *packet.Subscription) error {
-	// mutex locking not needed
+	m.subscriptionMutex.Lock()
+	defer m.subscriptionMutex.Unlock()
+
+	// get subscriptions of client
+	subs, ok := m.clientSubscriptions[client]
+	if !ok {
+		subs = make(map[string]*clientSubscription)
+		m.clientSubscriptions[client] = subs
+	}
+
+	// remove existing subscription
+	if existing, ok := subs[sub.Topic]; ok {
+		m.subscribedClients.Remove(sub.Topic, existing)
+	}
 
 	// add subscription
-	m.subscribedClients.Add(sub.Topic, client)
+	cs := &clientSubscription{client: client, sub: *sub}
+	m.subscribedClients.Add(sub.Topic, cs)
+	subs[sub.Topic] = cs
 
 	return nil
 }
 
 // Unsubscribe will unsubscribe the passed client from the specified topic.
 func (m *MemoryBackend) Unsubscribe(client *Client, topic string) error {
-	// mutex locking not needed
+	m.subscriptionMutex.Lock()
+	defer m.subscriptionMutex.Unlock()
 
 	// remove subscription
-	m.subscribedClients.Remove(topic, client)
+	if cs, ok := m.clientSubscriptions[client][topic]; ok {
+		m.subscribedClients.Remove(topic, cs)
+		delete(m.clientSubscriptions[client], topic)
+	}
 
 	return nil
 }
@@ -273,7 +536,7 @@ func (m *MemoryBackend) StoreRetained(client *Client, msg *packet.Message) error
 	// mutex locking not needed
 
 	// set retained message
-	m.retainedMessages.Set(msg.Topic, msg.Copy())
+	m.retainedMessages.Set(msg.Topic, newStoredMessage(client.ownMessage(msg).Copy(), 0))
 
 	return nil
 }
@@ -295,9 +558,19 @@ func (m *MemoryBackend) QueueRetained(client *Client, topic string) error {
 	// get retained messages
 	values := m.retainedMessages.Search(topic)
 
+	// get time
+	now := time.Now()
+
 	// publish messages
 	for _, value := range values {
-		client.Publish(value.(*packet.Message))
+		// remove expired messages
+		msg := value.(*storedMessage)
+		if msg.expired(now) {
+			m.retainedMessages.Remove(msg.msg.Topic, msg)
+			continue
+		}
+
+		client.Publish(msg.message(now))
 	}
 
 	return nil
@@ -305,23 +578,84 @@ func (m *MemoryBackend) QueueRetained(client *Client, topic string) error {
 
 // Publish will forward the passed message to all other subscribed clients. It
 // will also add the message to all sessions that have a matching offline
-// subscription.
+// subscription. The no local option of the matching subscriptions is
+// respected.
 func (m *MemoryBackend) Publish(client *Client, msg *packet.Message) error {
+	return m.PublishAsPublished(client, msg)
+}
+
+// PublishAsPublished will forward the passed message like Publish and clear its
+// retain flag unless a matching subscription of the receiving client requests
+// retain as published. If multiple subscriptions of a client match, the
+// message is forwarded once and the publishing client is only skipped if all
+// of them request no local messages.
+func (m *MemoryBackend) PublishAsPublished(client *Client, msg *packet.Message) error {
 	// mutex locking not needed
 
-	// publish directly to clients
+	// prepare message without retain flag
+	unretained := msg
+	if msg.Retain {
+		unretained = msg.Copy()
+		unretained.Retain = false
+	}
+
+	// merge the options of all matching subscriptions per client
+	type options struct {
+		noLocal bool
+		retain  bool
+	}
+	var subscribers []*Client
+	merged := make(map[*Client]*options)
 	for _, v := range m.subscribedClients.Match(msg.Topic) {
-		v.(*Client).Publish(msg)
+		cs := v.(*clientSubscription)
+		opts, ok := merged[cs.client]
+		if !ok {
+			opts = &options{noLocal: true}
+			merged[cs.client] = opts
+			subscribers = append(subscribers, cs.client)
+		}
+
+		opts.noLocal = opts.noLocal && cs.sub.NoLocal
+		opts.retain = opts.retain || cs.sub.RetainAsPublished
 	}
 
-	// queue for offline clients
-	for _, v := range m.offlineSubscriptions.Match(msg.Topic) {
-		v.(*MessageQueue).Push(msg)
+	// publish directly to clients
+	for _, subscriber := range subscribers {
+		opts := merged[subscriber]
+
+		// skip publishing client if requested
+		if opts.noLocal && subscriber == client {
+			continue
+		}
+
+		// keep retain flag if requested
+		if opts.retain {
+			subscriber.Publish(msg)
+		} else {
+			subscriber.Publish(unretained)
+		}
+	}
+
+	// queue for offline clients, a pooled message is copied once
+	offline := m.offlineSubscriptions.Match(msg.Topic)
+	if len(offline) > 0 {
+		unretained = client.ownMessage(unretained)
+	}
+	for _, v := range offline {
+		m.pushOffline(v.(*MessageQueue), unretained)
 	}
 
 	return nil
 }
 
+// pushes the message to the offline queue and reports spill errors
+func (m *MemoryBackend) pushOffline(queue *MessageQueue, msg *packet.Message) {
+	err := queue.Push(msg)
+	if err != nil && m.SpillErrorCallback != nil {
+		m.SpillErrorCallback(err)
+	}
+}
+
 // Terminate will unsubscribe the passed client from all previously subscribed
 // topics. If the client connect with clean=true it will also clean the session.
 // Otherwise it will create offline subscriptions for all QOS 1 and QOS 2
@@ -331,7 +665,12 @@ func (m *MemoryBackend) Terminate(client *Client) error {
 	defer m.mutex.Unlock()
 
 	// clear all subscriptions
-	m.subscribedClients.Clear(client)
+	m.subscriptionMutex.Lock()
+	for topic, cs := range m.clientSubscriptions[client] {
+		m.subscribedClients.Remove(topic, cs)
+	}
+	delete(m.clientSubscriptions, client)
+	m.subscriptionMutex.Unlock()
 
 	// remove client from list if an id is available
 	if len(client.ClientID()) > 0 {
@@ -353,7 +692,15 @@ func (m *MemoryBackend) Terminate(client *Client) error {
 	}
 
 	// create offline queue
-	queue := NewMessageQueue(1000)
+	queue, err := m.newOfflineQueue()
+	if err != nil {
+		return err
+	}
+
+	// close previous offline queue
+	if val, ok := m.offlineQueues.Load(client.ClientID()); ok {
+		val.(*MessageQueue).Close()
+	}
 
 	// iterate through stored subscriptions
 	for _, sub := range subscriptions {
@@ -368,3 +715,21 @@ func (m *MemoryBackend) Terminate(client *Client) error {
 
 	return nil
 }
+
+// returns a new offline queue using the configured TTL and spill directory
+func (m *MemoryBackend) newOfflineQueue() (*MessageQueue, error) {
+	// create queue
+	queue := NewMessageQueue(1000)
+	queue.TTL = m.QueueTTL
+	queue.Cipher = m.SpillCipher
+
+	// enable spilling if requested
+	if m.SpillDirectory != "" {
+		err := queue.Spill(m.SpillDirectory)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return queue, nil
+}
@@ -3,12 +3,14 @@ package broker
 import (
 	"errors"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/256dpi/gomqtt/session"
+	"github.com/256dpi/gomqtt/topic"
 	"github.com/256dpi/gomqtt/transport"
 	"gopkg.in/tomb.v2"
 )
@@ -23,6 +25,14 @@ const (
 // ConnectPacket.
 var ErrExpectedConnect = errors.New("expected a ConnectPacket as the first packet")
 
+// ErrNamespaceViolation is returned when a client publishes a message to a
+// topic outside of its namespace.
+var ErrNamespaceViolation = errors.New("topic outside of namespace")
+
+// ErrReceiveMaximumExceeded is returned when a client sends more unacknowledged
+// QOS 2 messages than allowed by the advertised receive maximum.
+var ErrReceiveMaximumExceeded = errors.New("receive maximum exceeded")
+
 // A Client represents a remote client that is connected to the broker.
 type Client struct {
 	state uint32
@@ -35,23 +45,45 @@ type Client struct {
 	conn   transport.Conn
 
 	clientID     string
+	username     string
 	cleanSession bool
 	session      Session
+	namespace    string
+	version      byte
+
+	// the ids of unacknowledged incoming qos 2 messages
+	incoming map[packet.ID]struct{}
 
-	out chan *packet.Message
+	out      chan outgoing
+	shedLoad bool
 
 	tomb   tomb.Tomb
 	mutex  sync.Mutex
 	finish sync.Once
 }
 
+// a message queued for sending and the pooled packet it belongs to
+type outgoing struct {
+	msg    *packet.Message
+	pooled *packet.PooledPublish
+}
+
+// release the pooled packet if available
+func (o outgoing) release() {
+	if o.pooled != nil {
+		o.pooled.Release()
+	}
+}
+
 // newClient takes over a connection and returns a Client
 func newClient(engine *Engine, conn transport.Conn) *Client {
 	c := &Client{
-		state:  clientConnecting,
-		engine: engine,
-		conn:   conn,
-		out:    make(chan *packet.Message),
+		state:    clientConnecting,
+		engine:   engine,
+		conn:     conn,
+		incoming: make(map[packet.ID]struct{}),
+		out:      make(chan outgoing, engine.QueueSize),
+		shedLoad: engine.ShedLoad,
 	}
 
 	// start processor
@@ -75,22 +107,66 @@ func (c *Client) ClientID() string {
 	return c.clientID
 }
 
+// SetNamespace will restrict the client to topics and subscription filters
+// that begin with the specified prefix. The prefix is matched on a level
+// boundary, the prefix "tenant1" therefore permits "tenant1" and "tenant1/foo"
+// but not "tenant10/foo". Messages published outside of the namespace close
+// the connection and subscriptions outside of the namespace are rejected. The
+// method should be called by the backend in Authenticate.
+func (c *Client) SetNamespace(prefix string) {
+	c.namespace = prefix
+}
+
+// Namespace returns the topic prefix the client is restricted to.
+func (c *Client) Namespace() string {
+	return c.namespace
+}
+
 // RemoteAddr returns the client's remote net address from the
 // underlying connection.
 func (c *Client) RemoteAddr() net.Addr {
 	return c.conn.RemoteAddr()
 }
 
-// Publish will send a Message to the client and initiate QOS flows.
+// Publish will send a Message to the client and initiate QOS flows. It returns
+// false if the client is closing. If load shedding is enabled, QOS 0 messages
+// are dropped while the outgoing queue is full.
 func (c *Client) Publish(msg *packet.Message) bool {
+	// retain pooled packet
+	out := outgoing{msg: msg, pooled: c.pooledPacket(msg)}
+	if out.pooled != nil {
+		out.pooled.Retain()
+	}
+
+	// drop qos 0 messages if the queue is full and load shedding is enabled
+	if c.shedLoad && msg.QOS == 0 {
+		select {
+		case c.out <- out:
+		case <-c.tomb.Dying():
+			out.release()
+			return false
+		default:
+			out.release()
+			c.log(MessageDropped, c, nil, msg, nil)
+		}
+
+		return true
+	}
+
 	select {
-	case c.out <- msg:
+	case c.out <- out:
 		return true
 	case <-c.tomb.Dying():
+		out.release()
 		return false
 	}
 }
 
+// queued returns the number of messages queued for delivery.
+func (c *Client) queued() int {
+	return len(c.out)
+}
+
 // Close will immediately close the connection. When clean=true the client
 // will be marked as cleanly disconnected, and the will message will not
 // get dispatched.
@@ -104,6 +180,38 @@ func (c *Client) Close(clean bool) {
 	c.conn.Close()
 }
 
+// inflight returns whether the client has outgoing messages that have not yet
+// been acknowledged.
+func (c *Client) inflight() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// check session
+	if c.session == nil {
+		return false
+	}
+
+	// get stored packets
+	packets, err := c.session.AllPackets(session.Outgoing)
+	if err != nil {
+		return false
+	}
+
+	return len(packets) > 0
+}
+
+// shutdown will notify connected MQTT 5 clients that the broker is shutting
+// down and close the connection afterwards.
+func (c *Client) shutdown() {
+	// notify client if connected (the version is set before the state)
+	if atomic.LoadUint32(&c.state) == clientConnected {
+		c.disconnect(packet.ServerShuttingDown)
+	}
+
+	// close underlying connection (triggers cleanup)
+	c.conn.Close()
+}
+
 /* processor goroutine */
 
 // processes incoming packets
@@ -123,6 +231,7 @@ func (c *Client) processor() error {
 		}
 
 		c.log(PacketReceived, c, pkt, nil, nil)
+		c.engine.tap(c, pkt, true)
 
 		if first {
 			// get connect
@@ -169,6 +278,8 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 	// set values
 	c.cleanSession = pkt.CleanSession
 	c.clientID = pkt.ClientID
+	c.username = pkt.Username
+	c.version = pkt.Version
 
 	// authenticate
 	ok, err := c.engine.Backend.Authenticate(c, pkt.Username, pkt.Password)
@@ -181,6 +292,30 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 	connack.ReturnCode = packet.ConnectionAccepted
 	connack.SessionPresent = false
 
+	// rewrite will topic
+	var rewriteErr error
+	if pkt.Will != nil {
+		pkt.Will.Topic, rewriteErr = rewriteTopic(c.engine.RewriteRules, pkt.Will.Topic, false)
+	}
+
+	// check will topic
+	if ok && rewriteErr != nil {
+		return c.die(ClientError, rewriteErr, true)
+	} else if ok && pkt.Will != nil && !c.inNamespace(pkt.Will.Topic) {
+		c.audit(AuditDenied, pkt.Will.Topic)
+		ok = false
+	} else if !ok {
+		c.audit(AuditAuthFailure, "")
+	}
+
+	// check will topic levels
+	if ok && pkt.Will != nil {
+		err = c.checkLevels(pkt.Will.Topic)
+		if err != nil {
+			return c.die(ClientError, err, true)
+		}
+	}
+
 	// check authentication
 	if !ok {
 		// set return code
@@ -202,6 +337,19 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 	// add client to the brokers list
 	c.engine.add(c)
 
+	c.audit(AuditConnect, "")
+
+	// advertise and apply limits
+	if c.version == packet.Version5 {
+		if c.engine.MaxPacketSize > 0 {
+			connack.Properties = connack.Properties.Set(packet.MaximumPacketSizeProperty, c.engine.MaxPacketSize)
+			c.conn.SetReadLimit(int64(c.engine.MaxPacketSize))
+		}
+		if c.engine.ReceiveMaximum > 0 {
+			connack.Properties = connack.Properties.Set(packet.ReceiveMaximumProperty, c.engine.ReceiveMaximum)
+		}
+	}
+
 	// set keep alive
 	if pkt.KeepAlive > 0 {
 		c.conn.SetReadTimeout(time.Duration(pkt.KeepAlive) * 1500 * time.Millisecond)
@@ -224,7 +372,9 @@ func (c *Client) processConnect(pkt *packet.ConnectPacket) error {
 	connack.SessionPresent = !pkt.CleanSession && resumed
 
 	// assign session
+	c.mutex.Lock()
 	c.session = s
+	c.mutex.Unlock()
 
 	// save will if present
 	if pkt.Will != nil {
@@ -308,8 +458,47 @@ func (c *Client) processSubscribe(pkt *packet.SubscribePacket) error {
 	suback.ReturnCodes = make([]byte, len(pkt.Subscriptions))
 	suback.ID = pkt.ID
 
+	// get existing subscriptions
+	existing, err := c.session.AllSubscriptions()
+	if err != nil {
+		return c.die(SessionError, err, true)
+	}
+
+	// prepare list of subscriptions that receive retained messages
+	var retained []string
+
 	// handle contained subscriptions
 	for i, subscription := range pkt.Subscriptions {
+		// rewrite filter
+		subscription.Topic, err = rewriteTopic(c.engine.RewriteRules, subscription.Topic, true)
+		if err != nil {
+			suback.ReturnCodes[i] = packet.QOSFailure
+			continue
+		}
+
+		// reject subscriptions outside of namespace
+		if !c.inNamespace(subscription.Topic) {
+			c.audit(AuditDenied, subscription.Topic)
+			suback.ReturnCodes[i] = packet.QOSFailure
+			continue
+		}
+
+		// reject subscriptions that exceed the topic limits
+		if c.checkLevels(subscription.Topic) != nil {
+			suback.ReturnCodes[i] = packet.QOSFailure
+			continue
+		}
+
+		// check retain handling
+		switch subscription.RetainHandling {
+		case packet.SendRetained:
+			retained = append(retained, subscription.Topic)
+		case packet.SendRetainedIfNew:
+			if !containsSubscription(existing, subscription.Topic) {
+				retained = append(retained, subscription.Topic)
+			}
+		}
+
 		// save subscription in session
 		err := c.session.SaveSubscription(&subscription)
 		if err != nil {
@@ -327,14 +516,14 @@ func (c *Client) processSubscribe(pkt *packet.SubscribePacket) error {
 	}
 
 	// send suback
-	err := c.send(suback, true)
+	err = c.send(suback, true)
 	if err != nil {
 		return c.die(TransportError, err, false)
 	}
 
 	// queue retained messages
-	for _, sub := range pkt.Subscriptions {
-		err := c.engine.Backend.QueueRetained(c, sub.Topic)
+	for _, topic := range retained {
+		err := c.engine.Backend.QueueRetained(c, topic)
 		if err != nil {
 			return c.die(BackendError, err, true)
 		}
@@ -347,8 +536,14 @@ func (c *Client) processSubscribe(pkt *packet.SubscribePacket) error {
 func (c *Client) processUnsubscribe(pkt *packet.UnsubscribePacket) error {
 	// handle contained topics
 	for _, topic := range pkt.Topics {
+		// rewrite filter, invalid filters cannot have been subscribed
+		topic, err := rewriteTopic(c.engine.RewriteRules, topic, true)
+		if err != nil {
+			continue
+		}
+
 		// unsubscribe client from queue
-		err := c.engine.Backend.Unsubscribe(c, topic)
+		err = c.engine.Backend.Unsubscribe(c, topic)
 		if err != nil {
 			return c.die(BackendError, err, true)
 		}
@@ -375,9 +570,33 @@ func (c *Client) processUnsubscribe(pkt *packet.UnsubscribePacket) error {
 
 // handle an incoming PublishPacket
 func (c *Client) processPublish(publish *packet.PublishPacket) error {
+	// release pooled packet when done
+	if pooled := publish.Pooled(); pooled != nil {
+		defer pooled.Release()
+	}
+
+	// rewrite topic
+	var err error
+	publish.Message.Topic, err = rewriteTopic(c.engine.RewriteRules, publish.Message.Topic, false)
+	if err != nil {
+		return c.die(ClientError, err, true)
+	}
+
+	// check namespace
+	if !c.inNamespace(publish.Message.Topic) {
+		c.audit(AuditDenied, publish.Message.Topic)
+		return c.die(ClientError, ErrNamespaceViolation, true)
+	}
+
+	// check topic levels
+	err = c.checkLevels(publish.Message.Topic)
+	if err != nil {
+		return c.die(ClientError, err, true)
+	}
+
 	// handle unacknowledged and directly acknowledged messages
 	if publish.Message.QOS <= 1 {
-		err := c.handleMessage(&publish.Message)
+		err := c.handlePublish(publish)
 		if err != nil {
 			return c.die(BackendError, err, true)
 		}
@@ -397,8 +616,26 @@ func (c *Client) processPublish(publish *packet.PublishPacket) error {
 
 	// handle qos 2 flow
 	if publish.Message.QOS == 2 {
-		// store packet
-		err := c.session.SavePacket(session.Incoming, publish)
+		// check receive maximum
+		if _, ok := c.incoming[publish.ID]; !ok && c.exceedsReceiveMaximum() {
+			c.disconnect(packet.ReceiveMaximumExceededDisconnect)
+			return c.die(ClientError, ErrReceiveMaximumExceeded, true)
+		}
+
+		// track packet
+		c.incoming[publish.ID] = struct{}{}
+
+		// store packet, a pooled packet is copied as it is released
+		stored := publish
+		if publish.Pooled() != nil {
+			stored = packet.NewPublishPacket()
+			stored.Message = publish.Message
+			stored.Message.Payload = copyPayload(publish.Message.Payload)
+			stored.Dup = publish.Dup
+			stored.ID = publish.ID
+			stored.Properties = publish.Properties
+		}
+		err := c.session.SavePacket(session.Incoming, stored)
 		if err != nil {
 			return c.die(SessionError, err, true)
 		}
@@ -448,6 +685,9 @@ func (c *Client) processPubrec(id packet.ID) error {
 
 // handle an incoming PubrelPacket
 func (c *Client) processPubrel(id packet.ID) error {
+	// untrack packet
+	delete(c.incoming, id)
+
 	// get packet from store
 	pkt, err := c.session.LookupPacket(session.Incoming, id)
 	if err != nil {
@@ -507,8 +747,9 @@ func (c *Client) sender() error {
 		select {
 		case <-c.tomb.Dying():
 			return tomb.ErrDying
-		case msg := <-c.out:
+		case out := <-c.out:
 			// prepare publish packet
+			msg := out.msg
 			publish := packet.NewPublishPacket()
 			publish.Message = *msg
 
@@ -531,8 +772,13 @@ func (c *Client) sender() error {
 				publish.ID = c.session.NextID()
 			}
 
-			// store packet if at least qos 1
+			// store packet if at least qos 1, the payload of a pooled packet
+			// is copied as the session keeps the packet
 			if publish.Message.QOS > 0 {
+				if out.pooled != nil {
+					publish.Message.Payload = copyPayload(msg.Payload)
+				}
+
 				err := c.session.SavePacket(session.Outgoing, publish)
 				if err != nil {
 					return c.die(SessionError, err, true)
@@ -546,12 +792,62 @@ func (c *Client) sender() error {
 			}
 
 			c.log(MessageForwarded, c, nil, msg, nil)
+
+			// release pooled packet
+			out.release()
 		}
 	}
 }
 
 /* helpers */
 
+// handle a received publish packet and share it with the subscribers if it
+// has been acquired from the publish pool
+func (c *Client) handlePublish(publish *packet.PublishPacket) error {
+	// register pooled packet
+	pooled := publish.Pooled()
+	if pooled != nil && len(publish.Message.Payload) > 0 {
+		key := &publish.Message.Payload[0]
+		c.engine.pooled.Store(key, pooled)
+		defer c.engine.pooled.Delete(key)
+	}
+
+	return c.handleMessage(&publish.Message)
+}
+
+// return the pooled packet the message payload belongs to, copies of the
+// message share the payload and thus the packet
+func (c *Client) pooledPacket(msg *packet.Message) *packet.PooledPublish {
+	// check pool
+	if c == nil || c.engine == nil || c.engine.PublishPool == nil || len(msg.Payload) == 0 {
+		return nil
+	}
+
+	// lookup packet
+	value, ok := c.engine.pooled.Load(&msg.Payload[0])
+	if !ok {
+		return nil
+	}
+
+	return value.(*packet.PooledPublish)
+}
+
+// return a message that does not share its payload with a pooled packet
+func (c *Client) ownMessage(msg *packet.Message) *packet.Message {
+	if c.pooledPacket(msg) == nil {
+		return msg
+	}
+
+	msg = msg.Copy()
+	msg.Payload = copyPayload(msg.Payload)
+
+	return msg
+}
+
+func copyPayload(payload []byte) []byte {
+	return append([]byte(nil), payload...)
+}
+
 func (c *Client) handleMessage(msg *packet.Message) error {
 	// check retain flag
 	if msg.Retain {
@@ -570,11 +866,16 @@ func (c *Client) handleMessage(msg *packet.Message) error {
 		}
 	}
 
-	// reset an existing retain flag
-	msg.Retain = false
+	// publish message to others with the original retain flag if supported
+	var err error
+	if backend, ok := c.engine.Backend.(RetainAsPublishedBackend); ok {
+		err = backend.PublishAsPublished(c, msg)
+	} else {
+		// reset an existing retain flag
+		msg.Retain = false
 
-	// publish message to others
-	err := c.engine.Backend.Publish(c, msg)
+		err = c.engine.Backend.Publish(c, msg)
+	}
 	if err != nil {
 		return err
 	}
@@ -637,6 +938,7 @@ func (c *Client) cleanup(event LogEvent, err error, close bool) (LogEvent, error
 	// remove client from the brokers list if added
 	if atomic.LoadUint32(&c.state) > clientConnecting {
 		c.engine.remove(c)
+		c.audit(AuditDisconnect, "")
 	}
 
 	return event, err
@@ -673,6 +975,7 @@ func (c *Client) send(pkt packet.GenericPacket, buffered bool) error {
 	}
 
 	c.log(PacketSent, c, pkt, nil, nil)
+	c.engine.tap(c, pkt, false)
 
 	return nil
 }
@@ -683,3 +986,76 @@ func (c *Client) log(event LogEvent, client *Client, pkt packet.GenericPacket, m
 		c.engine.Logger(event, client, pkt, msg, err)
 	}
 }
+
+// emit an audit event
+func (c *Client) audit(typ AuditEventType, topic string) {
+	// check sink
+	if c.engine.Audit == nil {
+		return
+	}
+
+	// prepare event
+	event := &AuditEvent{
+		Time:     time.Now(),
+		Type:     typ,
+		ClientID: c.clientID,
+		Username: c.username,
+		Topic:    topic,
+	}
+
+	// set remote address if available
+	if addr := c.conn.RemoteAddr(); addr != nil {
+		event.RemoteAddr = addr.String()
+	}
+
+	c.engine.Audit.Audit(event)
+}
+
+// check if the topic or filter is inside the namespace
+func (c *Client) inNamespace(topic string) bool {
+	// check namespace
+	if c.namespace == "" {
+		return true
+	}
+
+	// prefixes ending with a separator already mark a level boundary
+	if strings.HasSuffix(c.namespace, "/") {
+		return strings.HasPrefix(topic, c.namespace)
+	}
+
+	return topic == c.namespace || strings.HasPrefix(topic, c.namespace+"/")
+}
+
+func (c *Client) exceedsReceiveMaximum() bool {
+	return c.version == packet.Version5 && c.engine.ReceiveMaximum > 0 && len(c.incoming) >= int(c.engine.ReceiveMaximum)
+}
+
+// disconnect will send a DisconnectPacket with the reason code to MQTT 5
+// clients, errors are ignored as the connection is closed afterwards
+func (c *Client) disconnect(code packet.DisconnectCode) {
+	// check version
+	if c.version != packet.Version5 {
+		return
+	}
+
+	// prepare packet
+	disconnect := packet.NewDisconnectPacket()
+	disconnect.ReasonCode = code
+
+	// send packet
+	_ = c.send(disconnect, false)
+}
+
+func (c *Client) checkLevels(name string) error {
+	return topic.CheckLevels(name, c.engine.MaxTopicLevels, c.engine.MaxTopicLevelLength)
+}
+
+func containsSubscription(list []*packet.Subscription, topic string) bool {
+	for _, sub := range list {
+		if sub.Topic == topic {
+			return true
+		}
+	}
+
+	return false
+}
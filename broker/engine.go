@@ -3,6 +3,7 @@ package broker
 
 import (
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
@@ -11,6 +12,9 @@ import (
 	"gopkg.in/tomb.v2"
 )
 
+// the interval in which inflight messages are checked during a shutdown
+const shutdownInterval = 10 * time.Millisecond
+
 // LogEvent are received by a Logger.
 type LogEvent int
 
@@ -44,6 +48,10 @@ const (
 
 	// ClientError is emitted when the client violates the protocol.
 	ClientError
+
+	// MessageDropped is emitted when a message has been dropped because the
+	// outgoing queue of a client is full and load shedding is enabled.
+	MessageDropped
 )
 
 // The Logger callback handles incoming log messages.
@@ -57,11 +65,60 @@ type Engine struct {
 	ConnectTimeout   time.Duration
 	DefaultReadLimit int64
 
+	// The rules that are applied to the topics of incoming messages and
+	// subscription filters. Only the first matching rule is applied.
+	RewriteRules []*RewriteRule
+
+	// The maximum number of levels and the maximum length of a single level
+	// of topics and subscription filters. Subscriptions that exceed a limit
+	// are rejected and clients that publish to such a topic are closed. This
+	// protects the subscription and retained message trees from pathological
+	// deeply nested topics. A zero value disables the respective limit.
+	MaxTopicLevels      int
+	MaxTopicLevelLength int
+
+	// The maximum packet size and the maximum number of unacknowledged QOS 2
+	// messages that are accepted from MQTT 5 clients. Both limits are
+	// advertised in the ConnackPacket and clients that exceed them are
+	// disconnected with the respective reason code. A zero value disables the
+	// respective limit.
+	MaxPacketSize  uint32
+	ReceiveMaximum uint16
+
+	// The number of messages that can be queued for delivery to a client
+	// before publishers are blocked.
+	QueueSize int
+
+	// If enabled, QOS 0 messages are dropped instead of blocking the publisher
+	// while the outgoing queue of a client is full. QOS 1 and 2 messages are
+	// still delivered and acknowledged as usual.
+	ShedLoad bool
+
+	// The pool that is used to decode received publish packets. If set, a
+	// received packet is shared by all subscribers that receive the message
+	// and returned to the pool once the last of them has sent it. Messages
+	// that are stored in a session for a QOS 1 or 2 delivery are copied.
+	// Backends must copy messages that they keep after Publish returned, the
+	// MemoryBackend does this for its offline queues.
+	PublishPool *packet.PublishPool
+
+	// The sink that receives security relevant events like connects,
+	// authentication failures and namespace violations.
+	Audit AuditSink
+
 	closing   bool
+	draining  bool
+	accepting bool
+	servers   []transport.Server
 	clients   []*Client
+	taps      []*Tap
+	tapMutex  sync.RWMutex
 	mutex     sync.Mutex
 	waitGroup sync.WaitGroup
 
+	// the pooled packets of the messages that are currently published
+	pooled sync.Map
+
 	tomb tomb.Tomb
 }
 
@@ -81,6 +138,11 @@ func NewEngineWithBackend(backend Backend) *Engine {
 
 // Accept begins accepting connections from the passed server.
 func (e *Engine) Accept(server transport.Server) {
+	e.mutex.Lock()
+	e.accepting = true
+	e.servers = append(e.servers, server)
+	e.mutex.Unlock()
+
 	e.tomb.Go(func() error {
 		for {
 			conn, err := server.Accept()
@@ -109,6 +171,11 @@ func (e *Engine) Handle(conn transport.Conn) bool {
 	// set default read limit
 	conn.SetReadLimit(e.DefaultReadLimit)
 
+	// set publish pool
+	if e.PublishPool != nil {
+		conn.SetPublishPool(e.PublishPool)
+	}
+
 	// close conn immediately when closing
 	if e.closing {
 		conn.Close()
@@ -121,6 +188,45 @@ func (e *Engine) Handle(conn transport.Conn) bool {
 	return true
 }
 
+// ServeConn wraps the net.Conn and hands it over to Handle. It returns false if
+// the engine is closing and the connection has been closed.
+func (e *Engine) ServeConn(conn net.Conn) bool {
+	return e.Handle(transport.NewNetConn(conn))
+}
+
+// Serve accepts connections from the listener and hands them over to the
+// engine until the listener fails or the engine is closing. This allows the
+// usage of custom listeners e.g. with proxy protocol support. The error from
+// the listener is returned, or nil if the engine is closing.
+//
+// Note: The listener must be closed before calling Close or Stop.
+func (e *Engine) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		if !e.ServeConn(conn) {
+			return nil
+		}
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection and hands it over to
+// Handle. The engine can therefore be mounted as a http.Handler in existing
+// HTTP servers.
+func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// upgrade connection
+	conn, err := transport.Upgrade(w, r)
+	if err != nil {
+		// upgrader already responded to request
+		return
+	}
+
+	e.Handle(conn)
+}
+
 // Clients returns a current list of connected clients.
 func (e *Engine) Clients() []*Client {
 	e.mutex.Lock()
@@ -145,8 +251,7 @@ func (e *Engine) Close() {
 	e.closing = true
 
 	// stop acceptors
-	e.tomb.Kill(nil)
-	e.tomb.Wait()
+	e.stopAcceptors()
 
 	// close all clients
 	for _, client := range e.clients {
@@ -154,6 +259,95 @@ func (e *Engine) Close() {
 	}
 }
 
+// Stop will stop handling incoming connections and gracefully shutdown all
+// current clients. The clients get the chance to acknowledge inflight messages
+// until the timeout is reached. Afterwards, they are closed and MQTT 5 clients
+// are notified using a DisconnectPacket with the ServerShuttingDown reason code
+// beforehand. The
+// sessions of the clients are persisted by the backend when they are
+// terminated. The method returns whether all inflight messages have been
+// acknowledged and all clients have been closed in time (true) or the timeout
+// has been reached (false).
+//
+// Note: All passed servers to Accept must be closed before calling this method.
+func (e *Engine) Stop(timeout time.Duration) bool {
+	e.mutex.Lock()
+
+	// set closing
+	e.closing = true
+
+	// stop acceptors
+	e.stopAcceptors()
+
+	// copy list
+	clients := make([]*Client, len(e.clients))
+	copy(clients, e.clients)
+
+	e.mutex.Unlock()
+
+	// calculate deadline
+	deadline := time.Now().Add(timeout)
+
+	// wait for inflight messages
+	acknowledged := true
+	for _, client := range clients {
+		for client.inflight() {
+			// check deadline
+			if time.Now().After(deadline) {
+				acknowledged = false
+				break
+			}
+
+			time.Sleep(shutdownInterval)
+		}
+	}
+
+	// shutdown all clients
+	for _, client := range clients {
+		client.shutdown()
+	}
+
+	// wait for remaining time
+	remaining := time.Until(deadline)
+	if remaining < shutdownInterval {
+		remaining = shutdownInterval
+	}
+
+	return e.Wait(remaining) && acknowledged
+}
+
+// Drain prepares the engine for the termination of the node e.g. during a
+// scale-down. The engine is marked as draining, which can be reported to
+// readiness probes using Draining. The servers passed to Accept are closed and
+// afterwards all clients are gracefully stopped using Stop. The sessions of
+// the clients are persisted by the backend and can be resumed on other nodes
+// that share the backend. The method returns the result of Stop.
+//
+// Note: Listeners passed to Serve must be closed before calling this method.
+func (e *Engine) Drain(timeout time.Duration) bool {
+	e.mutex.Lock()
+
+	// set draining
+	e.draining = true
+
+	// close servers
+	for _, server := range e.servers {
+		server.Close()
+	}
+
+	e.mutex.Unlock()
+
+	return e.Stop(timeout)
+}
+
+// Draining returns whether Drain has been called.
+func (e *Engine) Draining() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.draining
+}
+
 // Wait can be called after close to wait until all clients have been closed.
 // The method returns whether all clients have been closed (true) or the timeout
 // has been reached (false).
@@ -173,6 +367,16 @@ func (e *Engine) Wait(timeout time.Duration) bool {
 	}
 }
 
+// kills the acceptors and waits until they returned
+func (e *Engine) stopAcceptors() {
+	e.tomb.Kill(nil)
+
+	// the tomb only dies if an acceptor has been started
+	if e.accepting {
+		e.tomb.Wait()
+	}
+}
+
 // clients call add to add themselves to the list
 func (e *Engine) add(client *Client) {
 	e.mutex.Lock()
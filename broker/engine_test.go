@@ -1,6 +1,9 @@
 package broker
 
 import (
+	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -50,3 +53,415 @@ func TestDefaultReadLimit(t *testing.T) {
 	close(quit)
 	safeReceive(done)
 }
+
+func TestEngineStop(t *testing.T) {
+	engineStopTest(t, packet.Version311)
+}
+
+func TestEngineStop5(t *testing.T) {
+	engineStopTest(t, packet.Version5)
+}
+
+func engineStopTest(t *testing.T, version byte) {
+	backend := NewMemoryBackend()
+	engine := NewEngineWithBackend(backend)
+
+	server, err := transport.Launch("tcp://localhost:0")
+	assert.NoError(t, err)
+
+	engine.Accept(server)
+
+	conn, err := transport.Dial("tcp://" + server.Addr().String())
+	assert.NoError(t, err)
+
+	connect := packet.NewConnectPacket()
+	connect.ClientID = "test"
+	connect.CleanSession = false
+	connect.Version = version
+	assert.NoError(t, conn.Send(connect))
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.CONNACK, pkt.Type())
+
+	subscribe := packet.NewSubscribePacket()
+	subscribe.ID = 1
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 1}}
+	assert.NoError(t, conn.Send(subscribe))
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.SUBACK, pkt.Type())
+
+	engine.Clients()[0].Publish(&packet.Message{Topic: "test", Payload: []byte("test"), QOS: 1})
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	publish := pkt.(*packet.PublishPacket)
+
+	assert.NoError(t, server.Close())
+
+	stopped := make(chan bool)
+	go func() {
+		stopped <- engine.Stop(time.Second)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	puback := packet.NewPubackPacket()
+	puback.ID = publish.ID
+	assert.NoError(t, conn.Send(puback))
+
+	if version == packet.Version5 {
+		pkt, err = conn.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, packet.DISCONNECT, pkt.Type())
+		assert.Equal(t, packet.ServerShuttingDown, pkt.(*packet.DisconnectPacket).ReasonCode)
+	}
+
+	pkt, err = conn.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+
+	assert.True(t, <-stopped)
+
+	val, ok := backend.storedSessions.Load("test")
+	assert.True(t, ok)
+
+	subs, err := val.(Session).AllSubscriptions()
+	assert.NoError(t, err)
+	assert.Len(t, subs, 1)
+}
+
+func TestEngineDrain(t *testing.T) {
+	backend := NewMemoryBackend()
+	engine := NewEngineWithBackend(backend)
+
+	server, err := transport.Launch("tcp://localhost:0")
+	assert.NoError(t, err)
+
+	engine.Accept(server)
+
+	conn, err := transport.Dial("tcp://" + server.Addr().String())
+	assert.NoError(t, err)
+
+	connect := packet.NewConnectPacket()
+	connect.ClientID = "test"
+	connect.CleanSession = false
+	assert.NoError(t, conn.Send(connect))
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.CONNACK, pkt.Type())
+
+	assert.False(t, engine.Draining())
+	assert.True(t, engine.Drain(time.Second))
+	assert.True(t, engine.Draining())
+
+	pkt, err = conn.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+
+	_, err = transport.Dial("tcp://" + server.Addr().String())
+	assert.Error(t, err)
+
+	_, ok := backend.storedSessions.Load("test")
+	assert.True(t, ok)
+}
+
+func TestEngineShedLoad(t *testing.T) {
+	var dropped int
+
+	engine := NewEngine()
+	engine.QueueSize = 1
+	engine.ShedLoad = true
+	engine.Logger = func(event LogEvent, client *Client, pkt packet.GenericPacket, msg *packet.Message, err error) {
+		if event == MessageDropped {
+			dropped++
+		}
+	}
+
+	client := &Client{
+		engine:   engine,
+		out:      make(chan outgoing, engine.QueueSize),
+		shedLoad: engine.ShedLoad,
+	}
+
+	assert.True(t, client.Publish(&packet.Message{Topic: "test", Payload: []byte("1")}))
+	assert.True(t, client.Publish(&packet.Message{Topic: "test", Payload: []byte("2")}))
+	assert.Equal(t, 1, dropped)
+
+	done := make(chan struct{})
+
+	go func() {
+		assert.True(t, client.Publish(&packet.Message{Topic: "test", Payload: []byte("3"), QOS: 1}))
+		close(done)
+	}()
+
+	assert.Equal(t, []byte("1"), (<-client.out).msg.Payload)
+	assert.Equal(t, []byte("3"), (<-client.out).msg.Payload)
+	assert.Equal(t, 1, dropped)
+
+	safeReceive(done)
+}
+
+func TestEnginePublishPool(t *testing.T) {
+	engine := NewEngine()
+	engine.PublishPool = packet.NewPublishPool()
+
+	port, quit, done := Run(engine, "tcp")
+
+	receive := func(ch chan string) string {
+		select {
+		case payload := <-ch:
+			return payload
+		case <-time.After(10 * time.Second):
+			t.Fatal("nothing received")
+			return ""
+		}
+	}
+
+	// connect subscribers
+	var subscribers []*client.Client
+	var received []chan string
+	for i := 0; i < 3; i++ {
+		ch := make(chan string, 100)
+		received = append(received, ch)
+
+		c := client.New()
+		c.Callback = func(msg *packet.Message, err error) error {
+			assert.NoError(t, err)
+			ch <- string(msg.Payload)
+			return nil
+		}
+
+		cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+		assert.NoError(t, err)
+		assert.NoError(t, cf.Wait(10*time.Second))
+
+		sf, err := c.Subscribe("test", byte(i))
+		assert.NoError(t, err)
+		assert.NoError(t, sf.Wait(10*time.Second))
+
+		subscribers = append(subscribers, c)
+	}
+
+	// connect publisher
+	publisher := client.New()
+	cf, err := publisher.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	// publish messages with changing payload sizes
+	for i := 0; i < 100; i++ {
+		payload := strings.Repeat(strconv.Itoa(i), i%7+1)
+		pf, err := publisher.Publish("test", []byte(payload), byte(i%3), i == 99)
+		assert.NoError(t, err)
+		assert.NoError(t, pf.Wait(10*time.Second))
+	}
+
+	// check payloads, qos 2 messages may arrive out of order
+	var expected []string
+	for i := 0; i < 100; i++ {
+		expected = append(expected, strings.Repeat(strconv.Itoa(i), i%7+1))
+	}
+	for _, ch := range received {
+		var payloads []string
+		for i := 0; i < 100; i++ {
+			payloads = append(payloads, receive(ch))
+		}
+		assert.ElementsMatch(t, expected, payloads)
+	}
+
+	// overwrite pooled packets
+	for i := 0; i < 10; i++ {
+		pf, err := publisher.Publish("other", []byte("overwrite"), 0, false)
+		assert.NoError(t, err)
+		assert.NoError(t, pf.Wait(10*time.Second))
+	}
+
+	// check retained message
+	sf, err := subscribers[0].Subscribe("test", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, strings.Repeat("99", 99%7+1), receive(received[0]))
+
+	for _, c := range append(subscribers, publisher) {
+		assert.NoError(t, c.Disconnect())
+	}
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestEngineTopicLimits(t *testing.T) {
+	engine := NewEngine()
+	engine.MaxTopicLevels = 2
+	engine.MaxTopicLevelLength = 3
+
+	port, quit, done := Run(engine, "tcp")
+
+	wait := make(chan struct{})
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Nil(t, msg)
+		assert.Error(t, err)
+		close(wait)
+		return nil
+	}
+
+	config := client.NewConfig("tcp://localhost:" + port)
+	config.ValidateSubs = false
+
+	cf, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.SubscribeMultiple([]packet.Subscription{
+		{Topic: "foo/#"},
+		{Topic: "foo/bar/#"},
+		{Topic: "fooo"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+	assert.Equal(t, []uint8{0, packet.QOSFailure, packet.QOSFailure}, sf.ReturnCodes())
+
+	pf, err := c.Publish("foo/bar/baz", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	safeReceive(wait)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestEngineMaxPacketSize(t *testing.T) {
+	engine := NewEngine()
+	engine.MaxPacketSize = 64
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	connect := packet.NewConnectPacket()
+	connect.Version = packet.Version5
+	err = conn.Send(connect)
+	assert.NoError(t, err)
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	connack := pkt.(*packet.ConnackPacket)
+	value, ok := connack.Properties.Get(packet.MaximumPacketSizeProperty)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(64), value)
+	_, ok = connack.Properties.Get(packet.ReceiveMaximumProperty)
+	assert.False(t, ok)
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = make([]byte, 64)
+	err = conn.Send(publish)
+	assert.NoError(t, err)
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.PacketTooLargeDisconnect, pkt.(*packet.DisconnectPacket).ReasonCode)
+
+	_, err = conn.Receive()
+	assert.Error(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestEngineReceiveMaximum(t *testing.T) {
+	engine := NewEngine()
+	engine.ReceiveMaximum = 1
+
+	port, quit, done := Run(engine, "tcp")
+
+	conn, err := transport.Dial("tcp://localhost:" + port)
+	assert.NoError(t, err)
+
+	connect := packet.NewConnectPacket()
+	connect.Version = packet.Version5
+	err = conn.Send(connect)
+	assert.NoError(t, err)
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	connack := pkt.(*packet.ConnackPacket)
+	value, ok := connack.Properties.Get(packet.ReceiveMaximumProperty)
+	assert.True(t, ok)
+	assert.Equal(t, uint16(1), value)
+
+	publish := packet.NewPublishPacket()
+	publish.ID = 1
+	publish.Message.Topic = "test"
+	publish.Message.QOS = 2
+	err = conn.Send(publish)
+	assert.NoError(t, err)
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(1), pkt.(*packet.PubrecPacket).ID)
+
+	// resending the same message is allowed
+	publish.Dup = true
+	err = conn.Send(publish)
+	assert.NoError(t, err)
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ID(1), pkt.(*packet.PubrecPacket).ID)
+
+	publish.ID = 2
+	publish.Dup = false
+	err = conn.Send(publish)
+	assert.NoError(t, err)
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.ReceiveMaximumExceededDisconnect, pkt.(*packet.DisconnectPacket).ReasonCode)
+
+	_, err = conn.Receive()
+	assert.Error(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestEngineServe(t *testing.T) {
+	engine := NewEngine()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- engine.Serve(listener)
+	}()
+
+	c := client.New()
+	cf, err := c.Connect(client.NewConfig("tcp://" + listener.Addr().String()))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+	assert.Len(t, engine.Clients(), 1)
+
+	assert.NoError(t, c.Disconnect())
+
+	assert.NoError(t, listener.Close())
+	assert.Error(t, <-served)
+
+	engine.Close()
+	assert.True(t, engine.Wait(time.Second))
+
+	c1, c2 := net.Pipe()
+	assert.False(t, engine.ServeConn(c1))
+
+	_, err = c2.Write([]byte{0})
+	assert.Error(t, err)
+}
@@ -1,20 +1,82 @@
 package broker
 
 import (
+	"crypto/cipher"
 	"sync"
+	"time"
 
 	"github.com/256dpi/gomqtt/packet"
 )
 
-// MessageQueue is a basic FIFO queue for messages.
+// a storedMessage tracks the time a message has been received to enforce its
+// expiry and the lifetime limit of the store
+type storedMessage struct {
+	msg      *packet.Message
+	received time.Time
+	deadline time.Time
+}
+
+func newStoredMessage(msg *packet.Message, ttl time.Duration) *storedMessage {
+	// get time
+	now := time.Now()
+
+	// prepare message
+	m := &storedMessage{
+		msg:      msg,
+		received: now,
+	}
+
+	// set deadline from message expiry
+	if msg.Expiry > 0 {
+		m.deadline = now.Add(msg.Expiry)
+	}
+
+	// lower deadline to ttl if earlier
+	if ttl > 0 && (m.deadline.IsZero() || ttl < msg.Expiry) {
+		m.deadline = now.Add(ttl)
+	}
+
+	return m
+}
+
+func (m *storedMessage) expired(now time.Time) bool {
+	return !m.deadline.IsZero() && !now.Before(m.deadline)
+}
+
+func (m *storedMessage) message(now time.Time) *packet.Message {
+	// return original if message does not expire
+	if m.msg.Expiry == 0 {
+		return m.msg
+	}
+
+	// return copy with remaining expiry
+	msg := m.msg.Copy()
+	msg.Expiry -= now.Sub(m.received)
+
+	return msg
+}
+
+// MessageQueue is a basic FIFO queue for messages. If the queue is full the
+// oldest message is dropped or moved to a spill file if enabled.
 type MessageQueue struct {
+	// The maximum duration a message is kept in the queue. A zero value keeps
+	// messages until they are popped or their expiry elapses.
+	TTL time.Duration
+
+	// The cipher used to encrypt messages that are spilled to disk. If set, the
+	// records of the segment file are sealed using a random nonce. The value
+	// must be set before calling Spill.
+	Cipher cipher.AEAD
+
 	size int
 
-	nodes []*packet.Message
+	nodes []*storedMessage
 	head  int
 	tail  int
 	count int
 
+	spill *spillFile
+
 	mutex sync.RWMutex
 }
 
@@ -23,75 +85,211 @@ type MessageQueue struct {
 func NewMessageQueue(size int) *MessageQueue {
 	return &MessageQueue{
 		size:  size,
-		nodes: make([]*packet.Message, size),
+		nodes: make([]*storedMessage, size),
 	}
 }
 
-// Push adds a message to the queue.
-func (q *MessageQueue) Push(msg *packet.Message) {
+// Push adds a message to the queue. If the queue is full and the oldest message
+// could not be spilled to disk, the message is still added and the error is
+// returned as the oldest message has been dropped.
+func (q *MessageQueue) Push(msg *packet.Message) error {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	// remove item if full
+	// remove item if full and eventually spill it to disk
+	var err error
 	if q.count == q.size {
-		q.Pop()
+		node := q.pop()
+		if q.spill != nil && !node.expired(time.Now()) {
+			err = q.spill.write(node)
+		}
 	}
 
 	// add item
-	q.nodes[q.head] = msg
+	q.nodes[q.head] = newStoredMessage(msg, q.TTL)
 	q.count++
 	q.head = q.wrap(q.head + 1)
+
+	return err
 }
 
 // Pop removes and returns a message from the queue in first to last order.
+// Expired messages are dropped and the expiry of the returned message is
+// reduced by the time it has been queued.
 func (q *MessageQueue) Pop() *packet.Message {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
-	if q.count == 0 {
-		return nil
-	}
+	// get time
+	now := time.Now()
 
-	// remove item
-	node := q.nodes[q.tail]
-	q.nodes[q.tail] = nil
-	q.count--
-	q.tail = q.wrap(q.tail + 1)
+	for {
+		// get item from spill file first as it holds the oldest items
+		node := q.popSpilled()
+		if node == nil {
+			node = q.pop()
+		}
 
-	return node
+		// check item
+		if node == nil {
+			return nil
+		}
+
+		// skip expired items
+		if node.expired(now) {
+			continue
+		}
+
+		return node.message(now)
+	}
 }
 
 // Range will call range with the contents of the queue. If fn returns false the
-// operation is stopped immediately.
+// operation is stopped immediately. Expired messages are skipped.
 func (q *MessageQueue) Range(fn func(*packet.Message) bool) {
 	q.mutex.RLock()
 	defer q.mutex.RUnlock()
 
+	// get time
+	now := time.Now()
+
+	// iterate spilled items first
+	if q.spill != nil {
+		stopped := false
+		q.spill.scan(func(node *storedMessage) bool {
+			if node.expired(now) {
+				return true
+			}
+
+			stopped = !fn(node.message(now))
+
+			return !stopped
+		})
+
+		if stopped {
+			return
+		}
+	}
+
 	for i := 0; i < q.count; i++ {
-		if !fn(q.nodes[q.wrap(q.head+i)]) {
+		// get item
+		node := q.nodes[q.wrap(q.tail+i)]
+		if node.expired(now) {
+			continue
+		}
+
+		if !fn(node.message(now)) {
 			return
 		}
 	}
 }
 
-// Len returns the length of the queue.
+// Len returns the length of the queue. The length includes spilled messages
+// and messages that have expired but not yet been removed.
 func (q *MessageQueue) Len() int {
 	q.mutex.RLock()
 	defer q.mutex.RUnlock()
 
+	// add spilled items
+	if q.spill != nil {
+		return q.count + q.spill.len()
+	}
+
 	return q.count
 }
 
+// Spill will enable spilling of messages to a segment file in the specified
+// directory. Instead of dropping the oldest message when the queue is full,
+// it is appended to the segment file and reloaded once the messages before it
+// have been popped. This keeps the memory usage of the queue bounded while
+// messages survive long outages of the consumer. The segment file is only
+// created when the queue overflows and removed once it has been drained. Close
+// must be called to remove a remaining segment file once the queue is not used
+// anymore.
+func (q *MessageQueue) Spill(dir string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// check existing
+	if q.spill != nil {
+		return nil
+	}
+
+	// create spill file
+	spill, err := newSpillFile(dir, q.Cipher)
+	if err != nil {
+		return err
+	}
+
+	// set spill file
+	q.spill = spill
+
+	return nil
+}
+
+// Close will remove the segment file if spilling has been enabled. The queue
+// may be used afterwards but will drop messages again.
+func (q *MessageQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// check spill file
+	if q.spill == nil {
+		return nil
+	}
+
+	// close spill file
+	err := q.spill.close()
+	q.spill = nil
+
+	return err
+}
+
 // Reset returns and removes all messages from the queue.
 func (q *MessageQueue) Reset() {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
 
 	// reset state
-	q.nodes = make([]*packet.Message, q.size)
+	q.nodes = make([]*storedMessage, q.size)
 	q.head = 0
 	q.tail = 0
 	q.count = 0
+
+	// reset spill file
+	if q.spill != nil {
+		q.spill.reset()
+	}
+}
+
+func (q *MessageQueue) pop() *storedMessage {
+	if q.count == 0 {
+		return nil
+	}
+
+	// remove item
+	node := q.nodes[q.tail]
+	q.nodes[q.tail] = nil
+	q.count--
+	q.tail = q.wrap(q.tail + 1)
+
+	return node
+}
+
+func (q *MessageQueue) popSpilled() *storedMessage {
+	if q.spill == nil || q.spill.len() == 0 {
+		return nil
+	}
+
+	// read item
+	node, err := q.spill.read()
+	if err != nil {
+		// drop unreadable items
+		q.spill.reset()
+		return nil
+	}
+
+	return node
 }
 
 func (q *MessageQueue) wrap(i int) int {
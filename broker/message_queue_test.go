@@ -1,7 +1,12 @@
 package broker
 
 import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/stretchr/testify/assert"
@@ -46,6 +51,54 @@ func TestMessageQueue(t *testing.T) {
 	assert.Equal(t, 0, queue.Len())
 }
 
+func TestMessageQueueExpiry(t *testing.T) {
+	msg1 := &packet.Message{Topic: "m1", Expiry: 10 * time.Millisecond}
+	msg2 := &packet.Message{Topic: "m2", Expiry: time.Minute}
+	msg3 := &packet.Message{Topic: "m3"}
+
+	queue := NewMessageQueue(3)
+	queue.Push(msg1)
+	queue.Push(msg2)
+	queue.Push(msg3)
+
+	time.Sleep(20 * time.Millisecond)
+
+	var list []string
+	queue.Range(func(msg *packet.Message) bool {
+		list = append(list, msg.Topic)
+		return true
+	})
+	assert.Equal(t, []string{"m2", "m3"}, list)
+
+	msg := queue.Pop()
+	assert.Equal(t, "m2", msg.Topic)
+	assert.True(t, msg.Expiry < time.Minute-20*time.Millisecond)
+	assert.True(t, msg.Expiry > 0)
+	assert.Equal(t, time.Minute, msg2.Expiry)
+
+	msg = queue.Pop()
+	assert.Equal(t, msg3, msg)
+
+	msg = queue.Pop()
+	assert.Nil(t, msg)
+}
+
+func TestMessageQueueTTL(t *testing.T) {
+	msg1 := &packet.Message{Topic: "m1"}
+	msg2 := &packet.Message{Topic: "m2", Expiry: time.Minute}
+
+	queue := NewMessageQueue(2)
+	queue.TTL = 10 * time.Millisecond
+	queue.Push(msg1)
+	queue.Push(msg2)
+
+	time.Sleep(20 * time.Millisecond)
+
+	msg := queue.Pop()
+	assert.Nil(t, msg)
+	assert.Equal(t, 0, queue.Len())
+}
+
 func BenchmarkMessageQueue(b *testing.B) {
 	b.ReportAllocs()
 	q := NewMessageQueue(100)
@@ -59,3 +112,122 @@ func BenchmarkMessageQueue(b *testing.B) {
 		q.Pop()
 	}
 }
+
+func TestMessageQueueSpill(t *testing.T) {
+	dir := t.TempDir()
+
+	queue := NewMessageQueue(2)
+	assert.NoError(t, queue.Spill(dir))
+
+	var msgs []*packet.Message
+	for i := 0; i < 5; i++ {
+		msg := &packet.Message{
+			Topic:   fmt.Sprintf("m%d", i),
+			Payload: []byte(fmt.Sprintf("p%d", i)),
+			QOS:     1,
+			Retain:  i%2 == 0,
+		}
+
+		msgs = append(msgs, msg)
+		assert.NoError(t, queue.Push(msg))
+
+		if i == 1 {
+			files, err := os.ReadDir(dir)
+			assert.NoError(t, err)
+			assert.Len(t, files, 0)
+		}
+	}
+
+	assert.Equal(t, 5, queue.Len())
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	var list []*packet.Message
+	queue.Range(func(msg *packet.Message) bool {
+		list = append(list, msg)
+		return true
+	})
+	assert.Equal(t, msgs, list)
+
+	assert.Equal(t, msgs[0], queue.Pop())
+	assert.Equal(t, msgs[1], queue.Pop())
+
+	msg := &packet.Message{Topic: "m5"}
+	queue.Push(msg)
+	msgs = append(msgs, msg)
+
+	for _, msg := range msgs[2:] {
+		assert.Equal(t, msg, queue.Pop())
+	}
+
+	assert.Nil(t, queue.Pop())
+	assert.Equal(t, 0, queue.Len())
+
+	files, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+
+	assert.NoError(t, queue.Close())
+
+	files, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+}
+
+func TestMessageQueueSpillError(t *testing.T) {
+	dir := t.TempDir()
+
+	queue := NewMessageQueue(1)
+	assert.NoError(t, queue.Spill(dir))
+	assert.NoError(t, os.Remove(dir))
+
+	msg1 := &packet.Message{Topic: "m1"}
+	msg2 := &packet.Message{Topic: "m2"}
+
+	assert.NoError(t, queue.Push(msg1))
+	assert.Error(t, queue.Push(msg2))
+
+	assert.Equal(t, 1, queue.Len())
+	assert.Equal(t, msg2, queue.Pop())
+	assert.NoError(t, queue.Close())
+
+	assert.Error(t, NewMessageQueue(1).Spill(dir))
+}
+
+func TestMessageQueueSpillCipher(t *testing.T) {
+	dir := t.TempDir()
+
+	aead, err := NewSpillCipher(bytes.Repeat([]byte{1}, 32))
+	assert.NoError(t, err)
+
+	queue := NewMessageQueue(1)
+	queue.Cipher = aead
+	assert.NoError(t, queue.Spill(dir))
+
+	msg1 := &packet.Message{Topic: "secret", Payload: []byte("payload"), QOS: 1}
+	msg2 := &packet.Message{Topic: "test", Payload: []byte("test")}
+
+	queue.Push(msg1)
+	queue.Push(msg2)
+
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	data, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, data)
+	assert.False(t, bytes.Contains(data, []byte("secret")))
+	assert.False(t, bytes.Contains(data, []byte("payload")))
+
+	assert.Equal(t, msg1, queue.Pop())
+	assert.Equal(t, msg2, queue.Pop())
+	assert.Nil(t, queue.Pop())
+
+	assert.NoError(t, queue.Close())
+
+	_, err = NewSpillCipher([]byte("short"))
+	assert.Error(t, err)
+}
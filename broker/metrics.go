@@ -0,0 +1,197 @@
+package broker
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A StatsBackend is a Backend that is able to report the number of stored
+// subscriptions and retained messages to Metrics.
+type StatsBackend interface {
+	Backend
+
+	// SubscriptionCount should return the number of active subscriptions.
+	SubscriptionCount() int
+
+	// RetainedCount should return the number of retained messages.
+	RetainedCount() int
+}
+
+var _ StatsBackend = (*MemoryBackend)(nil)
+
+// A MetricsSnapshot holds the values of Metrics at a specific time.
+type MetricsSnapshot struct {
+	// The number of connected clients.
+	Clients int
+
+	// The number of messages queued for delivery to clients.
+	Queued int
+
+	// Whether the engine is draining.
+	Draining bool
+
+	// The number of active subscriptions and retained messages. The values
+	// are only available if the backend implements StatsBackend.
+	Subscriptions int
+	Retained      int
+
+	// The number of published and forwarded messages per QOS level.
+	Published [3]uint64
+	Forwarded [3]uint64
+
+	// The number of messages dropped because of load shedding.
+	Dropped uint64
+
+	// The number and total of delivery latencies reported using
+	// ObserveLatency.
+	LatencyCount uint64
+	LatencySum   time.Duration
+}
+
+// Metrics collects statistics about an Engine. The counters are updated by
+// Log which must be called from the engines Logger. The metrics can be
+// exported using expvar or served in the Prometheus text format. The number of
+// clients and queued messages can be used as load metrics by autoscalers e.g.
+// through a Prometheus adapter or the KEDA metrics API scaler.
+type Metrics struct {
+	engine *Engine
+
+	published [3]uint64
+	forwarded [3]uint64
+	dropped   uint64
+
+	latencyCount uint64
+	latencySum   int64
+}
+
+// NewMetrics returns new Metrics for the specified engine.
+func NewMetrics(engine *Engine) *Metrics {
+	return &Metrics{
+		engine: engine,
+	}
+}
+
+// Log updates the counters using the specified event. It can be used directly
+// as the engines Logger or called from a custom Logger.
+func (m *Metrics) Log(event LogEvent, client *Client, pkt packet.GenericPacket, msg *packet.Message, err error) {
+	switch event {
+	case MessagePublished:
+		atomic.AddUint64(&m.published[qosIndex(msg)], 1)
+	case MessageForwarded:
+		atomic.AddUint64(&m.forwarded[qosIndex(msg)], 1)
+	case MessageDropped:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+// ObserveLatency records the delivery latency of a message. It can be called
+// from a client.LatencyCallback to track the latency measured by subscribers.
+func (m *Metrics) ObserveLatency(latency time.Duration) {
+	atomic.AddInt64(&m.latencySum, int64(latency))
+	atomic.AddUint64(&m.latencyCount, 1)
+}
+
+// Snapshot returns the current values.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	// get clients
+	clients := m.engine.Clients()
+
+	// prepare snapshot
+	snapshot := MetricsSnapshot{
+		Clients:  len(clients),
+		Draining: m.engine.Draining(),
+		Dropped:  atomic.LoadUint64(&m.dropped),
+
+		LatencyCount: atomic.LoadUint64(&m.latencyCount),
+		LatencySum:   time.Duration(atomic.LoadInt64(&m.latencySum)),
+	}
+
+	// count queued messages
+	for _, client := range clients {
+		snapshot.Queued += client.queued()
+	}
+
+	// get counters
+	for i := range snapshot.Published {
+		snapshot.Published[i] = atomic.LoadUint64(&m.published[i])
+		snapshot.Forwarded[i] = atomic.LoadUint64(&m.forwarded[i])
+	}
+
+	// get backend stats if available
+	if backend, ok := m.engine.Backend.(StatsBackend); ok {
+		snapshot.Subscriptions = backend.SubscriptionCount()
+		snapshot.Retained = backend.RetainedCount()
+	}
+
+	return snapshot
+}
+
+// Publish exports the metrics with the specified name using expvar.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// get snapshot
+	s := m.Snapshot()
+
+	// set content type
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	// write gauges
+	writeMetric(w, "gomqtt_clients", "gauge", "The number of connected clients.")
+	fmt.Fprintf(w, "gomqtt_clients %d\n", s.Clients)
+	writeMetric(w, "gomqtt_subscriptions", "gauge", "The number of active subscriptions.")
+	fmt.Fprintf(w, "gomqtt_subscriptions %d\n", s.Subscriptions)
+	writeMetric(w, "gomqtt_retained_messages", "gauge", "The number of retained messages.")
+	fmt.Fprintf(w, "gomqtt_retained_messages %d\n", s.Retained)
+	writeMetric(w, "gomqtt_queued_messages", "gauge", "The number of messages queued for delivery.")
+	fmt.Fprintf(w, "gomqtt_queued_messages %d\n", s.Queued)
+	writeMetric(w, "gomqtt_draining", "gauge", "Whether the broker is draining.")
+	fmt.Fprintf(w, "gomqtt_draining %d\n", boolToInt(s.Draining))
+
+	// write counters
+	writeMetric(w, "gomqtt_published_messages_total", "counter", "The number of published messages.")
+	for qos, n := range s.Published {
+		fmt.Fprintf(w, "gomqtt_published_messages_total{qos=\"%d\"} %d\n", qos, n)
+	}
+	writeMetric(w, "gomqtt_forwarded_messages_total", "counter", "The number of forwarded messages.")
+	for qos, n := range s.Forwarded {
+		fmt.Fprintf(w, "gomqtt_forwarded_messages_total{qos=\"%d\"} %d\n", qos, n)
+	}
+	writeMetric(w, "gomqtt_dropped_messages_total", "counter", "The number of dropped messages.")
+	fmt.Fprintf(w, "gomqtt_dropped_messages_total %d\n", s.Dropped)
+
+	// write summary
+	writeMetric(w, "gomqtt_delivery_latency_seconds", "summary", "The delivery latency of stamped messages.")
+	fmt.Fprintf(w, "gomqtt_delivery_latency_seconds_sum %g\n", s.LatencySum.Seconds())
+	fmt.Fprintf(w, "gomqtt_delivery_latency_seconds_count %d\n", s.LatencyCount)
+}
+
+func writeMetric(w http.ResponseWriter, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func qosIndex(msg *packet.Message) int {
+	if msg == nil || msg.QOS > 2 {
+		return 0
+	}
+
+	return int(msg.QOS)
+}
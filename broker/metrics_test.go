@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	engine := NewEngine()
+	metrics := NewMetrics(engine)
+	engine.Logger = metrics.Log
+
+	port, quit, done := Run(engine, "tcp")
+
+	c := client.New()
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("test", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	pf, err := c.Publish("test", []byte("test"), 1, true)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	pf, err = c.Publish("test", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	time.Sleep(50 * time.Millisecond)
+
+	snapshot := metrics.Snapshot()
+	assert.Equal(t, 1, snapshot.Clients)
+	assert.Equal(t, 1, snapshot.Subscriptions)
+	assert.Equal(t, 1, snapshot.Retained)
+	assert.Equal(t, 0, snapshot.Queued)
+	assert.False(t, snapshot.Draining)
+	assert.Equal(t, [3]uint64{1, 1, 0}, snapshot.Published)
+	assert.Equal(t, [3]uint64{1, 1, 0}, snapshot.Forwarded)
+	assert.Equal(t, uint64(0), snapshot.Dropped)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, "# TYPE gomqtt_clients gauge\ngomqtt_clients 1\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_published_messages_total{qos=\"1\"} 1\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_dropped_messages_total 0\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_queued_messages 0\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_draining 0\n"))
+
+	assert.NoError(t, c.Disconnect())
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestMetricsLatency(t *testing.T) {
+	engine := NewEngine()
+	metrics := NewMetrics(engine)
+
+	port, quit, done := Run(engine, "tcp")
+
+	received := make(chan *packet.Message, 1)
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		received <- msg
+		return nil
+	}
+	c.LatencyCallback = func(msg *packet.Message, latency time.Duration) {
+		assert.Equal(t, "test", msg.Topic)
+		assert.True(t, latency >= 0)
+		metrics.ObserveLatency(latency)
+	}
+
+	config := client.NewConfig("tcp://localhost:" + port)
+	config.LatencyStamps = true
+
+	cf, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("test", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	pf, err := c.Publish("test", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	msg := <-received
+	assert.Equal(t, []byte("test"), msg.Payload)
+
+	snapshot := metrics.Snapshot()
+	assert.Equal(t, uint64(1), snapshot.LatencyCount)
+	assert.True(t, snapshot.LatencySum >= 0)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, "# TYPE gomqtt_delivery_latency_seconds summary\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_delivery_latency_seconds_count 1\n"))
+
+	assert.NoError(t, c.Disconnect())
+
+	close(quit)
+	safeReceive(done)
+}
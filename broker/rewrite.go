@@ -0,0 +1,120 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/256dpi/gomqtt/topic"
+)
+
+// ErrInvalidRewrite is returned if a rewrite rule produced an invalid topic or
+// subscription filter.
+var ErrInvalidRewrite = errors.New("invalid rewritten topic")
+
+// A RewriteRule rewrites topics of incoming messages and subscription filters
+// that match its pattern. Rewritten topics and filters are validated before
+// they are used. Publishes and wills with an invalid rewritten topic close the
+// connection and subscriptions with an invalid rewritten filter are rejected.
+//
+// Note: Only inbound topics and filters are rewritten. Messages are forwarded
+// to subscribers using the rewritten topic, even if the subscriber's filter
+// has been rewritten. A device that subscribes to "old/#" while a rule maps
+// "old/" to "new/" therefore receives messages on "new/..." topics. Migrating
+// a hierarchy thus requires that subscribers accept the new topics.
+type RewriteRule struct {
+	// The pattern that is matched against topics and filters.
+	Pattern *regexp.Regexp
+
+	// The template that is used to build the new topic or filter. It may
+	// reference submatches of the pattern using $1 or ${name}.
+	Template string
+
+	// Whether the rule applies to topics of published messages.
+	Messages bool
+
+	// Whether the rule applies to subscription filters.
+	Subscriptions bool
+}
+
+// NewRewriteRule compiles the specified pattern and returns a rule that applies
+// to both messages and subscriptions.
+func NewRewriteRule(pattern, template string) (*RewriteRule, error) {
+	// compile pattern
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RewriteRule{
+		Pattern:       re,
+		Template:      template,
+		Messages:      true,
+		Subscriptions: true,
+	}, nil
+}
+
+// Rewrite will return the rewritten topic and true if the rule matches the
+// topic. Otherwise it returns the unchanged topic and false.
+func (r *RewriteRule) Rewrite(topic string) (string, bool) {
+	// find submatches
+	match := r.Pattern.FindStringSubmatchIndex(topic)
+	if match == nil {
+		return topic, false
+	}
+
+	// expand template
+	result := r.Pattern.ExpandString(nil, r.Template, topic, match)
+
+	return string(result), true
+}
+
+// rewriteTopic applies the first matching rule to the specified topic and
+// validates the result.
+func rewriteTopic(rules []*RewriteRule, name string, subscription bool) (string, error) {
+	for _, rule := range rules {
+		// check applicability
+		if subscription && !rule.Subscriptions || !subscription && !rule.Messages {
+			continue
+		}
+
+		// apply rule
+		result, ok := rule.Rewrite(name)
+		if !ok {
+			continue
+		}
+
+		// validate result
+		var err error
+		if subscription {
+			err = topic.Validate(result)
+		} else {
+			err = topic.ValidateName(result)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %q: %v", ErrInvalidRewrite, result, err)
+		}
+
+		// check for empty levels introduced by the template
+		if emptyLevels(result) > emptyLevels(name) {
+			return "", fmt.Errorf("%w: %q: empty level", ErrInvalidRewrite, result)
+		}
+
+		return result, nil
+	}
+
+	return name, nil
+}
+
+// emptyLevels returns the number of empty levels in the specified topic
+func emptyLevels(name string) int {
+	var count int
+	for _, level := range strings.Split(name, "/") {
+		if level == "" {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,110 @@
+package broker
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewriteRule(t *testing.T) {
+	rule, err := NewRewriteRule(`^old/(?P<device>[^/]+)/(.+)$`, "new/${device}/data/$2")
+	assert.NoError(t, err)
+
+	topic, ok := rule.Rewrite("old/foo/temp")
+	assert.True(t, ok)
+	assert.Equal(t, "new/foo/data/temp", topic)
+
+	topic, ok = rule.Rewrite("other/foo/temp")
+	assert.False(t, ok)
+	assert.Equal(t, "other/foo/temp", topic)
+
+	_, err = NewRewriteRule(`(`, "")
+	assert.Error(t, err)
+}
+
+func TestRewriteTopic(t *testing.T) {
+	rules := []*RewriteRule{
+		{Pattern: regexp.MustCompile(`^a$`), Template: "b", Messages: true},
+		{Pattern: regexp.MustCompile(`^a$`), Template: "c", Subscriptions: true},
+		{Pattern: regexp.MustCompile(`^a$`), Template: "d", Messages: true, Subscriptions: true},
+	}
+
+	topic, err := rewriteTopic(rules, "a", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", topic)
+
+	topic, err = rewriteTopic(rules, "a", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", topic)
+
+	topic, err = rewriteTopic(rules, "x", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "x", topic)
+}
+
+func TestRewriteTopicValidation(t *testing.T) {
+	rules := []*RewriteRule{
+		{Pattern: regexp.MustCompile(`^wild/(.*)$`), Template: "new/+/$1", Messages: true, Subscriptions: true},
+		{Pattern: regexp.MustCompile(`^empty/(?P<id>[^/]*)(/.*)?$`), Template: "new/${id}/${missing}$2", Messages: true, Subscriptions: true},
+	}
+
+	_, err := rewriteTopic(rules, "wild/foo", false)
+	assert.ErrorIs(t, err, ErrInvalidRewrite)
+
+	topic, err := rewriteTopic(rules, "wild/foo", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "new/+/foo", topic)
+
+	_, err = rewriteTopic(rules, "wild/foo/#/bar", true)
+	assert.ErrorIs(t, err, ErrInvalidRewrite)
+
+	_, err = rewriteTopic(rules, "empty/foo/bar", false)
+	assert.ErrorIs(t, err, ErrInvalidRewrite)
+
+	_, err = rewriteTopic(rules, "empty/foo/bar", true)
+	assert.ErrorIs(t, err, ErrInvalidRewrite)
+}
+
+func TestEngineRewriteRules(t *testing.T) {
+	rule, err := NewRewriteRule(`^old/(.+)$`, "new/$1")
+	assert.NoError(t, err)
+
+	engine := NewEngine()
+	engine.RewriteRules = []*RewriteRule{rule}
+
+	port, quit, done := Run(engine, "tcp")
+
+	wait := make(chan struct{})
+
+	c := client.New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "new/foo", msg.Topic)
+		close(wait)
+		return nil
+	}
+
+	cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, cf.Wait(10*time.Second))
+
+	sf, err := c.Subscribe("old/+", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, sf.Wait(10*time.Second))
+
+	pf, err := c.Publish("old/foo", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	safeReceive(wait)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	close(quit)
+	safeReceive(done)
+}
@@ -0,0 +1,272 @@
+package broker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// the length of the fixed part of a record
+const spillHeaderLen = 4 + 8 + 8 + 8 + 1 + 1 + 2
+
+var errSpillCorrupted = errors.New("corrupted spill file")
+
+// a spillFile stores messages in an append only segment file and keeps an
+// index of the record offsets in memory. The segment file is created with the
+// first record and removed once all records have been read.
+type spillFile struct {
+	dir    string
+	file   *os.File
+	cipher cipher.AEAD
+	index  []int64
+	next   int
+	end    int64
+}
+
+// NewSpillCipher returns an AES-GCM cipher for the specified key that can be
+// used to encrypt spilled messages. The key must be 16, 24 or 32 bytes long.
+func NewSpillCipher(key []byte) (cipher.AEAD, error) {
+	// create block cipher
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func newSpillFile(dir string, aead cipher.AEAD) (*spillFile, error) {
+	// check directory
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("spill directory %q is not a directory", dir)
+	}
+
+	return &spillFile{
+		dir:    dir,
+		cipher: aead,
+	}, nil
+}
+
+// returns the number of unread messages
+func (s *spillFile) len() int {
+	return len(s.index) - s.next
+}
+
+// appends a message to the segment
+func (s *spillFile) write(m *storedMessage) error {
+	// check topic
+	if len(m.msg.Topic) > 0xFFFF {
+		return errSpillCorrupted
+	}
+
+	// prepare buffer
+	buf := make([]byte, spillHeaderLen+len(m.msg.Topic)+len(m.msg.Payload))
+
+	// encode record
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+	binary.BigEndian.PutUint64(buf[4:], uint64(m.received.UnixNano()))
+	binary.BigEndian.PutUint64(buf[12:], uint64(unixNano(m.deadline)))
+	binary.BigEndian.PutUint64(buf[20:], uint64(m.msg.Expiry))
+	buf[28] = m.msg.QOS
+	if m.msg.Retain {
+		buf[29] = 1
+	}
+	binary.BigEndian.PutUint16(buf[30:], uint16(len(m.msg.Topic)))
+	copy(buf[spillHeaderLen:], m.msg.Topic)
+	copy(buf[spillHeaderLen+len(m.msg.Topic):], m.msg.Payload)
+
+	// encrypt record if requested
+	if s.cipher != nil {
+		// generate nonce
+		nonce := make([]byte, s.cipher.NonceSize())
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return err
+		}
+
+		// seal record and prepend length
+		sealed := s.cipher.Seal(nonce, nonce, buf[4:], nil)
+		buf = make([]byte, 4+len(sealed))
+		binary.BigEndian.PutUint32(buf, uint32(len(sealed)))
+		copy(buf[4:], sealed)
+	}
+
+	// create file if missing
+	if s.file == nil {
+		file, err := os.CreateTemp(s.dir, "gomqtt-queue-*.seg")
+		if err != nil {
+			return err
+		}
+
+		s.file = file
+	}
+
+	// write record
+	_, err := s.file.WriteAt(buf, s.end)
+	if err != nil {
+		return err
+	}
+
+	// update index
+	s.index = append(s.index, s.end)
+	s.end += int64(len(buf))
+
+	return nil
+}
+
+// reads the record at the specified position of the index
+func (s *spillFile) readAt(i int) (*storedMessage, error) {
+	// read length
+	var length [4]byte
+	_, err := s.file.ReadAt(length[:], s.index[i])
+	if err != nil {
+		return nil, err
+	}
+
+	// read record
+	buf := make([]byte, binary.BigEndian.Uint32(length[:]))
+	_, err = s.file.ReadAt(buf, s.index[i]+4)
+	if err == io.EOF {
+		return nil, errSpillCorrupted
+	} else if err != nil {
+		return nil, err
+	}
+
+	// decrypt record if requested
+	if s.cipher != nil {
+		// check length
+		if len(buf) < s.cipher.NonceSize() {
+			return nil, errSpillCorrupted
+		}
+
+		// open record
+		nonce := buf[:s.cipher.NonceSize()]
+		buf, err = s.cipher.Open(nil, nonce, buf[s.cipher.NonceSize():], nil)
+		if err != nil {
+			return nil, errSpillCorrupted
+		}
+	}
+
+	// check length
+	if len(buf) < spillHeaderLen-4 {
+		return nil, errSpillCorrupted
+	}
+
+	// check topic length
+	topicLen := int(binary.BigEndian.Uint16(buf[26:]))
+	if len(buf) < spillHeaderLen-4+topicLen {
+		return nil, errSpillCorrupted
+	}
+
+	// decode record
+	m := &storedMessage{
+		msg: &packet.Message{
+			Topic:   string(buf[28 : 28+topicLen]),
+			Payload: buf[28+topicLen:],
+			QOS:     buf[24],
+			Retain:  buf[25] == 1,
+			Expiry:  time.Duration(binary.BigEndian.Uint64(buf[16:])),
+		},
+		received: time.Unix(0, int64(binary.BigEndian.Uint64(buf))),
+	}
+
+	// set deadline
+	if deadline := int64(binary.BigEndian.Uint64(buf[8:])); deadline != 0 {
+		m.deadline = time.Unix(0, deadline)
+	}
+
+	return m, nil
+}
+
+// reads and removes the oldest message
+func (s *spillFile) read() (*storedMessage, error) {
+	// check length
+	if s.len() == 0 {
+		return nil, nil
+	}
+
+	// read record
+	m, err := s.readAt(s.next)
+	if err != nil {
+		return nil, err
+	}
+
+	// advance
+	s.next++
+
+	// remove file if all records have been read
+	if s.len() == 0 {
+		err = s.reset()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// calls fn with all unread messages
+func (s *spillFile) scan(fn func(*storedMessage) bool) error {
+	for i := s.next; i < len(s.index); i++ {
+		// read record
+		m, err := s.readAt(i)
+		if err != nil {
+			return err
+		}
+
+		if !fn(m) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// removes all messages and the file
+func (s *spillFile) reset() error {
+	// reset index
+	s.index = nil
+	s.next = 0
+	s.end = 0
+
+	return s.close()
+}
+
+// closes and removes the file
+func (s *spillFile) close() error {
+	// check file
+	if s.file == nil {
+		return nil
+	}
+
+	// get file
+	file := s.file
+	s.file = nil
+
+	// close file
+	err := file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(file.Name())
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixNano()
+}
@@ -0,0 +1,454 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+	"github.com/gorilla/websocket"
+)
+
+// ErrTapTarget is returned by Engine.Tap if neither a client id nor a topic
+// filter has been specified.
+var ErrTapTarget = errors.New("missing tap client id or filter")
+
+// ErrTapDuration is returned by Engine.Tap if the duration is not positive.
+var ErrTapDuration = errors.New("invalid tap duration")
+
+// A TapEvent describes a packet that has been mirrored by a Tap.
+type TapEvent struct {
+	Time      time.Time `json:"time"`
+	ClientID  string    `json:"client_id"`
+	Direction string    `json:"direction"`
+	Type      string    `json:"type"`
+	Topic     string    `json:"topic,omitempty"`
+	Packet    string    `json:"packet"`
+}
+
+// A TapSink receives the events of a Tap.
+//
+// Note: Tap is called synchronously from the client goroutines and should
+// therefore not block.
+type TapSink interface {
+	Tap(event *TapEvent)
+}
+
+// A Tap mirrors the packets that are received from and sent to a client or
+// that carry a topic matching a filter to a sink. The tap is removed
+// automatically once it expires.
+type Tap struct {
+	// The client whose packets are mirrored.
+	ClientID string
+
+	// The filter that the topics of mirrored packets must match.
+	Filter string
+
+	// The time the tap expires.
+	Expires time.Time
+
+	engine *Engine
+	sink   TapSink
+	timer  *time.Timer
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Tap will mirror the packets of the specified client or topic filter to the
+// sink until the duration has elapsed or the tap is closed. If both a client
+// id and a filter are specified, only packets of that client with a matching
+// topic are mirrored.
+func (e *Engine) Tap(clientID, filter string, duration time.Duration, sink TapSink) (*Tap, error) {
+	// check target
+	if clientID == "" && filter == "" {
+		return nil, ErrTapTarget
+	}
+
+	// check filter
+	if filter != "" {
+		var err error
+		filter, err = topic.Parse(filter, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// check duration
+	if duration <= 0 {
+		return nil, ErrTapDuration
+	}
+
+	// prepare tap
+	tap := &Tap{
+		ClientID: clientID,
+		Filter:   filter,
+		Expires:  time.Now().Add(duration),
+		engine:   e,
+		sink:     sink,
+		done:     make(chan struct{}),
+	}
+
+	// add tap and expire it later
+	e.tapMutex.Lock()
+	e.taps = append(e.taps, tap)
+	tap.timer = time.AfterFunc(duration, tap.Close)
+	e.tapMutex.Unlock()
+
+	return tap, nil
+}
+
+// Taps returns a list of the currently active taps.
+func (e *Engine) Taps() []*Tap {
+	// acquire mutex
+	e.tapMutex.RLock()
+	defer e.tapMutex.RUnlock()
+
+	// copy list
+	taps := make([]*Tap, len(e.taps))
+	copy(taps, e.taps)
+
+	return taps
+}
+
+// mirror the packet to all matching taps
+func (e *Engine) tap(client *Client, pkt packet.GenericPacket, received bool) {
+	// acquire mutex
+	e.tapMutex.RLock()
+	defer e.tapMutex.RUnlock()
+
+	// check taps
+	if len(e.taps) == 0 {
+		return
+	}
+
+	// get client id
+	clientID := client.ClientID()
+	if connect, ok := pkt.(*packet.ConnectPacket); ok {
+		clientID = connect.ClientID
+	}
+
+	// get topics
+	topics := packetTopics(pkt)
+
+	var event *TapEvent
+	for _, tap := range e.taps {
+		// check client
+		if tap.ClientID != "" && tap.ClientID != clientID {
+			continue
+		}
+
+		// check topics
+		eventTopic, ok := tap.match(topics)
+		if !ok {
+			continue
+		}
+
+		// prepare event
+		if event == nil || event.Topic != eventTopic {
+			event = &TapEvent{
+				Time:      time.Now(),
+				ClientID:  clientID,
+				Direction: "sent",
+				Type:      pkt.Type().String(),
+				Topic:     eventTopic,
+				Packet:    redactPacket(pkt).String(),
+			}
+			if received {
+				event.Direction = "received"
+			}
+		}
+
+		tap.sink.Tap(event)
+	}
+}
+
+// Done returns a channel that is closed once the tap has expired or has been
+// closed.
+func (t *Tap) Done() <-chan struct{} {
+	return t.done
+}
+
+// Close will remove the tap from the engine.
+func (t *Tap) Close() {
+	t.once.Do(func() {
+		// acquire mutex
+		t.engine.tapMutex.Lock()
+
+		// stop timer
+		t.timer.Stop()
+
+		// remove tap
+		for i, tap := range t.engine.taps {
+			if tap == t {
+				t.engine.taps = append(t.engine.taps[:i], t.engine.taps[i+1:]...)
+				break
+			}
+		}
+		t.engine.tapMutex.Unlock()
+
+		// signal close
+		close(t.done)
+	})
+}
+
+// returns the first topic that matches the filter of the tap
+func (t *Tap) match(topics []string) (string, bool) {
+	// check filter
+	if t.Filter == "" {
+		if len(topics) > 0 {
+			return topics[0], true
+		}
+
+		return "", true
+	}
+
+	// match topics
+	for _, tpc := range topics {
+		if _, ok := topic.Extract(t.Filter, tpc); ok {
+			return tpc, true
+		}
+	}
+
+	return "", false
+}
+
+// the value that replaces redacted credentials
+const redacted = "<redacted>"
+
+// returns a copy of the packet without passwords, authentication data and will
+// payloads as these must not be mirrored
+func redactPacket(pkt packet.GenericPacket) packet.GenericPacket {
+	switch pkt := pkt.(type) {
+	case *packet.ConnectPacket:
+		copied := *pkt
+		if copied.Password != "" {
+			copied.Password = redacted
+		}
+		if copied.Will != nil {
+			copied.Will = copied.Will.Copy()
+			copied.Will.Payload = nil
+		}
+		copied.Properties = redactProperties(copied.Properties)
+		return &copied
+	case *packet.AuthPacket:
+		copied := *pkt
+		copied.Properties = redactProperties(copied.Properties)
+		return &copied
+	}
+
+	return pkt
+}
+
+// returns the properties without authentication data
+func redactProperties(properties packet.Properties) packet.Properties {
+	if _, ok := properties.Get(packet.AuthDataProperty); ok {
+		return properties.Set(packet.AuthDataProperty, []byte(redacted))
+	}
+
+	return properties
+}
+
+// returns the topics and filters carried by the packet
+func packetTopics(pkt packet.GenericPacket) []string {
+	switch pkt := pkt.(type) {
+	case *packet.PublishPacket:
+		return []string{pkt.Message.Topic}
+	case *packet.SubscribePacket:
+		topics := make([]string, 0, len(pkt.Subscriptions))
+		for _, sub := range pkt.Subscriptions {
+			topics = append(topics, sub.Topic)
+		}
+		return topics
+	case *packet.UnsubscribePacket:
+		return pkt.Topics
+	case *packet.ConnectPacket:
+		if pkt.Will != nil {
+			return []string{pkt.Will.Topic}
+		}
+	}
+
+	return nil
+}
+
+// A TopicTapSink publishes tap events as JSON to a topic using the backend.
+// The events are queued and published in the background. Events are dropped if
+// the queue is full. Events about the topic itself are ignored to prevent
+// feedback loops.
+type TopicTapSink struct {
+	backend Backend
+	topic   string
+	queue   chan *TapEvent
+	done    chan struct{}
+}
+
+// NewTopicTapSink returns a new TopicTapSink that publishes to the specified
+// topic and queues up to queueSize events.
+func NewTopicTapSink(backend Backend, topic string, queueSize int) *TopicTapSink {
+	s := &TopicTapSink{
+		backend: backend,
+		topic:   topic,
+		queue:   make(chan *TapEvent, queueSize),
+		done:    make(chan struct{}),
+	}
+
+	// run publisher
+	go s.publisher()
+
+	return s
+}
+
+// Tap queues the event.
+func (s *TopicTapSink) Tap(event *TapEvent) {
+	// ignore own messages
+	if event.Topic == s.topic {
+		return
+	}
+
+	select {
+	case s.queue <- event:
+	default:
+	}
+}
+
+// Close will publish the remaining queued events and stop the sink. Tap must
+// not be called after Close.
+func (s *TopicTapSink) Close() {
+	close(s.queue)
+	<-s.done
+}
+
+func (s *TopicTapSink) publisher() {
+	defer close(s.done)
+
+	for event := range s.queue {
+		// encode event
+		buf, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+
+		// publish event
+		_ = s.backend.Publish(nil, &packet.Message{
+			Topic:   s.topic,
+			Payload: buf,
+		})
+	}
+}
+
+// the default and maximum duration of taps created by the TapHandler
+const (
+	defaultTapDuration = 5 * time.Minute
+	maxTapDuration     = time.Hour
+)
+
+// the number of events buffered per websocket stream
+const tapStreamBuffer = 256
+
+// TapHandler returns a http.Handler that streams tap events as JSON messages
+// over a WebSocket connection. The tap is configured using the "client",
+// "filter" and "duration" query parameters. The duration defaults to five
+// minutes and is limited to one hour. Events are dropped if the stream cannot
+// keep up.
+//
+// Note: The handler exposes the traffic of all clients and should only be
+// served on an administrative endpoint.
+func TapHandler(engine *Engine) http.Handler {
+	upgrader := &websocket.Upgrader{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// get parameters
+		query := r.URL.Query()
+		clientID := query.Get("client")
+		filter := query.Get("filter")
+
+		// parse duration
+		duration := defaultTapDuration
+		if str := query.Get("duration"); str != "" {
+			var err error
+			duration, err = time.ParseDuration(str)
+			if err != nil || duration <= 0 {
+				http.Error(w, ErrTapDuration.Error(), http.StatusBadRequest)
+				return
+			} else if duration > maxTapDuration {
+				duration = maxTapDuration
+			}
+		}
+
+		// check target
+		if clientID == "" && filter == "" {
+			http.Error(w, ErrTapTarget.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// check filter
+		if filter != "" {
+			_, err := topic.Parse(filter, true)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// upgrade connection
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// create tap
+		sink := make(tapChannel, tapStreamBuffer)
+		tap, err := engine.Tap(clientID, filter, duration, sink)
+		if err != nil {
+			return
+		}
+		defer tap.Close()
+
+		// detect closed connections
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case event := <-sink:
+				// encode event
+				buf, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+
+				// write event
+				err = conn.WriteMessage(websocket.TextMessage, buf)
+				if err != nil {
+					return
+				}
+			case <-tap.Done():
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "tap expired"))
+				return
+			case <-closed:
+				return
+			}
+		}
+	})
+}
+
+// a tapChannel is a TapSink that queues events in a channel and drops them if
+// the channel is full
+type tapChannel chan *TapEvent
+
+func (c tapChannel) Tap(event *TapEvent) {
+	select {
+	case c <- event:
+	default:
+	}
+}
@@ -0,0 +1,204 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// the default number of topics returned by the TopicStats handler
+const defaultHotTopics = 10
+
+// A TopicStat holds the statistics of a single topic.
+type TopicStat struct {
+	// The topic.
+	Topic string `json:"topic"`
+
+	// The number of published and forwarded messages.
+	Published uint64 `json:"published"`
+	Forwarded uint64 `json:"forwarded"`
+
+	// The number of published messages per second.
+	Rate float64 `json:"rate"`
+
+	// The average number of subscribers a published message has been
+	// forwarded to. Retained messages forwarded on subscription are included.
+	FanOut float64 `json:"fan_out"`
+}
+
+type topicCounter struct {
+	published uint64
+	forwarded uint64
+}
+
+// TopicStats tracks the publish rate and subscription match fan-out of every
+// topic to detect hot topics e.g. of misbehaving clients flooding the broker.
+// The counters are updated by Log which must be called from the engines
+// Logger. Statistics are calculated over the current and the previous window.
+// Topics that have not been used during both windows are forgotten.
+type TopicStats struct {
+	window time.Duration
+
+	current  map[string]*topicCounter
+	previous map[string]*topicCounter
+	start    time.Time
+	since    time.Time
+	mutex    sync.Mutex
+}
+
+// NewTopicStats returns new TopicStats that rotate the counters after the
+// specified window.
+func NewTopicStats(window time.Duration) *TopicStats {
+	now := time.Now()
+
+	return &TopicStats{
+		window:   window,
+		current:  make(map[string]*topicCounter),
+		previous: make(map[string]*topicCounter),
+		start:    now,
+		since:    now,
+	}
+}
+
+// Log updates the counters using the specified event. It can be used directly
+// as the engines Logger or called from a custom Logger.
+func (s *TopicStats) Log(event LogEvent, client *Client, pkt packet.GenericPacket, msg *packet.Message, err error) {
+	// check event
+	if msg == nil || (event != MessagePublished && event != MessageForwarded) {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// rotate counters
+	s.rotate(time.Now())
+
+	// get counter
+	counter, ok := s.current[msg.Topic]
+	if !ok {
+		counter = &topicCounter{}
+		s.current[msg.Topic] = counter
+	}
+
+	// increment counter
+	if event == MessagePublished {
+		counter.published++
+	} else {
+		counter.forwarded++
+	}
+}
+
+// Hot returns the statistics of the n topics with the highest publish rate.
+func (s *TopicStats) Hot(n int) []TopicStat {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// rotate counters
+	now := time.Now()
+	s.rotate(now)
+
+	// get covered duration
+	duration := now.Sub(s.since)
+	if duration <= 0 {
+		duration = time.Millisecond
+	}
+
+	// merge counters
+	merged := make(map[string]*topicCounter, len(s.current)+len(s.previous))
+	for _, counters := range []map[string]*topicCounter{s.previous, s.current} {
+		for topic, counter := range counters {
+			m, ok := merged[topic]
+			if !ok {
+				m = &topicCounter{}
+				merged[topic] = m
+			}
+
+			m.published += counter.published
+			m.forwarded += counter.forwarded
+		}
+	}
+
+	// prepare list
+	list := make([]TopicStat, 0, len(merged))
+	for topic, counter := range merged {
+		stat := TopicStat{
+			Topic:     topic,
+			Published: counter.published,
+			Forwarded: counter.forwarded,
+			Rate:      float64(counter.published) / duration.Seconds(),
+		}
+
+		if counter.published > 0 {
+			stat.FanOut = float64(counter.forwarded) / float64(counter.published)
+		}
+
+		list = append(list, stat)
+	}
+
+	// sort by published and forwarded messages
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Published != list[j].Published {
+			return list[i].Published > list[j].Published
+		} else if list[i].Forwarded != list[j].Forwarded {
+			return list[i].Forwarded > list[j].Forwarded
+		}
+
+		return list[i].Topic < list[j].Topic
+	})
+
+	// limit list
+	if n >= 0 && len(list) > n {
+		list = list[:n]
+	}
+
+	return list
+}
+
+// ServeHTTP serves the hottest topics as JSON. The number of topics is set
+// using the "n" query parameter and defaults to ten.
+//
+// Note: The handler exposes the topics of all clients and should only be
+// served on an administrative endpoint.
+func (s *TopicStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// parse number
+	n := defaultHotTopics
+	if str := r.URL.Query().Get("n"); str != "" {
+		var err error
+		n, err = strconv.Atoi(str)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid number of topics", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// write topics
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Hot(n))
+}
+
+func (s *TopicStats) rotate(now time.Time) {
+	// check window
+	elapsed := now.Sub(s.start)
+	if elapsed < s.window {
+		return
+	}
+
+	// drop both windows if the previous window has not been used
+	if elapsed >= 2*s.window {
+		s.previous = make(map[string]*topicCounter)
+		s.since = now
+	} else {
+		s.previous = s.current
+		s.since = s.start
+	}
+
+	// reset current window
+	s.current = make(map[string]*topicCounter)
+	s.start = now
+}
@@ -0,0 +1,89 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicStats(t *testing.T) {
+	engine := NewEngine()
+	stats := NewTopicStats(time.Minute)
+	engine.Logger = stats.Log
+
+	port, quit, done := Run(engine, "tcp")
+
+	var clients []*client.Client
+	for i := 0; i < 2; i++ {
+		c := client.New()
+		cf, err := c.Connect(client.NewConfig("tcp://localhost:" + port))
+		assert.NoError(t, err)
+		assert.NoError(t, cf.Wait(10*time.Second))
+
+		sf, err := c.Subscribe("foo", 0)
+		assert.NoError(t, err)
+		assert.NoError(t, sf.Wait(10*time.Second))
+
+		clients = append(clients, c)
+	}
+
+	for i := 0; i < 3; i++ {
+		pf, err := clients[0].Publish("foo", []byte("foo"), 0, false)
+		assert.NoError(t, err)
+		assert.NoError(t, pf.Wait(10*time.Second))
+	}
+
+	pf, err := clients[0].Publish("bar", []byte("bar"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(10*time.Second))
+
+	time.Sleep(50 * time.Millisecond)
+
+	hot := stats.Hot(10)
+	assert.Len(t, hot, 2)
+	assert.Equal(t, "foo", hot[0].Topic)
+	assert.Equal(t, uint64(3), hot[0].Published)
+	assert.Equal(t, uint64(6), hot[0].Forwarded)
+	assert.Equal(t, 2.0, hot[0].FanOut)
+	assert.True(t, hot[0].Rate > 0)
+	assert.Equal(t, "bar", hot[1].Topic)
+	assert.Equal(t, uint64(1), hot[1].Published)
+	assert.Equal(t, 0.0, hot[1].FanOut)
+
+	rec := httptest.NewRecorder()
+	stats.ServeHTTP(rec, httptest.NewRequest("GET", "/topics?n=1", nil))
+	var list []TopicStat
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list, 1)
+	assert.Equal(t, "foo", list[0].Topic)
+
+	rec = httptest.NewRecorder()
+	stats.ServeHTTP(rec, httptest.NewRequest("GET", "/topics?n=x", nil))
+	assert.Equal(t, 400, rec.Code)
+
+	for _, c := range clients {
+		assert.NoError(t, c.Disconnect())
+	}
+
+	close(quit)
+	safeReceive(done)
+}
+
+func TestTopicStatsWindow(t *testing.T) {
+	stats := NewTopicStats(20 * time.Millisecond)
+
+	msg := &packet.Message{Topic: "foo"}
+	stats.Log(MessagePublished, nil, nil, msg, nil)
+	assert.Len(t, stats.Hot(10), 1)
+
+	time.Sleep(25 * time.Millisecond)
+	assert.Len(t, stats.Hot(10), 1)
+
+	time.Sleep(45 * time.Millisecond)
+	assert.Len(t, stats.Hot(10), 0)
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/routines"
+)
+
+// An AlignedTicker delivers ticks at the wall clock boundaries of an interval
+// that are shifted by a random jitter. Devices that run the same firmware and
+// publish periodically spread their publishes around the boundaries instead
+// of publishing at the same time or drifting apart.
+//
+// The boundaries are multiples of the interval since the zero time. Intervals
+// that evenly divide a day are therefore aligned to the UTC wall clock e.g. an
+// interval of a minute ticks at the start of every minute.
+type AlignedTicker struct {
+	// The channel on which the boundaries of the ticks are delivered. The
+	// boundaries are delivered instead of the jittered time to allow devices
+	// to label their data consistently. Ticks are dropped if the receiver
+	// falls behind.
+	C <-chan time.Time
+
+	stop chan struct{}
+}
+
+// NewAlignedTicker returns a new ticker for the specified interval. Every tick
+// is delivered at a random offset between minus and plus the jitter around
+// its boundary. Boundaries whose jittered time has already passed are skipped.
+func NewAlignedTicker(interval, jitter time.Duration) *AlignedTicker {
+	// check interval
+	if interval <= 0 {
+		panic("non-positive interval for aligned ticker")
+	}
+
+	// prepare channels
+	ch := make(chan time.Time, 1)
+	stop := make(chan struct{})
+
+	// run ticker
+	routines.Go("client.ticker", func() {
+		boundary := time.Now().Truncate(interval)
+		for {
+			// get next boundary and jittered time
+			boundary = boundary.Add(interval)
+			at := boundary.Add(randomOffset(jitter))
+
+			// skip passed boundaries
+			wait := time.Until(at)
+			if wait <= 0 {
+				continue
+			}
+
+			// await time
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				return
+			}
+
+			// deliver boundary
+			select {
+			case ch <- boundary:
+			default:
+			}
+		}
+	})
+
+	return &AlignedTicker{
+		C:    ch,
+		stop: stop,
+	}
+}
+
+// Stop will stop the ticker. No more ticks are delivered afterwards.
+func (t *AlignedTicker) Stop() {
+	close(t.stop)
+}
+
+// PublishAligned will publish the messages returned by the function at the
+// wall clock boundaries of the interval shifted by a random jitter. See
+// AlignedTicker for details. The function is called with the boundary and
+// may return nil to skip a publish. The returned function stops publishing.
+func (s *Service) PublishAligned(interval, jitter time.Duration, fn func(boundary time.Time) *packet.Message) func() {
+	// create ticker
+	ticker := NewAlignedTicker(interval, jitter)
+
+	// prepare channel
+	done := make(chan struct{})
+
+	// publish messages
+	routines.Go("client.aligned", func() {
+		for {
+			select {
+			case boundary := <-ticker.C:
+				if msg := fn(boundary); msg != nil {
+					s.PublishMessage(msg)
+				}
+			case <-done:
+				return
+			}
+		}
+	})
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
+
+// randomOffset returns a random duration between minus and plus the jitter
+func randomOffset(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(2*jitter)+1)) - jitter
+}
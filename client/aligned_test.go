@@ -0,0 +1,56 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlignedTicker(t *testing.T) {
+	interval := 50 * time.Millisecond
+	jitter := 10 * time.Millisecond
+
+	ticker := NewAlignedTicker(interval, jitter)
+	defer ticker.Stop()
+
+	var last time.Time
+	for i := 0; i < 3; i++ {
+		boundary := <-ticker.C
+		now := time.Now()
+		assert.Equal(t, boundary, boundary.Truncate(interval))
+		assert.True(t, now.Sub(boundary) > -jitter-5*time.Millisecond)
+		assert.True(t, now.Sub(boundary) < jitter+5*time.Millisecond)
+		if !last.IsZero() {
+			assert.Equal(t, interval, boundary.Sub(last))
+		}
+		last = boundary
+	}
+}
+
+func TestRandomOffset(t *testing.T) {
+	assert.Equal(t, time.Duration(0), randomOffset(0))
+
+	for i := 0; i < 100; i++ {
+		offset := randomOffset(time.Second)
+		assert.True(t, offset >= -time.Second && offset <= time.Second)
+	}
+}
+
+func TestServicePublishAligned(t *testing.T) {
+	s := NewService()
+
+	stop := s.PublishAligned(20*time.Millisecond, 0, func(boundary time.Time) *packet.Message {
+		return &packet.Message{Topic: "test", Payload: []byte(boundary.String())}
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	n := s.QueueLength()
+	assert.True(t, n >= 2 && n <= 3)
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, n, s.QueueLength())
+}
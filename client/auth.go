@@ -0,0 +1,111 @@
+package client
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// ErrClientUnexpectedAuth is returned in the Callback if the broker sends an
+// AuthPacket that does not belong to the authentication exchange of the
+// configured Authenticator.
+var ErrClientUnexpectedAuth = errors.New("client unexpected auth")
+
+// ErrClientMissingAuthenticator is returned by Reauthenticate if no
+// Authenticator has been configured.
+var ErrClientMissingAuthenticator = errors.New("client missing authenticator")
+
+// An Authenticator performs the MQTT 5 enhanced authentication exchange of an
+// authentication method e.g. SCRAM or the use of refreshable OAuth tokens.
+type Authenticator interface {
+	// Method returns the name of the authentication method.
+	Method() string
+
+	// Initial returns the authentication data that is sent with the
+	// ConnectPacket or when a re-authentication is started.
+	Initial() ([]byte, error)
+
+	// Challenge is called with the authentication data of a challenge sent by
+	// the broker and returns the response data.
+	Challenge(data []byte) ([]byte, error)
+
+	// Success is called with the authentication data sent by the broker
+	// when the authentication has been completed successfully. A returned
+	// error closes the connection e.g. if the broker could not be verified.
+	Success(data []byte) error
+}
+
+// Reauthenticate will start a re-authentication using the configured
+// Authenticator. The connection is closed if the re-authentication fails.
+func (c *Client) Reauthenticate() error {
+	// check state
+	if atomic.LoadUint32(&c.state) != clientConnected {
+		return ErrClientNotConnected
+	}
+
+	// check authenticator
+	authenticator := c.config.Authenticator
+	if authenticator == nil {
+		return ErrClientMissingAuthenticator
+	}
+
+	// get data
+	data, err := authenticator.Initial()
+	if err != nil {
+		return err
+	}
+
+	return c.queue(authPacket(packet.ReAuthenticate, authenticator.Method(), data), true)
+}
+
+// handle an incoming AuthPacket
+func (c *Client) processAuth(auth *packet.AuthPacket) error {
+	// check authenticator and method
+	authenticator := c.config.Authenticator
+	method, _ := auth.Properties.Get(packet.AuthMethodProperty)
+	if authenticator == nil || method != authenticator.Method() {
+		return c.die(ErrClientUnexpectedAuth, true, false)
+	}
+
+	// get data
+	data, _ := auth.Properties.Get(packet.AuthDataProperty)
+	bytes, _ := data.([]byte)
+
+	switch auth.ReasonCode {
+	case packet.ContinueAuthentication:
+		// handle challenge
+		response, err := authenticator.Challenge(bytes)
+		if err != nil {
+			return c.die(err, true, false)
+		}
+
+		// send response
+		err = c.queue(authPacket(packet.ContinueAuthentication, authenticator.Method(), response), true)
+		if err != nil {
+			return c.die(err, false, false)
+		}
+	case packet.Success:
+		// complete authentication
+		err := authenticator.Success(bytes)
+		if err != nil {
+			return c.die(err, true, false)
+		}
+	default:
+		return c.die(ErrClientUnexpectedAuth, true, false)
+	}
+
+	return nil
+}
+
+// authPacket returns an AuthPacket with the specified method and data
+func authPacket(code packet.ReasonCode, method string, data []byte) *packet.AuthPacket {
+	auth := packet.NewAuthPacket()
+	auth.ReasonCode = code
+	auth.Properties = auth.Properties.Set(packet.AuthMethodProperty, method)
+	if data != nil {
+		auth.Properties = auth.Properties.Set(packet.AuthDataProperty, data)
+	}
+
+	return auth
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// a messageCache keeps the last message per topic for a limited number of
+// topics using a ring buffer of topics that evicts the oldest topic
+type messageCache struct {
+	messages map[string]*packet.Message
+	ring     []string
+	next     int
+	mutex    sync.RWMutex
+}
+
+func newMessageCache(size int) *messageCache {
+	return &messageCache{
+		messages: make(map[string]*packet.Message),
+		ring:     make([]string, size),
+	}
+}
+
+// stores a copy of the message
+func (c *messageCache) put(msg *packet.Message) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// update existing topic
+	if _, ok := c.messages[msg.Topic]; ok {
+		c.messages[msg.Topic] = msg.Copy()
+		return
+	}
+
+	// evict oldest topic if full
+	if len(c.messages) == len(c.ring) {
+		delete(c.messages, c.ring[c.next])
+	}
+
+	// add topic
+	c.messages[msg.Topic] = msg.Copy()
+	c.ring[c.next] = msg.Topic
+	c.next = (c.next + 1) % len(c.ring)
+}
+
+// returns a copy of the last message for the topic
+func (c *messageCache) get(topic string) *packet.Message {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	// get message
+	msg, ok := c.messages[topic]
+	if !ok {
+		return nil
+	}
+
+	return msg.Copy()
+}
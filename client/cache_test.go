@@ -0,0 +1,66 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageCache(t *testing.T) {
+	cache := newMessageCache(2)
+
+	cache.put(&packet.Message{Topic: "foo", Payload: []byte("1")})
+	cache.put(&packet.Message{Topic: "bar", Payload: []byte("2")})
+	cache.put(&packet.Message{Topic: "foo", Payload: []byte("3")})
+	assert.Equal(t, []byte("3"), cache.get("foo").Payload)
+	assert.Equal(t, []byte("2"), cache.get("bar").Payload)
+
+	cache.put(&packet.Message{Topic: "baz", Payload: []byte("4")})
+	assert.Nil(t, cache.get("foo"))
+	assert.Equal(t, []byte("2"), cache.get("bar").Payload)
+	assert.Equal(t, []byte("4"), cache.get("baz").Payload)
+}
+
+func TestClientLastMessage(t *testing.T) {
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(publish).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	wait := make(chan struct{})
+
+	c := New()
+	c.CacheSize = 10
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		close(wait)
+		return nil
+	}
+
+	assert.Nil(t, c.LastMessage("test"))
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	safeReceive(wait)
+
+	assert.Equal(t, &publish.Message, c.LastMessage("test"))
+	assert.Nil(t, c.LastMessage("foo"))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
@@ -3,8 +3,10 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"sync"
 	"sync/atomic"
@@ -12,6 +14,7 @@ import (
 
 	"github.com/256dpi/gomqtt/client/future"
 	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/routines"
 	"github.com/256dpi/gomqtt/session"
 	"github.com/256dpi/gomqtt/transport"
 	"gopkg.in/tomb.v2"
@@ -25,8 +28,12 @@ var ErrClientAlreadyConnecting = errors.New("client already connecting")
 // client is not currently connected.
 var ErrClientNotConnected = errors.New("client not connected")
 
-// ErrClientMissingID is returned by Connect if no ClientID has been provided in
-// the config while requesting to resume a session.
+// ErrClientDraining is returned by Publish, Subscribe and Unsubscribe while
+// Disconnect is waiting for outstanding flows to complete.
+var ErrClientDraining = errors.New("client draining")
+
+// ErrClientMissingID is returned by Config.Validate if no ClientID has been
+// provided in the config while requesting to resume a session.
 var ErrClientMissingID = errors.New("client missing id")
 
 // ErrClientConnectionDenied is returned in the Callback if the connection has
@@ -37,10 +44,22 @@ var ErrClientConnectionDenied = errors.New("client connection denied")
 // in time to a PingreqPacket.
 var ErrClientMissingPong = errors.New("client missing pong")
 
+// ErrClientSaturated is returned by Healthy if the Receipts channel or the
+// command queue of the service is full.
+var ErrClientSaturated = errors.New("client saturated")
+
 // ErrClientExpectedConnack is returned when the first received packet is not a
 // ConnackPacket.
 var ErrClientExpectedConnack = errors.New("client expected connack")
 
+// ErrClientSnapshotUnsupported is returned by Connect if a Checkpoint function
+// has been set but the session does not implement SnapshotSession.
+var ErrClientSnapshotUnsupported = errors.New("client snapshot unsupported")
+
+// ErrClientInflightExceeded is returned by Publish if the maximum number of
+// unacknowledged messages has been reached and Config.WaitInflight is not set.
+var ErrClientInflightExceeded = errors.New("client inflight exceeded")
+
 // ErrFailedSubscription is returned when a submitted subscription is marked as
 // failed when Config.ValidateSubs must be set to true.
 var ErrFailedSubscription = errors.New("failed subscription")
@@ -48,15 +67,40 @@ var ErrFailedSubscription = errors.New("failed subscription")
 // A Callback is a function called by the client upon received messages or
 // internal errors. An error can be returned if the callback is not already
 // called with an error to instantly close the client and prevent it from
-// sending any acknowledgments for the specified message.
+// sending any acknowledgments for the specified message. If manual acks are
+// enabled, a returned error only prevents the acknowledgement of the message
+// and the client stays connected.
 //
 // Note: Execution of the client is resumed after the callback returns. This
 // means that waiting on a future inside the callback will deadlock the client.
 type Callback func(msg *packet.Message, err error) error
 
+// A StreamCallback is a function called by the client upon received QOS 0
+// messages with a payload that exceeds the stream threshold. The message is
+// passed without a payload, which is instead streamed from the connection
+// using the provided reader. A returned error closes the client.
+//
+// Note: The reader is only valid until the callback returns. Any unread rest
+// of the payload is discarded afterwards.
+type StreamCallback func(msg *packet.Message, payload io.Reader, size int64) error
+
 // A Logger is a function called by the client to log activity.
 type Logger func(msg string)
 
+// A Receipt is emitted by the client when the quality of service flow of a
+// published message has been completed or the message has been canceled.
+type Receipt struct {
+	// The packet id of the published message.
+	ID packet.ID
+
+	// The topic of the published message.
+	Topic string
+
+	// The error is future.ErrCanceled if the connection has been closed before
+	// the message has been acknowledged.
+	Err error
+}
+
 const (
 	clientInitialized uint32 = iota
 	clientConnecting
@@ -66,7 +110,13 @@ const (
 	clientDisconnected
 )
 
-// A Session is used to persist incoming and outgoing packets.
+// the interval in which Disconnect checks for incoming flows to complete
+const drainInterval = 10 * time.Millisecond
+
+// A Session is used to persist incoming and outgoing packets. Unacknowledged
+// outgoing packets are resent after a connection has been established with
+// an existing session. Use a session.FileSession to retain these packets
+// across process restarts.
 type Session interface {
 	// NextID will return the next id for outgoing packets.
 	NextID() packet.ID
@@ -89,21 +139,55 @@ type Session interface {
 	Reset() error
 }
 
+// A SnapshotSession is a Session that is able to take snapshots of its state.
+// If a Checkpoint function is set or clean session is set to false, sessions
+// that also implement SaveSubscription and DeleteSubscription like the
+// MemorySession will store the acknowledged subscriptions of the client.
+type SnapshotSession interface {
+	Session
+
+	// Snapshot should return the current state of the session.
+	Snapshot() (*session.Snapshot, error)
+}
+
+// a session that stores subscriptions
+type subscriptionSession interface {
+	SaveSubscription(*packet.Subscription) error
+	DeleteSubscription(topic string) error
+}
+
+// a session that is able to report its health
+type healthSession interface {
+	Health() error
+}
+
+// a session that is able to list its subscriptions
+type subscriptionLister interface {
+	AllSubscriptions() ([]*packet.Subscription, error)
+}
+
 // A Client connects to a broker and handles the transmission of packets. It will
 // automatically send PingreqPackets to keep the connection alive. Outgoing
 // publish related packets will be stored in session and resent when the
 // connection gets closed abruptly. All methods return Futures that get completed
 // when the packets get acknowledged by the broker. Once the connection is closed
-// all waiting futures get canceled.
+// all waiting futures get canceled. Packets are written by a separate goroutine
+// that sends pings and acknowledgements ahead of other queued packets.
+//
+// If clean session is set to false and the broker did not resume the session,
+// the client discards the in-flight state unknown to the broker and replays
+// the subscriptions stored in the session unless SkipSubscriptionRestore is
+// set.
 //
 // Note: If clean session is set to false and there are packets in the session,
 // messages might get completed after connecting without triggering any futures
 // to complete.
 type Client struct {
-	state uint32
+	state    uint32
+	draining uint32
 
 	config *Config
-	conn   transport.Conn
+	conn   atomic.Pointer[transport.Conn]
 
 	// The session used by the client to store unacknowledged packets.
 	Session Session
@@ -117,31 +201,144 @@ type Client struct {
 	// automatic keep alive handler.
 	Logger Logger
 
-	clean bool
+	// The callback that is called with the delivery latency of received
+	// messages that carry a latency stamp. See Config.LatencyStamps.
+	LatencyCallback LatencyCallback
+
+	// The channel that receives a Receipt for every published message with a
+	// QOS level greater than zero once it has been acknowledged by the broker.
+	// The channel should be buffered and drained continuously as the client
+	// will block while it is full. Receipts for messages that are canceled when
+	// the connection gets closed are dropped if the channel is full.
+	Receipts chan<- Receipt
+
+	// The number of topics for which the last received message is cached and
+	// can be retrieved using LastMessage. If more topics are received, the
+	// topic that has been cached first is evicted. A zero value disables the
+	// cache.
+	//
+	// Note: The value must be changed before calling Connect.
+	CacheSize int
+
+	// If set, received messages with a QOS level greater than zero are not
+	// acknowledged automatically after the callback returns. Instead, Ack must
+	// be called with the message to complete the quality of service flow.
+	// Messages that are not acknowledged are redelivered by the broker once
+	// the session is resumed.
+	ManualAcks bool
+
+	// The callback that is called with QOS 0 messages that have a payload
+	// larger than StreamThreshold. These payloads are not buffered in memory
+	// and the messages are neither cached nor passed to Callback. Latency
+	// stamps are not removed from streamed payloads.
+	//
+	// Note: The value must be changed before calling Connect.
+	StreamCallback StreamCallback
+
+	// The payload size in bytes above which QOS 0 messages are passed to
+	// StreamCallback.
+	//
+	// Note: The value must be changed before calling Connect.
+	StreamThreshold int64
+
+	// The function that is called periodically with a snapshot of the session
+	// while the client is connected and once more when the connection has been
+	// closed. It can be used to persist the state of the client on devices
+	// with unreliable storage flushes to bound the loss after a power failure.
+	// The session must implement SnapshotSession. Acknowledged subscriptions
+	// are stored in the session if supported. An error returned by the
+	// function closes the client.
+	//
+	// Note: The value must be changed before calling Connect.
+	Checkpoint func(*session.Snapshot) error
+
+	// The interval in which the Checkpoint function is called.
+	//
+	// Note: The value must be changed before calling Connect.
+	CheckpointInterval time.Duration
+
+	// If set, the subscriptions stored in the session are not replayed if
+	// clean session is set to false and the broker did not resume the session.
+	// Acknowledged subscriptions are then only stored in the session if a
+	// Checkpoint function is set.
+	//
+	// Note: The value must be changed before calling Connect.
+	SkipSubscriptionRestore bool
+
+	// The metrics that are updated with the sent and received packets, the
+	// publish latencies and the number of inflight messages.
+	//
+	// Note: The value must be changed before calling Connect.
+	Metrics *Metrics
+
+	clean    bool
+	redirect atomic.Pointer[Redirect]
+
+	// the number of inflight messages counted in the metrics
+	counted int64
 
-	keepAlive     time.Duration
 	tracker       *tracker
 	futureStore   *future.Store
 	connectFuture *future.Future
 
+	urgent  chan outgoing
+	regular chan outgoing
+
+	receipts      map[packet.ID]string
+	receiptsMutex sync.Mutex
+
+	cache   *messageCache
+	service *Service
+
+	inflight      chan struct{}
+	inflightIDs   map[packet.ID]struct{}
+	inflightMutex sync.Mutex
+
+	pending      map[*packet.Message]packet.ID
+	pendingMutex sync.Mutex
+
+	dialCancel context.CancelFunc
+	dialMutex  sync.Mutex
+
 	tomb   tomb.Tomb
 	mutex  sync.Mutex
 	finish sync.Once
 }
 
+// an outgoing packet that is sent by the writer goroutine
+type outgoing struct {
+	pkt     packet.GenericPacket
+	payload io.Reader
+	size    int64
+	result  chan error
+}
+
 // New returns a new client that by default uses a fresh MemorySession.
 func New() *Client {
 	return &Client{
 		state:       clientInitialized,
 		Session:     session.NewMemorySession(),
 		futureStore: future.NewStore(),
+		urgent:      make(chan outgoing, 100),
+		regular:     make(chan outgoing),
+		receipts:    make(map[packet.ID]string),
+		pending:     make(map[*packet.Message]packet.ID),
 	}
 }
 
 // Connect opens the connection to the broker and sends a ConnectPacket. It will
 // return a ConnectFuture that gets completed once a ConnackPacket has been
 // received. If the ConnectPacket couldn't be transmitted it will return an error.
+//
+// A pending dial is aborted if Disconnect or Close is called.
 func (c *Client) Connect(config *Config) (ConnectFuture, error) {
+	return c.ConnectContext(context.Background(), config)
+}
+
+// ConnectContext is like Connect but aborts the dial and closes the client if
+// the context is done before a ConnackPacket has been received. The context
+// has no effect once the connection has been established.
+func (c *Client) ConnectContext(ctx context.Context, config *Config) (ConnectFuture, error) {
 	if config == nil {
 		panic("no config specified")
 	}
@@ -157,15 +354,22 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 		return nil, ErrClientAlreadyConnecting
 	}
 
-	// parse url
-	urlParts, err := url.ParseRequestURI(config.BrokerURL)
+	// validate config
+	err := config.Validate()
 	if err != nil {
 		return nil, err
 	}
 
-	// check client id
-	if !config.CleanSession && config.ClientID == "" {
-		return nil, ErrClientMissingID
+	// prepare inflight window
+	if config.MaxInflight > 0 {
+		c.inflight = make(chan struct{}, config.MaxInflight)
+		c.inflightIDs = make(map[packet.ID]struct{})
+	}
+
+	// parse url
+	urlParts, err := url.ParseRequestURI(config.BrokerURL)
+	if err != nil {
+		return nil, err
 	}
 
 	// parse keep alive
@@ -175,21 +379,48 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 	}
 
 	// allocate and initialize tracker
-	c.keepAlive = keepAlive
 	c.tracker = newTracker(keepAlive)
 
-	// dial broker (with custom dialer if present)
-	if config.Dialer != nil {
-		c.conn, err = config.Dialer.Dial(config.BrokerURL)
-		if err != nil {
-			return nil, err
+	// check session
+	if c.Checkpoint != nil {
+		if _, ok := c.Session.(SnapshotSession); !ok {
+			return nil, ErrClientSnapshotUnsupported
 		}
+	}
+
+	// prepare dial context that is canceled by Disconnect and Close
+	dialCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	c.dialMutex.Lock()
+	c.dialCancel = cancel
+	c.dialMutex.Unlock()
+
+	// dial broker (with custom dialer or tls config if present)
+	var conn transport.Conn
+	if config.Dialer != nil {
+		conn, err = config.Dialer.DialContext(dialCtx, config.BrokerURL)
+	} else if config.TLSConfig != nil {
+		dialer := transport.NewDialer()
+		dialer.TLSConfig = config.TLSConfig
+		conn, err = dialer.DialContext(dialCtx, config.BrokerURL)
 	} else {
-		c.conn, err = transport.Dial(config.BrokerURL)
-		if err != nil {
-			return nil, err
-		}
+		conn, err = transport.DialContext(dialCtx, config.BrokerURL)
+	}
+
+	// close connection if the dial has been aborted in the meantime
+	if c.takeDialCancel() == nil && err == nil {
+		_ = conn.Close()
+		err = context.Canceled
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	// set connection
+	c.conn.Store(&conn)
+
+	// set write timeout
+	conn.SetWriteTimeout(config.WriteTimeout)
 
 	// set to connecting as from this point the client cannot be reused
 	atomic.StoreUint32(&c.state, clientConnecting)
@@ -200,6 +431,11 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 	// save clean
 	c.clean = config.CleanSession
 
+	// create cache if requested
+	if c.cache == nil && c.CacheSize > 0 {
+		c.cache = newMessageCache(c.CacheSize)
+	}
+
 	// reset store
 	if c.clean {
 		err = c.Session.Reset()
@@ -213,6 +449,7 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 	connect.ClientID = config.ClientID
 	connect.KeepAlive = uint16(keepAlive.Seconds())
 	connect.CleanSession = config.CleanSession
+	connect.Version = config.Version
 
 	// check for credentials
 	if urlParts.User != nil {
@@ -220,6 +457,25 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 		connect.Password, _ = urlParts.User.Password()
 	}
 
+	// set configured credentials
+	if config.Username != "" {
+		connect.Username = config.Username
+		connect.Password = config.Password
+	}
+
+	// start enhanced authentication
+	if config.Authenticator != nil {
+		data, err := config.Authenticator.Initial()
+		if err != nil {
+			return nil, c.cleanup(err, true, false)
+		}
+
+		connect.Properties = connect.Properties.Set(packet.AuthMethodProperty, config.Authenticator.Method())
+		if data != nil {
+			connect.Properties = connect.Properties.Set(packet.AuthDataProperty, data)
+		}
+	}
+
 	// set will
 	connect.Will = config.WillMessage
 
@@ -232,8 +488,25 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 		return nil, c.cleanup(err, false, false)
 	}
 
-	// start process routine
-	c.tomb.Go(c.processor)
+	// start process and write routine
+	c.tomb.Go(routines.Wrap("client.processor", c.processor))
+	c.tomb.Go(routines.Wrap("client.writer", c.writer))
+
+	// start checkpoint routine if requested
+	if c.Checkpoint != nil && c.CheckpointInterval > 0 {
+		c.tomb.Go(routines.Wrap("client.checkpointer", c.checkpointer))
+	}
+
+	// close client if the context is done before the connack is received
+	if ctx.Done() != nil {
+		connectFuture := c.connectFuture
+		routines.Go("client.connect", func() {
+			err := connectFuture.WaitContext(ctx)
+			if err != nil && err != future.ErrCanceled {
+				_ = c.Close()
+			}
+		})
+	}
 
 	// wrap future
 	wrappedFuture := &connectFuture{c.connectFuture}
@@ -244,7 +517,13 @@ func (c *Client) Connect(config *Config) (ConnectFuture, error) {
 // Publish will send a PublishPacket containing the passed parameters. It will
 // return a PublishFuture that gets completed once the quality of service flow
 // has been completed.
-func (c *Client) Publish(topic string, payload []byte, qos uint8, retain bool) (GenericFuture, error) {
+func (c *Client) Publish(topic string, payload []byte, qos uint8, retain bool) (PublishFuture, error) {
+	return c.PublishContext(context.Background(), topic, payload, qos, retain)
+}
+
+// PublishContext is like Publish but aborts if the context is done before the
+// packet has been queued for sending.
+func (c *Client) PublishContext(ctx context.Context, topic string, payload []byte, qos uint8, retain bool) (PublishFuture, error) {
 	msg := &packet.Message{
 		Topic:   topic,
 		Payload: payload,
@@ -252,13 +531,44 @@ func (c *Client) Publish(topic string, payload []byte, qos uint8, retain bool) (
 		Retain:  retain,
 	}
 
-	return c.PublishMessage(msg)
+	return c.PublishMessageContext(ctx, msg)
 }
 
 // PublishMessage will send a PublishPacket containing the passed message. It will
 // return a PublishFuture that gets completed once the quality of service flow
 // has been completed.
-func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
+func (c *Client) PublishMessage(msg *packet.Message) (PublishFuture, error) {
+	return c.PublishMessageContext(context.Background(), msg)
+}
+
+// PublishMessageContext is like PublishMessage but aborts if the context is
+// done before the packet has been queued for sending. An aborted message is
+// removed from the session and the error of the context is returned.
+func (c *Client) PublishMessageContext(ctx context.Context, msg *packet.Message) (PublishFuture, error) {
+	// check if draining
+	if atomic.LoadUint32(&c.draining) == 1 {
+		return nil, ErrClientDraining
+	}
+
+	// acquire inflight slot
+	slot := msg.QOS > 0 && c.inflight != nil
+	if slot {
+		err := c.acquireInflight(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// publish message
+	pubFuture, err := c.publishMessage(ctx, msg, slot)
+	if err != nil && slot {
+		<-c.inflight
+	}
+
+	return pubFuture, err
+}
+
+func (c *Client) publishMessage(ctx context.Context, msg *packet.Message, slot bool) (PublishFuture, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -267,20 +577,44 @@ func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
 		return nil, ErrClientNotConnected
 	}
 
+	// check topic limits
+	if err := c.config.checkLevels(msg.Topic); err != nil {
+		return nil, err
+	}
+
 	// allocate packet
 	publish := packet.NewPublishPacket()
 	publish.Message = *msg
 
+	// stamp properties or payload if requested
+	if c.config.LatencyStamps && c.config.Version == packet.Version5 {
+		publish.Properties = StampProperties(publish.Properties, time.Now())
+	} else if c.config.LatencyStamps {
+		publish.Message.Payload = StampPayload(msg.Payload, time.Now())
+	}
+
 	// set packet id
 	if msg.QOS > 0 {
 		publish.ID = c.Session.NextID()
 	}
 
+	// assign inflight slot, the slot is returned by the caller if the
+	// message is not queued
+	var queued bool
+	if slot {
+		c.assignInflight(publish.ID)
+		defer func() {
+			if !queued {
+				c.unassignInflight(publish.ID)
+			}
+		}()
+	}
+
 	// create future
-	publishFuture := future.New()
+	pubFuture := newPublishFuture(msg.QOS)
 
 	// store future
-	c.futureStore.Put(publish.ID, publishFuture)
+	c.futureStore.Put(publish.ID, pubFuture)
 
 	// store packet if at least qos 1
 	if msg.QOS > 0 {
@@ -290,26 +624,130 @@ func (c *Client) PublishMessage(msg *packet.Message) (GenericFuture, error) {
 		}
 	}
 
-	// send packet
-	err := c.send(publish, true)
+	// track receipt if requested
+	if msg.QOS > 0 && c.Receipts != nil {
+		c.receiptsMutex.Lock()
+		c.receipts[publish.ID] = msg.Topic
+		c.receiptsMutex.Unlock()
+	}
+
+	// queue packet
+	err := c.queueContext(ctx, publish, false)
 	if err != nil {
-		return nil, c.cleanup(err, false, false)
+		// remove aborted message
+		if ctx.Err() != nil && err == ctx.Err() {
+			c.futureStore.Delete(publish.ID)
+			if msg.QOS > 0 {
+				c.receiptsMutex.Lock()
+				delete(c.receipts, publish.ID)
+				c.receiptsMutex.Unlock()
+
+				delErr := c.Session.DeletePacket(session.Outgoing, publish.ID)
+				if delErr != nil {
+					return nil, c.cleanup(delErr, true, false)
+				}
+			}
+		}
+
+		return nil, err
 	}
 
+	// set flag
+	queued = true
+
 	// complete and remove qos 0 future
 	if msg.QOS == 0 {
-		publishFuture.Complete()
+		acknowledge(pubFuture, 0)
 		c.futureStore.Delete(publish.ID)
 	}
 
-	return publishFuture, nil
+	return &publishFuture{pubFuture}, nil
+}
+
+// PublishReader will send a PublishPacket with a payload of the specified size
+// that is read from the passed reader. Messages with a quality of service of
+// zero are streamed directly to the connection and the call returns once the
+// packet has been written. As the session must be able to retransmit messages
+// with a higher quality of service, their payload is read into memory first.
+// Latency stamps are not applied to streamed payloads. A failure while reading
+// the payload will close the connection as the packet has been written
+// partially.
+func (c *Client) PublishReader(topic string, r io.Reader, size int64, qos uint8, retain bool) (PublishFuture, error) {
+	// buffer payload if the message must be stored
+	if qos > 0 {
+		if size < 0 {
+			return nil, fmt.Errorf("payload size (%d) negative", size)
+		}
+
+		// read payload
+		payload, err := io.ReadAll(io.LimitReader(r, size))
+		if err == nil && int64(len(payload)) < size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return c.Publish(topic, payload, qos, retain)
+	}
+
+	// check if draining
+	if atomic.LoadUint32(&c.draining) == 1 {
+		return nil, ErrClientDraining
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// check if connected
+	if atomic.LoadUint32(&c.state) != clientConnected {
+		return nil, ErrClientNotConnected
+	}
+
+	// check topic limits
+	if err := c.config.checkLevels(topic); err != nil {
+		return nil, err
+	}
+
+	// allocate packet
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = topic
+	publish.Message.Retain = retain
+
+	// prepare result
+	result := make(chan error, 1)
+
+	// queue packet
+	select {
+	case c.regular <- outgoing{pkt: publish, payload: r, size: size, result: result}:
+	case <-c.tomb.Dying():
+		return nil, ErrClientNotConnected
+	}
+
+	// await result, the writer always reports once it took the packet
+	err := <-result
+	if err != nil {
+		return nil, err
+	}
+
+	// create completed future
+	pubFuture := newPublishFuture(0)
+	acknowledge(pubFuture, 0)
+
+	return &publishFuture{pubFuture}, nil
 }
 
 // Subscribe will send a SubscribePacket containing one topic to subscribe. It
 // will return a SubscribeFuture that gets completed once a SubackPacket has
 // been received.
 func (c *Client) Subscribe(topic string, qos uint8) (SubscribeFuture, error) {
-	return c.SubscribeMultiple([]packet.Subscription{
+	return c.SubscribeContext(context.Background(), topic, qos)
+}
+
+// SubscribeContext is like Subscribe but aborts if the context is done before
+// the packet has been queued for sending.
+func (c *Client) SubscribeContext(ctx context.Context, topic string, qos uint8) (SubscribeFuture, error) {
+	return c.SubscribeMultipleContext(ctx, []packet.Subscription{
 		{Topic: topic, QOS: qos},
 	})
 }
@@ -318,6 +756,17 @@ func (c *Client) Subscribe(topic string, qos uint8) (SubscribeFuture, error) {
 // subscribe. It will return a SubscribeFuture that gets completed once a
 // SubackPacket has been received.
 func (c *Client) SubscribeMultiple(subscriptions []packet.Subscription) (SubscribeFuture, error) {
+	return c.SubscribeMultipleContext(context.Background(), subscriptions)
+}
+
+// SubscribeMultipleContext is like SubscribeMultiple but aborts if the context
+// is done before the packet has been queued for sending.
+func (c *Client) SubscribeMultipleContext(ctx context.Context, subscriptions []packet.Subscription) (SubscribeFuture, error) {
+	// check if draining
+	if atomic.LoadUint32(&c.draining) == 1 {
+		return nil, ErrClientDraining
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -326,6 +775,13 @@ func (c *Client) SubscribeMultiple(subscriptions []packet.Subscription) (Subscri
 		return nil, ErrClientNotConnected
 	}
 
+	// check topic limits
+	for _, sub := range subscriptions {
+		if err := c.config.checkLevels(sub.Topic); err != nil {
+			return nil, err
+		}
+	}
+
 	// allocate packet
 	subscribe := packet.NewSubscribePacket()
 	subscribe.ID = c.Session.NextID()
@@ -333,14 +789,20 @@ func (c *Client) SubscribeMultiple(subscriptions []packet.Subscription) (Subscri
 
 	// create future
 	subFuture := future.New()
+	subFuture.Data.Store(subscriptionsKey, subscriptions)
 
 	// store future
 	c.futureStore.Put(subscribe.ID, subFuture)
 
-	// send packet
-	err := c.send(subscribe, true)
+	// queue packet
+	err := c.queueContext(ctx, subscribe, false)
 	if err != nil {
-		return nil, c.cleanup(err, false, false)
+		// remove aborted future
+		if ctx.Err() != nil && err == ctx.Err() {
+			c.futureStore.Delete(subscribe.ID)
+		}
+
+		return nil, err
 	}
 
 	// wrap future
@@ -353,13 +815,30 @@ func (c *Client) SubscribeMultiple(subscriptions []packet.Subscription) (Subscri
 // It will return a UnsubscribeFuture that gets completed once a UnsubackPacket
 // has been received.
 func (c *Client) Unsubscribe(topic string) (GenericFuture, error) {
-	return c.UnsubscribeMultiple([]string{topic})
+	return c.UnsubscribeContext(context.Background(), topic)
+}
+
+// UnsubscribeContext is like Unsubscribe but aborts if the context is done
+// before the packet has been queued for sending.
+func (c *Client) UnsubscribeContext(ctx context.Context, topic string) (GenericFuture, error) {
+	return c.UnsubscribeMultipleContext(ctx, []string{topic})
 }
 
 // UnsubscribeMultiple will send a UnsubscribePacket containing multiple
 // topics to unsubscribe. It will return a UnsubscribeFuture that gets completed
 // once a UnsubackPacket has been received.
 func (c *Client) UnsubscribeMultiple(topics []string) (GenericFuture, error) {
+	return c.UnsubscribeMultipleContext(context.Background(), topics)
+}
+
+// UnsubscribeMultipleContext is like UnsubscribeMultiple but aborts if the
+// context is done before the packet has been queued for sending.
+func (c *Client) UnsubscribeMultipleContext(ctx context.Context, topics []string) (GenericFuture, error) {
+	// check if draining
+	if atomic.LoadUint32(&c.draining) == 1 {
+		return nil, ErrClientDraining
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -375,25 +854,94 @@ func (c *Client) UnsubscribeMultiple(topics []string) (GenericFuture, error) {
 
 	// create future
 	unsubscribeFuture := future.New()
+	unsubscribeFuture.Data.Store(topicsKey, topics)
 
 	// store future
 	c.futureStore.Put(unsubscribe.ID, unsubscribeFuture)
 
-	// send packet
-	err := c.send(unsubscribe, true)
+	// queue packet
+	err := c.queueContext(ctx, unsubscribe, false)
 	if err != nil {
-		return nil, c.cleanup(err, false, false)
+		// remove aborted future
+		if ctx.Err() != nil && err == ctx.Err() {
+			c.futureStore.Delete(unsubscribe.ID)
+		}
+
+		return nil, err
 	}
 
 	return unsubscribeFuture, nil
 }
 
+// Ack will acknowledge a message that has been received while manual acks are
+// enabled. Messages with a QOS level of zero and messages that have already
+// been acknowledged are ignored.
+func (c *Client) Ack(msg *packet.Message) error {
+	// check if connected
+	if atomic.LoadUint32(&c.state) != clientConnected {
+		return ErrClientNotConnected
+	}
+
+	// get and remove pending message
+	c.pendingMutex.Lock()
+	id, ok := c.pending[msg]
+	delete(c.pending, msg)
+	c.pendingMutex.Unlock()
+
+	// check message
+	if !ok {
+		return nil
+	}
+
+	// complete qos 1 flow
+	if msg.QOS == 1 {
+		// prepare puback packet
+		puback := packet.NewPubackPacket()
+		puback.ID = id
+
+		return c.queue(puback, true)
+	}
+
+	// prepare pubcomp packet
+	pubcomp := packet.NewPubcompPacket()
+	pubcomp.ID = id
+
+	// complete qos 2 flow
+	err := c.queue(pubcomp, true)
+	if err != nil {
+		return err
+	}
+
+	// remove packet from store
+	return c.Session.DeletePacket(session.Incoming, id)
+}
+
+// LastMessage returns a copy of the last message that has been received for
+// the specified topic. It returns nil if no message has been received or the
+// cache is disabled.
+func (c *Client) LastMessage(topic string) *packet.Message {
+	// check cache
+	if c.cache == nil {
+		return nil
+	}
+
+	return c.cache.get(topic)
+}
+
 // Disconnect will send a DisconnectPacket and close the connection.
 //
 // If a timeout is specified, the client will wait the specified amount of time
 // for all queued futures to complete or cancel. If no timeout is specified it
-// will not wait at all.
+// will not wait at all. While waiting, new publishes, subscriptions and
+// unsubscriptions are refused with ErrClientDraining while acknowledgements for
+// received messages are still sent to let the brokers QOS 2 flows complete.
+// A pending dial of Connect is aborted instead.
 func (c *Client) Disconnect(timeout ...time.Duration) error {
+	// abort pending dial
+	if c.abortDial() {
+		return nil
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -404,21 +952,57 @@ func (c *Client) Disconnect(timeout ...time.Duration) error {
 
 	// finish current packets
 	if len(timeout) > 0 {
+		// refuse new packets
+		atomic.StoreUint32(&c.draining, 1)
+		defer atomic.StoreUint32(&c.draining, 0)
+
+		// get deadline
+		deadline := time.Now().Add(timeout[0])
+
+		// wait for outgoing flows
 		c.futureStore.Await(timeout[0])
+
+		// wait for incoming flows
+		c.awaitIncoming(deadline)
 	}
 
 	// set state
 	atomic.StoreUint32(&c.state, clientDisconnecting)
 
-	// send disconnect packet
-	err := c.send(packet.NewDisconnectPacket(), false)
+	// send disconnect packet after all queued packets
+	err := c.sendQueued(packet.NewDisconnectPacket(), false)
 
 	return c.end(err, true)
 }
 
+// waits until all incoming QOS 2 flows have been completed or the deadline
+// has been reached
+func (c *Client) awaitIncoming(deadline time.Time) {
+	for time.Now().Before(deadline) {
+		// get incoming packets
+		pkts, err := c.Session.AllPackets(session.Incoming)
+		if err != nil || len(pkts) == 0 {
+			return
+		}
+
+		// stop if the connection is gone
+		if atomic.LoadUint32(&c.state) != clientConnected {
+			return
+		}
+
+		time.Sleep(drainInterval)
+	}
+}
+
 // Close closes the client immediately without sending a DisconnectPacket and
 // waiting for outgoing transmissions to finish.
+// A pending dial of Connect is aborted.
 func (c *Client) Close() error {
+	// abort pending dial
+	if c.abortDial() {
+		return nil
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
@@ -430,20 +1014,67 @@ func (c *Client) Close() error {
 	return c.end(nil, false)
 }
 
+// returns and clears the function that cancels the pending dial
+func (c *Client) takeDialCancel() context.CancelFunc {
+	c.dialMutex.Lock()
+	defer c.dialMutex.Unlock()
+
+	cancel := c.dialCancel
+	c.dialCancel = nil
+
+	return cancel
+}
+
+// cancels the pending dial and returns whether a dial has been pending
+func (c *Client) abortDial() bool {
+	// get cancel
+	cancel := c.takeDialCancel()
+	if cancel == nil {
+		return false
+	}
+
+	// cancel dial
+	cancel()
+
+	return true
+}
+
+// CloseReason returns the reason why the connection to the broker has been
+// closed. It returns transport.NotClosed if the connection is still open or
+// has not yet been established.
+func (c *Client) CloseReason() transport.CloseReason {
+	// get connection
+	conn := c.connection()
+	if conn == nil {
+		return transport.NotClosed
+	}
+
+	return conn.CloseReason()
+}
+
+// Redirect returns the redirect if the broker asked the client to connect to
+// another server using a MQTT 5 ConnackPacket or DisconnectPacket. It returns
+// nil otherwise.
+func (c *Client) Redirect() *Redirect {
+	return c.redirect.Load()
+}
+
 /* processor goroutine */
 
 // processes incoming packets
 func (c *Client) processor() error {
 	first := true
 
-	// start keep alive if greater than zero
-	if c.keepAlive > 0 {
-		c.tomb.Go(c.pinger)
-	}
-
 	for {
 		// get next packet from connection
-		pkt, err := c.conn.Receive()
+		var pkt packet.GenericPacket
+		var payload *transport.Payload
+		var err error
+		if c.StreamCallback != nil {
+			pkt, payload, err = c.connection().ReceiveStream(c.StreamThreshold)
+		} else {
+			pkt, err = c.connection().Receive()
+		}
 		if err != nil {
 			// if we are disconnecting we can ignore the error
 			if atomic.LoadUint32(&c.state) >= clientDisconnecting {
@@ -459,6 +1090,25 @@ func (c *Client) processor() error {
 			c.Logger(fmt.Sprintf("Received: %s", pkt.String()))
 		}
 
+		// count received packet
+		if c.Metrics != nil {
+			var size int64
+			if payload != nil {
+				size = payload.Size()
+			}
+			c.Metrics.countReceived(pkt, size)
+		}
+
+		// handle authentication exchange
+		if auth, ok := pkt.(*packet.AuthPacket); ok {
+			err = c.processAuth(auth)
+			if err != nil {
+				return err // error has already been cleaned
+			}
+
+			continue
+		}
+
 		if first {
 			// get connack
 			connack, ok := pkt.(*packet.ConnackPacket)
@@ -483,15 +1133,21 @@ func (c *Client) processor() error {
 		case *packet.PingrespPacket:
 			c.tracker.pong()
 		case *packet.PublishPacket:
-			err = c.processPublish(typedPkt)
+			if payload != nil {
+				err = c.processStream(typedPkt, payload)
+			} else {
+				err = c.processPublish(typedPkt)
+			}
 		case *packet.PubackPacket:
-			err = c.processPubackAndPubcomp(typedPkt.ID)
+			err = c.processPubackAndPubcomp(typedPkt.ID, typedPkt.ReasonCode)
 		case *packet.PubcompPacket:
-			err = c.processPubackAndPubcomp(typedPkt.ID)
+			err = c.processPubackAndPubcomp(typedPkt.ID, typedPkt.ReasonCode)
 		case *packet.PubrecPacket:
-			err = c.processPubrec(typedPkt.ID)
+			err = c.processPubrec(typedPkt.ID, typedPkt.ReasonCode)
 		case *packet.PubrelPacket:
 			err = c.processPubrel(typedPkt.ID)
+		case *packet.DisconnectPacket:
+			c.processDisconnect(typedPkt)
 		}
 
 		// return eventual error
@@ -501,6 +1157,14 @@ func (c *Client) processor() error {
 	}
 }
 
+// handle an incoming DisconnectPacket
+func (c *Client) processDisconnect(disconnect *packet.DisconnectPacket) {
+	// remember redirect, the broker closes the connection afterwards
+	if redirect := newRedirect(packet.ReasonCode(disconnect.ReasonCode), disconnect.Properties); redirect != nil {
+		c.redirect.Store(redirect)
+	}
+}
+
 // handle the incoming ConnackPacket
 func (c *Client) processConnack(connack *packet.ConnackPacket) error {
 	// check state
@@ -515,6 +1179,11 @@ func (c *Client) processConnack(connack *packet.ConnackPacket) error {
 	c.connectFuture.Data.Store(sessionPresentKey, connack.SessionPresent)
 	c.connectFuture.Data.Store(returnCodeKey, connack.ReturnCode)
 
+	// remember redirect
+	if redirect := newRedirect(connack.ReasonCode, connack.Properties); redirect != nil {
+		c.redirect.Store(redirect)
+	}
+
 	// return connection denied error and close connection if not accepted
 	if connack.ReturnCode != packet.ConnectionAccepted {
 		err := c.die(ErrClientConnectionDenied, true, false)
@@ -522,9 +1191,53 @@ func (c *Client) processConnack(connack *packet.ConnackPacket) error {
 		return err
 	}
 
+	// complete enhanced authentication
+	if c.config.Authenticator != nil {
+		data, _ := connack.Properties.Get(packet.AuthDataProperty)
+		bytes, _ := data.([]byte)
+		err := c.config.Authenticator.Success(bytes)
+		if err != nil {
+			err = c.die(err, true, false)
+			c.connectFuture.Cancel()
+			return err
+		}
+	}
+
+	// adopt server keep alive
+	if value, ok := connack.Properties.Get(packet.ServerKeepAliveProperty); ok {
+		keepAlive := time.Duration(value.(uint16)) * time.Second
+		c.tracker.setKeepAlive(keepAlive)
+
+		// log keep alive
+		if c.Logger != nil {
+			c.Logger(fmt.Sprintf("Adopted Server KeepAlive %s", keepAlive.String()))
+		}
+	}
+
+	// start keep alive if greater than zero
+	if c.tracker.keepAlive() > 0 {
+		c.tomb.Go(routines.Wrap("client.pinger", c.pinger))
+	}
+
 	// set state to connected
 	atomic.StoreUint32(&c.state, clientConnected)
 
+	// restore session if the broker did not resume it
+	lost := !c.clean && !connack.SessionPresent
+	if lost {
+		err := c.restoreSession()
+		if err != nil {
+			err = c.die(err, true, false)
+			c.connectFuture.Cancel()
+			return err
+		}
+	}
+
+	// count connection
+	if c.Metrics != nil {
+		atomic.AddUint64(&c.Metrics.connects, 1)
+	}
+
 	// complete future
 	c.connectFuture.Complete()
 
@@ -538,13 +1251,13 @@ func (c *Client) processConnack(connack *packet.ConnackPacket) error {
 	for _, pkt := range packets {
 		// check for publish packets
 		publish, ok := pkt.(*packet.PublishPacket)
-		if ok {
+		if ok && !lost {
 			// set the dup flag on a publish packet
 			publish.Dup = true
 		}
 
 		// resend packet
-		err = c.send(pkt, true)
+		err = c.queue(pkt, false)
 		if err != nil {
 			return c.die(err, false, false)
 		}
@@ -553,6 +1266,87 @@ func (c *Client) processConnack(connack *packet.ConnackPacket) error {
 	return nil
 }
 
+// discard the in-flight state the broker does not know anymore and replay the
+// subscriptions stored in the session if enabled
+func (c *Client) restoreSession() error {
+	// the broker will not release incoming messages of a lost session
+	incoming, err := c.Session.AllPackets(session.Incoming)
+	if err != nil {
+		return err
+	}
+	for _, pkt := range incoming {
+		if id, ok := packet.GetID(pkt); ok {
+			err = c.Session.DeletePacket(session.Incoming, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// outgoing messages that await a PubcompPacket have already been received
+	// by the broker and are completed, other packets are resent
+	outgoing, err := c.Session.AllPackets(session.Outgoing)
+	if err != nil {
+		return err
+	}
+	for _, pkt := range outgoing {
+		if pubrel, ok := pkt.(*packet.PubrelPacket); ok {
+			err = c.processPubackAndPubcomp(pubrel.ID, packet.Success)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// get stored subscriptions if enabled
+	if !c.restoresSubscriptions() {
+		return nil
+	}
+	stored, err := c.Session.(subscriptionLister).AllSubscriptions()
+	if err != nil || len(stored) == 0 {
+		return err
+	}
+
+	// prepare subscriptions
+	subscriptions := make([]packet.Subscription, 0, len(stored))
+	for _, sub := range stored {
+		subscriptions = append(subscriptions, *sub)
+	}
+
+	// allocate packet
+	subscribe := packet.NewSubscribePacket()
+	subscribe.ID = c.Session.NextID()
+	subscribe.Subscriptions = subscriptions
+
+	// create and store future
+	subFuture := future.New()
+	subFuture.Data.Store(subscriptionsKey, subscriptions)
+	c.futureStore.Put(subscribe.ID, subFuture)
+
+	// queue packet
+	return c.queue(subscribe, false)
+}
+
+// returns whether the subscriptions stored in the session are replayed if
+// the broker did not resume a persistent session
+func (c *Client) restoresSubscriptions() bool {
+	// check configuration
+	if c.clean || c.SkipSubscriptionRestore {
+		return false
+	}
+
+	// check session
+	_, ok1 := c.Session.(subscriptionSession)
+	_, ok2 := c.Session.(subscriptionLister)
+
+	return ok1 && ok2
+}
+
+// returns whether acknowledged subscriptions should be stored in the session
+func (c *Client) storeSubscriptions() bool {
+	return c.Checkpoint != nil || c.restoresSubscriptions()
+}
+
 // handle an incoming SubackPacket
 func (c *Client) processSuback(suback *packet.SubackPacket) error {
 	// remove packet from store
@@ -580,6 +1374,24 @@ func (c *Client) processSuback(suback *packet.SubackPacket) error {
 		}
 	}
 
+	// save granted subscriptions if requested and supported
+	if store, ok := c.Session.(subscriptionSession); ok && c.storeSubscriptions() {
+		value, _ := subscribeFuture.Data.Load(subscriptionsKey)
+		subscriptions, _ := value.([]packet.Subscription)
+		for i, code := range suback.ReturnCodes {
+			if i >= len(subscriptions) || code == packet.QOSFailure {
+				continue
+			}
+
+			sub := subscriptions[i]
+			sub.QOS = code
+			err = store.SaveSubscription(&sub)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	// complete future
 	subscribeFuture.Data.Store(returnCodesKey, suback.ReturnCodes)
 	subscribeFuture.Complete()
@@ -601,6 +1413,18 @@ func (c *Client) processUnsuback(unsuback *packet.UnsubackPacket) error {
 		return nil // ignore a wrongly sent UnsubackPacket
 	}
 
+	// delete subscriptions if requested and supported
+	if store, ok := c.Session.(subscriptionSession); ok && c.storeSubscriptions() {
+		value, _ := unsubscribeFuture.Data.Load(topicsKey)
+		topics, _ := value.([]string)
+		for _, topic := range topics {
+			err = store.DeleteSubscription(topic)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	// complete future
 	unsubscribeFuture.Complete()
 
@@ -612,16 +1436,47 @@ func (c *Client) processUnsuback(unsuback *packet.UnsubackPacket) error {
 
 // handle an incoming PublishPacket
 func (c *Client) processPublish(publish *packet.PublishPacket) error {
+	// remove stamp and report latency
+	if c.config.LatencyStamps {
+		properties, sent, ok := ParseStampProperties(publish.Properties)
+		if ok {
+			publish.Properties = properties
+		} else {
+			publish.Message.Payload, sent, ok = ParseStamp(publish.Message.Payload)
+		}
+		if ok && c.LatencyCallback != nil {
+			c.LatencyCallback(&publish.Message, time.Since(sent))
+		}
+	}
+
+	// keep message for manual ack before calling the callback as the message
+	// may be acknowledged from another goroutine
+	if publish.Message.QOS == 1 && c.ManualAcks {
+		c.addPending(&publish.Message, publish.ID)
+	}
+
 	// call callback for unacknowledged and directly acknowledged messages
 	if publish.Message.QOS <= 1 {
+		// cache message
+		if c.cache != nil {
+			c.cache.put(&publish.Message)
+		}
+
 		if c.Callback != nil {
 			err := c.Callback(&publish.Message, nil)
-			if err != nil {
+			if err != nil && c.ManualAcks {
+				return nil
+			} else if err != nil {
 				return c.die(err, true, true)
 			}
 		}
 	}
 
+	// return if acknowledged manually
+	if publish.Message.QOS == 1 && c.ManualAcks {
+		return nil
+	}
+
 	// handle qos 1 flow
 	if publish.Message.QOS == 1 {
 		// prepare puback packet
@@ -629,7 +1484,7 @@ func (c *Client) processPublish(publish *packet.PublishPacket) error {
 		puback.ID = publish.ID
 
 		// acknowledge qos 1 publish
-		err := c.send(puback, true)
+		err := c.queue(puback, true)
 		if err != nil {
 			return c.die(err, false, false)
 		}
@@ -648,7 +1503,7 @@ func (c *Client) processPublish(publish *packet.PublishPacket) error {
 		pubrec.ID = publish.ID
 
 		// acknowledge qos 2 publish
-		err = c.send(pubrec, true)
+		err = c.queue(pubrec, true)
 		if err != nil {
 			return c.die(err, false, false)
 		}
@@ -657,14 +1512,31 @@ func (c *Client) processPublish(publish *packet.PublishPacket) error {
 	return nil
 }
 
+// handle an incoming PublishPacket with a streamed payload
+func (c *Client) processStream(publish *packet.PublishPacket, payload *transport.Payload) error {
+	// call callback
+	err := c.StreamCallback(&publish.Message, payload, payload.Size())
+	if err != nil {
+		return c.die(err, true, true)
+	}
+
+	return nil
+}
+
 // handle an incoming PubackPacket or PubcompPacket
-func (c *Client) processPubackAndPubcomp(id packet.ID) error {
+func (c *Client) processPubackAndPubcomp(id packet.ID, code packet.ReasonCode) error {
 	// remove packet from store
 	err := c.Session.DeletePacket(session.Outgoing, id)
 	if err != nil {
 		return err
 	}
 
+	// release inflight slot
+	c.releaseInflight(id)
+
+	// emit receipt
+	c.emitReceipt(id)
+
 	// get future
 	publishFuture := c.futureStore.Get(id)
 	if publishFuture == nil {
@@ -672,7 +1544,15 @@ func (c *Client) processPubackAndPubcomp(id packet.ID) error {
 	}
 
 	// complete future
-	publishFuture.Complete()
+	acknowledge(publishFuture, code)
+
+	// update metrics
+	if c.Metrics != nil {
+		if sent, ok := publishFuture.Data.Load(sentKey); ok {
+			c.Metrics.observeLatency(time.Since(sent.(time.Time)))
+		}
+		c.uncount(1)
+	}
 
 	// remove future from store
 	c.futureStore.Delete(id)
@@ -681,7 +1561,12 @@ func (c *Client) processPubackAndPubcomp(id packet.ID) error {
 }
 
 // handle an incoming PubrecPacket
-func (c *Client) processPubrec(id packet.ID) error {
+func (c *Client) processPubrec(id packet.ID, code packet.ReasonCode) error {
+	// complete the flow if the broker rejected the message
+	if code.Failed() {
+		return c.processPubackAndPubcomp(id, code)
+	}
+
 	// prepare pubrel packet
 	pubrel := packet.NewPubrelPacket()
 	pubrel.ID = id
@@ -693,7 +1578,7 @@ func (c *Client) processPubrec(id packet.ID) error {
 	}
 
 	// send packet
-	err = c.send(pubrel, true)
+	err = c.queue(pubrel, true)
 	if err != nil {
 		return c.die(err, false, false)
 	}
@@ -715,20 +1600,38 @@ func (c *Client) processPubrel(id packet.ID) error {
 		return nil // ignore a wrongly sent PubrelPacket
 	}
 
+	// cache message
+	if c.cache != nil {
+		c.cache.put(&publish.Message)
+	}
+
+	// keep message for manual ack before calling the callback as the message
+	// may be acknowledged from another goroutine
+	if c.ManualAcks {
+		c.addPending(&publish.Message, publish.ID)
+	}
+
 	// call callback
 	if c.Callback != nil {
 		err = c.Callback(&publish.Message, nil)
-		if err != nil {
+		if err != nil && c.ManualAcks {
+			return nil
+		} else if err != nil {
 			return c.die(err, true, true)
 		}
 	}
 
+	// return if acknowledged manually
+	if c.ManualAcks {
+		return nil
+	}
+
 	// prepare pubcomp packet
 	pubcomp := packet.NewPubcompPacket()
 	pubcomp.ID = publish.ID
 
 	// acknowledge PublishPacket
-	err = c.send(pubcomp, true)
+	err = c.queue(pubcomp, true)
 	if err != nil {
 		return c.die(err, false, false)
 	}
@@ -757,14 +1660,14 @@ func (c *Client) pinger() error {
 				return c.die(ErrClientMissingPong, true, false)
 			}
 
-			// send pingreq packet
-			err := c.send(packet.NewPingreqPacket(), true)
+			// save ping attempt
+			c.tracker.ping()
+
+			// send pingreq packet and wait until it has been written
+			err := c.sendQueued(packet.NewPingreqPacket(), true)
 			if err != nil {
 				return c.die(err, false, false)
 			}
-
-			// save ping attempt
-			c.tracker.ping()
 		} else {
 			// log keep alive delay
 			if c.Logger != nil {
@@ -781,8 +1684,169 @@ func (c *Client) pinger() error {
 	}
 }
 
+/* checkpointer goroutine */
+
+// periodically passes snapshots of the session to the checkpoint function
+func (c *Client) checkpointer() error {
+	// create ticker
+	ticker := time.NewTicker(c.CheckpointInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := c.checkpoint()
+			if err != nil {
+				return c.die(err, true, false)
+			}
+		case <-c.tomb.Dying():
+			// take final checkpoint
+			_ = c.checkpoint()
+
+			return tomb.ErrDying
+		}
+	}
+}
+
+// takes a snapshot of the session and passes it to the checkpoint function
+func (c *Client) checkpoint() error {
+	// get snapshot
+	snapshot, err := c.Session.(SnapshotSession).Snapshot()
+	if err != nil {
+		return err
+	}
+
+	return c.Checkpoint(snapshot)
+}
+
+/* writer goroutine */
+
+// writes queued packets to the connection while urgent packets like pings and
+// acknowledgements are preferred over regular packets
+func (c *Client) writer() error {
+	for {
+		// send urgent packets first
+		select {
+		case out := <-c.urgent:
+			err := c.write(out)
+			if err != nil {
+				return c.die(err, false, false)
+			}
+
+			continue
+		default:
+		}
+
+		// wait for next packet
+		var out outgoing
+		select {
+		case <-c.tomb.Dying():
+			return tomb.ErrDying
+		case out = <-c.urgent:
+		case out = <-c.regular:
+		}
+
+		// send packet
+		err := c.write(out)
+		if err != nil {
+			return c.die(err, false, false)
+		}
+	}
+}
+
+// writes a packet and reports the result if requested
+func (c *Client) write(out outgoing) error {
+	// stream payload if present or send packet buffered unless a result is
+	// expected
+	var err error
+	if out.payload != nil {
+		err = c.sendStream(out.pkt.(*packet.PublishPacket), out.payload, out.size)
+	} else {
+		err = c.send(out.pkt, out.result == nil)
+	}
+
+	// report result
+	if out.result != nil {
+		out.result <- err
+
+		// failed streams leave the connection closed
+		if out.payload == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
 /* helpers */
 
+// queues a packet for the writer goroutine
+func (c *Client) queue(pkt packet.GenericPacket, urgent bool) error {
+	return c.queueContext(context.Background(), pkt, urgent)
+}
+
+// queues a packet for the writer goroutine unless the context is done
+func (c *Client) queueContext(ctx context.Context, pkt packet.GenericPacket, urgent bool) error {
+	// check context
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	// select queue
+	queue := c.regular
+	if urgent {
+		queue = c.urgent
+	}
+
+	// queue packet
+	select {
+	case queue <- outgoing{pkt: pkt}:
+		return nil
+	case <-c.tomb.Dying():
+		return ErrClientNotConnected
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queues a packet and waits until it has been sent by the writer goroutine
+func (c *Client) sendQueued(pkt packet.GenericPacket, urgent bool) error {
+	// select queue
+	queue := c.regular
+	if urgent {
+		queue = c.urgent
+	}
+
+	// prepare result
+	result := make(chan error, 1)
+
+	// queue packet
+	select {
+	case queue <- outgoing{pkt: pkt, result: result}:
+	case <-c.tomb.Dying():
+		return ErrClientNotConnected
+	}
+
+	// await result
+	select {
+	case err := <-result:
+		return err
+	case <-c.tomb.Dying():
+		return ErrClientNotConnected
+	}
+}
+
+// returns the current connection or nil if not yet established
+func (c *Client) connection() transport.Conn {
+	conn := c.conn.Load()
+	if conn == nil {
+		return nil
+	}
+
+	return *conn
+}
+
 // sends packet and updates lastSend
 func (c *Client) send(pkt packet.GenericPacket, buffered bool) error {
 	// reset keep alive tracker
@@ -791,9 +1855,9 @@ func (c *Client) send(pkt packet.GenericPacket, buffered bool) error {
 	// send packet
 	var err error
 	if buffered {
-		err = c.conn.BufferedSend(pkt)
+		err = c.connection().BufferedSend(pkt)
 	} else {
-		err = c.conn.Send(pkt)
+		err = c.connection().Send(pkt)
 	}
 	if err != nil {
 		return err
@@ -804,9 +1868,72 @@ func (c *Client) send(pkt packet.GenericPacket, buffered bool) error {
 		c.Logger(fmt.Sprintf("Sent: %s", pkt.String()))
 	}
 
+	// count sent packet
+	c.countSent(pkt, 0)
+
+	return nil
+}
+
+// streams a publish packet and updates lastSend
+func (c *Client) sendStream(pkt *packet.PublishPacket, payload io.Reader, size int64) error {
+	// reset keep alive tracker
+	c.tracker.reset()
+
+	// send packet
+	err := c.connection().SendStream(pkt, payload, size)
+	if err != nil {
+		return err
+	}
+
+	// log sent packet
+	if c.Logger != nil {
+		c.Logger(fmt.Sprintf("Sent: %s (streamed %d bytes)", pkt.String(), size))
+	}
+
+	// count sent packet
+	c.countSent(pkt, size)
+
 	return nil
 }
 
+// removes inflight messages from the metrics, messages sent on a previous
+// connection have already been removed
+func (c *Client) uncount(n int64) {
+	for {
+		// get count
+		counted := atomic.LoadInt64(&c.counted)
+		if counted == 0 {
+			return
+		}
+		if n > counted {
+			n = counted
+		}
+
+		// update count
+		if atomic.CompareAndSwapInt64(&c.counted, counted, counted-n) {
+			atomic.AddInt64(&c.Metrics.inflight, -n)
+			return
+		}
+	}
+}
+
+// counts a sent packet and inflight messages if metrics are configured
+func (c *Client) countSent(pkt packet.GenericPacket, size int64) {
+	// check metrics
+	if c.Metrics == nil {
+		return
+	}
+
+	// count packet
+	c.Metrics.countSent(pkt, size)
+
+	// count inflight message
+	if publish, ok := pkt.(*packet.PublishPacket); ok && publish.Message.QOS > 0 {
+		atomic.AddInt64(&c.counted, 1)
+		atomic.AddInt64(&c.Metrics.inflight, 1)
+	}
+}
+
 // will try to cleanup as many resources as possible
 func (c *Client) cleanup(err error, doClose bool, possiblyClosed bool) error {
 	// cancel connect future if appropriate
@@ -819,7 +1946,7 @@ func (c *Client) cleanup(err error, doClose bool, possiblyClosed bool) error {
 
 	// ensure that the connection gets closed
 	if doClose {
-		connErr := c.conn.Close()
+		connErr := c.connection().Close()
 		if connErr != nil && err == nil && !possiblyClosed {
 			err = connErr
 		}
@@ -836,14 +1963,139 @@ func (c *Client) cleanup(err error, doClose bool, possiblyClosed bool) error {
 	// cancel all futures
 	c.futureStore.Clear()
 
+	// cancel all receipts
+	c.cancelReceipts()
+
+	// forget pending messages
+	c.pendingMutex.Lock()
+	c.pending = make(map[*packet.Message]packet.ID)
+	c.pendingMutex.Unlock()
+
+	// remove inflight messages from metrics
+	if c.Metrics != nil {
+		c.uncount(atomic.LoadInt64(&c.counted))
+	}
+
 	return err
 }
 
+// acquire a slot of the inflight window or wait for one if configured
+func (c *Client) acquireInflight(ctx context.Context) error {
+	// check window
+	if c.inflight == nil {
+		return nil
+	}
+
+	// try to acquire slot
+	select {
+	case c.inflight <- struct{}{}:
+		return nil
+	default:
+	}
+
+	// check if waiting
+	if !c.config.WaitInflight {
+		return ErrClientInflightExceeded
+	}
+
+	// wait for slot
+	select {
+	case c.inflight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.tomb.Dying():
+		return ErrClientNotConnected
+	}
+}
+
+// assign the acquired slot of the inflight window to the specified packet
+func (c *Client) assignInflight(id packet.ID) {
+	c.inflightMutex.Lock()
+	c.inflightIDs[id] = struct{}{}
+	c.inflightMutex.Unlock()
+}
+
+// remove the slot assignment of the specified packet and return whether the
+// packet held a slot
+func (c *Client) unassignInflight(id packet.ID) bool {
+	// check window
+	if c.inflight == nil {
+		return false
+	}
+
+	c.inflightMutex.Lock()
+	defer c.inflightMutex.Unlock()
+
+	// remove assignment
+	_, ok := c.inflightIDs[id]
+	delete(c.inflightIDs, id)
+
+	return ok
+}
+
+// release the slot of the inflight window held by the specified packet,
+// acknowledgements of resent, completed or unknown packets are ignored as
+// they never acquired a slot
+func (c *Client) releaseInflight(id packet.ID) {
+	if c.unassignInflight(id) {
+		<-c.inflight
+	}
+}
+
+// keeps a message until it is acknowledged manually
+func (c *Client) addPending(msg *packet.Message, id packet.ID) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+
+	c.pending[msg] = id
+}
+
+// emits the receipt for an acknowledged message if tracked
+func (c *Client) emitReceipt(id packet.ID) {
+	// get and remove topic
+	c.receiptsMutex.Lock()
+	topic, ok := c.receipts[id]
+	delete(c.receipts, id)
+	c.receiptsMutex.Unlock()
+
+	// check topic
+	if !ok {
+		return
+	}
+
+	// send receipt
+	select {
+	case c.Receipts <- Receipt{ID: id, Topic: topic}:
+	case <-c.tomb.Dying():
+	}
+}
+
+// emits receipts for all pending messages if the channel is not full
+func (c *Client) cancelReceipts() {
+	c.receiptsMutex.Lock()
+	defer c.receiptsMutex.Unlock()
+
+	// send receipts
+	for id, topic := range c.receipts {
+		select {
+		case c.Receipts <- Receipt{ID: id, Topic: topic, Err: future.ErrCanceled}:
+		default:
+		}
+
+		// remove topic
+		delete(c.receipts, id)
+	}
+}
+
 // used for closing and cleaning up from internal goroutines
 func (c *Client) die(err error, close bool, fromCallback bool) error {
 	c.finish.Do(func() {
 		err = c.cleanup(err, close, false)
 
+		// shutdown remaining goroutines
+		c.tomb.Kill(err)
+
 		if c.Callback != nil && !fromCallback {
 			returnedErr := c.Callback(nil, err)
 			if returnedErr == nil {
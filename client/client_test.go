@@ -1,8 +1,12 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -10,7 +14,9 @@ import (
 
 	"github.com/256dpi/gomqtt/client/future"
 	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/routines"
 	"github.com/256dpi/gomqtt/session"
+	"github.com/256dpi/gomqtt/topic"
 	"github.com/256dpi/gomqtt/transport"
 	"github.com/256dpi/gomqtt/transport/flow"
 	"github.com/stretchr/testify/assert"
@@ -60,6 +66,125 @@ func TestClientConnectErrorMissingClientID(t *testing.T) {
 	assert.Nil(t, connectFuture)
 }
 
+func TestClientConnectAbortDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tls://" + listener.Addr().String())
+	config.Dialer = transport.NewDialer()
+	config.Dialer.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, c.Close())
+	}()
+
+	connectFuture, err := c.Connect(config)
+	assert.Error(t, err)
+	assert.Nil(t, connectFuture)
+
+	assert.Equal(t, ErrClientNotConnected, c.Close())
+
+	assert.NoError(t, listener.Close())
+}
+
+func TestClientConnectContext(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	connectFuture, err := c.ConnectContext(ctx, NewConfig("tcp://localhost:"+port))
+	assert.NoError(t, err)
+	assert.Equal(t, future.ErrCanceled, connectFuture.Wait(1*time.Second))
+
+	safeReceive(done)
+}
+
+func TestClientContextMethods(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test"}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{0}
+	suback.ID = 1
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(publish).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	connectFuture, err := c.ConnectContext(ctx, NewConfig("tcp://localhost:"+port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.WaitContext(ctx))
+
+	subscribeFuture, err := c.SubscribeContext(ctx, "test", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, subscribeFuture.WaitContext(ctx))
+	assert.Equal(t, []uint8{0}, subscribeFuture.ReturnCodes())
+
+	publishFuture, err := c.PublishContext(ctx, "test", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, publishFuture.WaitContext(ctx))
+
+	canceled, cancel2 := context.WithCancel(context.Background())
+	cancel2()
+
+	publishFuture, err = c.PublishContext(canceled, "test", []byte("test"), 1, false)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, publishFuture)
+
+	out, err := c.Session.AllPackets(session.Outgoing)
+	assert.NoError(t, err)
+	assert.Empty(t, out)
+
+	_, err = c.SubscribeContext(canceled, "test", 0)
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = c.UnsubscribeContext(canceled, "test")
+	assert.Equal(t, context.Canceled, err)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
 func TestClientConnect(t *testing.T) {
 	broker := flow.New().
 		Receive(connectPacket()).
@@ -72,14 +197,22 @@ func TestClientConnect(t *testing.T) {
 	c := New()
 	c.Callback = errorCallback(t)
 
+	reason := make(chan transport.CloseReason, 1)
+	go func() {
+		reason <- c.CloseReason()
+	}()
+
 	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
 	assert.NoError(t, err)
 	assert.NoError(t, connectFuture.Wait(1*time.Second))
 	assert.False(t, connectFuture.SessionPresent())
 	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+	assert.Equal(t, transport.NotClosed, c.CloseReason())
+	assert.Equal(t, transport.NotClosed, <-reason)
 
 	err = c.Disconnect()
 	assert.NoError(t, err)
+	assert.Equal(t, transport.LocalClose, c.CloseReason())
 
 	safeReceive(done)
 }
@@ -111,6 +244,68 @@ func TestClientConnectCustomDialer(t *testing.T) {
 	safeReceive(done)
 }
 
+func TestClientConnectTLSConfig(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	cert, pool := generateCertificate()
+
+	done, port := fakeSecureBroker(t, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+	}, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("mqtts://localhost:" + port)
+	config.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientConnectTLSConfigUnknownAuthority(t *testing.T) {
+	cert, _ := generateCertificate()
+
+	server, err := transport.NewSecureNetServer("localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	assert.NoError(t, err)
+
+	go func() {
+		conn, err := server.Accept()
+		if err == nil {
+			_, _ = conn.Receive()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(server.Addr().String())
+
+	config := NewConfig("mqtts://localhost:" + port)
+	config.TLSConfig = &tls.Config{}
+
+	_, err = New().Connect(config)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, transport.ErrTLSHandshake))
+
+	err = server.Close()
+	assert.NoError(t, err)
+}
+
 func TestClientConnectAfterConnect(t *testing.T) {
 	broker := flow.New().
 		Receive(connectPacket()).
@@ -300,6 +495,82 @@ func TestClientKeepAlive(t *testing.T) {
 	safeReceive(done)
 }
 
+func TestClientServerKeepAlive(t *testing.T) {
+	connect := connectPacket()
+	connect.Version = packet.Version5
+	connect.KeepAlive = 30
+
+	connack := connackPacket()
+	connack.Properties = connack.Properties.Set(packet.ServerKeepAliveProperty, uint16(1))
+
+	broker := flow.New().
+		Receive(connect).
+		Send(connack).
+		Receive(packet.NewPingreqPacket()).
+		Send(packet.NewPingrespPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.Version = packet.Version5
+
+	assert.Equal(t, time.Duration(0), c.KeepAlive())
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.Equal(t, time.Second, c.KeepAlive())
+
+	<-time.After(1200 * time.Millisecond)
+	assert.False(t, c.LastPong().IsZero())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientServerKeepAliveDisabled(t *testing.T) {
+	connect := connectPacket()
+	connect.Version = packet.Version5
+	connect.KeepAlive = 0
+
+	connack := connackPacket()
+	connack.Properties = connack.Properties.Set(packet.ServerKeepAliveProperty, uint16(0))
+
+	broker := flow.New().
+		Receive(connect).
+		Send(connack).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.Version = packet.Version5
+	config.KeepAlive = "100ms"
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.Equal(t, time.Duration(0), c.KeepAlive())
+
+	<-time.After(250 * time.Millisecond)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
 func TestClientKeepAliveTimeout(t *testing.T) {
 	connect := connectPacket()
 	connect.KeepAlive = 0
@@ -406,96 +677,346 @@ func TestClientPublishSubscribeQOS0(t *testing.T) {
 	assert.Equal(t, 0, len(out))
 }
 
-func TestClientPublishSubscribeQOS1(t *testing.T) {
-	subscribe := packet.NewSubscribePacket()
-	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 1}}
-	subscribe.ID = 1
-
-	suback := packet.NewSubackPacket()
-	suback.ReturnCodes = []uint8{1}
-	suback.ID = 1
+func TestClientPublishReader(t *testing.T) {
+	publish0 := packet.NewPublishPacket()
+	publish0.Message.Topic = "test"
+	publish0.Message.Payload = []byte("test")
 
-	publish := packet.NewPublishPacket()
-	publish.Message.Topic = "test"
-	publish.Message.Payload = []byte("test")
-	publish.Message.QOS = 1
-	publish.ID = 2
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("test")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
 
 	puback := packet.NewPubackPacket()
-	puback.ID = 2
+	puback.ID = 1
 
 	broker := flow.New().
 		Receive(connectPacket()).
 		Send(connackPacket()).
-		Receive(subscribe).
-		Send(suback).
-		Receive(publish).
+		Receive(publish0).
+		Receive(publish1).
 		Send(puback).
-		Send(publish).
-		Receive(puback).
 		Receive(disconnectPacket()).
 		End()
 
 	done, port := fakeBroker(t, broker)
 
-	wait := make(chan struct{})
-
 	c := New()
-	c.Callback = func(msg *packet.Message, err error) error {
-		assert.NoError(t, err)
-		assert.Equal(t, "test", msg.Topic)
-		assert.Equal(t, []byte("test"), msg.Payload)
-		assert.Equal(t, uint8(1), msg.QOS)
-		assert.False(t, msg.Retain)
-		close(wait)
-		return nil
-	}
 
 	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
 	assert.NoError(t, err)
 	assert.NoError(t, connectFuture.Wait(1*time.Second))
-	assert.False(t, connectFuture.SessionPresent())
-	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
 
-	subscribeFuture, err := c.Subscribe("test", 1)
+	publishFuture, err := c.PublishReader("test", strings.NewReader("test"), 4, 0, false)
 	assert.NoError(t, err)
-	assert.NoError(t, subscribeFuture.Wait(1*time.Second))
-	assert.Equal(t, []uint8{1}, subscribeFuture.ReturnCodes())
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
 
-	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	publishFuture, err = c.PublishReader("test", strings.NewReader("test"), 4, 1, false)
 	assert.NoError(t, err)
 	assert.NoError(t, publishFuture.Wait(1*time.Second))
 
-	safeReceive(wait)
+	_, err = c.PublishReader("test", strings.NewReader("te"), 4, 1, false)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
 
 	err = c.Disconnect()
 	assert.NoError(t, err)
 
 	safeReceive(done)
+}
 
-	in, err := c.Session.AllPackets(session.Incoming)
-	assert.NoError(t, err)
-	assert.Equal(t, 0, len(in))
+func TestClientTopicLimits(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
 
-	out, err := c.Session.AllPackets(session.Outgoing)
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.MaxTopicLevels = 2
+	config.MaxTopicLevelLength = 3
+
+	connectFuture, err := c.Connect(config)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, len(out))
-}
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
 
-func TestClientPublishSubscribeQOS2(t *testing.T) {
-	subscribe := packet.NewSubscribePacket()
-	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 2}}
-	subscribe.ID = 1
+	_, err = c.Publish("a/b/c", nil, 0, false)
+	assert.Equal(t, topic.ErrTooManyLevels, err)
 
-	suback := packet.NewSubackPacket()
-	suback.ReturnCodes = []uint8{2}
-	suback.ID = 1
+	_, err = c.PublishReader("a/bcde", strings.NewReader(""), 0, 0, false)
+	assert.Equal(t, topic.ErrLevelTooLong, err)
 
-	publish := packet.NewPublishPacket()
-	publish.Message.Topic = "test"
-	publish.Message.Payload = []byte("test")
-	publish.Message.QOS = 2
-	publish.ID = 2
+	_, err = c.Subscribe("a/b/#", 0)
+	assert.Equal(t, topic.ErrTooManyLevels, err)
+
+	_, err = c.Subscribe(SharedFilter("group", "abcd"), 0)
+	assert.Equal(t, topic.ErrLevelTooLong, err)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientPublishReaderError(t *testing.T) {
+	wait := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Wait(wait).
+		Close()
+
+	done, port := fakeBroker(t, broker)
+
+	errs := make(chan error, 1)
+
+	c := New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		errs <- err
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	_, err = c.PublishReader("test", strings.NewReader("te"), 4, 0, false)
+	assert.True(t, errors.Is(err, transport.ErrEncode))
+
+	assert.Error(t, <-errs)
+	close(wait)
+
+	safeReceive(done)
+}
+
+func TestClientStreamCallback(t *testing.T) {
+	large := packet.NewPublishPacket()
+	large.Message.Topic = "test"
+	large.Message.Payload = make([]byte, 4096)
+
+	small := packet.NewPublishPacket()
+	small.Message.Topic = "test"
+	small.Message.Payload = []byte("test")
+
+	wait := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(large).
+		Send(large).
+		Send(small).
+		Wait(wait).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	var streamed [][]byte
+
+	c := New()
+	c.StreamThreshold = 1024
+	c.StreamCallback = func(msg *packet.Message, payload io.Reader, size int64) error {
+		assert.Equal(t, "test", msg.Topic)
+		assert.Empty(t, msg.Payload)
+		assert.Equal(t, int64(4096), size)
+
+		data, err := io.ReadAll(payload)
+		assert.NoError(t, err)
+		streamed = append(streamed, data)
+
+		return nil
+	}
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("test"), msg.Payload)
+		close(wait)
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	safeReceive(wait)
+
+	assert.Equal(t, [][]byte{large.Message.Payload, large.Message.Payload}, streamed)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientPublishSubscribeQOS1(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 1}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{1}
+	suback.ID = 1
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 1
+	publish.ID = 2
+
+	puback := packet.NewPubackPacket()
+	puback.ID = 2
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(publish).
+		Send(puback).
+		Send(publish).
+		Receive(puback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	wait := make(chan struct{})
+
+	c := New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "test", msg.Topic)
+		assert.Equal(t, []byte("test"), msg.Payload)
+		assert.Equal(t, uint8(1), msg.QOS)
+		assert.False(t, msg.Retain)
+		close(wait)
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.False(t, connectFuture.SessionPresent())
+	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+
+	subscribeFuture, err := c.Subscribe("test", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, subscribeFuture.Wait(1*time.Second))
+	assert.Equal(t, []uint8{1}, subscribeFuture.ReturnCodes())
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+
+	safeReceive(wait)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	in, err := c.Session.AllPackets(session.Incoming)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(in))
+
+	out, err := c.Session.AllPackets(session.Outgoing)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(out))
+}
+
+func TestClientReceipts(t *testing.T) {
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "foo"
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	puback := packet.NewPubackPacket()
+	puback.ID = 1
+
+	publish2 := packet.NewPublishPacket()
+	publish2.Message.Topic = "bar"
+	publish2.Message.QOS = 2
+	publish2.ID = 2
+
+	pubrec := packet.NewPubrecPacket()
+	pubrec.ID = 2
+
+	pubrel := packet.NewPubrelPacket()
+	pubrel.ID = 2
+
+	pubcomp := packet.NewPubcompPacket()
+	pubcomp.ID = 2
+
+	publish3 := packet.NewPublishPacket()
+	publish3.Message.Topic = "baz"
+	publish3.Message.QOS = 1
+	publish3.ID = 3
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish1).
+		Send(puback).
+		Receive(publish2).
+		Send(pubrec).
+		Receive(pubrel).
+		Send(pubcomp).
+		Receive(publish3).
+		Close()
+
+	done, port := fakeBroker(t, broker)
+
+	receipts := make(chan Receipt, 10)
+	closed := make(chan struct{})
+
+	c := New()
+	c.Receipts = receipts
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.Error(t, err)
+		close(closed)
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	_, err = c.Publish("foo", nil, 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipt{ID: 1, Topic: "foo"}, <-receipts)
+
+	_, err = c.Publish("bar", nil, 2, false)
+	assert.NoError(t, err)
+	assert.Equal(t, Receipt{ID: 2, Topic: "bar"}, <-receipts)
+
+	_, err = c.Publish("baz", nil, 1, false)
+	assert.NoError(t, err)
+
+	safeReceive(closed)
+	safeReceive(done)
+
+	assert.Equal(t, Receipt{ID: 3, Topic: "baz", Err: future.ErrCanceled}, <-receipts)
+}
+
+func TestClientPublishSubscribeQOS2(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 2}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{2}
+	suback.ID = 1
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 2
+	publish.ID = 2
 
 	pubrec := packet.NewPubrecPacket()
 	pubrec.ID = 2
@@ -568,64 +1089,662 @@ func TestClientPublishSubscribeQOS2(t *testing.T) {
 	assert.Equal(t, 0, len(out))
 }
 
-func TestClientUnsubscribe(t *testing.T) {
-	unsubscribe := packet.NewUnsubscribePacket()
-	unsubscribe.Topics = []string{"test"}
-	unsubscribe.ID = 1
+func TestClientUnsubscribe(t *testing.T) {
+	unsubscribe := packet.NewUnsubscribePacket()
+	unsubscribe.Topics = []string{"test"}
+	unsubscribe.ID = 1
+
+	unsuback := packet.NewUnsubackPacket()
+	unsuback.ID = 1
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(unsubscribe).
+		Send(unsuback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.False(t, connectFuture.SessionPresent())
+	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+
+	unsubscribeFuture, err := c.Unsubscribe("test")
+	assert.NoError(t, err)
+	assert.NoError(t, unsubscribeFuture.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientHardDisconnect(t *testing.T) {
+	connect := connectPacket()
+	connect.ClientID = "test"
+	connect.CleanSession = false
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 1
+	publish.ID = 1
+
+	broker := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(publish).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.ClientID = "test"
+	config.CleanSession = false
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.False(t, connectFuture.SessionPresent())
+	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+	assert.NotNil(t, publishFuture)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	assert.Equal(t, future.ErrCanceled, publishFuture.Wait(1*time.Second))
+
+	safeReceive(done)
+
+	list, err := c.Session.AllPackets(session.Outgoing)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+}
+
+func TestClientDisconnectWithTimeout(t *testing.T) {
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 1
+	publish.ID = 1
+
+	puback := packet.NewPubackPacket()
+	puback.ID = 1
+
+	wait := func() {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish).
+		Run(wait).
+		Send(puback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.False(t, connectFuture.SessionPresent())
+	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+	assert.NotNil(t, publishFuture)
+
+	err = c.Disconnect(10 * time.Second)
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+
+	list, err := c.Session.AllPackets(session.Outgoing)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestClientDisconnectDrain(t *testing.T) {
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 2
+	publish.ID = 1
+
+	pubrec := packet.NewPubrecPacket()
+	pubrec.ID = 1
+
+	pubrel := packet.NewPubrelPacket()
+	pubrel.ID = 1
+
+	pubcomp := packet.NewPubcompPacket()
+	pubcomp.ID = 1
+
+	received := make(chan struct{})
+	draining := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(publish).
+		Receive(pubrec).
+		Run(func() {
+			close(received)
+			safeReceive(draining)
+		}).
+		Send(pubrel).
+		Receive(pubcomp).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	wait := make(chan struct{})
+
+	c := New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "test", msg.Topic)
+		close(wait)
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	safeReceive(received)
+
+	result := make(chan error)
+	go func() {
+		result <- c.Disconnect(10 * time.Second)
+	}()
+
+	for atomic.LoadUint32(&c.draining) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = c.Publish("test", []byte("test"), 0, false)
+	assert.Equal(t, ErrClientDraining, err)
+
+	_, err = c.Subscribe("test", 0)
+	assert.Equal(t, ErrClientDraining, err)
+
+	close(draining)
+
+	safeReceive(wait)
+	assert.NoError(t, <-result)
+
+	safeReceive(done)
+
+	list, err := c.Session.AllPackets(session.Incoming)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+}
+
+func TestClientCheckpoint(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 1}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{1}
+	suback.ID = 1
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	snapshots := make(chan *session.Snapshot, 100)
+
+	c := New()
+	c.Callback = errorCallback(t)
+	c.CheckpointInterval = 10 * time.Millisecond
+	c.Checkpoint = func(snapshot *session.Snapshot) error {
+		snapshots <- snapshot
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	subscribeFuture, err := c.Subscribe("test", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, subscribeFuture.Wait(1*time.Second))
+
+	for snapshot := range snapshots {
+		if len(snapshot.Subscriptions) > 0 {
+			assert.Equal(t, packet.ID(2), snapshot.NextID)
+			assert.Equal(t, []packet.Subscription{{Topic: "test", QOS: 1}}, snapshot.Subscriptions)
+			break
+		}
+	}
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	c = New()
+	c.Session = &struct{ Session }{session.NewMemorySession()}
+	c.Checkpoint = func(*session.Snapshot) error {
+		return nil
+	}
+
+	_, err = c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.Equal(t, ErrClientSnapshotUnsupported, err)
+}
+
+func TestClientSubscriptionsNotStored(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 1}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{1}
+	suback.ID = 1
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	memory := session.NewMemorySession()
+
+	c := New()
+	c.Session = memory
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	subscribeFuture, err := c.Subscribe("test", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, subscribeFuture.Wait(1*time.Second))
+
+	subs, err := memory.AllSubscriptions()
+	assert.NoError(t, err)
+	assert.Empty(t, subs)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientClose(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.False(t, connectFuture.SessionPresent())
+	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+
+	err = c.Close()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientInvalidPackets(t *testing.T) {
+	c := New()
+
+	// state not connecting
+	err := c.processConnack(packet.NewConnackPacket())
+	assert.NoError(t, err)
+
+	c.state = clientConnecting
+
+	// missing future
+	err = c.processSuback(packet.NewSubackPacket())
+	assert.NoError(t, err)
+
+	// missing future
+	err = c.processUnsuback(packet.NewUnsubackPacket())
+	assert.NoError(t, err)
+
+	// missing future
+	err = c.processPubrel(0)
+	assert.NoError(t, err)
+
+	// missing future
+	err = c.processPubackAndPubcomp(0, 0)
+	assert.NoError(t, err)
+}
+
+func TestClientMaxInflight(t *testing.T) {
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("test")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	puback1 := packet.NewPubackPacket()
+	puback1.ID = 1
+
+	publish2 := packet.NewPublishPacket()
+	publish2.Message = publish1.Message
+	publish2.ID = 2
+
+	puback2 := packet.NewPubackPacket()
+	puback2.ID = 2
+
+	publish0 := packet.NewPublishPacket()
+	publish0.Message.Topic = "test"
+	publish0.Message.Payload = []byte("test")
+
+	release := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish1).
+		Receive(publish0).
+		Wait(release).
+		Send(puback1).
+		Receive(publish2).
+		Send(puback2).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.MaxInflight = 1
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	pf1, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+
+	_, err = c.Publish("test", []byte("test"), 1, false)
+	assert.Equal(t, ErrClientInflightExceeded, err)
+
+	pf, err := c.Publish("test", []byte("test"), 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf.Wait(1*time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	config.WaitInflight = true
+	_, err = c.PublishContext(ctx, "test", []byte("test"), 1, false)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	close(release)
+
+	pf2, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf1.Wait(1*time.Second))
+	assert.NoError(t, pf2.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientMaxInflightFailedPubrec(t *testing.T) {
+	connect := connectPacket()
+	connect.Version = packet.Version5
+
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("test")
+	publish1.Message.QOS = 2
+	publish1.ID = 1
+
+	pubrec1 := packet.NewPubrecPacket()
+	pubrec1.ID = 1
+	pubrec1.ReasonCode = packet.QuotaExceeded
+
+	publish2 := packet.NewPublishPacket()
+	publish2.Message = publish1.Message
+	publish2.ID = 2
+
+	pubrec2 := packet.NewPubrecPacket()
+	pubrec2.ID = 2
+
+	pubrel2 := packet.NewPubrelPacket()
+	pubrel2.ID = 2
+
+	pubcomp2 := packet.NewPubcompPacket()
+	pubcomp2.ID = 2
+
+	broker := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(publish1).
+		Send(pubrec1).
+		Receive(publish2).
+		Send(pubrec2).
+		Receive(pubrel2).
+		Send(pubcomp2).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.Version = packet.Version5
+	config.MaxInflight = 1
+	config.WaitInflight = true
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	pf1, err := c.Publish("test", []byte("test"), 2, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf1.Wait(1*time.Second))
+	assert.Equal(t, packet.QuotaExceeded, pf1.ReasonCode())
+
+	pf2, err := c.Publish("test", []byte("test"), 2, false)
+	assert.NoError(t, err)
+	assert.NoError(t, pf2.Wait(1*time.Second))
+	assert.Equal(t, packet.Success, pf2.ReasonCode())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientMaxInflightStrayAck(t *testing.T) {
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("test")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	puback1 := packet.NewPubackPacket()
+	puback1.ID = 1
+
+	stray := packet.NewPubackPacket()
+	stray.ID = 7
+
+	incoming := packet.NewPublishPacket()
+	incoming.Message.Topic = "test"
+	incoming.Message.Payload = []byte("test")
+
+	release := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish1).
+		Send(stray).
+		Send(incoming).
+		Wait(release).
+		Send(puback1).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	received := make(chan struct{})
+
+	c := New()
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		close(received)
+		return nil
+	}
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.MaxInflight = 1
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	pf1, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+
+	safeReceive(received)
+
+	_, err = c.Publish("test", []byte("test"), 1, false)
+	assert.Equal(t, ErrClientInflightExceeded, err)
+
+	close(release)
+
+	assert.NoError(t, pf1.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestClientPublishFuture(t *testing.T) {
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 1
+	publish.ID = 1
 
-	unsuback := packet.NewUnsubackPacket()
-	unsuback.ID = 1
+	puback := packet.NewPubackPacket()
+	puback.ID = 1
 
 	broker := flow.New().
 		Receive(connectPacket()).
 		Send(connackPacket()).
-		Receive(unsubscribe).
-		Send(unsuback).
+		Receive(publish).
+		Delay(10 * time.Millisecond).
+		Send(puback).
 		Receive(disconnectPacket()).
 		End()
 
 	done, port := fakeBroker(t, broker)
 
 	c := New()
-	c.Callback = errorCallback(t)
 
 	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
 	assert.NoError(t, err)
 	assert.NoError(t, connectFuture.Wait(1*time.Second))
-	assert.False(t, connectFuture.SessionPresent())
-	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
 
-	unsubscribeFuture, err := c.Unsubscribe("test")
+	pubFuture, err := c.Publish("test", []byte("test"), 1, false)
 	assert.NoError(t, err)
-	assert.NoError(t, unsubscribeFuture.Wait(1*time.Second))
+	assert.Equal(t, time.Duration(0), pubFuture.Latency())
+	assert.True(t, pubFuture.Acknowledged().IsZero())
+	assert.NoError(t, pubFuture.Wait(1*time.Second))
+	assert.Equal(t, uint8(1), pubFuture.QOS())
+	assert.Equal(t, packet.ReasonCode(0), pubFuture.ReasonCode())
+	assert.False(t, pubFuture.Sent().IsZero())
+	assert.True(t, pubFuture.Acknowledged().After(pubFuture.Sent()))
+	assert.True(t, pubFuture.Latency() >= 10*time.Millisecond)
 
 	err = c.Disconnect()
 	assert.NoError(t, err)
 
 	safeReceive(done)
+
+	// reason code
+	c = New()
+	f := newPublishFuture(2)
+	c.futureStore.Put(1, f)
+	assert.NoError(t, c.processPubackAndPubcomp(1, packet.NoMatchingSubscribers))
+	assert.Equal(t, packet.NoMatchingSubscribers, (&publishFuture{f}).ReasonCode())
+	assert.Equal(t, uint8(2), (&publishFuture{f}).QOS())
 }
 
-func TestClientHardDisconnect(t *testing.T) {
+func TestClientSessionResumption(t *testing.T) {
 	connect := connectPacket()
 	connect.ClientID = "test"
 	connect.CleanSession = false
 
-	publish := packet.NewPublishPacket()
-	publish.Message.Topic = "test"
-	publish.Message.Payload = []byte("test")
-	publish.Message.QOS = 1
-	publish.ID = 1
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "test"
+	publish1.Message.Payload = []byte("test")
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	puback1 := packet.NewPubackPacket()
+	puback1.ID = 1
 
 	broker := flow.New().
 		Receive(connect).
 		Send(connackPacket()).
-		Receive(publish).
+		Receive(publish1).
+		Send(puback1).
 		Receive(disconnectPacket()).
 		End()
 
 	done, port := fakeBroker(t, broker)
 
 	c := New()
+	c.Session.SavePacket(session.Outgoing, publish1)
+	c.Session.NextID()
 	c.Callback = errorCallback(t)
 
 	config := NewConfig("tcp://localhost:" + port)
@@ -638,149 +1757,110 @@ func TestClientHardDisconnect(t *testing.T) {
 	assert.False(t, connectFuture.SessionPresent())
 	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
 
-	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
-	assert.NoError(t, err)
-	assert.NotNil(t, publishFuture)
+	time.Sleep(20 * time.Millisecond)
 
 	err = c.Disconnect()
 	assert.NoError(t, err)
 
-	assert.Equal(t, future.ErrCanceled, publishFuture.Wait(1*time.Second))
-
 	safeReceive(done)
 
-	list, err := c.Session.AllPackets(session.Outgoing)
+	pkts, err := c.Session.AllPackets(session.Outgoing)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(list))
+	assert.Equal(t, 0, len(pkts))
 }
 
-func TestClientDisconnectWithTimeout(t *testing.T) {
+func TestClientSessionRestoration(t *testing.T) {
+	connect := connectPacket()
+	connect.ClientID = "test"
+	connect.CleanSession = false
+
 	publish := packet.NewPublishPacket()
 	publish.Message.Topic = "test"
 	publish.Message.Payload = []byte("test")
-	publish.Message.QOS = 1
+	publish.Message.QOS = 2
 	publish.ID = 1
 
-	puback := packet.NewPubackPacket()
-	puback.ID = 1
+	pubrel := packet.NewPubrelPacket()
+	pubrel.ID = 2
 
-	wait := func() {
-		time.Sleep(100 * time.Millisecond)
-	}
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test", QOS: 1}}
+	subscribe.ID = 3
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{1}
+	suback.ID = 3
 
 	broker := flow.New().
-		Receive(connectPacket()).
+		Receive(connect).
 		Send(connackPacket()).
-		Receive(publish).
-		Run(wait).
-		Send(puback).
+		Receive(subscribe).
+		Send(suback).
 		Receive(disconnectPacket()).
 		End()
 
 	done, port := fakeBroker(t, broker)
 
-	c := New()
-	c.Callback = errorCallback(t)
-
-	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
-	assert.NoError(t, err)
-	assert.NoError(t, connectFuture.Wait(1*time.Second))
-	assert.False(t, connectFuture.SessionPresent())
-	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
-
-	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
-	assert.NoError(t, err)
-	assert.NotNil(t, publishFuture)
-
-	err = c.Disconnect(10 * time.Second)
-	assert.NoError(t, err)
-
-	safeReceive(done)
-
-	assert.NoError(t, publishFuture.Wait(1*time.Second))
-
-	list, err := c.Session.AllPackets(session.Outgoing)
-	assert.NoError(t, err)
-	assert.Equal(t, 0, len(list))
-}
-
-func TestClientClose(t *testing.T) {
-	broker := flow.New().
-		Receive(connectPacket()).
-		Send(connackPacket()).
-		End()
-
-	done, port := fakeBroker(t, broker)
+	s := session.NewMemorySession()
+	s.SavePacket(session.Incoming, publish)
+	s.SavePacket(session.Outgoing, pubrel)
+	s.SaveSubscription(&packet.Subscription{Topic: "test", QOS: 1})
+	s.NextID()
+	s.NextID()
 
 	c := New()
+	c.Session = s
 	c.Callback = errorCallback(t)
 
-	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	config := NewConfig("tcp://localhost:" + port)
+	config.ClientID = "test"
+	config.CleanSession = false
+
+	connectFuture, err := c.Connect(config)
 	assert.NoError(t, err)
 	assert.NoError(t, connectFuture.Wait(1*time.Second))
 	assert.False(t, connectFuture.SessionPresent())
-	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
-
-	err = c.Close()
-	assert.NoError(t, err)
-
-	safeReceive(done)
-}
 
-func TestClientInvalidPackets(t *testing.T) {
-	c := New()
+	time.Sleep(20 * time.Millisecond)
 
-	// state not connecting
-	err := c.processConnack(packet.NewConnackPacket())
+	err = c.Disconnect()
 	assert.NoError(t, err)
 
-	c.state = clientConnecting
-
-	// missing future
-	err = c.processSuback(packet.NewSubackPacket())
-	assert.NoError(t, err)
+	safeReceive(done)
 
-	// missing future
-	err = c.processUnsuback(packet.NewUnsubackPacket())
+	pkts, err := s.AllPackets(session.Incoming)
 	assert.NoError(t, err)
+	assert.Empty(t, pkts)
 
-	// missing future
-	err = c.processPubrel(0)
+	pkts, err = s.AllPackets(session.Outgoing)
 	assert.NoError(t, err)
+	assert.Empty(t, pkts)
 
-	// missing future
-	err = c.processPubackAndPubcomp(0)
+	sub, err := s.LookupSubscription("test")
 	assert.NoError(t, err)
+	assert.Equal(t, &packet.Subscription{Topic: "test", QOS: 1}, sub)
 }
 
-func TestClientSessionResumption(t *testing.T) {
+func TestClientSessionRestorationDisabled(t *testing.T) {
 	connect := connectPacket()
 	connect.ClientID = "test"
 	connect.CleanSession = false
 
-	publish1 := packet.NewPublishPacket()
-	publish1.Message.Topic = "test"
-	publish1.Message.Payload = []byte("test")
-	publish1.Message.QOS = 1
-	publish1.ID = 1
-
-	puback1 := packet.NewPubackPacket()
-	puback1.ID = 1
-
 	broker := flow.New().
 		Receive(connect).
 		Send(connackPacket()).
-		Receive(publish1).
-		Send(puback1).
 		Receive(disconnectPacket()).
 		End()
 
 	done, port := fakeBroker(t, broker)
 
+	s := session.NewMemorySession()
+	s.SaveSubscription(&packet.Subscription{Topic: "test", QOS: 1})
+
 	c := New()
-	c.Session.SavePacket(session.Outgoing, publish1)
-	c.Session.NextID()
+	c.Session = s
 	c.Callback = errorCallback(t)
+	c.SkipSubscriptionRestore = true
 
 	config := NewConfig("tcp://localhost:" + port)
 	config.ClientID = "test"
@@ -790,18 +1870,11 @@ func TestClientSessionResumption(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoError(t, connectFuture.Wait(1*time.Second))
 	assert.False(t, connectFuture.SessionPresent())
-	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
-
-	time.Sleep(20 * time.Millisecond)
 
 	err = c.Disconnect()
 	assert.NoError(t, err)
 
 	safeReceive(done)
-
-	pkts, err := c.Session.AllPackets(session.Outgoing)
-	assert.NoError(t, err)
-	assert.Equal(t, 0, len(pkts))
 }
 
 func TestClientUnexpectedClose(t *testing.T) {
@@ -830,6 +1903,8 @@ func TestClientUnexpectedClose(t *testing.T) {
 
 	safeReceive(wait)
 	safeReceive(done)
+
+	assert.Equal(t, transport.RemoteClose, c.CloseReason())
 }
 
 func TestClientConnackFutureCancellation(t *testing.T) {
@@ -923,6 +1998,114 @@ func TestClientErrorCallback(t *testing.T) {
 	safeReceive(done)
 }
 
+func TestClientManualAcks(t *testing.T) {
+	publish1 := packet.NewPublishPacket()
+	publish1.Message.Topic = "error"
+	publish1.Message.QOS = 1
+	publish1.ID = 1
+
+	publish2 := packet.NewPublishPacket()
+	publish2.Message.Topic = "test"
+	publish2.Message.QOS = 1
+	publish2.ID = 2
+
+	puback := packet.NewPubackPacket()
+	puback.ID = 2
+
+	publish3 := packet.NewPublishPacket()
+	publish3.Message.Topic = "test"
+	publish3.Message.QOS = 2
+	publish3.ID = 3
+
+	pubrec := packet.NewPubrecPacket()
+	pubrec.ID = 3
+
+	pubrel := packet.NewPubrelPacket()
+	pubrel.ID = 3
+
+	pubcomp := packet.NewPubcompPacket()
+	pubcomp.ID = 3
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Send(publish1).
+		Send(publish2).
+		Receive(puback).
+		Send(publish3).
+		Receive(pubrec).
+		Send(pubrel).
+		Receive(pubcomp).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	messages := make(chan *packet.Message, 2)
+
+	c := New()
+	c.ManualAcks = true
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+
+		if msg.Topic == "error" {
+			return errors.New("some error")
+		}
+
+		messages <- msg
+		return nil
+	}
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	msg := <-messages
+	assert.Equal(t, uint8(1), msg.QOS)
+	assert.NoError(t, c.Ack(msg))
+	assert.NoError(t, c.Ack(msg))
+
+	msg = <-messages
+	assert.Equal(t, uint8(2), msg.QOS)
+	assert.NoError(t, c.Ack(msg))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	in, err := c.Session.AllPackets(session.Incoming)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(in))
+}
+
+func TestClientGoroutines(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	assert.Equal(t, 1, routines.Active()["client.processor"])
+	assert.Equal(t, 1, routines.Active()["client.writer"])
+	assert.Equal(t, 1, routines.Active()["client.pinger"])
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	verifyRoutines(t, time.Second)
+}
+
 func TestClientLogger(t *testing.T) {
 	subscribe := packet.NewSubscribePacket()
 	subscribe.Subscriptions = []packet.Subscription{{Topic: "test"}}
@@ -982,10 +2165,44 @@ func TestClientLogger(t *testing.T) {
 	assert.Equal(t, uint32(8), counter)
 }
 
+func TestClientWriterPriority(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+
+	c := New()
+	local := transport.Conn(transport.NewNetConn(conn1))
+	c.conn.Store(&local)
+	c.tracker = newTracker(time.Minute)
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+
+	c.urgent <- outgoing{pkt: packet.NewPingreqPacket()}
+
+	go func() {
+		assert.NoError(t, c.queue(publish, false))
+	}()
+
+	c.tomb.Go(c.writer)
+
+	conn := transport.NewNetConn(conn2)
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.PINGREQ, pkt.Type())
+
+	pkt, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.PUBLISH, pkt.Type())
+
+	c.tomb.Kill(nil)
+	assert.NoError(t, c.tomb.Wait())
+	assert.NoError(t, conn.Close())
+}
+
 func BenchmarkClientPublish(b *testing.B) {
 	c := New()
 
-	connectFuture, err := c.Connect(NewConfig("mqtt://0.0.0.0"))
+	connectFuture, err := c.Connect(NewConfig(embeddedBroker()))
 	if err != nil {
 		panic(err)
 	}
@@ -1,10 +1,52 @@
 package client
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
 	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
 	"github.com/256dpi/gomqtt/transport"
 )
 
+// A ConfigError is returned by Config.Validate and lists all problems found in
+// a Config.
+type ConfigError struct {
+	Errors []error
+}
+
+// Error implements the error interface.
+func (e *ConfigError) Error() string {
+	list := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		list = append(list, err.Error())
+	}
+
+	return "invalid config: " + strings.Join(list, "; ")
+}
+
+// Unwrap returns the individual errors.
+func (e *ConfigError) Unwrap() []error {
+	return e.Errors
+}
+
+// Is returns whether one of the individual errors matches the target. It keeps
+// comparisons with sentinel errors like ErrClientMissingID working.
+func (e *ConfigError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // A Config holds information about establishing a connection to a broker.
 type Config struct {
 	Dialer       *transport.Dialer
@@ -14,6 +56,63 @@ type Config struct {
 	KeepAlive    string
 	WillMessage  *packet.Message
 	ValidateSubs bool
+
+	// The credentials sent with the ConnectPacket. If set, they take
+	// precedence over the credentials of the broker URL.
+	Username string
+	Password string
+
+	// The Authenticator that performs an MQTT 5 enhanced authentication
+	// exchange. It requires protocol level 5.
+	Authenticator Authenticator
+
+	// The TLS config used to connect to brokers using the "tls", "mqtts", "wss"
+	// and "quic" schemes. It allows to configure client certificates, custom
+	// CAs, the server name and whether the server is verified. It may only be
+	// set if no Dialer is configured, use Dialer.TLSConfig otherwise.
+	TLSConfig *tls.Config
+
+	// The maximum time that can pass while writing a packet. If the broker
+	// stops reading, the connection is closed with an error of the kind
+	// transport.ErrWriteTimeout. A zero value disables the timeout.
+	WriteTimeout time.Duration
+
+	// The protocol version used to connect. A zero value defaults to 3.1.1.
+	// Protocol level 5 enables the MQTT 5 encoding of all packets.
+	Version byte
+
+	// The protocol version a Service falls back to if the broker rejects the
+	// connection because of an unacceptable protocol version. The fallback is
+	// kept for all subsequent reconnects. A zero value disables the fallback.
+	FallbackVersion byte
+
+	// If set, published messages are stamped with the send time. The stamp
+	// is removed from received messages and the delivery latency is reported
+	// to the LatencyCallback. MQTT 5 clients use a user property while MQTT
+	// 3.1 and 3.1.1 clients use a payload header. In the latter case, all
+	// publishers and subscribers of the affected topics must enable the
+	// option.
+	LatencyStamps bool
+
+	// The maximum number of levels and the maximum length of a single level
+	// of published topics and subscription filters. Publishes and
+	// subscriptions that exceed a limit are rejected with
+	// topic.ErrTooManyLevels or topic.ErrLevelTooLong before they are sent. A
+	// zero value disables the respective limit.
+	MaxTopicLevels      int
+	MaxTopicLevelLength int
+
+	// The maximum number of QOS 1 and 2 messages that have been published but
+	// not yet acknowledged. This prevents bursts of publishes from exhausting
+	// the packet identifiers and the memory of the session. Messages that are
+	// resent from a stored session do not count towards the limit. A zero
+	// value disables the limit.
+	MaxInflight int
+
+	// If set, publishes wait for an acknowledgement if the maximum number of
+	// inflight messages has been reached. Otherwise they fail immediately
+	// with ErrClientInflightExceeded.
+	WaitInflight bool
 }
 
 // NewConfig creates a new Config using the specified URL.
@@ -32,3 +131,105 @@ func NewConfigWithClientID(url, id string) *Config {
 	config.ClientID = id
 	return config
 }
+
+// Validate checks the config for invalid values and inconsistent fields. All
+// problems are collected and returned as a ConfigError. Connect validates the
+// config before dialing the broker.
+func (c *Config) Validate() error {
+	var errs []error
+
+	// check url
+	urlParts, err := url.ParseRequestURI(c.BrokerURL)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("broker url: %w", err))
+	} else {
+		switch urlParts.Scheme {
+		case "tcp", "mqtt", "ws", "serial":
+			if c.TLSConfig != nil {
+				errs = append(errs, fmt.Errorf("tls config set for non tls scheme %q", urlParts.Scheme))
+			}
+		case "tls", "mqtts", "wss", "quic":
+		default:
+			errs = append(errs, fmt.Errorf("broker url: %w", transport.ErrUnsupportedProtocol))
+		}
+	}
+
+	// check tls config
+	if c.TLSConfig != nil && c.Dialer != nil {
+		errs = append(errs, fmt.Errorf("tls config set together with dialer"))
+	}
+
+	// check client id
+	if !c.CleanSession && c.ClientID == "" {
+		errs = append(errs, ErrClientMissingID)
+	}
+
+	// check keep alive
+	keepAlive, err := time.ParseDuration(c.KeepAlive)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("keep alive: %w", err))
+	} else if keepAlive < 0 || keepAlive.Seconds() > math.MaxUint16 {
+		errs = append(errs, fmt.Errorf("keep alive %s out of range", c.KeepAlive))
+	}
+
+	// check will message
+	if c.WillMessage != nil {
+		_, err = topic.Parse(c.WillMessage.Topic, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("will topic: %w", err))
+		} else if err = c.checkLevels(c.WillMessage.Topic); err != nil {
+			errs = append(errs, fmt.Errorf("will topic: %w", err))
+		}
+		if c.WillMessage.QOS > 2 {
+			errs = append(errs, fmt.Errorf("will qos %d invalid", c.WillMessage.QOS))
+		}
+	}
+
+	// check versions
+	if !validVersion(c.Version) {
+		errs = append(errs, fmt.Errorf("version %d unsupported", c.Version))
+	}
+	if !validVersion(c.FallbackVersion) {
+		errs = append(errs, fmt.Errorf("fallback version %d unsupported", c.FallbackVersion))
+	}
+
+	// check authenticator
+	if c.Authenticator != nil && c.Version != packet.Version5 {
+		errs = append(errs, fmt.Errorf("authenticator requires version 5"))
+	}
+
+	// check write timeout
+	if c.WriteTimeout < 0 {
+		errs = append(errs, fmt.Errorf("write timeout %s negative", c.WriteTimeout))
+	}
+
+	// check topic limits
+	if c.MaxTopicLevels < 0 || c.MaxTopicLevelLength < 0 {
+		errs = append(errs, fmt.Errorf("topic limits negative"))
+	}
+
+	// check max inflight
+	if c.MaxInflight < 0 || c.MaxInflight > math.MaxUint16 {
+		errs = append(errs, fmt.Errorf("max inflight %d out of range", c.MaxInflight))
+	}
+
+	if len(errs) > 0 {
+		return &ConfigError{Errors: errs}
+	}
+
+	return nil
+}
+
+func validVersion(version byte) bool {
+	return version == 0 || version == packet.Version31 || version == packet.Version311 || version == packet.Version5
+}
+
+// checkLevels checks the topic or filter against the topic limits
+func (c *Config) checkLevels(name string) error {
+	// ignore shared subscription prefix
+	if _, filter, err := packet.ParseShared(name); err == nil {
+		name = filter
+	}
+
+	return topic.CheckLevels(name, c.MaxTopicLevels, c.MaxTopicLevelLength)
+}
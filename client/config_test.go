@@ -1,8 +1,15 @@
 package client
 
 import (
-	"github.com/stretchr/testify/assert"
+	"crypto/tls"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+	"github.com/256dpi/gomqtt/transport"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestConfig(t *testing.T) {
@@ -12,3 +19,77 @@ func TestConfig(t *testing.T) {
 	assert.True(t, config.CleanSession)
 	assert.Equal(t, "30s", config.KeepAlive)
 }
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, NewConfig("tcp://localhost:1883").Validate())
+
+	config := NewConfig("tls://localhost:8883")
+	config.ClientID = "test"
+	config.CleanSession = false
+	config.KeepAlive = "1h"
+	config.WillMessage = &packet.Message{Topic: "will", QOS: 2}
+	config.Version = packet.Version31
+	config.FallbackVersion = packet.Version311
+	config.Dialer = transport.NewDialer()
+	config.Dialer.TLSConfig = &tls.Config{}
+	assert.NoError(t, config.Validate())
+
+	config = NewConfig("foo://localhost")
+	config.CleanSession = false
+	config.KeepAlive = "20h"
+	config.WillMessage = &packet.Message{Topic: "will/#", QOS: 3}
+	config.Version = 6
+	config.WriteTimeout = -time.Second
+
+	err := config.Validate()
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrClientMissingID))
+	assert.True(t, errors.Is(err, transport.ErrUnsupportedProtocol))
+	assert.True(t, errors.Is(err, topic.ErrWildcards))
+	assert.Len(t, err.(*ConfigError).Errors, 7)
+	assert.Equal(t, "invalid config: broker url: unsupported protocol; client missing id; "+
+		"keep alive 20h out of range; will topic: invalid use of wildcards; will qos 3 invalid; "+
+		"version 6 unsupported; write timeout -1s negative", err.Error())
+
+	config = NewConfig("tcp://localhost:1883")
+	config.Dialer = transport.NewDialer()
+	config.Dialer.TLSConfig = &tls.Config{}
+	assert.NoError(t, config.Validate())
+
+	config = NewConfig("tcp://localhost:1883")
+	config.CleanSession = false
+	err = config.Validate()
+	assert.True(t, errors.Is(err, ErrClientMissingID))
+	assert.True(t, (&ConfigError{Errors: []error{ErrClientMissingID}}).Is(ErrClientMissingID))
+	assert.False(t, (&ConfigError{Errors: []error{ErrClientMissingID}}).Is(ErrClientNotConnected))
+
+	config = NewConfig("mqtts://localhost:8883")
+	config.TLSConfig = &tls.Config{ServerName: "broker"}
+	assert.NoError(t, config.Validate())
+
+	config = NewConfig("ws://localhost:8080")
+	config.TLSConfig = &tls.Config{}
+	assert.Error(t, config.Validate())
+
+	config = NewConfig("wss://localhost:8443")
+	config.TLSConfig = &tls.Config{}
+	config.Dialer = transport.NewDialer()
+	assert.Error(t, config.Validate())
+
+	config = NewConfig("tcp://localhost:1883")
+	config.KeepAlive = "foo"
+	assert.Error(t, config.Validate())
+
+	config = NewConfig("tcp://localhost:1883")
+	config.MaxTopicLevels = 2
+	config.WillMessage = &packet.Message{Topic: "a/b/c"}
+	err = config.Validate()
+	assert.True(t, errors.Is(err, topic.ErrTooManyLevels))
+
+	config = NewConfig("tcp://localhost:1883")
+	config.Authenticator = &testAuthenticator{}
+	assert.EqualError(t, config.Validate(), "invalid config: authenticator requires version 5")
+
+	config.Version = packet.Version5
+	assert.NoError(t, config.Validate())
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type dedupEntry struct {
+	key     string
+	future  PublishFuture
+	expires time.Time
+	ready   chan struct{}
+}
+
+// a dedupStore remembers the futures of recent publishes by their idempotency
+// key
+type dedupStore struct {
+	entries map[string]*list.Element
+	order   *list.List
+	mutex   sync.Mutex
+}
+
+func newDedupStore() *dedupStore {
+	return &dedupStore{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// lookup returns the future stored for the key if it has not yet expired.
+// Otherwise it reserves the key, calls fn without holding the lock and stores
+// the returned future for the window. Concurrent lookups of a reserved key
+// wait until the future is available.
+func (s *dedupStore) lookup(key string, window time.Duration, fn func() PublishFuture) (PublishFuture, bool) {
+	s.mutex.Lock()
+
+	// get time
+	now := time.Now()
+
+	// remove expired entries in the order they have been added
+	for elem := s.order.Front(); elem != nil; elem = s.order.Front() {
+		if now.Before(elem.Value.(*dedupEntry).expires) {
+			break
+		}
+
+		s.remove(elem)
+	}
+
+	// return existing future or remove entry if expired
+	if elem, ok := s.entries[key]; ok {
+		entry := elem.Value.(*dedupEntry)
+		if now.Before(entry.expires) {
+			s.mutex.Unlock()
+			<-entry.ready
+			return entry.future, true
+		}
+
+		s.remove(elem)
+	}
+
+	// reserve key
+	entry := &dedupEntry{
+		key:     key,
+		expires: now.Add(window),
+		ready:   make(chan struct{}),
+	}
+	s.entries[key] = s.order.PushBack(entry)
+
+	s.mutex.Unlock()
+
+	// create future
+	entry.future = fn()
+	close(entry.ready)
+
+	return entry.future, false
+}
+
+func (s *dedupStore) remove(elem *list.Element) {
+	s.order.Remove(elem)
+	delete(s.entries, elem.Value.(*dedupEntry).key)
+}
@@ -23,7 +23,7 @@ func ExampleClient() {
 		return nil
 	}
 
-	config := NewConfigWithClientID("mqtt://0.0.0.0", "gomqtt/client")
+	config := NewConfigWithClientID(embeddedBroker(), "gomqtt/client")
 
 	connectFuture, err := c.Connect(config)
 	if err != nil {
@@ -70,7 +70,7 @@ func ExampleService() {
 	wait := make(chan struct{})
 	done := make(chan struct{})
 
-	config := NewConfigWithClientID("mqtt://0.0.0.0", "gomqtt/service")
+	config := NewConfigWithClientID(embeddedBroker(), "gomqtt/service")
 	config.CleanSession = false
 
 	s := NewService()
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// SharedFilter returns the MQTT 5 shared subscription filter for the group.
+// Messages matching the filter are distributed among the subscribers of the
+// group. The syntax is supported by most brokers that implement MQTT 5 and by
+// some brokers also for MQTT 3 clients.
+func SharedFilter(group, filter string) string {
+	return packet.SharedFilter(group, filter)
+}
+
+// QueueFilter returns the EMQX shared queue filter. It is equivalent to a
+// shared subscription without a group.
+//
+// Note: The syntax is not portable and only supported by EMQX.
+func QueueFilter(filter string) string {
+	return "$queue/" + filter
+}
+
+// DelayedTopic returns the EMQX delayed publish topic. Messages published to
+// the topic are held back by the broker and delivered to the specified topic
+// once the delay has elapsed. The delay is rounded up to full seconds.
+//
+// Note: The syntax is not portable and only supported by EMQX and brokers that
+// implement the same extension.
+func DelayedTopic(topic string, delay time.Duration) string {
+	// round up to seconds
+	seconds := int64((delay + time.Second - 1) / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	return "$delayed/" + strconv.FormatInt(seconds, 10) + "/" + topic
+}
+
+// PlainTopic removes the shared subscription, queue and delayed publish
+// prefixes from the topic or filter. The returned value can be used to match
+// the topics of received messages as they are delivered without the prefix.
+func PlainTopic(topic string) string {
+	// check prefix
+	if !strings.HasPrefix(topic, "$") {
+		return topic
+	}
+
+	// split first two levels
+	segments := strings.SplitN(topic, "/", 3)
+
+	switch segments[0] {
+	case "$queue":
+		return strings.TrimPrefix(topic, "$queue/")
+	case "$share", "$delayed":
+		if len(segments) == 3 {
+			return segments[2]
+		}
+	}
+
+	return topic
+}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedFilter(t *testing.T) {
+	assert.Equal(t, "$share/workers/jobs/#", SharedFilter("workers", "jobs/#"))
+}
+
+func TestQueueFilter(t *testing.T) {
+	assert.Equal(t, "$queue/jobs/#", QueueFilter("jobs/#"))
+}
+
+func TestDelayedTopic(t *testing.T) {
+	assert.Equal(t, "$delayed/10/foo/bar", DelayedTopic("foo/bar", 10*time.Second))
+	assert.Equal(t, "$delayed/2/foo", DelayedTopic("foo", 1500*time.Millisecond))
+	assert.Equal(t, "$delayed/0/foo", DelayedTopic("foo", 0))
+	assert.Equal(t, "$delayed/0/foo", DelayedTopic("foo", -time.Second))
+}
+
+func TestPlainTopic(t *testing.T) {
+	table := map[string]string{
+		"foo/bar":                "foo/bar",
+		"$share/group/foo/#":     "foo/#",
+		"$queue/foo/+":           "foo/+",
+		"$delayed/10/foo/bar":    "foo/bar",
+		"$SYS/broker/uptime":     "$SYS/broker/uptime",
+		"$share/group":           "$share/group",
+		SharedFilter("g", "a/b"): "a/b",
+		DelayedTopic("a/b", 1e9): "a/b",
+	}
+
+	for topic, plain := range table {
+		assert.Equal(t, plain, PlainTopic(topic), topic)
+	}
+}
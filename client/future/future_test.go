@@ -1,6 +1,7 @@
 package future
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -54,6 +55,21 @@ func TestFutureTimeout(t *testing.T) {
 	assert.Equal(t, ErrTimeout, f.Wait(1*time.Millisecond))
 }
 
+func TestFutureWaitContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	f := New()
+	assert.Equal(t, context.Canceled, f.WaitContext(ctx))
+
+	f.Complete()
+	assert.NoError(t, f.WaitContext(ctx))
+
+	f = New()
+	f.Cancel()
+	assert.Equal(t, ErrCanceled, f.WaitContext(ctx))
+}
+
 func TestFutureBindBefore(t *testing.T) {
 	done := make(chan struct{})
 
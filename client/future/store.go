@@ -7,56 +7,75 @@ import (
 	"github.com/256dpi/gomqtt/packet"
 )
 
-// A Store is used to store futures.
-type Store struct {
+// storeShards is the number of shards used by a Store. Packet IDs are
+// allocated sequentially and thus spread evenly across the shards.
+const storeShards = 16
+
+type storeShard struct {
 	sync.RWMutex
+	futures map[packet.ID]*Future
+}
+
+// A Store is used to store futures. The futures are distributed across
+// multiple shards to reduce lock contention on packet ID lookups.
+type Store struct {
+	shards [storeShards]storeShard
 
 	protected bool
-	store     map[packet.ID]*Future
+	mutex     sync.Mutex
 }
 
 // NewStore will create a new Store.
 func NewStore() *Store {
-	return &Store{
-		store: make(map[packet.ID]*Future),
+	s := &Store{}
+	for i := range s.shards {
+		s.shards[i].futures = make(map[packet.ID]*Future)
 	}
+
+	return s
+}
+
+func (s *Store) shard(id packet.ID) *storeShard {
+	return &s.shards[id%storeShards]
 }
 
 // Put will save a future to the store.
 func (s *Store) Put(id packet.ID, future *Future) {
-	s.Lock()
-	defer s.Unlock()
-
-	s.store[id] = future
+	shard := s.shard(id)
+	shard.Lock()
+	shard.futures[id] = future
+	shard.Unlock()
 }
 
 // Get will retrieve a future from the store.
 func (s *Store) Get(id packet.ID) *Future {
-	s.RLock()
-	defer s.RUnlock()
+	shard := s.shard(id)
+	shard.RLock()
+	future := shard.futures[id]
+	shard.RUnlock()
 
-	return s.store[id]
+	return future
 }
 
 // Delete will remove a future from the store.
 func (s *Store) Delete(id packet.ID) {
-	s.Lock()
-	defer s.Unlock()
-
-	delete(s.store, id)
+	shard := s.shard(id)
+	shard.Lock()
+	delete(shard.futures, id)
+	shard.Unlock()
 }
 
 // All will return a slice with all stored futures.
 func (s *Store) All() []*Future {
-	s.RLock()
-	defer s.RUnlock()
-
-	all := make([]*Future, len(s.store))
+	var all []*Future
 
-	i := 0
-	for _, savedFuture := range s.store {
-		all[i] = savedFuture
-		i++
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.RLock()
+		for _, savedFuture := range shard.futures {
+			all = append(all, savedFuture)
+		}
+		shard.RUnlock()
 	}
 
 	return all
@@ -65,26 +84,30 @@ func (s *Store) All() []*Future {
 // Protect will set the protection attribute and if true prevents the store from
 // being cleared.
 func (s *Store) Protect(value bool) {
-	s.Lock()
-	defer s.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
 	s.protected = value
 }
 
 // Clear will cancel all stored futures and remove them if the store is unprotected.
 func (s *Store) Clear() {
-	s.Lock()
-	defer s.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
 	if s.protected {
 		return
 	}
 
-	for _, savedFuture := range s.store {
-		savedFuture.Cancel()
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.Lock()
+		for _, savedFuture := range shard.futures {
+			savedFuture.Cancel()
+		}
+		shard.futures = make(map[packet.ID]*Future)
+		shard.Unlock()
 	}
-
-	s.store = make(map[packet.ID]*Future)
 }
 
 // Await will wait until all futures have completed and removed or timeout is
@@ -93,10 +116,8 @@ func (s *Store) Await(timeout time.Duration) error {
 	stop := time.Now().Add(timeout)
 
 	for {
-		// Get futures
-		s.RLock()
+		// get futures
 		futures := s.All()
-		s.RUnlock()
 
 		// return if no futures are left
 		if len(futures) == 0 {
@@ -1,9 +1,11 @@
 package future
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/256dpi/gomqtt/packet"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -55,3 +57,42 @@ func TestStoreAwaitTimeout(t *testing.T) {
 	err := store.Await(10 * time.Millisecond)
 	assert.Equal(t, ErrTimeout, err)
 }
+
+func BenchmarkStore(b *testing.B) {
+	store := NewStore()
+	f := New()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		id := packet.ID(i)
+		store.Put(id, f)
+		store.Get(id)
+		store.Delete(id)
+	}
+}
+
+func BenchmarkStoreParallel(b *testing.B) {
+	store := NewStore()
+	f := New()
+
+	var counter uint32
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		// use a distinct range of ids per goroutine
+		base := packet.ID(atomic.AddUint32(&counter, 1) << 10)
+
+		var i packet.ID
+		for pb.Next() {
+			id := base + i%1024
+			store.Put(id, f)
+			store.Get(id)
+			store.Delete(id)
+			i++
+		}
+	})
+}
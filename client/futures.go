@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"time"
 
 	"github.com/256dpi/gomqtt/client/future"
@@ -15,6 +16,13 @@ type GenericFuture interface {
 	//
 	// Note: Wait will not return any Client related errors.
 	Wait(timeout time.Duration) error
+
+	// WaitContext will block until the future is completed or canceled or the
+	// context is done. It will return future.ErrCanceled if the future gets
+	// canceled and the error of the context if it is done first.
+	//
+	// Note: WaitContext will not return any Client related errors.
+	WaitContext(ctx context.Context) error
 }
 
 // A ConnectFuture is returned by the connect method.
@@ -28,6 +36,33 @@ type ConnectFuture interface {
 	ReturnCode() packet.ConnackCode
 }
 
+// A PublishFuture is returned by the publish methods.
+type PublishFuture interface {
+	GenericFuture
+
+	// QOS will return the quality of service the message has been published
+	// and acknowledged with.
+	QOS() uint8
+
+	// ReasonCode will return the reason code of the PubackPacket or
+	// PubcompPacket returned by the broker. It is only transmitted using
+	// MQTT 5 and zero otherwise.
+	ReasonCode() packet.ReasonCode
+
+	// Sent will return the time the message has been published.
+	Sent() time.Time
+
+	// Acknowledged will return the time the acknowledgement has been received
+	// or the message has been sent if the quality of service is zero. It
+	// returns the zero time if the future is not yet completed.
+	Acknowledged() time.Time
+
+	// Latency will return the round trip time between sending the message and
+	// receiving the acknowledgement. It returns zero if the future is not yet
+	// completed.
+	Latency() time.Duration
+}
+
 // A SubscribeFuture is returned by the subscribe methods.
 type SubscribeFuture interface {
 	GenericFuture
@@ -42,6 +77,12 @@ const (
 	sessionPresentKey futureKey = iota
 	returnCodeKey
 	returnCodesKey
+	subscriptionsKey
+	topicsKey
+	qosKey
+	reasonCodeKey
+	sentKey
+	acknowledgedKey
 )
 
 type connectFuture struct {
@@ -66,6 +107,73 @@ func (f *connectFuture) ReturnCode() packet.ConnackCode {
 	return v.(packet.ConnackCode)
 }
 
+type publishFuture struct {
+	*future.Future
+}
+
+// newPublishFuture returns a new publish future for the specified quality of
+// service that is sent now
+func newPublishFuture(qos uint8) *future.Future {
+	f := future.New()
+	f.Data.Store(qosKey, qos)
+	f.Data.Store(sentKey, time.Now())
+	return f
+}
+
+// acknowledge records the reason code and acknowledgement time and completes
+// the future
+func acknowledge(f *future.Future, code packet.ReasonCode) {
+	f.Data.Store(reasonCodeKey, code)
+	f.Data.Store(acknowledgedKey, time.Now())
+	f.Complete()
+}
+
+func (f *publishFuture) QOS() uint8 {
+	v, ok := f.Data.Load(qosKey)
+	if !ok {
+		return 0
+	}
+
+	return v.(uint8)
+}
+
+func (f *publishFuture) ReasonCode() packet.ReasonCode {
+	v, ok := f.Data.Load(reasonCodeKey)
+	if !ok {
+		return 0
+	}
+
+	return v.(packet.ReasonCode)
+}
+
+func (f *publishFuture) Sent() time.Time {
+	v, ok := f.Data.Load(sentKey)
+	if !ok {
+		return time.Time{}
+	}
+
+	return v.(time.Time)
+}
+
+func (f *publishFuture) Acknowledged() time.Time {
+	v, ok := f.Data.Load(acknowledgedKey)
+	if !ok {
+		return time.Time{}
+	}
+
+	return v.(time.Time)
+}
+
+func (f *publishFuture) Latency() time.Duration {
+	// get acknowledgement time
+	acknowledged := f.Acknowledged()
+	if acknowledged.IsZero() {
+		return 0
+	}
+
+	return acknowledged.Sub(f.Sent())
+}
+
 type subscribeFuture struct {
 	*future.Future
 }
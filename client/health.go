@@ -0,0 +1,175 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// A HealthReport describes the health of a client.
+type HealthReport struct {
+	// The error returned by Healthy, if any.
+	Error string `json:"error,omitempty"`
+
+	// Whether the client is connected to the broker.
+	Connected bool `json:"connected"`
+
+	// The time the last pong has been received from the broker.
+	LastPong time.Time `json:"last_pong"`
+
+	// The number of buffered receipts and the capacity of the channel.
+	Receipts         int `json:"receipts"`
+	ReceiptsCapacity int `json:"receipts_capacity"`
+
+	// The number of queued commands and the capacity of the queue if the
+	// client is managed by a service.
+	Queue         int `json:"queue"`
+	QueueCapacity int `json:"queue_capacity"`
+}
+
+// A HealthReporter is a Client or Service that reports its health.
+type HealthReporter interface {
+	Report() HealthReport
+}
+
+// KeepAlive returns the effective keep alive interval. It is the interval
+// requested by the config unless the broker returned a different Server Keep
+// Alive using MQTT 5.
+func (c *Client) KeepAlive() time.Duration {
+	// check state
+	if atomic.LoadUint32(&c.state) < clientConnected {
+		return 0
+	}
+
+	return c.tracker.keepAlive()
+}
+
+// LastPong returns the time the last PingrespPacket has been received from the
+// broker. The zero time is returned if no pong has been received yet.
+func (c *Client) LastPong() time.Time {
+	// check state
+	if atomic.LoadUint32(&c.state) < clientConnected {
+		return time.Time{}
+	}
+
+	return c.tracker.lastPong()
+}
+
+// Healthy returns an error if the client is not connected, a pong from the
+// broker is overdue, the Receipts channel or the command queue of the managing
+// service is full or the session reports an error using a Health method like
+// the session.FileSession does.
+func (c *Client) Healthy() error {
+	// check state
+	if atomic.LoadUint32(&c.state) != clientConnected {
+		return ErrClientNotConnected
+	}
+
+	// check keep alive
+	if c.tracker.pending() && c.tracker.window() < 0 {
+		return ErrClientMissingPong
+	}
+
+	// check receipts
+	if c.Receipts != nil && cap(c.Receipts) > 0 && len(c.Receipts) == cap(c.Receipts) {
+		return ErrClientSaturated
+	}
+
+	// check command queue
+	if c.service != nil && c.service.saturated() {
+		return ErrClientSaturated
+	}
+
+	// check session
+	if session, ok := c.Session.(healthSession); ok {
+		err := session.Health()
+		if err != nil {
+			return fmt.Errorf("session: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Report returns a HealthReport for the client.
+func (c *Client) Report() HealthReport {
+	report := HealthReport{
+		Connected: atomic.LoadUint32(&c.state) == clientConnected,
+		LastPong:  c.LastPong(),
+	}
+
+	// set error
+	if err := c.Healthy(); err != nil {
+		report.Error = err.Error()
+	}
+
+	// set receipts
+	if c.Receipts != nil {
+		report.Receipts = len(c.Receipts)
+		report.ReceiptsCapacity = cap(c.Receipts)
+	}
+
+	// set queue
+	if c.service != nil {
+		report.Queue = c.service.QueueLength()
+		report.QueueCapacity = cap(c.service.commandQueue)
+	}
+
+	return report
+}
+
+// Healthy returns ErrClientNotConnected if the service is not online and
+// otherwise the result of Healthy of the current client.
+func (s *Service) Healthy() error {
+	// get client
+	client := s.current.Load()
+	if client == nil {
+		return ErrClientNotConnected
+	}
+
+	return client.Healthy()
+}
+
+// Report returns the HealthReport of the current client.
+func (s *Service) Report() HealthReport {
+	// get client
+	client := s.current.Load()
+	if client == nil {
+		return HealthReport{
+			Error:         ErrClientNotConnected.Error(),
+			Queue:         s.QueueLength(),
+			QueueCapacity: cap(s.commandQueue),
+		}
+	}
+
+	return client.Report()
+}
+
+// returns whether the command queue is full
+func (s *Service) saturated() bool {
+	return cap(s.commandQueue) > 0 && s.QueueLength() == cap(s.commandQueue)
+}
+
+// HealthHandler returns a handler that serves the HealthReport of the client
+// or service as JSON. The status code is 200 if the client is healthy and 503
+// otherwise, which allows the handler to be used as a readiness or liveness
+// probe.
+func HealthHandler(reporter HealthReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// get report
+		report := reporter.Report()
+
+		// write header
+		w.Header().Set("Content-Type", "application/json")
+		if report.Error != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+
+		// write report
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
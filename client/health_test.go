@@ -0,0 +1,176 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/session"
+	"github.com/256dpi/gomqtt/transport/flow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientHealth(t *testing.T) {
+	connect := connectPacket()
+	connect.KeepAlive = 0
+
+	broker := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(packet.NewPingreqPacket()).
+		Send(packet.NewPingrespPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	receipts := make(chan Receipt, 1)
+
+	c := New()
+	c.Callback = errorCallback(t)
+	c.Receipts = receipts
+
+	handler := HealthHandler(c)
+
+	assert.Equal(t, ErrClientNotConnected, c.Healthy())
+	assert.True(t, c.LastPong().IsZero())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	config := NewConfig("tcp://localhost:" + port)
+	config.KeepAlive = "100ms"
+
+	connectFuture, err := c.Connect(config)
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	<-time.After(150 * time.Millisecond)
+
+	assert.NoError(t, c.Healthy())
+	assert.False(t, c.LastPong().IsZero())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var report HealthReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.True(t, report.Connected)
+	assert.Empty(t, report.Error)
+	assert.False(t, report.LastPong.IsZero())
+	assert.Equal(t, 1, report.ReceiptsCapacity)
+
+	receipts <- Receipt{}
+	assert.Equal(t, ErrClientSaturated, c.Healthy())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	<-receipts
+
+	c.service = NewService(1)
+	c.service.commandQueue <- &command{}
+	assert.Equal(t, ErrClientSaturated, c.Healthy())
+
+	report = c.Report()
+	assert.Equal(t, 1, report.Queue)
+	assert.Equal(t, 1, report.QueueCapacity)
+
+	<-c.service.commandQueue
+	assert.NoError(t, c.Healthy())
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	assert.Equal(t, ErrClientNotConnected, c.Healthy())
+
+	safeReceive(done)
+}
+
+func TestClientSessionHealth(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	dir := t.TempDir()
+	fileSession, err := session.NewFileSession(dir)
+	assert.NoError(t, err)
+
+	c := New()
+	c.Session = fileSession
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+	assert.NoError(t, c.Healthy())
+
+	err = os.WriteFile(filepath.Join(dir, "outgoing", "1"), []byte{0xFF}, 0600)
+	assert.NoError(t, err)
+
+	err = c.Healthy()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "session: outgoing: packet 1")
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestServiceHealth(t *testing.T) {
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	s := NewService()
+	handler := HealthHandler(s)
+
+	assert.Equal(t, ErrClientNotConnected, s.Healthy())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	online := make(chan struct{})
+	s.OnlineCallback = func(bool) {
+		close(online)
+	}
+
+	s.Start(NewConfig("tcp://localhost:" + port))
+
+	safeReceive(online)
+
+	assert.NoError(t, s.Healthy())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var report HealthReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.True(t, report.Connected)
+	assert.Equal(t, 100, report.QueueCapacity)
+
+	s.Stop(true)
+
+	safeReceive(done)
+
+	assert.Equal(t, ErrClientNotConnected, s.Healthy())
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A LatencyCallback is called with received messages that carry a latency
+// stamp and the time that passed since they have been published.
+type LatencyCallback func(msg *packet.Message, latency time.Duration)
+
+// the magic bytes that introduce a latency stamp
+var stampMagic = []byte("\x00GMTS")
+
+// the length of a latency stamp
+var stampLen = len(stampMagic) + 8
+
+// the key of the user property that carries a latency stamp
+const stampKey = "gomqtt-sent"
+
+// StampPayload returns a new payload that is prefixed with a header holding
+// the specified send time. The header is used by clients that enable
+// Config.LatencyStamps and connect using MQTT 3.1 or 3.1.1.
+func StampPayload(payload []byte, t time.Time) []byte {
+	// prepare buffer
+	buf := make([]byte, stampLen+len(payload))
+
+	// write header
+	copy(buf, stampMagic)
+	binary.BigEndian.PutUint64(buf[len(stampMagic):], uint64(t.UnixNano()))
+
+	// write payload
+	copy(buf[stampLen:], payload)
+
+	return buf
+}
+
+// ParseStamp returns the original payload and the send time of a payload that
+// has been stamped using StampPayload. The payload is returned unchanged if it
+// does not carry a stamp.
+func ParseStamp(payload []byte) ([]byte, time.Time, bool) {
+	// check header
+	if len(payload) < stampLen || !bytes.Equal(payload[:len(stampMagic)], stampMagic) {
+		return payload, time.Time{}, false
+	}
+
+	// read time
+	nanos := int64(binary.BigEndian.Uint64(payload[len(stampMagic):]))
+
+	return payload[stampLen:], time.Unix(0, nanos), true
+}
+
+// StampProperties returns the properties with an added user property holding
+// the specified send time. The property is used by clients that enable
+// Config.LatencyStamps and connect using MQTT 5.
+func StampProperties(properties packet.Properties, t time.Time) packet.Properties {
+	return append(properties, packet.Property{
+		ID:    packet.UserProperty,
+		Value: packet.StringPair{Key: stampKey, Value: strconv.FormatInt(t.UnixNano(), 10)},
+	})
+}
+
+// ParseStampProperties returns the properties without the stamp and the send
+// time of properties that have been stamped using StampProperties. The
+// properties are returned unchanged if they do not carry a stamp.
+func ParseStampProperties(properties packet.Properties) (packet.Properties, time.Time, bool) {
+	for i, property := range properties {
+		// check property
+		pair, ok := property.Value.(packet.StringPair)
+		if !ok || property.ID != packet.UserProperty || pair.Key != stampKey {
+			continue
+		}
+
+		// parse time
+		nanos, err := strconv.ParseInt(pair.Value, 10, 64)
+		if err != nil {
+			return properties, time.Time{}, false
+		}
+
+		// remove property
+		list := make(packet.Properties, 0, len(properties)-1)
+		list = append(list, properties[:i]...)
+		list = append(list, properties[i+1:]...)
+
+		return list, time.Unix(0, nanos), true
+	}
+
+	return properties, time.Time{}, false
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStampPayload(t *testing.T) {
+	now := time.Now()
+
+	payload := []byte("foo")
+	stamped := StampPayload(payload, now)
+	assert.Equal(t, []byte("foo"), payload)
+	assert.Len(t, stamped, stampLen+3)
+
+	original, sent, ok := ParseStamp(stamped)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("foo"), original)
+	assert.True(t, now.Equal(sent))
+
+	original, sent, ok = ParseStamp(StampPayload(nil, now))
+	assert.True(t, ok)
+	assert.Empty(t, original)
+	assert.True(t, now.Equal(sent))
+
+	original, sent, ok = ParseStamp(payload)
+	assert.False(t, ok)
+	assert.Equal(t, payload, original)
+	assert.True(t, sent.IsZero())
+}
+
+func TestStampProperties(t *testing.T) {
+	now := time.Now()
+
+	properties := packet.Properties{{ID: packet.UserProperty, Value: packet.StringPair{Key: "foo", Value: "bar"}}}
+	stamped := StampProperties(properties, now)
+	assert.Len(t, properties, 1)
+	assert.Len(t, stamped, 2)
+
+	original, sent, ok := ParseStampProperties(stamped)
+	assert.True(t, ok)
+	assert.Equal(t, properties, original)
+	assert.True(t, now.Equal(sent))
+
+	original, sent, ok = ParseStampProperties(properties)
+	assert.False(t, ok)
+	assert.Equal(t, properties, original)
+	assert.True(t, sent.IsZero())
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// the upper bounds of the publish latency histogram
+var latencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// A MetricsSnapshot holds the values of Metrics at a specific time.
+type MetricsSnapshot struct {
+	// The number of sent and received packets per packet type.
+	Sent     map[string]uint64
+	Received map[string]uint64
+
+	// The number of sent and received bytes.
+	BytesSent     uint64
+	BytesReceived uint64
+
+	// The number of accepted connections and connection attempts made by a
+	// service after the first attempt.
+	Connects   uint64
+	Reconnects uint64
+
+	// The number of published messages with a QOS level greater than zero
+	// that have been sent but not yet acknowledged.
+	Inflight int64
+
+	// The number of acknowledged publishes per latency bucket and the total
+	// latency of all publishes. The buckets are bounded by 5ms, 10ms, 25ms,
+	// 50ms, 100ms, 250ms, 500ms, 1s, 2.5s, 5s, 10s and infinity.
+	LatencyBuckets []uint64
+	LatencyCount   uint64
+	LatencySum     time.Duration
+}
+
+// Metrics collects statistics about clients. The metrics are updated by all
+// clients that use them and can be exported using expvar or served in the
+// Prometheus text format. A single instance can be shared by the clients of
+// a process to monitor fleets of clients using the same scrape target.
+type Metrics struct {
+	sent     [16]uint64
+	received [16]uint64
+
+	bytesSent     uint64
+	bytesReceived uint64
+
+	connects   uint64
+	reconnects uint64
+
+	inflight int64
+
+	buckets      []uint64
+	latencyCount uint64
+	latencySum   int64
+}
+
+// NewMetrics returns new Metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		buckets: make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+// Snapshot returns the current values.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	// prepare snapshot
+	snapshot := MetricsSnapshot{
+		Sent:          map[string]uint64{},
+		Received:      map[string]uint64{},
+		BytesSent:     atomic.LoadUint64(&m.bytesSent),
+		BytesReceived: atomic.LoadUint64(&m.bytesReceived),
+		Connects:      atomic.LoadUint64(&m.connects),
+		Reconnects:    atomic.LoadUint64(&m.reconnects),
+		Inflight:      atomic.LoadInt64(&m.inflight),
+
+		LatencyBuckets: make([]uint64, len(m.buckets)),
+		LatencyCount:   atomic.LoadUint64(&m.latencyCount),
+		LatencySum:     time.Duration(atomic.LoadInt64(&m.latencySum)),
+	}
+
+	// get packet counters
+	for _, t := range packetTypes() {
+		snapshot.Sent[t.String()] = atomic.LoadUint64(&m.sent[t])
+		snapshot.Received[t.String()] = atomic.LoadUint64(&m.received[t])
+	}
+
+	// get buckets
+	for i := range m.buckets {
+		snapshot.LatencyBuckets[i] = atomic.LoadUint64(&m.buckets[i])
+	}
+
+	return snapshot
+}
+
+// Publish exports the metrics with the specified name using expvar.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// get snapshot
+	s := m.Snapshot()
+
+	// set content type
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	// write packet counters
+	writeMetric(w, "gomqtt_client_sent_packets_total", "counter", "The number of sent packets.")
+	for _, t := range packetTypes() {
+		fmt.Fprintf(w, "gomqtt_client_sent_packets_total{type=\"%s\"} %d\n", strings.ToLower(t.String()), s.Sent[t.String()])
+	}
+	writeMetric(w, "gomqtt_client_received_packets_total", "counter", "The number of received packets.")
+	for _, t := range packetTypes() {
+		fmt.Fprintf(w, "gomqtt_client_received_packets_total{type=\"%s\"} %d\n", strings.ToLower(t.String()), s.Received[t.String()])
+	}
+
+	// write byte counters
+	writeMetric(w, "gomqtt_client_sent_bytes_total", "counter", "The number of sent bytes.")
+	fmt.Fprintf(w, "gomqtt_client_sent_bytes_total %d\n", s.BytesSent)
+	writeMetric(w, "gomqtt_client_received_bytes_total", "counter", "The number of received bytes.")
+	fmt.Fprintf(w, "gomqtt_client_received_bytes_total %d\n", s.BytesReceived)
+
+	// write connection counters
+	writeMetric(w, "gomqtt_client_connects_total", "counter", "The number of accepted connections.")
+	fmt.Fprintf(w, "gomqtt_client_connects_total %d\n", s.Connects)
+	writeMetric(w, "gomqtt_client_reconnects_total", "counter", "The number of reconnection attempts.")
+	fmt.Fprintf(w, "gomqtt_client_reconnects_total %d\n", s.Reconnects)
+
+	// write gauge
+	writeMetric(w, "gomqtt_client_inflight_messages", "gauge", "The number of unacknowledged published messages.")
+	fmt.Fprintf(w, "gomqtt_client_inflight_messages %d\n", s.Inflight)
+
+	// write histogram
+	writeMetric(w, "gomqtt_client_publish_latency_seconds", "histogram", "The time until published messages have been acknowledged.")
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += s.LatencyBuckets[i]
+		fmt.Fprintf(w, "gomqtt_client_publish_latency_seconds_bucket{le=\"%g\"} %d\n", bound.Seconds(), cumulative)
+	}
+	fmt.Fprintf(w, "gomqtt_client_publish_latency_seconds_bucket{le=\"+Inf\"} %d\n", s.LatencyCount)
+	fmt.Fprintf(w, "gomqtt_client_publish_latency_seconds_sum %g\n", s.LatencySum.Seconds())
+	fmt.Fprintf(w, "gomqtt_client_publish_latency_seconds_count %d\n", s.LatencyCount)
+}
+
+// records a sent packet with the specified additional streamed bytes
+func (m *Metrics) countSent(pkt packet.GenericPacket, extra int64) {
+	atomic.AddUint64(&m.sent[pkt.Type()&0xF], 1)
+	atomic.AddUint64(&m.bytesSent, uint64(int64(pkt.Len())+extra))
+}
+
+// records a received packet with the specified additional streamed bytes
+func (m *Metrics) countReceived(pkt packet.GenericPacket, extra int64) {
+	atomic.AddUint64(&m.received[pkt.Type()&0xF], 1)
+	atomic.AddUint64(&m.bytesReceived, uint64(int64(pkt.Len())+extra))
+}
+
+// records the latency of an acknowledged publish
+func (m *Metrics) observeLatency(latency time.Duration) {
+	// find bucket
+	i := 0
+	for i < len(latencyBuckets) && latency > latencyBuckets[i] {
+		i++
+	}
+
+	atomic.AddUint64(&m.buckets[i], 1)
+	atomic.AddInt64(&m.latencySum, int64(latency))
+	atomic.AddUint64(&m.latencyCount, 1)
+}
+
+// returns the packet types that are counted
+func packetTypes() []packet.Type {
+	return []packet.Type{
+		packet.CONNECT, packet.CONNACK, packet.PUBLISH, packet.PUBACK,
+		packet.PUBREC, packet.PUBREL, packet.PUBCOMP, packet.SUBSCRIBE,
+		packet.SUBACK, packet.UNSUBSCRIBE, packet.UNSUBACK, packet.PINGREQ,
+		packet.PINGRESP, packet.DISCONNECT, packet.AUTH,
+	}
+}
+
+func writeMetric(w http.ResponseWriter, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 1
+	publish.ID = 1
+
+	puback := packet.NewPubackPacket()
+	puback.ID = 1
+
+	wait := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(publish).
+		Wait(wait).
+		Send(puback).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	metrics := NewMetrics()
+
+	c := New()
+	c.Metrics = metrics
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	publishFuture, err := c.Publish("test", []byte("test"), 1, false)
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(1), metrics.Snapshot().Inflight)
+
+	close(wait)
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+
+	snapshot := metrics.Snapshot()
+	assert.Equal(t, uint64(1), snapshot.Sent["Connect"])
+	assert.Equal(t, uint64(1), snapshot.Sent["Publish"])
+	assert.Equal(t, uint64(1), snapshot.Sent["Disconnect"])
+	assert.Equal(t, uint64(1), snapshot.Received["Connack"])
+	assert.Equal(t, uint64(1), snapshot.Received["Puback"])
+	assert.Equal(t, uint64(connectPacket().Len()+publish.Len()+disconnectPacket().Len()), snapshot.BytesSent)
+	assert.Equal(t, uint64(connackPacket().Len()+puback.Len()), snapshot.BytesReceived)
+	assert.Equal(t, uint64(1), snapshot.Connects)
+	assert.Equal(t, int64(0), snapshot.Inflight)
+	assert.Equal(t, uint64(1), snapshot.LatencyCount)
+	assert.True(t, snapshot.LatencySum >= 20*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, "gomqtt_client_sent_packets_total{type=\"publish\"} 1\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_client_received_packets_total{type=\"puback\"} 1\n"))
+	assert.True(t, strings.Contains(body, "# TYPE gomqtt_client_inflight_messages gauge\ngomqtt_client_inflight_messages 0\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_client_publish_latency_seconds_bucket{le=\"+Inf\"} 1\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_client_publish_latency_seconds_count 1\n"))
+}
+
+func TestMetricsObserveLatency(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.observeLatency(time.Millisecond)
+	metrics.observeLatency(5 * time.Millisecond)
+	metrics.observeLatency(20 * time.Millisecond)
+	metrics.observeLatency(time.Minute)
+
+	snapshot := metrics.Snapshot()
+	assert.Equal(t, []uint64{2, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 1}, snapshot.LatencyBuckets)
+	assert.Equal(t, uint64(4), snapshot.LatencyCount)
+
+	rec := httptest.NewRecorder()
+	metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, "gomqtt_client_publish_latency_seconds_bucket{le=\"0.005\"} 2\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_client_publish_latency_seconds_bucket{le=\"0.025\"} 3\n"))
+	assert.True(t, strings.Contains(body, "gomqtt_client_publish_latency_seconds_bucket{le=\"10\"} 3\n"))
+}
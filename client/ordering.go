@@ -0,0 +1,109 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/routines"
+)
+
+// An OrderingKey is a function that returns the key of a message. Messages
+// with the same key are handled in order while messages with different keys
+// are handled concurrently.
+type OrderingKey func(*packet.Message) string
+
+// TopicOrdering is an OrderingKey that handles messages in order per topic.
+func TopicOrdering(msg *packet.Message) string {
+	return msg.Topic
+}
+
+// an orderedMessage is a queued message and the function that acknowledges it
+type orderedMessage struct {
+	msg *packet.Message
+	ack func() error
+}
+
+// an orderedHandler calls the handler concurrently for different keys while
+// serializing the calls for the same key using a goroutine per active key
+type orderedHandler struct {
+	size    int
+	handler func(*packet.Message, func() error)
+	pending map[string][]orderedMessage
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	group   sync.WaitGroup
+}
+
+func newOrderedHandler(size int, handler func(*packet.Message, func() error)) *orderedHandler {
+	// check size
+	if size < 1 {
+		size = 1
+	}
+
+	// prepare handler
+	h := &orderedHandler{
+		size:    size,
+		handler: handler,
+		pending: make(map[string][]orderedMessage),
+	}
+	h.cond = sync.NewCond(&h.mutex)
+
+	return h
+}
+
+// queues the message and starts a goroutine for the key if missing, blocks
+// while the queue of the key is full
+func (h *orderedHandler) handle(key string, msg *packet.Message, ack func() error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// wait while queue is full
+	for len(h.pending[key]) >= h.size {
+		h.cond.Wait()
+	}
+
+	// add message
+	queue, running := h.pending[key]
+	h.pending[key] = append(queue, orderedMessage{msg: msg, ack: ack})
+
+	// start goroutine if not running
+	if !running {
+		h.group.Add(1)
+		routines.Go("client.handler", func() {
+			h.run(key)
+		})
+	}
+}
+
+// handles the messages of the key until the queue is empty
+func (h *orderedHandler) run(key string) {
+	defer h.group.Done()
+
+	for {
+		// get next message, it is removed once handled to keep the
+		// goroutine registered
+		h.mutex.Lock()
+		queue := h.pending[key]
+		if len(queue) == 0 {
+			delete(h.pending, key)
+			h.mutex.Unlock()
+			return
+		}
+		item := queue[0]
+		h.mutex.Unlock()
+
+		// call handler
+		h.handler(item.msg, item.ack)
+
+		// remove message and wake up blocked callers
+		h.mutex.Lock()
+		h.pending[key] = h.pending[key][1:]
+		h.cond.Broadcast()
+		h.mutex.Unlock()
+	}
+}
+
+// waits until all queued messages have been handled
+func (h *orderedHandler) wait() {
+	h.group.Wait()
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// ErrRedirectMissingReference is returned by Redirect.URL if the broker did not
+// provide a server reference.
+var ErrRedirectMissingReference = errors.New("redirect missing reference")
+
+// A Redirect is received from MQTT 5 brokers that ask the client to connect to
+// another server using the UseAnotherServer or ServerMoved reason code in a
+// ConnackPacket or DisconnectPacket.
+type Redirect struct {
+	// The server reference provided by the broker. It usually has the form
+	// "host:port" and may contain multiple space separated references.
+	Reference string
+
+	// Whether the server has moved permanently (ServerMoved) or should only
+	// be used for the next connection (UseAnotherServer).
+	Permanent bool
+}
+
+// returns a redirect if the reason code asks the client to use another server
+func newRedirect(rc packet.ReasonCode, properties packet.Properties) *Redirect {
+	// check reason code
+	if rc != packet.UseAnotherServer && rc != packet.ServerMoved {
+		return nil
+	}
+
+	// get reference
+	value, _ := properties.Get(packet.ServerReferenceProperty)
+	reference, _ := value.(string)
+
+	return &Redirect{
+		Reference: reference,
+		Permanent: rc == packet.ServerMoved,
+	}
+}
+
+// URL returns the broker URL with the host and port replaced by the first
+// server reference. The port of the broker URL is kept if the reference does
+// not specify one. References that are full URLs are returned unchanged.
+func (r *Redirect) URL(brokerURL string) (string, error) {
+	// get first reference
+	references := strings.Fields(r.Reference)
+	if len(references) == 0 {
+		return "", ErrRedirectMissingReference
+	}
+	reference := references[0]
+
+	// return full urls
+	if strings.Contains(reference, "://") {
+		return reference, nil
+	}
+
+	// parse broker url
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return "", err
+	}
+
+	// replace host and eventually port
+	if _, _, err := net.SplitHostPort(reference); err == nil {
+		u.Host = reference
+	} else if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(reference, port)
+	} else {
+		u.Host = reference
+	}
+
+	return u.String(), nil
+}
@@ -0,0 +1,297 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/routines"
+	"github.com/256dpi/gomqtt/transport"
+)
+
+// A BrokerStatus describes the last probe of a broker.
+type BrokerStatus struct {
+	// The URL of the broker.
+	URL string
+
+	// The smoothed time it took to establish a connection to the broker. It
+	// is zero if the broker has not yet been probed successfully.
+	Latency time.Duration
+
+	// Whether the last probe or connection attempt succeeded.
+	Healthy bool
+
+	// Whether the broker is currently selected.
+	Selected bool
+}
+
+// the weight of a new probe in the smoothed latency
+const selectorSmoothing = 0.3
+
+// a probed broker
+type probedBroker struct {
+	url     string
+	latency time.Duration
+	healthy bool
+	probed  bool
+}
+
+// A BrokerSelector selects the broker with the lowest latency from a list of
+// brokers e.g. deployed in multiple regions. It periodically probes the
+// brokers by establishing a connection and measuring the time it took. The
+// selection is applied by using Config as the ConfigCallback of a Service:
+//
+//	selector := client.NewBrokerSelector("tcp://eu.example.com", "tcp://us.example.com")
+//	selector.Start(time.Minute)
+//	defer selector.Stop()
+//
+//	service.ConfigCallback = selector.Config
+//
+// The selected broker is only replaced if another healthy broker is faster by
+// more than the hysteresis or if the selected broker became unhealthy. This
+// prevents the service from flapping between regions with similar latencies.
+type BrokerSelector struct {
+	// The dialer used to probe the brokers. It should be configured like the
+	// dialer of the client e.g. to support TLS.
+	//
+	// Note: The value must be changed before calling Start.
+	Dialer *transport.Dialer
+
+	// The timeout of a single probe.
+	//
+	// Note: The value must be changed before calling Start.
+	Timeout time.Duration
+
+	// The latency by which another broker must be faster than the selected
+	// broker to be selected instead.
+	Hysteresis time.Duration
+
+	brokers  []*probedBroker
+	selected int
+	stop     chan struct{}
+	mutex    sync.Mutex
+}
+
+// NewBrokerSelector returns a new selector for the specified broker URLs. The
+// first broker is selected until the brokers have been probed.
+func NewBrokerSelector(urls ...string) *BrokerSelector {
+	// check urls
+	if len(urls) == 0 {
+		panic("no broker urls for broker selector")
+	}
+
+	// prepare brokers, brokers are considered healthy until probed
+	brokers := make([]*probedBroker, 0, len(urls))
+	for _, url := range urls {
+		brokers = append(brokers, &probedBroker{
+			url:     url,
+			healthy: true,
+		})
+	}
+
+	return &BrokerSelector{
+		Dialer:     transport.NewDialer(),
+		Timeout:    5 * time.Second,
+		Hysteresis: 20 * time.Millisecond,
+		brokers:    brokers,
+	}
+}
+
+// Start will probe the brokers immediately and then in the specified interval
+// until Stop is called.
+func (s *BrokerSelector) Start(interval time.Duration) {
+	// check interval
+	if interval <= 0 {
+		panic("non-positive interval for broker selector")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// check state
+	if s.stop != nil {
+		return
+	}
+
+	// prepare channel
+	stop := make(chan struct{})
+	s.stop = stop
+
+	// run prober
+	routines.Go("client.selector", func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.Probe()
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	})
+}
+
+// Probe will probe all brokers concurrently and update the selection.
+func (s *BrokerSelector) Probe() {
+	// probe brokers
+	var wg sync.WaitGroup
+	latencies := make([]time.Duration, len(s.brokers))
+	for i, broker := range s.brokers {
+		wg.Add(1)
+		i, url := i, broker.url
+		routines.Go("client.selector.probe", func() {
+			defer wg.Done()
+			latencies[i] = s.probe(url)
+		})
+	}
+	wg.Wait()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// update brokers
+	for i, broker := range s.brokers {
+		latency := latencies[i]
+		if latency < 0 {
+			broker.healthy = false
+			continue
+		}
+
+		// smooth latency
+		if broker.probed {
+			latency = time.Duration(selectorSmoothing*float64(latency) + (1-selectorSmoothing)*float64(broker.latency))
+		}
+
+		broker.latency = latency
+		broker.healthy = true
+		broker.probed = true
+	}
+
+	// update selection
+	s.update()
+}
+
+// Select returns the URL of the currently selected broker.
+func (s *BrokerSelector) Select() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.brokers[s.selected].url
+}
+
+// Config sets the broker URL of the config to the selected broker. If the
+// previous connection attempt failed, the broker is marked as unhealthy until
+// it has been probed successfully and another broker is selected. If no broker
+// is healthy, the brokers are tried in turn. The method can be used as the
+// ConfigCallback of a Service.
+func (s *BrokerSelector) Config(config *Config, failures int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// mark failed broker and try the next broker if no broker is healthy
+	failed := s.selected
+	if failures > 0 && config.BrokerURL == s.brokers[failed].url {
+		s.brokers[failed].healthy = false
+		s.update()
+		if s.selected == failed {
+			s.selected = (failed + 1) % len(s.brokers)
+		}
+	}
+
+	// set url
+	config.BrokerURL = s.brokers[s.selected].url
+}
+
+// Status returns the status of all brokers in the order they have been
+// specified.
+func (s *BrokerSelector) Status() []BrokerStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// collect status
+	list := make([]BrokerStatus, 0, len(s.brokers))
+	for i, broker := range s.brokers {
+		list = append(list, BrokerStatus{
+			URL:      broker.url,
+			Latency:  broker.latency,
+			Healthy:  broker.healthy,
+			Selected: i == s.selected,
+		})
+	}
+
+	return list
+}
+
+// Stop will stop probing the brokers.
+func (s *BrokerSelector) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// stop prober
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+}
+
+// returns the time it took to establish a connection or -1 on failure
+func (s *BrokerSelector) probe(url string) time.Duration {
+	// prepare context
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	// dial broker
+	start := time.Now()
+	conn, err := s.Dialer.DialContext(ctx, url)
+	if err != nil {
+		return -1
+	}
+
+	// get latency
+	latency := time.Since(start)
+
+	// close connection
+	_ = conn.Close()
+
+	return latency
+}
+
+// selects the fastest healthy broker, the mutex must be held
+func (s *BrokerSelector) update() {
+	// find fastest healthy broker, unprobed brokers are only used if the
+	// selected broker is unhealthy
+	best := -1
+	for i, broker := range s.brokers {
+		if !broker.healthy {
+			continue
+		}
+		if best < 0 || s.faster(broker, s.brokers[best]) {
+			best = i
+		}
+	}
+
+	// keep selection if no broker is healthy
+	if best < 0 || best == s.selected {
+		return
+	}
+
+	// switch if the selected broker is unhealthy or the best broker is faster
+	// by more than the hysteresis
+	current := s.brokers[s.selected]
+	candidate := s.brokers[best]
+	if !current.healthy || (candidate.probed && (!current.probed || current.latency-candidate.latency > s.Hysteresis)) {
+		s.selected = best
+	}
+}
+
+// returns whether broker a is preferred over broker b
+func (s *BrokerSelector) faster(a, b *probedBroker) bool {
+	if a.probed != b.probed {
+		return a.probed
+	}
+
+	return a.latency < b.latency
+}
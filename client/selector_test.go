@@ -0,0 +1,99 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerSelectorProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	_ = closed.Close()
+
+	down := "tcp://" + closed.Addr().String()
+	up := "tcp://" + listener.Addr().String()
+
+	selector := NewBrokerSelector(down, up)
+	assert.Equal(t, down, selector.Select())
+
+	selector.Probe()
+	assert.Equal(t, up, selector.Select())
+
+	status := selector.Status()
+	assert.Len(t, status, 2)
+	assert.Equal(t, down, status[0].URL)
+	assert.False(t, status[0].Healthy)
+	assert.False(t, status[0].Selected)
+	assert.Equal(t, up, status[1].URL)
+	assert.True(t, status[1].Healthy)
+	assert.True(t, status[1].Selected)
+	assert.True(t, status[1].Latency > 0)
+
+	selector.Start(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	selector.Stop()
+	assert.Equal(t, up, selector.Select())
+}
+
+func TestBrokerSelectorHysteresis(t *testing.T) {
+	selector := NewBrokerSelector("tcp://a", "tcp://b")
+	selector.Hysteresis = 10 * time.Millisecond
+
+	set := func(i int, latency time.Duration) {
+		selector.brokers[i].latency = latency
+		selector.brokers[i].probed = true
+		selector.brokers[i].healthy = true
+		selector.update()
+	}
+
+	set(1, 50*time.Millisecond)
+	assert.Equal(t, "tcp://b", selector.Select())
+
+	set(0, 45*time.Millisecond)
+	assert.Equal(t, "tcp://b", selector.Select())
+
+	set(0, 30*time.Millisecond)
+	assert.Equal(t, "tcp://a", selector.Select())
+
+	set(1, 25*time.Millisecond)
+	assert.Equal(t, "tcp://a", selector.Select())
+}
+
+func TestBrokerSelectorConfig(t *testing.T) {
+	selector := NewBrokerSelector("tcp://a", "tcp://b", "tcp://c")
+	selector.brokers[2].latency = 10 * time.Millisecond
+	selector.brokers[2].probed = true
+
+	config := NewConfig("tcp://localhost")
+	selector.Config(config, 0)
+	assert.Equal(t, "tcp://a", config.BrokerURL)
+
+	selector.Config(config, 1)
+	assert.Equal(t, "tcp://c", config.BrokerURL)
+
+	selector.Config(config, 2)
+	assert.Equal(t, "tcp://b", config.BrokerURL)
+
+	selector.Config(config, 3)
+	assert.Equal(t, "tcp://c", config.BrokerURL)
+
+	selector.Config(config, 4)
+	assert.Equal(t, "tcp://a", config.BrokerURL)
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -8,11 +10,42 @@ import (
 
 	"github.com/256dpi/gomqtt/client/future"
 	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/routines"
 	"github.com/256dpi/gomqtt/session"
+	"github.com/256dpi/gomqtt/transport"
 	"github.com/jpillora/backoff"
 	"gopkg.in/tomb.v2"
 )
 
+// ErrServiceReconnectAttempts is passed to the ErrorCallback when the service
+// gives up after MaxReconnectAttempts consecutive failed connection attempts.
+var ErrServiceReconnectAttempts = errors.New("service reconnect attempts exhausted")
+
+// ErrServiceQueueFull is passed to the ErrorCallback for every command that is
+// dropped because the command queue is full.
+var ErrServiceQueueFull = errors.New("service queue full")
+
+// ErrServicePublishAttempts is passed to the ErrorCallback when a publish could
+// not be handed to a client within the attempts allowed by its RetryPolicy.
+var ErrServicePublishAttempts = errors.New("service publish attempts exhausted")
+
+// A QueuePolicy defines how the service handles commands that are issued while
+// the command queue is full.
+type QueuePolicy int
+
+// The available queue policies.
+const (
+	// BlockWhenFull blocks the caller until the command can be queued.
+	BlockWhenFull QueuePolicy = iota
+
+	// DropNewest drops the command that is about to be queued.
+	DropNewest
+
+	// DropOldest drops the oldest queued command to make room for the new
+	// command.
+	DropOldest
+)
+
 type command struct {
 	publish     bool
 	subscribe   bool
@@ -22,6 +55,37 @@ type command struct {
 	message       *packet.Message
 	subscriptions []packet.Subscription
 	topics        []string
+
+	policy   *RetryPolicy
+	attempts int
+}
+
+// A RetryPolicy controls how often and how fast the service retries a publish
+// that could not be handed to a client because the connection failed. Retried
+// publishes are queued again after the delay and may therefore be reordered
+// with publishes that have been queued in the meantime.
+type RetryPolicy struct {
+	// The maximum number of attempts. A value of zero or one disables retries
+	// and cancels the future of the publish after the first failure.
+	MaxAttempts int
+
+	// The delay before the first retry. The delay is doubled for every further
+	// retry. Defaults to 100ms.
+	MinDelay time.Duration
+
+	// The maximum delay between retries. Defaults to 10s.
+	MaxDelay time.Duration
+}
+
+// delay returns the delay before the specified retry
+func (p RetryPolicy) delay(retry int) time.Duration {
+	b := backoff.Backoff{
+		Min:    p.MinDelay,
+		Max:    p.MaxDelay,
+		Factor: 2,
+	}
+
+	return b.ForAttempt(float64(retry - 1))
 }
 
 // An OnlineCallback is a function that is called when the service is connected.
@@ -32,7 +96,8 @@ type OnlineCallback func(resumed bool)
 
 // A MessageCallback is a function that is called when a message is received.
 // If an error is returned the underlying client will be prevented from
-// acknowledging the specified message and closes immediately.
+// acknowledging the specified message and closes immediately. The error is
+// passed to the ErrorCallback and the service reconnects afterwards.
 //
 // Note: Execution of the service is resumed after the callback returns. This
 // means that waiting on a future inside the callback will deadlock the service.
@@ -45,11 +110,40 @@ type MessageCallback func(*packet.Message) error
 type ErrorCallback func(error)
 
 // An OfflineCallback is a function that is called when the service is disconnected.
+// The reason of the disconnect can be retrieved by calling CloseReason.
 //
 // Note: Execution of the service is resumed after the callback returns. This
 // means that waiting on a future inside the callback will deadlock the service.
 type OfflineCallback func()
 
+// A QueueCallback is a function that is called when the number of queued
+// commands reaches the high watermark (high=true) and when it drops back to the
+// low watermark afterwards (high=false).
+//
+// Note: The callback is called from the goroutine that queued or dispatched
+// the command that crossed the watermark and must therefore not block.
+type QueueCallback func(high bool)
+
+// A ConfigCallback is a function that is called before every connection
+// attempt with a copy of the current config and the number of consecutive
+// failed attempts. Changes made to the config are used for the attempt and all
+// subsequent attempts. This allows to e.g. increase the keep alive, replace the
+// will message, refresh credentials, renew certificates by setting a new
+// dialer or select a broker using a BrokerSelector.
+//
+// Note: Execution of the service is resumed after the callback returns.
+type ConfigCallback func(config *Config, failures int)
+
+// A ReconnectCallback is a function that is called before every reconnection
+// attempt with the number of the attempt since the connection has been lost
+// and the delay the service is going to wait before the attempt. If an error
+// is returned, the service stops reconnecting and passes the error to the
+// ErrorCallback. This allows to e.g. pause reconnecting outside of business
+// hours or implement a circuit breaker.
+//
+// Note: Execution of the service is resumed after the callback returns.
+type ReconnectCallback func(attempt int, delay time.Duration) error
+
 const (
 	serviceStarted uint32 = iota
 	serviceStopped
@@ -85,11 +179,43 @@ type Service struct {
 	// The callback that is used to notify that the service is offline.
 	OfflineCallback OfflineCallback
 
+	// The callback that is used to notify that the command queue crossed one of
+	// the watermarks.
+	QueueCallback QueueCallback
+
+	// The callback that is used to change the config between connection
+	// attempts.
+	ConfigCallback ConfigCallback
+
+	// The callback that is used to approve or cancel reconnection attempts.
+	// Queued commands stay pending until Stop is called if reconnecting has
+	// been canceled.
+	ReconnectCallback ReconnectCallback
+
+	// The policy applied to commands that are issued while the command queue
+	// is full. Commands are queued while the service is offline and flushed
+	// once it is connected again. The size of the queue is set using
+	// NewService. The futures of dropped commands are canceled and the
+	// ErrorCallback is called with ErrServiceQueueFull.
+	QueuePolicy QueuePolicy
+
+	// The number of queued commands at which the QueueCallback is called with
+	// high set to true. A zero value disables the watermark notifications.
+	HighWatermark int
+
+	// The number of queued commands at which the QueueCallback is called with
+	// high set to false after the high watermark has been reached.
+	LowWatermark int
+
 	// The logger that is used to log write low level information like packets
 	// that have ben successfully sent and received, details about the
 	// automatic keep alive handler, reconnection and occurring errors.
 	Logger Logger
 
+	// The callback that is called with the delivery latency of received
+	// messages that carry a latency stamp. See Config.LatencyStamps.
+	LatencyCallback LatencyCallback
+
 	// The minimum delay between reconnects.
 	//
 	// Note: The value must be changed before calling Start.
@@ -100,14 +226,111 @@ type Service struct {
 	// Note: The value must be changed before calling Start.
 	MaxReconnectDelay time.Duration
 
+	// If set, the delays between reconnects are randomized between the minimum
+	// delay and the current backoff delay to prevent many clients from
+	// reconnecting at the same time.
+	//
+	// Note: The value must be changed before calling Start.
+	ReconnectJitter bool
+
+	// The maximum number of consecutive failed connection attempts. If reached,
+	// the service stops reconnecting and passes ErrServiceReconnectAttempts to
+	// the ErrorCallback. Queued commands stay pending until Stop is called. A
+	// zero value retries forever.
+	MaxReconnectAttempts int
+
+	// If set, the subscriptions made using the service are restored after
+	// reconnecting if the broker did not resume the session. If clean session
+	// is set to false and the session stores subscriptions like the
+	// MemorySession, the client instead replays the subscriptions stored in
+	// the session.
+	RestoreSubscriptions bool
+
+	// If set, the subscriptions made using the service are issued again after
+	// reconnecting if the broker claims to have resumed the session. This
+	// protects against brokers that report a present session while they have
+	// actually lost its subscriptions. With MQTT 5 the subscriptions are
+	// issued using the SendRetainedIfNew retain handling. With older versions
+	// the broker will send the retained messages of the subscribed topics
+	// again.
+	VerifySubscriptions bool
+
 	// The allowed timeout until a connection attempt is canceled.
 	ConnectTimeout time.Duration
 
 	// The allowed timeout until a connection is forcefully closed.
 	DisconnectTimeout time.Duration
 
+	// The duration during which publishes with the same idempotency key are
+	// suppressed by PublishWithKey.
+	DeduplicationWindow time.Duration
+
+	// The policy used to retry publishes that could not be handed to a client.
+	// It can be overridden for single messages using PublishWithRetry. The
+	// zero value disables retries.
+	RetryPolicy RetryPolicy
+
+	// The number of topics for which the last received message is cached and
+	// can be retrieved using LastMessage. The cache is kept across reconnects.
+	//
+	// Note: The value must be changed before calling Start.
+	CacheSize int
+
+	// The configuration of a standby broker. If set, the service maintains a
+	// second connection to the standby broker while connected to the primary
+	// broker and switches to it immediately if the primary connection fails.
+	// The standby client is connected in the background and uses its own
+	// memory session. The subscriptions made using the service are restored
+	// on the standby broker when switching. The futures of commands that are
+	// still in flight on the failed connection are canceled when switching.
+	//
+	// Note: The value must be changed before calling Start.
+	StandbyConfig *Config
+
+	// The metrics that are updated by the clients of the service and with the
+	// number of reconnection attempts.
+	//
+	// Note: The value must be changed before calling Start.
+	Metrics *Metrics
+
+	// The function used to get the ordering key of received messages. If set,
+	// the MessageCallback is called from separate goroutines. Messages with
+	// the same key are handled in order while messages with different keys
+	// are handled concurrently. Messages are acknowledged once the callback
+	// returned without an error. Errors returned by the callback are only
+	// passed to the ErrorCallback and the affected messages are redelivered
+	// by the broker once the session is resumed. Stop waits until all queued
+	// messages have been handled.
+	//
+	// Note: The value must be changed before calling Start.
+	OrderingKey OrderingKey
+
+	// The maximum number of messages that are queued per ordering key. The
+	// client stops reading further messages while a queue is full. The
+	// default is 100.
+	//
+	// Note: The value must be changed before calling Start.
+	OrderingQueueSize int
+
 	commandQueue chan *command
 	futureStore  *future.Store
+	dedupStore   *dedupStore
+	cache        *messageCache
+	handler      *orderedHandler
+	closeReason  uint32
+	aboveHigh    uint32
+	fallback     bool
+	redirect     string
+	redirected   bool
+	current      atomic.Pointer[Client]
+
+	subscriptions map[string]packet.Subscription
+
+	retries    map[*command]*time.Timer
+	retryMutex sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
 
 	mutex sync.Mutex
 	tomb  *tomb.Tomb
@@ -123,14 +346,18 @@ func NewService(queueSize ...int) *Service {
 	}
 
 	return &Service{
-		state:             serviceStopped,
-		Session:           session.NewMemorySession(),
-		MinReconnectDelay: 1 * time.Second,
-		MaxReconnectDelay: 32 * time.Second,
-		ConnectTimeout:    5 * time.Second,
-		DisconnectTimeout: 10 * time.Second,
-		commandQueue:      make(chan *command, qs),
-		futureStore:       future.NewStore(),
+		state:               serviceStopped,
+		Session:             session.NewMemorySession(),
+		MinReconnectDelay:   1 * time.Second,
+		MaxReconnectDelay:   32 * time.Second,
+		ConnectTimeout:      5 * time.Second,
+		DisconnectTimeout:   10 * time.Second,
+		DeduplicationWindow: 1 * time.Minute,
+		OrderingQueueSize:   100,
+		commandQueue:        make(chan *command, qs),
+		futureStore:         future.NewStore(),
+		dedupStore:          newDedupStore(),
+		subscriptions:       make(map[string]packet.Subscription),
 	}
 }
 
@@ -154,28 +381,59 @@ func (s *Service) Start(config *Config) {
 
 	// save config
 	s.config = config
+	s.fallback = false
+	s.redirect = ""
 
 	// initialize backoff
 	s.backoff = &backoff.Backoff{
 		Min:    s.MinReconnectDelay,
 		Max:    s.MaxReconnectDelay,
 		Factor: 2,
+		Jitter: s.ReconnectJitter,
+	}
+
+	// create cache if requested
+	if s.cache == nil && s.CacheSize > 0 {
+		s.cache = newMessageCache(s.CacheSize)
+	}
+
+	// create ordered handler if requested
+	s.handler = nil
+	if s.OrderingKey != nil && s.MessageCallback != nil {
+		s.handler = newOrderedHandler(s.OrderingQueueSize, func(msg *packet.Message, ack func() error) {
+			// call callback
+			err := s.MessageCallback(msg)
+			if err != nil {
+				s.err("Message", err)
+				return
+			}
+
+			// acknowledge message, the broker will redeliver the message if
+			// the client has been closed in the meantime
+			err = ack()
+			if err != nil && err != ErrClientNotConnected {
+				s.err("Ack", err)
+			}
+		})
 	}
 
 	// mark future store as protected
 	s.futureStore.Protect(true)
 
+	// create context that aborts connection attempts on Stop
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
 	// create new tomb
 	s.tomb = new(tomb.Tomb)
 
 	// start supervisor
-	s.tomb.Go(s.supervisor)
+	s.tomb.Go(routines.Wrap("client.supervisor", s.supervisor))
 }
 
 // Publish will send a PublishPacket containing the passed parameters. It will
 // return a PublishFuture that gets completed once the quality of service flow
 // has been completed.
-func (s *Service) Publish(topic string, payload []byte, qos uint8, retain bool) GenericFuture {
+func (s *Service) Publish(topic string, payload []byte, qos uint8, retain bool) PublishFuture {
 	msg := &packet.Message{
 		Topic:   topic,
 		Payload: payload,
@@ -189,18 +447,48 @@ func (s *Service) Publish(topic string, payload []byte, qos uint8, retain bool)
 // PublishMessage will send a PublishPacket containing the passed message. It will
 // return a PublishFuture that gets completed once the quality of service flow
 // has been completed.
-func (s *Service) PublishMessage(msg *packet.Message) GenericFuture {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *Service) PublishMessage(msg *packet.Message) PublishFuture {
+	// allocate future
+	f := future.New()
+
+	// queue publish
+	s.queue(&command{
+		publish: true,
+		future:  f,
+		message: msg,
+	})
 
+	return &publishFuture{f}
+}
+
+// PublishWithRetry will send a PublishPacket containing the passed message
+// like PublishMessage, but retries the publish according to the specified
+// policy instead of the RetryPolicy of the service.
+func (s *Service) PublishWithRetry(msg *packet.Message, policy RetryPolicy) PublishFuture {
 	// allocate future
 	f := future.New()
 
 	// queue publish
-	s.commandQueue <- &command{
+	s.queue(&command{
 		publish: true,
 		future:  f,
 		message: msg,
+		policy:  &policy,
+	})
+
+	return &publishFuture{f}
+}
+
+// PublishWithKey will send a PublishPacket containing the passed message unless
+// a message with the same idempotency key has been published during the
+// DeduplicationWindow. In that case the message is dropped and the future of
+// the previous publish is returned.
+func (s *Service) PublishWithKey(key string, msg *packet.Message) PublishFuture {
+	f, duplicate := s.dedupStore.lookup(key, s.DeduplicationWindow, func() PublishFuture {
+		return s.PublishMessage(msg)
+	})
+	if duplicate {
+		s.log(fmt.Sprintf("Duplicate Publish: %s", key))
 	}
 
 	return f
@@ -219,18 +507,15 @@ func (s *Service) Subscribe(topic string, qos uint8) SubscribeFuture {
 // subscribe. It will return a SubscribeFuture that gets completed once the
 // acknowledgements have been received.
 func (s *Service) SubscribeMultiple(subscriptions []packet.Subscription) SubscribeFuture {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	// allocate future
 	f := future.New()
 
 	// queue subscribe
-	s.commandQueue <- &command{
+	s.queue(&command{
 		subscribe:     true,
 		future:        f,
 		subscriptions: subscriptions,
-	}
+	})
 
 	return &subscribeFuture{f}
 }
@@ -246,24 +531,22 @@ func (s *Service) Unsubscribe(topic string) GenericFuture {
 // topics to unsubscribe. It will return a SubscribeFuture that gets completed
 // once the acknowledgements have been received.
 func (s *Service) UnsubscribeMultiple(topics []string) GenericFuture {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	// allocate future
 	f := future.New()
 
 	// queue unsubscribe
-	s.commandQueue <- &command{
+	s.queue(&command{
 		unsubscribe: true,
 		future:      f,
 		topics:      topics,
-	}
+	})
 
 	return f
 }
 
 // Stop will disconnect the client if online and cancel all futures if requested.
-// After the service is stopped in can be started again.
+// The futures of publishes that wait to be retried are always canceled. After
+// the service is stopped in can be started again.
 //
 // Note: You should clear the futures on the last stop before exiting to ensure
 // that all goroutines return that wait on futures.
@@ -276,10 +559,21 @@ func (s *Service) Stop(clearFutures bool) {
 		return
 	}
 
+	// abort connection attempts
+	s.cancel()
+
 	// kill and wait
 	s.tomb.Kill(nil)
 	s.tomb.Wait()
 
+	// wait for queued messages
+	if s.handler != nil {
+		s.handler.wait()
+	}
+
+	// cancel pending retries
+	s.cancelRetries()
+
 	// clear futures if requested
 	if clearFutures {
 		s.futureStore.Protect(false)
@@ -290,17 +584,62 @@ func (s *Service) Stop(clearFutures bool) {
 	atomic.StoreUint32(&s.state, serviceStopped)
 }
 
+// LastMessage returns a copy of the last message that has been received for
+// the specified topic. It returns nil if no message has been received or the
+// cache is disabled.
+func (s *Service) LastMessage(topic string) *packet.Message {
+	// check cache
+	if s.cache == nil {
+		return nil
+	}
+
+	return s.cache.get(topic)
+}
+
+// QueueLength returns the number of commands that are currently queued and
+// not yet dispatched to a client.
+func (s *Service) QueueLength() int {
+	return len(s.commandQueue)
+}
+
+// CloseReason returns the reason why the last connection to the broker has
+// been closed. The method can be called from within the OfflineCallback.
+func (s *Service) CloseReason() transport.CloseReason {
+	return transport.CloseReason(atomic.LoadUint32(&s.closeReason))
+}
+
 // the supervised reconnect loop
 func (s *Service) supervisor() error {
 	first := true
+	failures := 0
+	attempts := 0
+
+	// the standby client, its stop channel and the channel that receives
+	// the standby client while it is connected in the background
+	var standby *Client
+	var standbyFail chan struct{}
+	var standbyResult chan *Client
 
 	for {
+		// get backoff duration
+		var d time.Duration
 		if first {
 			// no delay on first attempt
 			first = false
 		} else {
-			// get backoff duration
-			d := s.backoff.Duration()
+			d = s.backoff.Duration()
+		}
+
+		// run callback and give up if reconnecting has been canceled
+		if s.ReconnectCallback != nil && attempts > 0 {
+			err := s.ReconnectCallback(failures+1, d)
+			if err != nil {
+				s.err("Reconnect", err)
+				return err
+			}
+		}
+
+		if d > 0 {
 			s.log(fmt.Sprintf("Delay Reconnect: %v", d))
 
 			// sleep but return on Stop
@@ -316,28 +655,145 @@ func (s *Service) supervisor() error {
 		// prepare the stop channel
 		fail := make(chan struct{})
 
+		// run callback
+		if s.ConfigCallback != nil {
+			config := *s.config
+			s.ConfigCallback(&config, failures)
+			s.config = &config
+		}
+
+		// count reconnection attempt
+		if s.Metrics != nil && attempts > 0 {
+			atomic.AddUint64(&s.Metrics.reconnects, 1)
+		}
+		attempts++
+
 		// try once to get a client
+		fallback := s.fallback
+		s.redirected = false
 		client, resumed := s.connect(fail)
 		if client == nil {
+			// increment failures
+			failures++
+
+			// give up if the maximum attempts have been reached
+			if s.MaxReconnectAttempts > 0 && failures >= s.MaxReconnectAttempts {
+				s.err("Reconnect", ErrServiceReconnectAttempts)
+				return ErrServiceReconnectAttempts
+			}
+
+			// retry without delay if the fallback version has been activated
+			// or the broker redirected the client
+			if (!fallback && s.fallback) || s.redirected {
+				first = true
+			}
+
 			continue
 		}
 
-		// run callback
-		if s.OnlineCallback != nil {
-			s.OnlineCallback(resumed)
-		}
+		// reset failures
+		failures = 0
 
-		// run dispatcher on client
-		dying := s.dispatcher(client, fail)
+		// restore subscriptions if the session has been lost and the client
+		// does not replay them from the session or verify them if the
+		// session has been resumed
+		if s.RestoreSubscriptions && !resumed && !client.restoresSubscriptions() {
+			s.restore(client, false)
+		} else if s.VerifySubscriptions && resumed {
+			s.restore(client, true)
+		}
 
-		// run callback
-		if s.OfflineCallback != nil {
-			s.OfflineCallback()
+		// connect standby client in the background if configured and missing
+		if s.StandbyConfig != nil && standbyResult == nil && (standby == nil || closed(standbyFail)) {
+			standby, standbyFail, standbyResult = nil, make(chan struct{}), make(chan *Client, 1)
+			result, fail := standbyResult, standbyFail
+			routines.Go("client.standby", func() {
+				result <- s.connectStandby(fail)
+			})
 		}
 
-		// return goroutine if dying
-		if dying {
-			return tomb.ErrDying
+		for {
+			// set current client
+			s.current.Store(client)
+
+			// run callback
+			if s.OnlineCallback != nil {
+				s.OnlineCallback(resumed)
+			}
+
+			// run dispatcher on client
+			dying := s.dispatcher(client, fail)
+			s.current.Store(nil)
+
+			// save close reason
+			reason := client.CloseReason()
+			atomic.StoreUint32(&s.closeReason, uint32(reason))
+
+			// restart backoff if the connection has been lost because of a
+			// network failure while protocol errors will further increase
+			// the delay
+			if reason != transport.ProtocolError {
+				s.backoff.Reset()
+			}
+
+			// reconnect without delay if the broker redirected the client
+			if s.adoptRedirect(client) {
+				first = true
+			}
+
+			// run callback
+			if s.OfflineCallback != nil {
+				s.OfflineCallback()
+			}
+
+			// await standby client if dying or check if it has been connected
+			if standbyResult != nil && dying {
+				standby, standbyResult = <-standbyResult, nil
+			} else if standbyResult != nil {
+				select {
+				case standby = <-standbyResult:
+					standbyResult = nil
+				default:
+				}
+			}
+
+			// disconnect standby client and return goroutine if dying
+			if dying {
+				if standby != nil && !closed(standbyFail) {
+					err := standby.Disconnect(s.DisconnectTimeout)
+					if err != nil {
+						s.err("Disconnect", err)
+					}
+				}
+
+				return tomb.ErrDying
+			}
+
+			// cancel futures of the standby client as its session is lost
+			if client.futureStore != s.futureStore {
+				client.futureStore.Clear()
+			}
+
+			// reconnect if no standby client is available
+			if standby == nil || closed(standbyFail) {
+				standby = nil
+				break
+			}
+
+			s.log("Switch Standby")
+
+			// cancel futures of the primary client as they will not be
+			// completed by the standby client
+			s.futureStore.Protect(false)
+			s.futureStore.Clear()
+			s.futureStore.Protect(true)
+
+			// switch to standby client
+			client, fail, resumed = standby, standbyFail, false
+			standby, standbyFail = nil, nil
+
+			// restore subscriptions
+			s.restore(client, false)
 		}
 	}
 }
@@ -345,29 +801,26 @@ func (s *Service) supervisor() error {
 // will try to connect one client to the broker
 func (s *Service) connect(fail chan struct{}) (*Client, bool) {
 	// prepare new client
-	client := New()
-	client.Session = s.Session
-	client.Logger = s.Logger
-	client.futureStore = s.futureStore
-
-	// set callback
-	client.Callback = func(msg *packet.Message, err error) error {
-		if err != nil {
-			s.err("Client", err)
-			close(fail)
-			return nil
-		}
-
-		// call the handler
-		if s.MessageCallback != nil {
-			return s.MessageCallback(msg)
-		}
+	client := s.prepare(fail)
+
+	// use fallback version if activated
+	config := s.config
+	if s.fallback {
+		copied := *config
+		copied.Version = config.FallbackVersion
+		config = &copied
+	}
 
-		return nil
+	// use temporary redirect once
+	if s.redirect != "" {
+		copied := *config
+		copied.BrokerURL = s.redirect
+		config = &copied
+		s.redirect = ""
 	}
 
 	// attempt to connect
-	connectFuture, err := client.Connect(s.config)
+	connectFuture, err := client.ConnectContext(s.ctx, config)
 	if err != nil {
 		s.err("Connect", err)
 		return nil, false
@@ -379,6 +832,19 @@ func (s *Service) connect(fail chan struct{}) (*Client, bool) {
 	// check if future has been canceled
 	if err == future.ErrCanceled {
 		s.err("Connect", err)
+
+		// follow redirect if the broker asked to use another server
+		if s.adoptRedirect(client) {
+			return nil, false
+		}
+
+		// activate fallback version if the version has been rejected
+		if connectFuture.ReturnCode() == packet.ErrInvalidProtocolVersion &&
+			config.FallbackVersion != 0 && !s.fallback {
+			s.log(fmt.Sprintf("Fallback Version: %d", config.FallbackVersion))
+			s.fallback = true
+		}
+
 		return nil, false
 	}
 
@@ -401,11 +867,164 @@ func (s *Service) connect(fail chan struct{}) (*Client, bool) {
 	return client, connectFuture.SessionPresent()
 }
 
+// adopts the redirect received by the client and returns whether the client
+// has been redirected
+func (s *Service) adoptRedirect(client *Client) bool {
+	// get redirect
+	redirect := client.Redirect()
+	if redirect == nil {
+		return false
+	}
+
+	// get broker url
+	brokerURL, err := redirect.URL(s.config.BrokerURL)
+	if err != nil {
+		s.err("Redirect", err)
+		return false
+	}
+
+	s.log(fmt.Sprintf("Redirect: %s (permanent %t)", brokerURL, redirect.Permanent))
+
+	// replace broker url if the server moved or use it once
+	if redirect.Permanent {
+		config := *s.config
+		config.BrokerURL = brokerURL
+		s.config = &config
+	} else {
+		s.redirect = brokerURL
+	}
+
+	// set flag
+	s.redirected = true
+
+	return true
+}
+
+// will try to connect the standby client to the standby broker
+func (s *Service) connectStandby(fail chan struct{}) *Client {
+	// prepare new client
+	client := s.prepare(fail)
+	client.Session = session.NewMemorySession()
+	client.futureStore = future.NewStore()
+
+	// attempt to connect
+	connectFuture, err := client.ConnectContext(s.ctx, s.StandbyConfig)
+	if err != nil {
+		s.err("Standby", err)
+		return nil
+	}
+
+	// wait for connack
+	err = connectFuture.Wait(s.ConnectTimeout)
+	if err == future.ErrTimeout {
+		client.Close()
+	}
+	if err != nil {
+		s.err("Standby", err)
+		return nil
+	}
+
+	// check return code
+	if connectFuture.ReturnCode() != packet.ConnectionAccepted {
+		client.Close()
+
+		s.err("Standby", connectFuture.ReturnCode())
+		return nil
+	}
+
+	s.log("Standby Connected")
+
+	return client
+}
+
+// restores the subscriptions made using the service on a client
+func (s *Service) restore(client *Client, verify bool) {
+	// check subscriptions
+	if len(s.subscriptions) == 0 {
+		return
+	}
+
+	// collect subscriptions
+	subscriptions := s.restorable(client.config.Version, verify)
+
+	// subscribe
+	_, err := client.SubscribeMultiple(subscriptions)
+	if err != nil {
+		s.err("Subscribe", err)
+	}
+}
+
+// returns the subscriptions made using the service, verified subscriptions do
+// not receive retained messages again if supported
+func (s *Service) restorable(version byte, verify bool) []packet.Subscription {
+	// collect subscriptions
+	subscriptions := make([]packet.Subscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		// skip retained messages for existing subscriptions
+		if verify && version == packet.Version5 && sub.RetainHandling == packet.SendRetained {
+			sub.RetainHandling = packet.SendRetainedIfNew
+		}
+
+		subscriptions = append(subscriptions, sub)
+	}
+
+	return subscriptions
+}
+
+// returns a new client that closes the fail channel on errors
+func (s *Service) prepare(fail chan struct{}) *Client {
+	// prepare new client
+	client := New()
+	client.Session = s.Session
+	client.Logger = s.Logger
+	client.LatencyCallback = s.LatencyCallback
+	client.Metrics = s.Metrics
+	client.futureStore = s.futureStore
+	client.cache = s.cache
+	client.service = s
+
+	// acknowledge messages manually if ordered
+	client.ManualAcks = s.handler != nil
+
+	// set callback
+	client.Callback = func(msg *packet.Message, err error) error {
+		if err != nil {
+			s.err("Client", err)
+			close(fail)
+			return nil
+		}
+
+		// queue message if ordered
+		if s.handler != nil {
+			s.handler.handle(s.OrderingKey(msg), msg, func() error {
+				return client.Ack(msg)
+			})
+			return nil
+		}
+
+		// call the handler
+		if s.MessageCallback != nil {
+			err = s.MessageCallback(msg)
+			if err != nil {
+				s.err("Message", err)
+				close(fail)
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return client
+}
+
 // reads from the queues and calls the current client
 func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 	for {
 		select {
 		case cmd := <-s.commandQueue:
+			// check low watermark
+			s.checkLowWatermark()
 
 			// handle subscribe command
 			if cmd.subscribe {
@@ -421,7 +1040,14 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 
 				// bind future in a own goroutine. the goroutine will be
 				// ultimately collected when the service is stopped
-				go cmd.future.Bind(f2.(*subscribeFuture).Future)
+				routines.Go("client.bind", func() {
+					cmd.future.Bind(f2.(*subscribeFuture).Future)
+				})
+
+				// remember subscriptions
+				for _, sub := range cmd.subscriptions {
+					s.subscriptions[sub.Topic] = sub
+				}
 			}
 
 			// handle unsubscribe command
@@ -438,7 +1064,14 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 
 				// bind future in a own goroutine. the goroutine will be
 				// ultimately collected when the service is stopped
-				go cmd.future.Bind(f2.(*future.Future))
+				routines.Go("client.bind", func() {
+					cmd.future.Bind(f2.(*future.Future))
+				})
+
+				// forget subscriptions
+				for _, topic := range cmd.topics {
+					delete(s.subscriptions, topic)
+				}
 			}
 
 			// handle publish command
@@ -447,15 +1080,17 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 				if err != nil {
 					s.err("Publish", err)
 
-					// cancel future
-					cmd.future.Cancel()
+					// retry publish or cancel future
+					s.retry(cmd)
 
 					return false
 				}
 
 				// bind future in a own goroutine. the goroutine will be
 				// ultimately collected when the service is stopped
-				go cmd.future.Bind(f2.(*future.Future))
+				routines.Go("client.bind", func() {
+					cmd.future.Bind(f2.(*publishFuture).Future)
+				})
 			}
 		case <-s.tomb.Dying():
 			// disconnect client on Stop
@@ -471,6 +1106,115 @@ func (s *Service) dispatcher(client *Client, fail chan struct{}) bool {
 	}
 }
 
+func (s *Service) queue(cmd *command) {
+	s.mutex.Lock()
+
+	// queue command according to policy
+	var dropped *command
+	switch s.QueuePolicy {
+	case DropNewest:
+		select {
+		case s.commandQueue <- cmd:
+		default:
+			dropped = cmd
+		}
+	case DropOldest:
+		select {
+		case s.commandQueue <- cmd:
+		default:
+			select {
+			case dropped = <-s.commandQueue:
+			default:
+			}
+
+			s.commandQueue <- cmd
+		}
+	default:
+		s.commandQueue <- cmd
+	}
+
+	s.mutex.Unlock()
+
+	// cancel dropped command
+	if dropped != nil {
+		dropped.future.Cancel()
+		s.err("Queue", ErrServiceQueueFull)
+	}
+
+	// check high watermark
+	if s.HighWatermark > 0 && len(s.commandQueue) >= s.HighWatermark {
+		if atomic.CompareAndSwapUint32(&s.aboveHigh, 0, 1) && s.QueueCallback != nil {
+			s.QueueCallback(true)
+		}
+	}
+}
+
+func (s *Service) retry(cmd *command) {
+	// get policy
+	policy := s.RetryPolicy
+	if cmd.policy != nil {
+		policy = *cmd.policy
+	}
+
+	// increment attempts
+	cmd.attempts++
+
+	// cancel future if attempts are exhausted
+	if cmd.attempts >= policy.MaxAttempts {
+		if policy.MaxAttempts > 1 {
+			s.err("Publish", ErrServicePublishAttempts)
+		}
+
+		cmd.future.Cancel()
+
+		return
+	}
+
+	// queue command again after the delay unless it has been canceled by Stop
+	d := policy.delay(cmd.attempts)
+	s.log(fmt.Sprintf("Retry Publish: %s (attempt %d in %s)", cmd.message.Topic, cmd.attempts+1, d))
+	s.retryMutex.Lock()
+	if s.retries == nil {
+		s.retries = make(map[*command]*time.Timer)
+	}
+	s.retries[cmd] = time.AfterFunc(d, func() {
+		// remove pending retry
+		s.retryMutex.Lock()
+		_, ok := s.retries[cmd]
+		delete(s.retries, cmd)
+		s.retryMutex.Unlock()
+
+		// queue command if still pending
+		if ok {
+			s.queue(cmd)
+		}
+	})
+	s.retryMutex.Unlock()
+}
+
+func (s *Service) cancelRetries() {
+	// acquire mutex
+	s.retryMutex.Lock()
+	defer s.retryMutex.Unlock()
+
+	// stop timers and cancel futures
+	for cmd, timer := range s.retries {
+		timer.Stop()
+		cmd.future.Cancel()
+	}
+
+	// reset map
+	s.retries = nil
+}
+
+func (s *Service) checkLowWatermark() {
+	if s.HighWatermark > 0 && len(s.commandQueue) <= s.LowWatermark {
+		if atomic.CompareAndSwapUint32(&s.aboveHigh, 1, 0) && s.QueueCallback != nil {
+			s.QueueCallback(false)
+		}
+	}
+}
+
 func (s *Service) err(sys string, err error) {
 	s.log(fmt.Sprintf("%s Error: %s", sys, err.Error()))
 
@@ -484,3 +1228,13 @@ func (s *Service) log(str string) {
 		s.Logger(str)
 	}
 }
+
+// returns whether the channel has been closed
+func closed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"hash/fnv"
+	"strconv"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// ShardTopic returns the shard topic below base that is assigned to the key.
+// The key is hashed to one of the shards base/0 to base/N-1. Messages with the
+// same key are therefore always published to the same shard, which preserves
+// their order for a single consumer.
+func ShardTopic(base, key string, shards int) string {
+	// check shards
+	if shards < 1 {
+		shards = 1
+	}
+
+	// hash key
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	shard := hash.Sum32() % uint32(shards)
+
+	return base + "/" + strconv.FormatUint(uint64(shard), 10)
+}
+
+// ShardFilters returns the shard topics below base that should be subscribed
+// by the consumer with the specified index out of the total number of
+// consumers. The shards are spread evenly across the consumers. If there are
+// more consumers than shards, the remaining consumers share a shard with the
+// others.
+func ShardFilters(base string, shards, consumer, consumers int) []string {
+	// check values
+	if shards < 1 {
+		shards = 1
+	}
+	if consumers < 1 {
+		consumers = 1
+	}
+	consumer = ((consumer % consumers) + consumers) % consumers
+
+	// collect shards
+	var filters []string
+	for i := consumer; i < shards; i += consumers {
+		filters = append(filters, base+"/"+strconv.Itoa(i))
+	}
+
+	// share a shard if none has been assigned
+	if len(filters) == 0 {
+		filters = append(filters, base+"/"+strconv.Itoa(consumer%shards))
+	}
+
+	return filters
+}
+
+func shardSubscriptions(base string, shards, consumer, consumers int, qos uint8) []packet.Subscription {
+	// prepare subscriptions
+	filters := ShardFilters(base, shards, consumer, consumers)
+	subscriptions := make([]packet.Subscription, 0, len(filters))
+	for _, filter := range filters {
+		subscriptions = append(subscriptions, packet.Subscription{
+			Topic: filter,
+			QOS:   qos,
+		})
+	}
+
+	return subscriptions
+}
+
+// ShardedPublish will publish the payload to the shard topic below base that
+// is assigned to the key. See ShardTopic for details.
+func (c *Client) ShardedPublish(base, key string, payload []byte, shards int, qos uint8, retain bool) (PublishFuture, error) {
+	return c.Publish(ShardTopic(base, key, shards), payload, qos, retain)
+}
+
+// ShardedSubscribe will subscribe the shard topics below base that are
+// assigned to the consumer. See ShardFilters for details.
+func (c *Client) ShardedSubscribe(base string, shards, consumer, consumers int, qos uint8) (SubscribeFuture, error) {
+	return c.SubscribeMultiple(shardSubscriptions(base, shards, consumer, consumers, qos))
+}
+
+// ShardedPublish will publish the payload to the shard topic below base that
+// is assigned to the key. See ShardTopic for details.
+func (s *Service) ShardedPublish(base, key string, payload []byte, shards int, qos uint8, retain bool) PublishFuture {
+	return s.Publish(ShardTopic(base, key, shards), payload, qos, retain)
+}
+
+// ShardedSubscribe will subscribe the shard topics below base that are
+// assigned to the consumer. See ShardFilters for details.
+func (s *Service) ShardedSubscribe(base string, shards, consumer, consumers int, qos uint8) SubscribeFuture {
+	return s.SubscribeMultiple(shardSubscriptions(base, shards, consumer, consumers, qos))
+}
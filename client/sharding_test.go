@@ -0,0 +1,81 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardTopic(t *testing.T) {
+	assert.Equal(t, ShardTopic("foo", "bar", 8), ShardTopic("foo", "bar", 8))
+	assert.Equal(t, "foo/0", ShardTopic("foo", "bar", 1))
+	assert.Equal(t, "foo/0", ShardTopic("foo", "bar", 0))
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		counts[ShardTopic("foo", strconv.Itoa(i), 4)]++
+	}
+	assert.Len(t, counts, 4)
+	for topic := range counts {
+		assert.Contains(t, []string{"foo/0", "foo/1", "foo/2", "foo/3"}, topic)
+	}
+}
+
+func TestShardFilters(t *testing.T) {
+	assert.Equal(t, []string{"foo/0", "foo/1", "foo/2", "foo/3"}, ShardFilters("foo", 4, 0, 1))
+	assert.Equal(t, []string{"foo/0", "foo/2"}, ShardFilters("foo", 4, 0, 2))
+	assert.Equal(t, []string{"foo/1", "foo/3"}, ShardFilters("foo", 4, 1, 2))
+	assert.Equal(t, []string{"foo/2"}, ShardFilters("foo", 3, 2, 3))
+	assert.Equal(t, []string{"foo/1"}, ShardFilters("foo", 2, 3, 4))
+	assert.Equal(t, []string{"foo/0"}, ShardFilters("foo", 0, 0, 0))
+}
+
+func TestClientShardedPublishSubscribe(t *testing.T) {
+	subscribe := packet.NewSubscribePacket()
+	subscribe.Subscriptions = []packet.Subscription{{Topic: "test/1", QOS: 1}, {Topic: "test/3", QOS: 1}}
+	subscribe.ID = 1
+
+	suback := packet.NewSubackPacket()
+	suback.ReturnCodes = []uint8{1, 1}
+	suback.ID = 1
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = ShardTopic("test", "key", 4)
+	publish.Message.Payload = []byte("test")
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe).
+		Send(suback).
+		Receive(publish).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	c := New()
+	c.Callback = errorCallback(t)
+
+	connectFuture, err := c.Connect(NewConfig("tcp://localhost:" + port))
+	assert.NoError(t, err)
+	assert.NoError(t, connectFuture.Wait(1*time.Second))
+
+	subscribeFuture, err := c.ShardedSubscribe("test", 4, 1, 2, 1)
+	assert.NoError(t, err)
+	assert.NoError(t, subscribeFuture.Wait(1*time.Second))
+	assert.Equal(t, []uint8{1, 1}, subscribeFuture.ReturnCodes())
+
+	publishFuture, err := c.ShardedPublish("test", "key", []byte("test"), 4, 0, false)
+	assert.NoError(t, err)
+	assert.NoError(t, publishFuture.Wait(1*time.Second))
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
@@ -10,6 +10,7 @@ type tracker struct {
 	sync.RWMutex
 
 	last    time.Time
+	ponged  time.Time
 	pings   uint8
 	timeout time.Duration
 }
@@ -38,6 +39,22 @@ func (t *tracker) window() time.Duration {
 	return t.timeout - time.Since(t.last)
 }
 
+// returns the keep alive timeout
+func (t *tracker) keepAlive() time.Duration {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.timeout
+}
+
+// changes the keep alive timeout
+func (t *tracker) setKeepAlive(timeout time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.timeout = timeout
+}
+
 // mark ping
 func (t *tracker) ping() {
 	t.Lock()
@@ -52,6 +69,15 @@ func (t *tracker) pong() {
 	defer t.Unlock()
 
 	t.pings--
+	t.ponged = time.Now()
+}
+
+// returns the time of the last pong
+func (t *tracker) lastPong() time.Time {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.ponged
 }
 
 // returns if pings are pending
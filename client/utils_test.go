@@ -1,16 +1,45 @@
 package client
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/256dpi/gomqtt/broker"
 	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/routines"
 	"github.com/256dpi/gomqtt/transport"
 	"github.com/256dpi/gomqtt/transport/flow"
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	routines.Enable()
+}
+
+var embedded struct {
+	url  string
+	once sync.Once
+}
+
+// returns the url of an in-process broker shared by examples and benchmarks
+func embeddedBroker() string {
+	embedded.once.Do(func() {
+		port, _, _ := broker.Run(broker.NewEngine(), "tcp")
+		embedded.url = "tcp://localhost:" + port
+	})
+
+	return embedded.url
+}
+
 func safeReceive(ch chan struct{}) {
 	select {
 	case <-time.After(1 * time.Minute):
@@ -19,6 +48,16 @@ func safeReceive(ch chan struct{}) {
 	}
 }
 
+// fails the test if tracked goroutines are still running after the timeout
+func verifyRoutines(t *testing.T, timeout time.Duration) {
+	t.Helper()
+
+	err := routines.Wait(timeout)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
 func errorCallback(t *testing.T) func(*packet.Message, error) error {
 	return func(msg *packet.Message, err error) error {
 		if err != nil {
@@ -31,11 +70,25 @@ func errorCallback(t *testing.T) func(*packet.Message, error) error {
 }
 
 func fakeBroker(t *testing.T, testFlows ...*flow.Flow) (chan struct{}, string) {
-	done := make(chan struct{})
-
 	server, err := transport.Launch("tcp://localhost:0")
 	assert.NoError(t, err)
 
+	return serveFlows(t, server, testFlows...)
+}
+
+func fakeSecureBroker(t *testing.T, config *tls.Config, testFlows ...*flow.Flow) (chan struct{}, string) {
+	launcher := transport.NewLauncher()
+	launcher.TLSConfig = config
+
+	server, err := launcher.Launch("mqtts://localhost:0?clientauth=require")
+	assert.NoError(t, err)
+
+	return serveFlows(t, server, testFlows...)
+}
+
+func serveFlows(t *testing.T, server transport.Server, testFlows ...*flow.Flow) (chan struct{}, string) {
+	done := make(chan struct{})
+
 	go func() {
 		for _, flow := range testFlows {
 			conn, err := server.Accept()
@@ -45,7 +98,7 @@ func fakeBroker(t *testing.T, testFlows ...*flow.Flow) (chan struct{}, string) {
 			assert.NoError(t, err)
 		}
 
-		err = server.Close()
+		err := server.Close()
 		assert.NoError(t, err)
 
 		close(done)
@@ -73,3 +126,38 @@ func connackPacket() *packet.ConnackPacket {
 func disconnectPacket() *packet.DisconnectPacket {
 	return packet.NewDisconnectPacket()
 }
+
+// returns a self-signed certificate for localhost and a pool containing it
+func generateCertificate() (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
@@ -0,0 +1,388 @@
+// Package cloudevents implements the CloudEvents 1.0 MQTT protocol binding.
+//
+// Message and Parse use the structured JSON content mode that works with all
+// protocol versions. Packet and ParsePacket use the binary content mode that
+// carries the attributes in MQTT 5 publish properties. As packet.Message does
+// not carry properties, the binary content mode requires direct access to the
+// publish packets.
+package cloudevents
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// SpecVersion is the supported CloudEvents specification version.
+const SpecVersion = "1.0"
+
+// ErrInvalidSpecVersion is returned by Parse if the event does not use the
+// supported specification version.
+var ErrInvalidSpecVersion = errors.New("invalid spec version")
+
+// ErrMissingAttribute is returned if a required attribute is missing.
+var ErrMissingAttribute = errors.New("missing attribute")
+
+// the attributes defined by the specification
+var attributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"source":          true,
+	"type":            true,
+	"subject":         true,
+	"time":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"data":            true,
+	"data_base64":     true,
+}
+
+// An Event is a CloudEvent.
+type Event struct {
+	// The required attributes.
+	ID     string
+	Source string
+	Type   string
+
+	// The optional attributes.
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+
+	// The event data. If the content type is JSON and the data is valid JSON,
+	// it is embedded directly. Otherwise, it is encoded using base64.
+	Data []byte
+
+	// Additional extension attributes.
+	Extensions map[string]interface{}
+}
+
+// Validate checks whether all required attributes are present.
+func (e *Event) Validate() error {
+	if e.ID == "" {
+		return fmt.Errorf("%w: id", ErrMissingAttribute)
+	} else if e.Source == "" {
+		return fmt.Errorf("%w: source", ErrMissingAttribute)
+	} else if e.Type == "" {
+		return fmt.Errorf("%w: type", ErrMissingAttribute)
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the event in the structured JSON format.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	// validate event
+	err := e.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare object
+	obj := make(map[string]interface{}, len(e.Extensions)+8)
+	for name, value := range e.Extensions {
+		if attributes[name] {
+			return nil, fmt.Errorf("extension %q collides with attribute", name)
+		}
+
+		obj[name] = value
+	}
+
+	// set attributes
+	obj["specversion"] = SpecVersion
+	obj["id"] = e.ID
+	obj["source"] = e.Source
+	obj["type"] = e.Type
+	setString(obj, "subject", e.Subject)
+	setString(obj, "datacontenttype", e.DataContentType)
+	setString(obj, "dataschema", e.DataSchema)
+	if !e.Time.IsZero() {
+		obj["time"] = e.Time.Format(time.RFC3339Nano)
+	}
+
+	// set data
+	if e.Data != nil {
+		if isJSON(e.DataContentType) && json.Valid(e.Data) {
+			obj["data"] = json.RawMessage(e.Data)
+		} else {
+			obj["data_base64"] = base64.StdEncoding.EncodeToString(e.Data)
+		}
+	}
+
+	return json.Marshal(obj)
+}
+
+// UnmarshalJSON decodes an event in the structured JSON format.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	// decode object
+	var obj map[string]json.RawMessage
+	err := json.Unmarshal(data, &obj)
+	if err != nil {
+		return err
+	}
+
+	// check spec version
+	var version string
+	err = getString(obj, "specversion", &version)
+	if err != nil {
+		return err
+	} else if version != SpecVersion {
+		return ErrInvalidSpecVersion
+	}
+
+	// reset event
+	*e = Event{}
+
+	// get attributes
+	for name, ptr := range map[string]*string{
+		"id":              &e.ID,
+		"source":          &e.Source,
+		"type":            &e.Type,
+		"subject":         &e.Subject,
+		"datacontenttype": &e.DataContentType,
+		"dataschema":      &e.DataSchema,
+	} {
+		err = getString(obj, name, ptr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// get time
+	var timestamp string
+	err = getString(obj, "time", &timestamp)
+	if err != nil {
+		return err
+	} else if timestamp != "" {
+		e.Time, err = time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return err
+		}
+	}
+
+	// get data
+	if raw, ok := obj["data"]; ok {
+		e.Data = []byte(raw)
+	} else if _, ok := obj["data_base64"]; ok {
+		var encoded string
+		err = getString(obj, "data_base64", &encoded)
+		if err != nil {
+			return err
+		}
+
+		e.Data, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return err
+		}
+	}
+
+	// get extensions
+	for name, raw := range obj {
+		if attributes[name] {
+			continue
+		}
+
+		var value interface{}
+		err = json.Unmarshal(raw, &value)
+		if err != nil {
+			return err
+		}
+
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]interface{})
+		}
+
+		e.Extensions[name] = value
+	}
+
+	return e.Validate()
+}
+
+// Message returns a message that carries the event in the structured JSON
+// format.
+func Message(topic string, event *Event, qos uint8, retain bool) (*packet.Message, error) {
+	// encode event
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &packet.Message{
+		Topic:   topic,
+		Payload: payload,
+		QOS:     qos,
+		Retain:  retain,
+	}, nil
+}
+
+// Parse decodes the event carried by a message in the structured JSON format.
+func Parse(msg *packet.Message) (*Event, error) {
+	// decode event
+	var event Event
+	err := json.Unmarshal(msg.Payload, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+// Packet returns a MQTT 5 publish packet that carries the event in the binary
+// content mode. The content type is transmitted as a ContentTypeProperty and
+// all other attributes and extensions as user properties.
+func Packet(topic string, event *Event, qos uint8, retain bool) (*packet.PublishPacket, error) {
+	// validate event
+	err := event.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare properties
+	var properties packet.Properties
+	add := func(name, value string) {
+		if value != "" {
+			properties = append(properties, packet.Property{
+				ID:    packet.UserProperty,
+				Value: packet.StringPair{Key: name, Value: value},
+			})
+		}
+	}
+
+	// set attributes
+	add("specversion", SpecVersion)
+	add("id", event.ID)
+	add("source", event.Source)
+	add("type", event.Type)
+	add("subject", event.Subject)
+	add("dataschema", event.DataSchema)
+	if !event.Time.IsZero() {
+		add("time", event.Time.Format(time.RFC3339Nano))
+	}
+
+	// set extensions in a stable order
+	names := make([]string, 0, len(event.Extensions))
+	for name := range event.Extensions {
+		if attributes[name] {
+			return nil, fmt.Errorf("extension %q collides with attribute", name)
+		}
+
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, fmt.Sprint(event.Extensions[name]))
+	}
+
+	// set content type
+	if event.DataContentType != "" {
+		properties = properties.Set(packet.ContentTypeProperty, event.DataContentType)
+	}
+
+	// prepare packet
+	pkt := packet.NewPublishPacket()
+	pkt.Message = packet.Message{
+		Topic:   topic,
+		Payload: event.Data,
+		QOS:     qos,
+		Retain:  retain,
+	}
+	pkt.Properties = properties
+
+	return pkt, nil
+}
+
+// ParsePacket decodes the event carried by a publish packet. Packets that do
+// not carry a "specversion" user property are decoded using the structured
+// JSON content mode. Extensions are returned as strings in the binary content
+// mode.
+func ParsePacket(pkt *packet.PublishPacket) (*Event, error) {
+	// collect user properties
+	values := map[string]string{}
+	for _, pair := range pkt.Properties.UserProperties() {
+		values[pair.Key] = pair.Value
+	}
+
+	// use structured mode if no spec version is present
+	version, ok := values["specversion"]
+	if !ok {
+		return Parse(&pkt.Message)
+	} else if version != SpecVersion {
+		return nil, ErrInvalidSpecVersion
+	}
+
+	// get attributes
+	event := &Event{
+		ID:         values["id"],
+		Source:     values["source"],
+		Type:       values["type"],
+		Subject:    values["subject"],
+		DataSchema: values["dataschema"],
+		Data:       pkt.Message.Payload,
+	}
+
+	// get time
+	if timestamp := values["time"]; timestamp != "" {
+		var err error
+		event.Time, err = time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// get content type
+	if value, ok := pkt.Properties.Get(packet.ContentTypeProperty); ok {
+		event.DataContentType, _ = value.(string)
+	}
+
+	// get extensions
+	for name, value := range values {
+		if attributes[name] {
+			continue
+		}
+
+		if event.Extensions == nil {
+			event.Extensions = make(map[string]interface{})
+		}
+
+		event.Extensions[name] = value
+	}
+
+	return event, event.Validate()
+}
+
+func setString(obj map[string]interface{}, name, value string) {
+	if value != "" {
+		obj[name] = value
+	}
+}
+
+func getString(obj map[string]json.RawMessage, name string, value *string) error {
+	// get raw value
+	raw, ok := obj[name]
+	if !ok {
+		return nil
+	}
+
+	// decode value
+	err := json.Unmarshal(raw, value)
+	if err != nil {
+		return fmt.Errorf("attribute %s: %w", name, err)
+	}
+
+	return nil
+}
+
+func isJSON(contentType string) bool {
+	// remove parameters
+	contentType = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+
+	return contentType == "" || contentType == "application/json" ||
+		contentType == "text/json" || strings.HasSuffix(contentType, "+json")
+}
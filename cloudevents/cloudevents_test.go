@@ -0,0 +1,152 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageAndParse(t *testing.T) {
+	event := &Event{
+		ID:              "1",
+		Source:          "/sensors/1",
+		Type:            "com.example.reading",
+		Subject:         "temperature",
+		Time:            time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		DataContentType: "application/json",
+		Data:            []byte(`{"value":21.5}`),
+		Extensions: map[string]interface{}{
+			"traceparent": "foo",
+		},
+	}
+
+	msg, err := Message("events", event, 1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "events", msg.Topic)
+	assert.Equal(t, uint8(1), msg.QOS)
+	assert.True(t, msg.Retain)
+
+	var obj map[string]interface{}
+	assert.NoError(t, json.Unmarshal(msg.Payload, &obj))
+	assert.Equal(t, map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              "1",
+		"source":          "/sensors/1",
+		"type":            "com.example.reading",
+		"subject":         "temperature",
+		"time":            "2020-01-02T03:04:05Z",
+		"datacontenttype": "application/json",
+		"data": map[string]interface{}{
+			"value": 21.5,
+		},
+		"traceparent": "foo",
+	}, obj)
+
+	parsed, err := Parse(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, event, parsed)
+}
+
+func TestBinaryData(t *testing.T) {
+	event := &Event{
+		ID:              "1",
+		Source:          "test",
+		Type:            "test",
+		DataContentType: "application/octet-stream",
+		Data:            []byte{0, 1, 2},
+	}
+
+	msg, err := Message("events", event, 0, false)
+	assert.NoError(t, err)
+	assert.Contains(t, string(msg.Payload), `"data_base64":"AAEC"`)
+
+	parsed, err := Parse(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, event, parsed)
+}
+
+func TestErrors(t *testing.T) {
+	_, err := Message("events", &Event{ID: "1", Type: "test"}, 0, false)
+	assert.True(t, errors.Is(err, ErrMissingAttribute))
+
+	_, err = Message("events", &Event{ID: "1", Source: "test", Type: "test", Extensions: map[string]interface{}{
+		"id": "2",
+	}}, 0, false)
+	assert.Error(t, err)
+
+	_, err = Parse(&packet.Message{Payload: []byte(`{"specversion":"0.3","id":"1","source":"test","type":"test"}`)})
+	assert.Equal(t, ErrInvalidSpecVersion, err)
+
+	_, err = Parse(&packet.Message{Payload: []byte(`{"specversion":"1.0","id":"1","type":"test"}`)})
+	assert.True(t, errors.Is(err, ErrMissingAttribute))
+
+	_, err = Parse(&packet.Message{Payload: []byte(`{"specversion":"1.0","id":1,"source":"test","type":"test"}`)})
+	assert.Error(t, err)
+
+	_, err = Parse(&packet.Message{Payload: []byte(`foo`)})
+	assert.Error(t, err)
+}
+
+func TestPacketAndParsePacket(t *testing.T) {
+	event := &Event{
+		ID:              "1",
+		Source:          "/sensors/1",
+		Type:            "com.example.reading",
+		Subject:         "temperature",
+		Time:            time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		DataContentType: "application/json",
+		Data:            []byte(`{"value":21.5}`),
+		Extensions: map[string]interface{}{
+			"traceparent": "foo",
+		},
+	}
+
+	pkt, err := Packet("events", event, 1, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "events", pkt.Message.Topic)
+	assert.Equal(t, []byte(`{"value":21.5}`), pkt.Message.Payload)
+	assert.Equal(t, uint8(1), pkt.Message.QOS)
+	assert.True(t, pkt.Message.Retain)
+	assert.Equal(t, []packet.StringPair{
+		{Key: "specversion", Value: "1.0"},
+		{Key: "id", Value: "1"},
+		{Key: "source", Value: "/sensors/1"},
+		{Key: "type", Value: "com.example.reading"},
+		{Key: "subject", Value: "temperature"},
+		{Key: "time", Value: "2020-01-02T03:04:05Z"},
+		{Key: "traceparent", Value: "foo"},
+	}, pkt.Properties.UserProperties())
+
+	contentType, ok := pkt.Properties.Get(packet.ContentTypeProperty)
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", contentType)
+
+	pkt.ID = 1
+	buf := make([]byte, pkt.LenVersion(packet.Version5))
+	_, err = pkt.EncodeVersion(buf, packet.Version5)
+	assert.NoError(t, err)
+
+	decoded := packet.NewPublishPacket()
+	_, err = decoded.DecodeVersion(buf, packet.Version5)
+	assert.NoError(t, err)
+
+	parsed, err := ParsePacket(decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, event, parsed)
+
+	msg, err := Message("events", event, 0, false)
+	assert.NoError(t, err)
+
+	parsed, err = ParsePacket(&packet.PublishPacket{Message: *msg})
+	assert.NoError(t, err)
+	assert.Equal(t, event, parsed)
+
+	_, err = ParsePacket(&packet.PublishPacket{Properties: packet.Properties{
+		{ID: packet.UserProperty, Value: packet.StringPair{Key: "specversion", Value: "0.3"}},
+	}})
+	assert.Equal(t, ErrInvalidSpecVersion, err)
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/exec"
 	"os/signal"
+	"strings"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -18,36 +23,219 @@ import (
 )
 
 var url = flag.String("url", "tcp://0.0.0.0:1883", "broker url")
+var certFile = flag.String("cert", "", "tls certificate file")
+var keyFile = flag.String("key", "", "tls key file")
+var aclFile = flag.String("acl", "", "file with lines of 'user password [namespace]'")
+var queueSize = flag.Int("queue", 0, "outgoing queue size per client")
+var shedLoad = flag.Bool("shed", false, "drop qos 0 messages if a clients queue is full")
+var auditFile = flag.String("audit", "", "file to append audit events to")
+var drainTimeout = flag.Duration("drain", 10*time.Second, "time given to clients to finish inflight messages on termination")
 
 func main() {
 	flag.Parse()
 
+	// prepare backend
+	backend := broker.NewMemoryBackend()
+
+	// prepare launcher
+	launcher := transport.NewLauncher()
+
+	// load certificate
+	var certificate *transport.CertificateReloader
+	if *certFile != "" {
+		var err error
+		certificate, err = transport.NewCertificateReloader(*certFile, *keyFile)
+		if err != nil {
+			panic(err)
+		}
+
+		launcher.TLSConfig = &tls.Config{GetCertificate: certificate.GetCertificate}
+	}
+
+	// prepare reload
+	reload := func() error {
+		// reload certificate
+		if certificate != nil {
+			err := certificate.Reload()
+			if err != nil {
+				return err
+			}
+		}
+
+		// reload acl
+		if *aclFile != "" {
+			credentials, namespaces, err := loadACL(*aclFile)
+			if err != nil {
+				return err
+			}
+
+			backend.Reload(credentials, namespaces)
+		}
+
+		return nil
+	}
+
+	// load initial acl
+	err := reload()
+	if err != nil {
+		panic(err)
+	}
+
+	http.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		err := reload()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Println("Reloaded!")
+	})
+
 	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
+		for {
+			// retry as a predecessor may still be running after a handoff
+			log.Println(http.ListenAndServe("localhost:6060", nil))
+			time.Sleep(time.Second)
+		}
 	}()
 
 	// start
 
-	fmt.Printf("Starting broker on URL %s... ", *url)
+	var servers []transport.Server
 
-	server, err := transport.Launch(*url)
+	// use activated sockets if available
+	listeners, names, err := transport.Activated()
 	if err != nil {
 		panic(err)
 	}
+	for i, listener := range listeners {
+		fmt.Printf("Using activated socket %s on %s\n", names[i], listener.Addr())
+
+		// enable tls if configured
+		if launcher.TLSConfig != nil {
+			listener = tls.NewListener(listener, launcher.TLSConfig)
+		}
+
+		servers = append(servers, transport.NewNetServerWithListener(listener))
+	}
+
+	// otherwise launch server
+	if len(servers) == 0 {
+		fmt.Printf("Starting broker on URL %s... ", *url)
+
+		var server transport.Server
+		if host, ok := strings.CutPrefix(*url, "tcp://"); ok {
+			// listen directly to allow a handoff
+			listener, err := net.Listen("tcp", host)
+			if err != nil {
+				panic(err)
+			}
+
+			listeners = append(listeners, listener)
+			names = append(names, "mqtt")
+			server = transport.NewNetServerWithListener(listener)
+		} else {
+			server, err = launcher.Launch(*url)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		servers = append(servers, server)
+
+		fmt.Println("Done!")
+	}
+
+	// prepare finish
+	finish := make(chan os.Signal, 1)
+	signal.Notify(finish, syscall.SIGINT, syscall.SIGTERM)
+
+	// hand off listeners to a new process e.g. after an upgrade
+	http.HandleFunc("/handoff", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// check listeners
+		if len(listeners) == 0 {
+			http.Error(w, "no listeners to hand off", http.StatusConflict)
+			return
+		}
+
+		// start successor with the same arguments
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := transport.Handoff(cmd, listeners, names)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Printf("Handed off to process %d!\n", cmd.Process.Pid)
+
+		// drain this process
+		select {
+		case finish <- syscall.SIGTERM:
+		default:
+		}
+	})
+
+	engine := broker.NewEngineWithBackend(backend)
+	engine.QueueSize = *queueSize
+	engine.ShedLoad = *shedLoad
+
+	// open audit log
+	if *auditFile != "" {
+		file, err := os.OpenFile(*auditFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			panic(err)
+		}
+
+		engine.Audit = broker.NewWriterAuditSink(file)
+	}
 
-	fmt.Println("Done!")
+	for _, server := range servers {
+		engine.Accept(server)
+	}
 
-	engine := broker.NewEngine()
-	engine.Accept(server)
+	metrics := broker.NewMetrics(engine)
+	metrics.Publish("broker")
+	http.Handle("/metrics", metrics)
+	http.Handle("/tap", broker.TapHandler(engine))
+
+	topics := broker.NewTopicStats(time.Minute)
+	http.Handle("/topics", topics)
+
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if engine.Draining() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
 
 	var published int32
 	var forwarded int32
+	var dropped int32
 
 	engine.Logger = func(event broker.LogEvent, client *broker.Client, pkt packet.GenericPacket, msg *packet.Message, err error) {
+		metrics.Log(event, client, pkt, msg, err)
+		topics.Log(event, client, pkt, msg, err)
+
 		if event == broker.MessagePublished {
 			atomic.AddInt32(&published, 1)
 		} else if event == broker.MessageForwarded {
 			atomic.AddInt32(&forwarded, 1)
+		} else if event == broker.MessageDropped {
+			atomic.AddInt32(&dropped, 1)
 		}
 	}
 
@@ -57,24 +245,79 @@ func main() {
 
 			pub := atomic.LoadInt32(&published)
 			fwd := atomic.LoadInt32(&forwarded)
-			fmt.Printf("Publish Rate: %d msg/s, Forward Rate: %d msg/s\n", pub, fwd)
+			drp := atomic.LoadInt32(&dropped)
+			fmt.Printf("Publish Rate: %d msg/s, Forward Rate: %d msg/s, Drop Rate: %d msg/s\n", pub, fwd, drp)
 
 			atomic.StoreInt32(&published, 0)
 			atomic.StoreInt32(&forwarded, 0)
+			atomic.StoreInt32(&dropped, 0)
 		}
 	}()
 
-	// finish
+	// reload
 
-	finish := make(chan os.Signal, 1)
-	signal.Notify(finish, syscall.SIGINT, syscall.SIGTERM)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			err := reload()
+			if err != nil {
+				fmt.Printf("Reload failed: %s\n", err)
+				continue
+			}
+
+			fmt.Println("Reloaded!")
+		}
+	}()
+
+	// finish
 
 	<-finish
 
-	server.Close()
+	fmt.Println("Draining...")
 
-	engine.Close()
-	engine.Wait(1 * time.Second)
+	if !engine.Drain(*drainTimeout) {
+		fmt.Println("Drain timed out!")
+	}
 
 	fmt.Println("Bye!")
 }
+
+func loadACL(path string) (map[string]string, map[string]string, error) {
+	// open file
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer file.Close()
+
+	// prepare maps
+	credentials := make(map[string]string)
+	namespaces := make(map[string]string)
+
+	// parse lines
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		// check fields
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, nil, fmt.Errorf("invalid acl line: %q", scanner.Text())
+		}
+
+		// set credentials
+		credentials[fields[0]] = fields[1]
+
+		// set namespace
+		if len(fields) == 3 {
+			namespaces[fields[0]] = fields[2]
+		}
+	}
+
+	return credentials, namespaces, scanner.Err()
+}
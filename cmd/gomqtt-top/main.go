@@ -0,0 +1,165 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+)
+
+var urlString = flag.String("url", "tcp://0.0.0.0:1883", "broker url")
+var filter = flag.String("filter", "#", "the subscribed topic filter")
+var qos = flag.Uint("qos", 0, "the qos level")
+var limit = flag.Int("top", 20, "the number of displayed topics")
+var interval = flag.Duration("interval", time.Second, "the refresh interval")
+
+type topicStats struct {
+	topic    string
+	messages int
+	bytes    int
+	total    int
+}
+
+var mutex sync.Mutex
+var topics = make(map[string]*topicStats)
+var messages int
+var bytes int
+var maxSize int
+var lastRender time.Time
+
+func main() {
+	flag.Parse()
+
+	cl := client.New()
+
+	cl.Callback = func(msg *packet.Message, err error) error {
+		if err != nil {
+			panic(err)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		// get stats
+		stats, ok := topics[msg.Topic]
+		if !ok {
+			stats = &topicStats{topic: msg.Topic}
+			topics[msg.Topic] = stats
+		}
+
+		// update stats
+		stats.messages++
+		stats.bytes += len(msg.Payload)
+		stats.total++
+		messages++
+		bytes += len(msg.Payload)
+		if len(msg.Payload) > maxSize {
+			maxSize = len(msg.Payload)
+		}
+
+		return nil
+	}
+
+	cf, err := cl.Connect(client.NewConfig(*urlString))
+	if err != nil {
+		panic(err)
+	}
+
+	err = cf.Wait(10 * time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	sf, err := cl.Subscribe(*filter, uint8(*qos))
+	if err != nil {
+		panic(err)
+	}
+
+	err = sf.Wait(10 * time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	finish := make(chan os.Signal, 1)
+	signal.Notify(finish, syscall.SIGINT, syscall.SIGTERM)
+
+	mutex.Lock()
+	lastRender = time.Now()
+	mutex.Unlock()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			render()
+		case <-finish:
+			err = cl.Disconnect()
+			if err != nil {
+				panic(err)
+			}
+
+			return
+		}
+	}
+}
+
+func render() {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// sort topics by messages in the last interval and total messages
+	list := make([]*topicStats, 0, len(topics))
+	for _, stats := range topics {
+		list = append(list, stats)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].messages != list[j].messages {
+			return list[i].messages > list[j].messages
+		}
+
+		return list[i].total > list[j].total
+	})
+
+	// calculate rates using the measured time as ticks may be delayed
+	now := time.Now()
+	seconds := now.Sub(lastRender).Seconds()
+	lastRender = now
+	avgSize := 0
+	if messages > 0 {
+		avgSize = bytes / messages
+	}
+
+	// clear screen and print summary
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf("gomqtt-top - %s - %s\n\n", *urlString, *filter)
+	fmt.Printf("Rate: %.1f msg/s, Throughput: %.1f B/s, Avg Size: %d B, Max Size: %d B, Topics: %d\n\n",
+		float64(messages)/seconds, float64(bytes)/seconds, avgSize, maxSize, len(topics))
+	fmt.Printf("%10s %12s %10s  %s\n", "MSG/S", "B/S", "TOTAL", "TOPIC")
+
+	// print most active topics
+	for i, stats := range list {
+		if i >= *limit {
+			break
+		}
+
+		fmt.Printf("%10.1f %12.1f %10d  %s\n", float64(stats.messages)/seconds,
+			float64(stats.bytes)/seconds, stats.total, stats.topic)
+	}
+
+	// reset interval counters
+	for _, stats := range topics {
+		stats.messages = 0
+		stats.bytes = 0
+	}
+	messages = 0
+	bytes = 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+)
+
+var urlString = flag.String("url", "tcp://0.0.0.0:1883", "broker url")
+var filter = flag.String("filter", "#", "the subscribed topic filter")
+var duration = flag.Duration("duration", 10*time.Second, "the sampling duration")
+var retained = flag.Bool("retained", false, "only collect retained messages")
+
+type node struct {
+	children map[string]*node
+	count    int
+	total    int
+}
+
+func newNode() *node {
+	return &node{
+		children: make(map[string]*node),
+	}
+}
+
+func (n *node) add(segments []string) {
+	// increment total
+	n.total++
+
+	// increment count if last segment
+	if len(segments) == 0 {
+		n.count++
+		return
+	}
+
+	// get child
+	child, ok := n.children[segments[0]]
+	if !ok {
+		child = newNode()
+		n.children[segments[0]] = child
+	}
+
+	child.add(segments[1:])
+}
+
+func (n *node) print(prefix string) {
+	// sort keys
+	keys := make([]string, 0, len(n.children))
+	for key := range n.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		child := n.children[key]
+
+		// select branch characters
+		branch, indent := "├── ", "│   "
+		if i == len(keys)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		// print node
+		if child.count > 0 {
+			fmt.Printf("%s%s%s (%d/%d)\n", prefix, branch, key, child.count, child.total)
+		} else {
+			fmt.Printf("%s%s%s (%d)\n", prefix, branch, key, child.total)
+		}
+
+		child.print(prefix + indent)
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	root := newNode()
+	var mutex sync.Mutex
+
+	cl := client.New()
+
+	cl.Callback = func(msg *packet.Message, err error) error {
+		if err != nil {
+			panic(err)
+		}
+
+		// skip live messages if requested
+		if *retained && !msg.Retain {
+			return nil
+		}
+
+		mutex.Lock()
+		root.add(strings.Split(msg.Topic, "/"))
+		mutex.Unlock()
+
+		return nil
+	}
+
+	cf, err := cl.Connect(client.NewConfig(*urlString))
+	if err != nil {
+		panic(err)
+	}
+
+	err = cf.Wait(10 * time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	sf, err := cl.Subscribe(*filter, 0)
+	if err != nil {
+		panic(err)
+	}
+
+	err = sf.Wait(10 * time.Second)
+	if err != nil {
+		panic(err)
+	}
+
+	time.Sleep(*duration)
+
+	err = cl.Disconnect()
+	if err != nil {
+		panic(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	fmt.Printf("%s (%d)\n", *filter, root.total)
+	root.print("")
+}
@@ -0,0 +1,139 @@
+package ota
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/router"
+)
+
+// A Distributor serves a firmware version to devices.
+type Distributor struct {
+	// The QOS level used to publish the manifest and chunks.
+	QOS uint8
+
+	// The callback that is called when a device acknowledged a chunk. The
+	// transfer has been completed once next equals the number of chunks.
+	ProgressCallback func(device string, next int)
+
+	// The callback that is called if a chunk could not be read.
+	ErrorCallback func(error)
+
+	router   *router.Router
+	base     string
+	manifest *Manifest
+	firmware io.ReaderAt
+
+	route    *router.Route
+	progress map[string]int
+	mutex    sync.Mutex
+}
+
+// NewDistributor will create and return a new distributor that serves the
+// firmware described by the manifest below the base topic.
+func NewDistributor(r *router.Router, base string, manifest *Manifest, firmware io.ReaderAt) *Distributor {
+	return &Distributor{
+		QOS:      1,
+		router:   r,
+		base:     base,
+		manifest: manifest,
+		firmware: firmware,
+		progress: make(map[string]int),
+	}
+}
+
+// Start will publish the manifest and start serving chunks.
+func (d *Distributor) Start() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// encode manifest
+	payload, err := json.Marshal(d.manifest)
+	if err != nil {
+		return err
+	}
+
+	// handle acks
+	d.route = router.HandleParams(d.router, d.base+"/ack/+", d.handleAck)
+
+	// publish manifest
+	d.router.Publish(d.base+"/manifest", payload, d.QOS, true)
+
+	return nil
+}
+
+// Stop will stop serving chunks and clear the retained manifest.
+func (d *Distributor) Stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// remove route
+	if d.route != nil {
+		d.router.Remove(d.route)
+		d.route = nil
+	}
+
+	// clear manifest
+	d.router.Publish(d.base+"/manifest", nil, d.QOS, true)
+}
+
+// Progress returns the index of the next chunk expected by the device and
+// whether the device has acknowledged any chunk yet.
+func (d *Distributor) Progress(device string) (int, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	next, ok := d.progress[device]
+	return next, ok
+}
+
+func (d *Distributor) handleAck(msg *packet.Message, params []string) error {
+	// decode ack and ignore invalid payloads
+	var ack Ack
+	err := json.Unmarshal(msg.Payload, &ack)
+	if err != nil {
+		return nil
+	}
+
+	// ignore other versions and invalid indexes
+	if ack.Version != d.manifest.Version || ack.Next < 0 || ack.Next > len(d.manifest.Chunks) {
+		return nil
+	}
+
+	// get device
+	device := params[0]
+
+	// record progress
+	d.mutex.Lock()
+	d.progress[device] = ack.Next
+	d.mutex.Unlock()
+
+	// call callback
+	if d.ProgressCallback != nil {
+		d.ProgressCallback(device, ack.Next)
+	}
+
+	// check completion
+	if ack.Next == len(d.manifest.Chunks) {
+		return nil
+	}
+
+	// read chunk
+	data := make([]byte, d.manifest.Len(ack.Next))
+	n, err := d.firmware.ReadAt(data, d.manifest.Offset(ack.Next))
+	if n < len(data) {
+		if d.ErrorCallback != nil {
+			d.ErrorCallback(fmt.Errorf("chunk %d: %w", ack.Next, err))
+		}
+
+		return nil
+	}
+
+	// publish chunk
+	d.router.Publish(d.base+"/chunk/"+device, encodeChunk(ack.Next, data), d.QOS, false)
+
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package ota implements chunked firmware distribution on top of the router.
+//
+// A Distributor publishes a retained Manifest that describes the firmware and
+// the checksums of its chunks. An Updater that receives a manifest for a new
+// version requests the chunks one at a time by acknowledging the next chunk it
+// expects. Every chunk is verified before it is stored and the whole firmware
+// is verified once all chunks have been received. Transfers can be resumed by
+// acknowledging the first chunk that has not yet been stored.
+//
+// The following topics below the base topic are used:
+//
+//	<base>/manifest        the retained manifest
+//	<base>/ack/<device>    the acknowledgments of a device
+//	<base>/chunk/<device>  the chunks sent to a device
+//
+// The router should subscribe with a QOS level of at least one to ensure that
+// no chunks or acknowledgments are lost.
+package ota
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrChecksumMismatch is returned if a chunk or the firmware does not match
+// the checksum of the manifest.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrInvalidChunk is returned if a chunk message could not be decoded.
+var ErrInvalidChunk = errors.New("invalid chunk")
+
+// A Manifest describes a firmware version.
+type Manifest struct {
+	// The version of the firmware.
+	Version string `json:"version"`
+
+	// The total size of the firmware.
+	Size int64 `json:"size"`
+
+	// The size of all chunks except the last.
+	ChunkSize int `json:"chunk_size"`
+
+	// The hex encoded SHA-256 checksum of the firmware.
+	Checksum string `json:"checksum"`
+
+	// The hex encoded SHA-256 checksums of the chunks.
+	Chunks []string `json:"chunks"`
+}
+
+// NewManifest will read the firmware and return a manifest for the specified
+// version that splits it into chunks of the specified size.
+func NewManifest(version string, firmware io.Reader, chunkSize int) (*Manifest, error) {
+	// check chunk size
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunk size %d invalid", chunkSize)
+	}
+
+	// prepare manifest
+	manifest := &Manifest{
+		Version:   version,
+		ChunkSize: chunkSize,
+	}
+
+	// hash chunks
+	total := sha256.New()
+	buf := make([]byte, chunkSize)
+	for {
+		// read chunk
+		n, err := io.ReadFull(firmware, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			manifest.Chunks = append(manifest.Chunks, hex.EncodeToString(sum[:]))
+			manifest.Size += int64(n)
+			total.Write(buf[:n])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	// set checksum
+	manifest.Checksum = hex.EncodeToString(total.Sum(nil))
+
+	return manifest, nil
+}
+
+// Offset returns the offset of the specified chunk.
+func (m *Manifest) Offset(index int) int64 {
+	return int64(index) * int64(m.ChunkSize)
+}
+
+// Len returns the length of the specified chunk.
+func (m *Manifest) Len(index int) int {
+	// get remaining size
+	remaining := m.Size - m.Offset(index)
+	if remaining < int64(m.ChunkSize) {
+		return int(remaining)
+	}
+
+	return m.ChunkSize
+}
+
+// Verify will verify the data of the specified chunk.
+func (m *Manifest) Verify(index int, data []byte) error {
+	// check index
+	if index < 0 || index >= len(m.Chunks) {
+		return fmt.Errorf("chunk %d: %w", index, ErrInvalidChunk)
+	}
+
+	// check checksum
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != m.Chunks[index] {
+		return fmt.Errorf("chunk %d: %w", index, ErrChecksumMismatch)
+	}
+
+	return nil
+}
+
+// VerifyFirmware will read the firmware and verify it against the checksum.
+func (m *Manifest) VerifyFirmware(firmware io.ReaderAt) error {
+	// hash firmware
+	hash := sha256.New()
+	_, err := io.Copy(hash, io.NewSectionReader(firmware, 0, m.Size))
+	if err != nil {
+		return err
+	}
+
+	// check checksum
+	if hex.EncodeToString(hash.Sum(nil)) != m.Checksum {
+		return fmt.Errorf("firmware: %w", ErrChecksumMismatch)
+	}
+
+	return nil
+}
+
+// An Ack is published by a device to request the next chunk of a version.
+type Ack struct {
+	// The version that is transferred.
+	Version string `json:"version"`
+
+	// The index of the next expected chunk. It equals the number of chunks
+	// once the transfer has been completed.
+	Next int `json:"next"`
+}
+
+// encodeChunk prefixes the data with the chunk index
+func encodeChunk(index int, data []byte) []byte {
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(index))
+	copy(buf[4:], data)
+	return buf
+}
+
+// decodeChunk returns the chunk index and data
+func decodeChunk(payload []byte) (int, []byte, error) {
+	// check length
+	if len(payload) < 4 {
+		return 0, nil, ErrInvalidChunk
+	}
+
+	return int(binary.BigEndian.Uint32(payload)), payload[4:], nil
+}
@@ -0,0 +1,183 @@
+package ota
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/router"
+	"github.com/256dpi/gomqtt/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryTarget struct {
+	data  []byte
+	mutex sync.Mutex
+}
+
+func (t *memoryTarget) ReadAt(p []byte, off int64) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return bytes.NewReader(t.data).ReadAt(p, off)
+}
+
+func (t *memoryTarget) WriteAt(p []byte, off int64) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if end := int(off) + len(p); end > len(t.data) {
+		t.data = append(t.data, make([]byte, end-len(t.data))...)
+	}
+
+	return copy(t.data[off:], p), nil
+}
+
+func firmware() []byte {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	return data
+}
+
+func startRouter(t *testing.T, url string) *router.Router {
+	r := router.New(client.NewService())
+	r.SubscribeQOS = 1
+	r.Start(client.NewConfig(url))
+
+	t.Cleanup(func() {
+		r.Stop(true)
+	})
+
+	return r
+}
+
+func safeReceive(ch chan struct{}) {
+	select {
+	case <-time.After(10 * time.Second):
+		panic("nothing received")
+	case <-ch:
+	}
+}
+
+func TestManifest(t *testing.T) {
+	data := firmware()
+
+	manifest, err := NewManifest("1.0.0", bytes.NewReader(data), 4096)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", manifest.Version)
+	assert.Equal(t, int64(10000), manifest.Size)
+	assert.Len(t, manifest.Chunks, 3)
+	assert.Equal(t, int64(8192), manifest.Offset(2))
+	assert.Equal(t, 4096, manifest.Len(0))
+	assert.Equal(t, 1808, manifest.Len(2))
+
+	assert.NoError(t, manifest.Verify(2, data[8192:]))
+	assert.True(t, errors.Is(manifest.Verify(1, data[8192:]), ErrChecksumMismatch))
+	assert.True(t, errors.Is(manifest.Verify(3, nil), ErrInvalidChunk))
+
+	assert.NoError(t, manifest.VerifyFirmware(bytes.NewReader(data)))
+	data[0] = 1
+	assert.True(t, errors.Is(manifest.VerifyFirmware(bytes.NewReader(data)), ErrChecksumMismatch))
+
+	_, err = NewManifest("1.0.0", bytes.NewReader(data), 0)
+	assert.Error(t, err)
+}
+
+func TestChunk(t *testing.T) {
+	index, data, err := decodeChunk(encodeChunk(7, []byte("foo")))
+	assert.NoError(t, err)
+	assert.Equal(t, 7, index)
+	assert.Equal(t, []byte("foo"), data)
+
+	_, _, err = decodeChunk([]byte{1})
+	assert.Equal(t, ErrInvalidChunk, err)
+}
+
+func TestTransfer(t *testing.T) {
+	url := testutil.Embedded(t)
+	data := firmware()
+
+	manifest, err := NewManifest("1.0.0", bytes.NewReader(data), 1024)
+	require.NoError(t, err)
+
+	distributed := make(chan struct{})
+
+	distributor := NewDistributor(startRouter(t, url), "firmware", manifest, bytes.NewReader(data))
+	distributor.ProgressCallback = func(device string, next int) {
+		assert.Equal(t, "device", device)
+		if next == len(manifest.Chunks) {
+			close(distributed)
+		}
+	}
+	require.NoError(t, distributor.Start())
+
+	completed := make(chan struct{})
+	var progress []int
+
+	target := &memoryTarget{}
+	updater := NewUpdater(startRouter(t, url), "firmware", "device", target)
+	updater.ProgressCallback = func(m *Manifest, next int) {
+		progress = append(progress, next)
+	}
+	updater.CompleteCallback = func(m *Manifest, err error) {
+		assert.NoError(t, err)
+		assert.Equal(t, "1.0.0", m.Version)
+		close(completed)
+	}
+	updater.Start()
+
+	safeReceive(completed)
+	safeReceive(distributed)
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, progress)
+	assert.Equal(t, data, target.data)
+
+	next, ok := distributor.Progress("device")
+	assert.True(t, ok)
+	assert.Equal(t, 10, next)
+
+	m, next := updater.Manifest()
+	assert.Equal(t, manifest, m)
+	assert.Equal(t, 10, next)
+
+	updater.Stop()
+	distributor.Stop()
+}
+
+func TestTransferResume(t *testing.T) {
+	url := testutil.Embedded(t)
+	data := firmware()
+
+	manifest, err := NewManifest("1.0.0", bytes.NewReader(data), 4096)
+	require.NoError(t, err)
+
+	distributor := NewDistributor(startRouter(t, url), "firmware", manifest, bytes.NewReader(data))
+	require.NoError(t, distributor.Start())
+
+	completed := make(chan struct{})
+	var progress []int
+
+	target := &memoryTarget{data: append([]byte{}, data[:8192]...)}
+	updater := NewUpdater(startRouter(t, url), "firmware", "device", target)
+	updater.Resume("1.0.0", 2)
+	updater.ProgressCallback = func(m *Manifest, next int) {
+		progress = append(progress, next)
+	}
+	updater.CompleteCallback = func(m *Manifest, err error) {
+		assert.NoError(t, err)
+		close(completed)
+	}
+	updater.Start()
+
+	safeReceive(completed)
+
+	assert.Equal(t, []int{3}, progress)
+	assert.Equal(t, data, target.data)
+}
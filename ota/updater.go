@@ -0,0 +1,216 @@
+package ota
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/router"
+)
+
+// A Target stores the received firmware. Chunks are written at their offset
+// and the firmware is read back for verification.
+type Target interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// An Updater receives new firmware versions on a device.
+type Updater struct {
+	// The currently installed version. Manifests of this version are ignored.
+	Current string
+
+	// The QOS level used to publish acknowledgments.
+	QOS uint8
+
+	// The callback that is called after a chunk has been stored. The progress
+	// can be persisted to resume the transfer after a restart.
+	ProgressCallback func(manifest *Manifest, next int)
+
+	// The callback that is called once all chunks have been received with an
+	// error if the firmware could not be stored or verified.
+	CompleteCallback func(manifest *Manifest, err error)
+
+	router *router.Router
+	base   string
+	device string
+	target Target
+
+	resumeVersion string
+	resumeNext    int
+
+	manifest *Manifest
+	next     int
+
+	routes []*router.Route
+	mutex  sync.Mutex
+}
+
+// NewUpdater will create and return a new updater that receives firmware
+// below the base topic for the specified device.
+func NewUpdater(r *router.Router, base, device string, target Target) *Updater {
+	return &Updater{
+		QOS:    1,
+		router: r,
+		base:   base,
+		device: device,
+		target: target,
+	}
+}
+
+// Resume will continue an interrupted transfer of the specified version with
+// the next chunk that has not yet been stored. It must be called before Start.
+func (u *Updater) Resume(version string, next int) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.resumeVersion = version
+	u.resumeNext = next
+}
+
+// Start will start receiving manifests and chunks.
+func (u *Updater) Start() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	u.routes = []*router.Route{
+		u.router.Handle(u.base+"/chunk/"+u.device, u.handleChunk),
+		u.router.Handle(u.base+"/manifest", u.handleManifest),
+	}
+}
+
+// Stop will stop receiving manifests and chunks.
+func (u *Updater) Stop() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	for _, route := range u.routes {
+		u.router.Remove(route)
+	}
+
+	u.routes = nil
+}
+
+// Manifest returns the manifest of the current transfer and the index of the
+// next expected chunk.
+func (u *Updater) Manifest() (*Manifest, int) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return u.manifest, u.next
+}
+
+func (u *Updater) handleManifest(msg *packet.Message) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	// decode manifest and ignore cleared or invalid manifests
+	var manifest Manifest
+	err := json.Unmarshal(msg.Payload, &manifest)
+	if err != nil || manifest.ChunkSize <= 0 {
+		return nil
+	}
+
+	// ignore installed and already transferred versions
+	if manifest.Version == u.Current || (u.manifest != nil && u.manifest.Version == manifest.Version) {
+		return nil
+	}
+
+	// set manifest
+	u.manifest = &manifest
+	u.next = 0
+
+	// resume transfer
+	if u.resumeVersion == manifest.Version && u.resumeNext > 0 && u.resumeNext <= len(manifest.Chunks) {
+		u.next = u.resumeNext
+	}
+
+	// complete resumed transfer
+	if u.next == len(manifest.Chunks) {
+		u.complete()
+	}
+
+	// request next chunk
+	u.ack()
+
+	return nil
+}
+
+func (u *Updater) handleChunk(msg *packet.Message) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	// check manifest
+	if u.manifest == nil {
+		return nil
+	}
+
+	// decode chunk
+	index, data, err := decodeChunk(msg.Payload)
+	if err != nil {
+		return nil
+	}
+
+	// ignore duplicate and unexpected chunks
+	if index != u.next {
+		return nil
+	}
+
+	// verify chunk and request it again if corrupted
+	err = u.manifest.Verify(index, data)
+	if err != nil {
+		u.ack()
+		return nil
+	}
+
+	// store chunk and abort transfer on errors
+	_, err = u.target.WriteAt(data, u.manifest.Offset(index))
+	if err != nil {
+		u.next = len(u.manifest.Chunks)
+		if u.CompleteCallback != nil {
+			u.CompleteCallback(u.manifest, err)
+		}
+
+		return nil
+	}
+
+	// increment
+	u.next++
+
+	// call callback
+	if u.ProgressCallback != nil {
+		u.ProgressCallback(u.manifest, u.next)
+	}
+
+	// verify firmware if complete
+	if u.next == len(u.manifest.Chunks) {
+		u.complete()
+	}
+
+	// request next chunk or acknowledge completion
+	u.ack()
+
+	return nil
+}
+
+func (u *Updater) complete() {
+	// verify firmware
+	err := u.manifest.VerifyFirmware(u.target)
+
+	// call callback
+	if u.CompleteCallback != nil {
+		u.CompleteCallback(u.manifest, err)
+	}
+}
+
+func (u *Updater) ack() {
+	// encode ack
+	payload, _ := json.Marshal(Ack{
+		Version: u.manifest.Version,
+		Next:    u.next,
+	})
+
+	// publish ack
+	u.router.Publish(u.base+"/ack/"+u.device, payload, u.QOS, false)
+}
@@ -0,0 +1,80 @@
+package packet
+
+import "fmt"
+
+// An AuthPacket is sent from the client to the server or from the server to
+// the client as part of an extended authentication exchange. It is only
+// available with MQTT 5.
+type AuthPacket struct {
+	// The reason code.
+	ReasonCode ReasonCode
+
+	// The properties. The authentication method and data are transmitted as
+	// AuthMethodProperty and AuthDataProperty.
+	Properties Properties
+}
+
+// NewAuthPacket creates a new AuthPacket.
+func NewAuthPacket() *AuthPacket {
+	return &AuthPacket{}
+}
+
+// Type returns the packets type.
+func (ap *AuthPacket) Type() Type {
+	return AUTH
+}
+
+// String returns a string representation of the packet.
+func (ap *AuthPacket) String() string {
+	return fmt.Sprintf("<AuthPacket ReasonCode=%d Properties=%s>",
+		ap.ReasonCode, ap.Properties.String())
+}
+
+// Len returns the byte length of the encoded packet.
+func (ap *AuthPacket) Len() int {
+	return ap.LenVersion(Version5)
+}
+
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (ap *AuthPacket) LenVersion(version byte) int {
+	ml := reasonPacketLen(ap.ReasonCode, ap.Properties)
+	return headerLen(ml) + ml
+}
+
+// Decode reads from the byte slice argument. It returns the total number of
+// bytes decoded, and whether there have been any errors during the process.
+func (ap *AuthPacket) Decode(src []byte) (int, error) {
+	return ap.DecodeVersion(src, Version5)
+}
+
+// DecodeVersion decodes the packet using the specified protocol level. An
+// error is returned if the protocol level is not 5.
+func (ap *AuthPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	// check version
+	if version != Version5 {
+		return 0, decodeError(ap.Type(), "", 0, "unsupported protocol version %d", version)
+	}
+
+	n, rc, props, err := reasonPacketDecode(src, AUTH)
+	ap.ReasonCode, ap.Properties = rc, props
+	return n, err
+}
+
+// Encode writes the packet bytes into the byte slice from the argument. It
+// returns the number of bytes encoded and whether there's any errors along
+// the way. If there is an error, the byte slice should be considered invalid.
+func (ap *AuthPacket) Encode(dst []byte) (int, error) {
+	return ap.EncodeVersion(dst, Version5)
+}
+
+// EncodeVersion encodes the packet using the specified protocol level. An
+// error is returned if the protocol level is not 5.
+func (ap *AuthPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	// check version
+	if version != Version5 {
+		return 0, fmt.Errorf("[%s] unsupported protocol version %d", ap.Type(), version)
+	}
+
+	return reasonPacketEncode(dst, ap.ReasonCode, ap.Properties, AUTH)
+}
@@ -0,0 +1,48 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthInterface(t *testing.T) {
+	pkt := NewAuthPacket()
+
+	assert.Equal(t, pkt.Type(), AUTH)
+	assert.Equal(t, "<AuthPacket ReasonCode=0 Properties=[]>", pkt.String())
+}
+
+func TestAuthPacketEncodeDecode(t *testing.T) {
+	pkt := NewAuthPacket()
+	assert.Equal(t, []byte{byte(AUTH << 4), 0}, roundTrip(t, pkt, NewAuthPacket(), Version5))
+
+	pkt.ReasonCode = ContinueAuthentication
+	pkt.Properties = Properties{
+		{ID: AuthMethodProperty, Value: "SCRAM-SHA-1"},
+		{ID: AuthDataProperty, Value: []byte("data")},
+	}
+
+	out := NewAuthPacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+
+	n, err := out.Decode([]byte{byte(AUTH << 4), 1, byte(ReAuthenticate)})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, ReAuthenticate, out.ReasonCode)
+	assert.Nil(t, out.Properties)
+}
+
+func TestAuthPacketVersionError(t *testing.T) {
+	pkt := NewAuthPacket()
+
+	_, err := pkt.EncodeVersion(make([]byte, 2), Version311)
+	assert.Error(t, err)
+
+	_, err = pkt.DecodeVersion([]byte{byte(AUTH << 4), 0}, Version311)
+	assert.Error(t, err)
+
+	_, err = pkt.Decode([]byte{byte(AUTH << 4), 1, 0x05})
+	assert.Error(t, err)
+}
@@ -0,0 +1,122 @@
+package packet
+
+import (
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer is the capacity up to which buffers are returned to the
+// pool. Larger buffers are left to the garbage collector to not pin memory.
+const maxPooledBuffer = 64 * 1024
+
+// inlinePayload is the payload length up to which publish packets are written
+// with a single write. Larger payloads are written directly after the header to
+// avoid copying them.
+const inlinePayload = 4 * 1024
+
+// A BufferPool manages reusable byte buffers for encoding packets.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// NewBufferPool creates a new BufferPool.
+func NewBufferPool() *BufferPool {
+	return &BufferPool{}
+}
+
+// Get will return a buffer with the specified length. The buffer must be
+// returned using Put once it is not used anymore.
+func (p *BufferPool) Get(size int) *[]byte {
+	// get pooled buffer
+	buf, _ := p.pool.Get().(*[]byte)
+	if buf == nil {
+		buf = new([]byte)
+	}
+
+	// grow buffer if too small
+	if cap(*buf) < size {
+		*buf = make([]byte, size)
+	}
+
+	// set length
+	*buf = (*buf)[:size]
+
+	return buf
+}
+
+// Put will return the buffer to the pool. Buffers larger than 64 KiB are
+// dropped.
+func (p *BufferPool) Put(buf *[]byte) {
+	if cap(*buf) > maxPooledBuffer {
+		return
+	}
+
+	p.pool.Put(buf)
+}
+
+var buffers = NewBufferPool()
+
+// EncodeTo encodes the packet using the specified protocol level and writes it
+// to the writer. The packet is encoded into a pooled buffer to not allocate a
+// buffer per packet. It returns the number of bytes written.
+func EncodeTo(w io.Writer, pkt GenericPacket, version byte) (int, error) {
+	// use publish specific encoding
+	if pp, ok := pkt.(*PublishPacket); ok {
+		return pp.EncodeTo(w, version)
+	}
+
+	// get buffer
+	buf := buffers.Get(Len(pkt, version))
+	defer buffers.Put(buf)
+
+	// encode packet
+	n, err := Encode(pkt, *buf, version)
+	if err != nil {
+		return 0, err
+	}
+
+	return w.Write((*buf)[:n])
+}
+
+// EncodeTo encodes the packet using the specified protocol level and writes it
+// to the writer. The header is encoded into a pooled buffer and large payloads
+// are written directly to the writer without being copied. It returns the
+// number of bytes written.
+func (pp *PublishPacket) EncodeTo(w io.Writer, version byte) (int, error) {
+	// encode small packets using a single write
+	if len(pp.Message.Payload) <= inlinePayload {
+		// get buffer
+		buf := buffers.Get(pp.LenVersion(version))
+		defer buffers.Put(buf)
+
+		// encode packet
+		n, err := pp.EncodeVersion(*buf, version)
+		if err != nil {
+			return 0, err
+		}
+
+		return w.Write((*buf)[:n])
+	}
+
+	// get buffer
+	payloadLen := len(pp.Message.Payload)
+	buf := buffers.Get(pp.HeaderLen(payloadLen, version))
+	defer buffers.Put(buf)
+
+	// encode header
+	n, err := pp.EncodeHeader(*buf, payloadLen, version)
+	if err != nil {
+		return 0, err
+	}
+
+	// write header
+	hn, err := w.Write((*buf)[:n])
+	if err != nil {
+		return hn, err
+	}
+
+	// write payload
+	pn, err := w.Write(pp.Message.Payload)
+
+	return hn + pn, err
+}
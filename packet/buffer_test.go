@@ -0,0 +1,127 @@
+package packet
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferPool(t *testing.T) {
+	pool := NewBufferPool()
+
+	buf := pool.Get(10)
+	assert.Len(t, *buf, 10)
+	pool.Put(buf)
+
+	buf = pool.Get(5)
+	assert.Len(t, *buf, 5)
+	pool.Put(buf)
+
+	buf = pool.Get(maxPooledBuffer + 1)
+	assert.Len(t, *buf, maxPooledBuffer+1)
+	pool.Put(buf)
+}
+
+func TestEncodeTo(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		for _, pkt := range []GenericPacket{
+			NewPingreqPacket(),
+			&SubscribePacket{
+				ID: 1,
+				Subscriptions: []Subscription{
+					{Topic: "foo", QOS: QOSAtLeastOnce},
+				},
+			},
+			&PublishPacket{
+				ID: 1,
+				Message: Message{
+					Topic:   "foo",
+					Payload: []byte("bar"),
+					QOS:     QOSAtLeastOnce,
+				},
+			},
+			&PublishPacket{
+				Message: Message{
+					Topic:   "foo",
+					Payload: make([]byte, inlinePayload+1),
+				},
+			},
+		} {
+			expected := make([]byte, Len(pkt, version))
+			_, err := Encode(pkt, expected, version)
+			assert.NoError(t, err)
+
+			var buf bytes.Buffer
+			n, err := EncodeTo(&buf, pkt, version)
+			assert.NoError(t, err)
+			assert.Equal(t, len(expected), n)
+			assert.Equal(t, expected, buf.Bytes())
+		}
+	}
+}
+
+func TestEncodeToError(t *testing.T) {
+	pkt := NewPublishPacket()
+
+	var buf bytes.Buffer
+	n, err := EncodeTo(&buf, pkt, Version311)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.Zero(t, buf.Len())
+
+	pkt.Message.Payload = make([]byte, inlinePayload+1)
+	n, err = EncodeTo(&buf, pkt, Version311)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+	assert.Zero(t, buf.Len())
+}
+
+func BenchmarkEncodeTo(b *testing.B) {
+	pkt := NewPingreqPacket()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := EncodeTo(io.Discard, pkt, Version311)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkPublishEncodeTo(b *testing.B) {
+	for _, size := range []int{16, 1024, 64 * 1024, 1024 * 1024} {
+		pkt := NewPublishPacket()
+		pkt.Message.Topic = "t"
+		pkt.Message.QOS = QOSAtLeastOnce
+		pkt.ID = 1
+		pkt.Message.Payload = make([]byte, size)
+
+		b.Run(byteSize(size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(pkt.Len()))
+
+			for i := 0; i < b.N; i++ {
+				_, err := pkt.EncodeTo(io.Discard, Version311)
+				if err != nil {
+					panic(err)
+				}
+			}
+		})
+	}
+}
+
+func byteSize(size int) string {
+	switch {
+	case size >= 1024*1024:
+		return strconv.Itoa(size/1024/1024) + "MiB"
+	case size >= 1024:
+		return strconv.Itoa(size/1024) + "KiB"
+	default:
+		return strconv.Itoa(size) + "B"
+	}
+}
@@ -52,6 +52,14 @@ type ConnackPacket struct {
 	// is unable to process it for some reason, then the server should attempt
 	// to send a ConnackPacket containing a non-zero ReturnCode.
 	ReturnCode ConnackCode
+
+	// The ReasonCode is transmitted instead of the ReturnCode when using MQTT
+	// 5. If it is not set, the reason code that matches the ReturnCode is
+	// transmitted. When decoded, the ReturnCode is set to the closest match.
+	ReasonCode ReasonCode
+
+	// The connack properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 // NewConnackPacket creates a new ConnackPacket.
@@ -72,12 +80,29 @@ func (cp *ConnackPacket) String() string {
 
 // Len returns the byte length of the encoded packet.
 func (cp *ConnackPacket) Len() int {
-	return headerLen(2) + 2
+	return cp.LenVersion(Version311)
+}
+
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (cp *ConnackPacket) LenVersion(version byte) int {
+	ml := cp.len(version)
+	return headerLen(ml) + ml
 }
 
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (cp *ConnackPacket) Decode(src []byte) (int, error) {
+	return cp.DecodeVersion(src, Version311)
+}
+
+// DecodeVersion decodes the packet using the specified protocol level.
+func (cp *ConnackPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	// decode MQTT 5 packets
+	if version == Version5 {
+		return cp.decode5(src)
+	}
+
 	total := 0
 
 	// decode header
@@ -89,7 +114,7 @@ func (cp *ConnackPacket) Decode(src []byte) (int, error) {
 
 	// check remaining length
 	if rl != 2 {
-		return total, fmt.Errorf("[%s] expected remaining length to be 2", cp.Type())
+		return total, decodeError(cp.Type(), "remaining length", 1, "expected remaining length to be 2")
 	}
 
 	// read connack flags
@@ -99,7 +124,7 @@ func (cp *ConnackPacket) Decode(src []byte) (int, error) {
 
 	// check flags
 	if connackFlags&254 != 0 {
-		return 0, fmt.Errorf("[%s] bits 7-1 in acknowledge flags are not 0", cp.Type())
+		return 0, decodeError(cp.Type(), "acknowledge flags", total-1, "bits 7-1 in acknowledge flags are not 0")
 	}
 
 	// read return code
@@ -108,7 +133,7 @@ func (cp *ConnackPacket) Decode(src []byte) (int, error) {
 
 	// check return code
 	if !cp.ReturnCode.Valid() {
-		return 0, fmt.Errorf("[%s] invalid return code (%d)", cp.Type(), cp.ReturnCode)
+		return 0, decodeError(cp.Type(), "return code", total-1, "invalid return code (%d)", cp.ReturnCode)
 	}
 
 	return total, nil
@@ -118,6 +143,16 @@ func (cp *ConnackPacket) Decode(src []byte) (int, error) {
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
 func (cp *ConnackPacket) Encode(dst []byte) (int, error) {
+	return cp.EncodeVersion(dst, Version311)
+}
+
+// EncodeVersion encodes the packet using the specified protocol level.
+func (cp *ConnackPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	// encode MQTT 5 packets
+	if version == Version5 {
+		return cp.encode5(dst)
+	}
+
 	total := 0
 
 	// encode header
@@ -146,3 +181,126 @@ func (cp *ConnackPacket) Encode(dst []byte) (int, error) {
 
 	return total, nil
 }
+
+func (cp *ConnackPacket) decode5(src []byte) (int, error) {
+	total := 0
+
+	// decode header
+	hl, _, rl, err := headerDecode(src, CONNACK)
+	total += hl
+	if err != nil {
+		return total, err
+	}
+
+	// check remaining length
+	if rl < 2 {
+		return total, decodeError(cp.Type(), "remaining length", 1, "expected remaining length to be at least 2")
+	}
+
+	// read connack flags
+	connackFlags := src[total]
+	cp.SessionPresent = connackFlags&0x1 == 1
+	total++
+
+	// check flags
+	if connackFlags&254 != 0 {
+		return total, decodeError(cp.Type(), "acknowledge flags", total-1, "bits 7-1 in acknowledge flags are not 0")
+	}
+
+	// a server that does not support MQTT 5 answers with a MQTT 3.1.1 connack
+	// that carries a return code and no properties
+	if rl == 2 {
+		cp.ReturnCode = ConnackCode(src[total])
+		total++
+
+		// check return code
+		if !cp.ReturnCode.Valid() {
+			return total, decodeError(cp.Type(), "return code", total-1, "invalid return code (%d)", cp.ReturnCode)
+		}
+
+		cp.ReasonCode = connackReasons[cp.ReturnCode]
+		cp.Properties = nil
+
+		return total, nil
+	}
+
+	// read reason code
+	cp.ReasonCode = ReasonCode(src[total])
+	cp.ReturnCode = connackCode(cp.ReasonCode)
+	total++
+
+	// check reason code
+	if !cp.ReasonCode.Valid() {
+		return total, decodeError(cp.Type(), "reason code", total-1, "invalid reason code (%d)", cp.ReasonCode)
+	}
+
+	// read properties
+	var n int
+	cp.Properties, n, err = readProperties(src[total:hl+rl], cp.Type())
+	total += n
+	if err != nil {
+		return total, shiftError(err, total-n, "properties")
+	}
+
+	return total, nil
+}
+
+func (cp *ConnackPacket) encode5(dst []byte) (int, error) {
+	total := 0
+
+	// encode header
+	n, err := headerEncode(dst[total:], 0, cp.len(Version5), cp.LenVersion(Version5), CONNACK)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	// set session present flag
+	if cp.SessionPresent {
+		dst[total] = 1 // 00000001
+	} else {
+		dst[total] = 0 // 00000000
+	}
+	total++
+
+	// check return code
+	if !cp.ReturnCode.Valid() {
+		return total, fmt.Errorf("[%s] invalid return code (%d)", cp.Type(), cp.ReturnCode)
+	}
+
+	// get reason code
+	reasonCode := cp.ReasonCode
+	if reasonCode == Success {
+		reasonCode = connackReasons[cp.ReturnCode]
+	}
+
+	// check reason code
+	if !reasonCode.Valid() {
+		return total, fmt.Errorf("[%s] invalid reason code (%d)", cp.Type(), reasonCode)
+	}
+
+	// set reason code
+	dst[total] = byte(reasonCode)
+	total++
+
+	// write properties
+	n, err = writeProperties(dst[total:], cp.Properties, cp.Type())
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	return total, nil
+}
+
+// Returns the payload length.
+func (cp *ConnackPacket) len(version byte) int {
+	// 1 byte flags
+	// 1 byte return or reason code
+	total := 2
+
+	// add the properties length
+	total += versionPropertiesLen(cp.Properties, version)
+
+	return total
+}
@@ -211,3 +211,47 @@ func BenchmarkConnackDecode(b *testing.B) {
 		}
 	}
 }
+
+func TestConnackPacketEncodeDecode5(t *testing.T) {
+	pkt := NewConnackPacket()
+	pkt.SessionPresent = true
+	pkt.ReturnCode = ErrBadUsernameOrPassword
+
+	buf := roundTrip(t, pkt, NewConnackPacket(), Version5)
+	assert.Equal(t, []byte{byte(CONNACK << 4), 3, 1, byte(BadUsernameOrPassword), 0}, buf)
+
+	pkt = NewConnackPacket()
+	pkt.ReasonCode = Banned
+	pkt.Properties = Properties{
+		{ID: AssignedClientIDProperty, Value: "foo"},
+		{ID: ReasonStringProperty, Value: "bar"},
+	}
+
+	out := NewConnackPacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, Banned, out.ReasonCode)
+	assert.Equal(t, ErrNotAuthorized, out.ReturnCode)
+	assert.Equal(t, pkt.Properties, out.Properties)
+
+	_, err := out.DecodeVersion([]byte{byte(CONNACK << 4), 3, 0, 0x05, 0}, Version5)
+	assert.Error(t, err)
+
+	_, err = out.DecodeVersion([]byte{byte(CONNACK << 4), 1, 0}, Version5)
+	assert.Error(t, err)
+
+	_, err = out.DecodeVersion([]byte{byte(CONNACK << 4), 2, 0, 6}, Version5)
+	assert.Error(t, err)
+}
+
+func TestConnackPacketDecode5Fallback(t *testing.T) {
+	pkt := NewConnackPacket()
+	pkt.Properties = Properties{{ID: ReasonStringProperty, Value: "foo"}}
+
+	n, err := pkt.DecodeVersion([]byte{byte(CONNACK << 4), 2, 0, byte(ErrInvalidProtocolVersion)}, Version5)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.False(t, pkt.SessionPresent)
+	assert.Equal(t, ErrInvalidProtocolVersion, pkt.ReturnCode)
+	assert.Equal(t, UnsupportedProtocolVersion, pkt.ReasonCode)
+	assert.Empty(t, pkt.Properties)
+}
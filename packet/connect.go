@@ -8,6 +8,7 @@ import (
 
 // The supported MQTT versions.
 const (
+	Version5   byte = 5
 	Version311 byte = 4
 	Version31  byte = 3
 )
@@ -36,8 +37,16 @@ type ConnectPacket struct {
 	// The will message.
 	Will *Message
 
-	// The MQTT version 3 or 4 (defaults to 4 when 0).
+	// The MQTT version 3, 4 or 5 (defaults to 4 when 0).
 	Version byte
+
+	// The connect properties. They are only transmitted using MQTT 5.
+	Properties Properties
+
+	// The will properties. They are only transmitted using MQTT 5 and if a
+	// will message is present. The expiry of the will message is transmitted
+	// as a MessageExpiryProperty.
+	WillProperties Properties
 }
 
 // NewConnectPacket creates a new ConnectPacket.
@@ -95,12 +104,12 @@ func (cp *ConnectPacket) Decode(src []byte) (int, error) {
 	protoName, n, err := readLPBytes(src[total:], false, cp.Type())
 	total += n
 	if err != nil {
-		return total, err
+		return total, shiftError(err, total-n, "protocol name")
 	}
 
 	// check buffer length
 	if len(src) < total+1 {
-		return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", cp.Type(), total+1, len(src))
+		return total, decodeError(cp.Type(), "protocol version", total, "insufficient buffer size, expected %d, got %d", total+1, len(src))
 	}
 
 	// read version
@@ -108,8 +117,8 @@ func (cp *ConnectPacket) Decode(src []byte) (int, error) {
 	total++
 
 	// check protocol string and version
-	if versionByte != Version311 && versionByte != Version31 {
-		return total, fmt.Errorf("[%s] invalid protocol version (%d)", cp.Type(), versionByte)
+	if versionByte != Version311 && versionByte != Version31 && versionByte != Version5 {
+		return total, decodeError(cp.Type(), "protocol version", total-1, "invalid protocol version (%d)", versionByte)
 	}
 
 	// set version
@@ -117,12 +126,12 @@ func (cp *ConnectPacket) Decode(src []byte) (int, error) {
 
 	// check protocol version string
 	if !bytes.Equal(protoName, version311Name) && !bytes.Equal(protoName, version31Name) {
-		return total, fmt.Errorf("[%s] invalid protocol version description (%s)", cp.Type(), protoName)
+		return total, decodeError(cp.Type(), "protocol name", hl, "invalid protocol version description (%s)", protoName)
 	}
 
 	// check buffer length
 	if len(src) < total+1 {
-		return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", cp.Type(), total+1, len(src))
+		return total, decodeError(cp.Type(), "connect flags", total, "insufficient buffer size, expected %d, got %d", total+1, len(src))
 	}
 
 	// read connect flags
@@ -139,17 +148,17 @@ func (cp *ConnectPacket) Decode(src []byte) (int, error) {
 
 	// check reserved bit
 	if connectFlags&0x1 != 0 {
-		return total, fmt.Errorf("[%s] reserved bit 0 is not 0", cp.Type())
+		return total, decodeError(cp.Type(), "connect flags", total-1, "reserved bit 0 is not 0")
 	}
 
 	// check will qos
 	if !validQOS(willQOS) {
-		return total, fmt.Errorf("[%s] invalid QOS level (%d) for will message", cp.Type(), willQOS)
+		return total, decodeError(cp.Type(), "connect flags", total-1, "invalid QOS level (%d) for will message", willQOS)
 	}
 
 	// check will flags
 	if !willFlag && (willRetain || willQOS != 0) {
-		return total, fmt.Errorf("[%s] if the will flag (%t) is set to 0 the will qos (%d) and will retain (%t) fields must be set to zero", cp.Type(), willFlag, willQOS, willRetain)
+		return total, decodeError(cp.Type(), "connect flags", total-1, "if the will flag (%t) is set to 0 the will qos (%d) and will retain (%t) fields must be set to zero", willFlag, willQOS, willRetain)
 	}
 
 	// create will if present
@@ -159,42 +168,61 @@ func (cp *ConnectPacket) Decode(src []byte) (int, error) {
 
 	// check auth flags
 	if !usernameFlag && passwordFlag {
-		return total, fmt.Errorf("[%s] password flag is set but username flag is not set", cp.Type())
+		return total, decodeError(cp.Type(), "connect flags", total-1, "password flag is set but username flag is not set")
 	}
 
 	// check buffer length
 	if len(src) < total+2 {
-		return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", cp.Type(), total+2, len(src))
+		return total, decodeError(cp.Type(), "keep alive", total, "insufficient buffer size, expected %d, got %d", total+2, len(src))
 	}
 
 	// read keep alive
 	cp.KeepAlive = binary.BigEndian.Uint16(src[total:])
 	total += 2
 
+	// read properties
+	if cp.Version == Version5 {
+		cp.Properties, n, err = readProperties(src[total:], cp.Type())
+		total += n
+		if err != nil {
+			return total, shiftError(err, total-n, "properties")
+		}
+	}
+
 	// read client id
 	cp.ClientID, n, err = readLPString(src[total:], cp.Type())
 	total += n
 	if err != nil {
-		return total, err
+		return total, shiftError(err, total-n, "client id")
 	}
 
 	// if the client supplies a zero-byte clientID, the client must also set CleanSession to 1
 	if len(cp.ClientID) == 0 && !cp.CleanSession {
-		return total, fmt.Errorf("[%s] clean session must be 1 if client id is zero length", cp.Type())
+		return total, decodeError(cp.Type(), "client id", total-n, "clean session must be 1 if client id is zero length")
 	}
 
-	// read will topic and payload
+	// read will properties, topic and payload
 	if cp.Will != nil {
+		if cp.Version == Version5 {
+			cp.WillProperties, n, err = readProperties(src[total:], cp.Type())
+			total += n
+			if err != nil {
+				return total, shiftError(err, total-n, "will properties")
+			}
+
+			cp.WillProperties, cp.Will.Expiry = extractExpiry(cp.WillProperties)
+		}
+
 		cp.Will.Topic, n, err = readLPString(src[total:], cp.Type())
 		total += n
 		if err != nil {
-			return total, err
+			return total, shiftError(err, total-n, "will topic")
 		}
 
 		cp.Will.Payload, n, err = readLPBytes(src[total:], true, cp.Type())
 		total += n
 		if err != nil {
-			return total, err
+			return total, shiftError(err, total-n, "will payload")
 		}
 	}
 
@@ -203,7 +231,7 @@ func (cp *ConnectPacket) Decode(src []byte) (int, error) {
 		cp.Username, n, err = readLPString(src[total:], cp.Type())
 		total += n
 		if err != nil {
-			return total, err
+			return total, shiftError(err, total-n, "username")
 		}
 	}
 
@@ -212,7 +240,7 @@ func (cp *ConnectPacket) Decode(src []byte) (int, error) {
 		cp.Password, n, err = readLPString(src[total:], cp.Type())
 		total += n
 		if err != nil {
-			return total, err
+			return total, shiftError(err, total-n, "password")
 		}
 	}
 
@@ -238,12 +266,12 @@ func (cp *ConnectPacket) Encode(dst []byte) (int, error) {
 	}
 
 	// check version byte
-	if cp.Version != Version311 && cp.Version != Version31 {
+	if cp.Version != Version311 && cp.Version != Version31 && cp.Version != Version5 {
 		return total, fmt.Errorf("[%s] unsupported protocol version %d", cp.Type(), cp.Version)
 	}
 
 	// write version string, length has been checked beforehand
-	if cp.Version == Version311 {
+	if cp.Version == Version311 || cp.Version == Version5 {
 		n, _ = writeLPBytes(dst[total:], version311Name, cp.Type())
 		total += n
 	} else if cp.Version == Version31 {
@@ -319,6 +347,15 @@ func (cp *ConnectPacket) Encode(dst []byte) (int, error) {
 	binary.BigEndian.PutUint16(dst[total:], cp.KeepAlive)
 	total += 2
 
+	// write properties
+	if cp.Version == Version5 {
+		n, err = writeProperties(dst[total:], cp.Properties, cp.Type())
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
 	// write client id
 	n, err = writeLPString(dst[total:], cp.ClientID, cp.Type())
 	total += n
@@ -326,8 +363,16 @@ func (cp *ConnectPacket) Encode(dst []byte) (int, error) {
 		return total, err
 	}
 
-	// write will topic and payload
+	// write will properties, topic and payload
 	if cp.Will != nil {
+		if cp.Version == Version5 {
+			n, err = writeProperties(dst[total:], injectExpiry(cp.WillProperties, cp.Will.Expiry), cp.Type())
+			total += n
+			if err != nil {
+				return total, err
+			}
+		}
+
 		n, err = writeLPString(dst[total:], cp.Will.Topic, cp.Type())
 		total += n
 		if err != nil {
@@ -386,12 +431,22 @@ func (cp *ConnectPacket) len() int {
 	// 2 bytes keep alive timer
 	total += 1 + 2
 
+	// add the properties length
+	if cp.Version == Version5 {
+		total += propertiesLen(cp.Properties)
+	}
+
 	// add the clientID length
 	total += 2 + len(cp.ClientID)
 
 	// add the will topic and will message length
 	if cp.Will != nil {
 		total += 2 + len(cp.Will.Topic) + 2 + len(cp.Will.Payload)
+
+		// add the will properties length
+		if cp.Version == Version5 {
+			total += propertiesLen(injectExpiry(cp.WillProperties, cp.Will.Expiry))
+		}
 	}
 
 	// add the username length
@@ -2,6 +2,7 @@ package packet
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -765,3 +766,34 @@ func BenchmarkConnectDecode(b *testing.B) {
 		}
 	}
 }
+
+func TestConnectPacketEncodeDecode5(t *testing.T) {
+	pkt := NewConnectPacket()
+	pkt.Version = Version5
+	pkt.ClientID = "gomqtt"
+	pkt.KeepAlive = 10
+	pkt.Username = "user"
+	pkt.Password = "pass"
+	pkt.Properties = Properties{
+		{ID: SessionExpiryProperty, Value: uint32(3600)},
+		{ID: ReceiveMaximumProperty, Value: uint16(10)},
+	}
+	pkt.Will = &Message{
+		Topic:   "will",
+		Payload: []byte("bye"),
+		QOS:     QOSAtLeastOnce,
+		Expiry:  time.Minute,
+	}
+	pkt.WillProperties = Properties{
+		{ID: WillDelayProperty, Value: uint32(5)},
+	}
+
+	out := NewConnectPacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+
+	// the protocol level is detected on decode
+	out = NewConnectPacket()
+	roundTrip(t, pkt, out, Version311)
+	assert.Equal(t, pkt, out)
+}
@@ -0,0 +1,53 @@
+package packet
+
+import "fmt"
+
+// A DecodeError is returned if a packet could not be decoded. It describes the
+// malformed field and the offset of the byte at which the problem has been
+// detected.
+type DecodeError struct {
+	// The type of the decoded packet.
+	Type Type
+
+	// The name of the malformed field e.g. "topic" or "properties". It is
+	// empty if the problem concerns the packet as a whole.
+	Field string
+
+	// The offset of the byte from the start of the packet.
+	Offset int
+
+	// The description of the problem.
+	Reason string
+}
+
+// Error returns a description of the error.
+func (e *DecodeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("[%s] %s (offset %d)", e.Type, e.Reason, e.Offset)
+	}
+
+	return fmt.Sprintf("[%s] %s: %s (offset %d)", e.Type, e.Field, e.Reason, e.Offset)
+}
+
+// returns a new decode error
+func decodeError(t Type, field string, offset int, format string, args ...interface{}) error {
+	return &DecodeError{
+		Type:   t,
+		Field:  field,
+		Offset: offset,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+// adds the offset of a sub slice to a decode error and sets the field if it
+// has not yet been set
+func shiftError(err error, offset int, field string) error {
+	if de, ok := err.(*DecodeError); ok {
+		de.Offset += offset
+		if de.Field == "" {
+			de.Field = field
+		}
+	}
+
+	return err
+}
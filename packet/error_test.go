@@ -0,0 +1,88 @@
+package packet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeError(t *testing.T) {
+	buf := []byte{
+		byte(CONNECT << 4),
+		10,
+		0, // protocol string msb
+		4, // protocol string lsb
+		'M', 'Q', 'T', 'T',
+		9,  // < invalid version
+		0,  // connect flags
+		0,  // keep alive msb
+		10, // keep alive lsb
+	}
+
+	cp := NewConnectPacket()
+	_, err := cp.Decode(buf)
+	require.Error(t, err)
+
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, &DecodeError{
+		Type:   CONNECT,
+		Field:  "protocol version",
+		Offset: 8,
+		Reason: "invalid protocol version (9)",
+	}, de)
+	assert.Equal(t, "[Connect] protocol version: invalid protocol version (9) (offset 8)", err.Error())
+}
+
+func TestDecodeErrorNested(t *testing.T) {
+	buf := []byte{
+		byte(PUBLISH << 4),
+		3,
+		0,   // topic msb
+		10,  // topic lsb
+		't', // < missing bytes
+	}
+
+	pp := NewPublishPacket()
+	_, err := pp.Decode(buf)
+	require.Error(t, err)
+
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, PUBLISH, de.Type)
+	assert.Equal(t, "topic", de.Field)
+	assert.Equal(t, 2, de.Offset)
+
+	buf = []byte{
+		byte(PUBACK << 4),
+		6,
+		0,    // packet id msb
+		1,    // packet id lsb
+		0,    // reason code
+		2,    // properties length
+		0xFF, // < invalid property identifier
+		0,
+	}
+
+	pa := NewPubackPacket()
+	_, err = pa.DecodeVersion(buf, Version5)
+	require.Error(t, err)
+
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, PUBACK, de.Type)
+	assert.Equal(t, "properties", de.Field)
+	assert.Equal(t, 6, de.Offset)
+}
+
+func TestDecodeErrorHeader(t *testing.T) {
+	_, _, _, err := headerDecode([]byte{0x62}, PUBREL)
+	require.Error(t, err)
+
+	var de *DecodeError
+	require.True(t, errors.As(err, &de))
+	assert.Equal(t, PUBREL, de.Type)
+	assert.Equal(t, "header", de.Field)
+	assert.Equal(t, 1, de.Offset)
+}
@@ -61,7 +61,7 @@ func headerDecode(src []byte, t Type) (int, byte, int, error) {
 
 	// check buffer size
 	if len(src) < 2 {
-		return total, 0, 0, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", t, 2, len(src))
+		return total, 0, 0, decodeError(t, "header", len(src), "insufficient buffer size, expected %d, got %d", 2, len(src))
 	}
 
 	// read type and flags
@@ -72,12 +72,12 @@ func headerDecode(src []byte, t Type) (int, byte, int, error) {
 
 	// check against static type
 	if decodedType != t {
-		return total, 0, 0, fmt.Errorf("[%s] invalid type %d", t, decodedType)
+		return total, 0, 0, decodeError(t, "type", 0, "invalid type %d", decodedType)
 	}
 
 	// check flags except for publish packets
 	if t != PUBLISH && flags != t.defaultFlags() {
-		return total, 0, 0, fmt.Errorf("[%s] invalid flags, expected %d, got %d", t, t.defaultFlags(), flags)
+		return total, 0, 0, decodeError(t, "flags", 0, "invalid flags, expected %d, got %d", t.defaultFlags(), flags)
 	}
 
 	// read remaining length
@@ -87,12 +87,12 @@ func headerDecode(src []byte, t Type) (int, byte, int, error) {
 
 	// check resulting remaining length
 	if m <= 0 {
-		return total, 0, 0, fmt.Errorf("[%s] error reading remaining length", t)
+		return total, 0, 0, decodeError(t, "remaining length", 1, "error reading remaining length")
 	}
 
 	// check remaining buffer
 	if rl > len(src[total:]) {
-		return total, 0, 0, fmt.Errorf("[%s] remaining length (%d) is greater than remaining buffer (%d)", t, rl, len(src[total:]))
+		return total, 0, 0, decodeError(t, "remaining length", 1, "remaining length (%d) is greater than remaining buffer (%d)", rl, len(src[total:]))
 	}
 
 	return total, flags, rl, nil
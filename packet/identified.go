@@ -23,7 +23,7 @@ func identifiedPacketDecode(src []byte, t Type) (int, ID, error) {
 
 	// check remaining length
 	if rl != 2 {
-		return total, 0, fmt.Errorf("[%s] expected remaining length to be 2", t)
+		return total, 0, decodeError(t, "remaining length", 1, "expected remaining length to be 2")
 	}
 
 	// read packet id
@@ -32,7 +32,7 @@ func identifiedPacketDecode(src []byte, t Type) (int, ID, error) {
 
 	// check packet id
 	if packetID == 0 {
-		return total, 0, fmt.Errorf("[%s] packet id must be grater than zero", t)
+		return total, 0, decodeError(t, "packet id", total-2, "packet id must be grater than zero")
 	}
 
 	return total, ID(packetID), nil
@@ -61,10 +61,163 @@ func identifiedPacketEncode(dst []byte, id ID, t Type) (int, error) {
 	return total, nil
 }
 
+// Returns the byte length of an acknowledgement packet encoded using the
+// protocol level.
+func ackPacketLenVersion(reasonCode ReasonCode, properties Properties, version byte) int {
+	if version != Version5 {
+		return identifiedPacketLen()
+	}
+
+	return ackPacketLen(reasonCode, properties)
+}
+
+// Decodes an acknowledgement packet using the protocol level. The reason code
+// and properties are only decoded using MQTT 5.
+func ackPacketDecodeVersion(src []byte, version byte, t Type) (int, ID, ReasonCode, Properties, error) {
+	if version != Version5 {
+		n, pid, err := identifiedPacketDecode(src, t)
+		return n, pid, Success, nil, err
+	}
+
+	return ackPacketDecode(src, t)
+}
+
+// Encodes an acknowledgement packet using the protocol level. The reason code
+// and properties are only encoded using MQTT 5.
+func ackPacketEncodeVersion(dst []byte, id ID, reasonCode ReasonCode, properties Properties, version byte, t Type) (int, error) {
+	if version != Version5 {
+		return identifiedPacketEncode(dst, id, t)
+	}
+
+	return ackPacketEncode(dst, id, reasonCode, properties, t)
+}
+
+// Returns the byte length of an MQTT 5 acknowledgement packet.
+func ackPacketLen(reasonCode ReasonCode, properties Properties) int {
+	ml := ackPacketRemainingLen(reasonCode, properties)
+	return headerLen(ml) + ml
+}
+
+// Returns the remaining length of an MQTT 5 acknowledgement packet. The reason
+// code and properties are omitted if possible.
+func ackPacketRemainingLen(reasonCode ReasonCode, properties Properties) int {
+	if len(properties) > 0 {
+		return 2 + 1 + propertiesLen(properties)
+	} else if reasonCode != Success {
+		return 2 + 1
+	}
+
+	return 2
+}
+
+// Decodes an MQTT 5 acknowledgement packet.
+func ackPacketDecode(src []byte, t Type) (int, ID, ReasonCode, Properties, error) {
+	total := 0
+
+	// decode header
+	hl, _, rl, err := headerDecode(src, t)
+	total += hl
+	if err != nil {
+		return total, 0, 0, nil, err
+	}
+
+	// check remaining length
+	if rl < 2 {
+		return total, 0, 0, nil, decodeError(t, "remaining length", 1, "expected remaining length to be at least 2")
+	}
+
+	// read packet id
+	packetID := ID(binary.BigEndian.Uint16(src[total:]))
+	total += 2
+
+	// check packet id
+	if packetID == 0 {
+		return total, 0, 0, nil, decodeError(t, "packet id", total-2, "packet id must be grater than zero")
+	}
+
+	// the reason code defaults to success if omitted
+	if rl == 2 {
+		return total, packetID, Success, nil, nil
+	}
+
+	// read reason code
+	reasonCode := ReasonCode(src[total])
+	total++
+
+	// check reason code
+	if !reasonCode.Valid() {
+		return total, 0, 0, nil, decodeError(t, "reason code", total-1, "invalid reason code (%d)", reasonCode)
+	}
+
+	// the properties may be omitted as well
+	if rl == 3 {
+		return total, packetID, reasonCode, nil, nil
+	}
+
+	// read properties
+	properties, n, err := readProperties(src[total:hl+rl], t)
+	total += n
+	if err != nil {
+		return total, 0, 0, nil, shiftError(err, total-n, "properties")
+	}
+
+	return total, packetID, reasonCode, properties, nil
+}
+
+// Encodes an MQTT 5 acknowledgement packet.
+func ackPacketEncode(dst []byte, id ID, reasonCode ReasonCode, properties Properties, t Type) (int, error) {
+	total := 0
+
+	// check packet id
+	if id == 0 {
+		return total, fmt.Errorf("[%s] packet id must be grater than zero", t)
+	}
+
+	// check reason code
+	if !reasonCode.Valid() {
+		return total, fmt.Errorf("[%s] invalid reason code (%d)", t, reasonCode)
+	}
+
+	// encode header
+	rl := ackPacketRemainingLen(reasonCode, properties)
+	n, err := headerEncode(dst[total:], 0, rl, ackPacketLen(reasonCode, properties), t)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	// write packet id
+	binary.BigEndian.PutUint16(dst[total:], uint16(id))
+	total += 2
+
+	// write reason code
+	if rl > 2 {
+		dst[total] = byte(reasonCode)
+		total++
+	}
+
+	// write properties
+	if rl > 3 {
+		n, err = writeProperties(dst[total:], properties, t)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
 // A PubackPacket is the response to a PublishPacket with QOS level 1.
 type PubackPacket struct {
 	// The packet identifier.
 	ID ID
+
+	// The reason code. It is only transmitted using MQTT 5.
+	ReasonCode ReasonCode
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 // NewPubackPacket creates a new PubackPacket.
@@ -82,6 +235,12 @@ func (pp *PubackPacket) Len() int {
 	return identifiedPacketLen()
 }
 
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (pp *PubackPacket) LenVersion(version byte) int {
+	return ackPacketLenVersion(pp.ReasonCode, pp.Properties, version)
+}
+
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (pp *PubackPacket) Decode(src []byte) (int, error) {
@@ -90,6 +249,13 @@ func (pp *PubackPacket) Decode(src []byte) (int, error) {
 	return n, err
 }
 
+// DecodeVersion decodes the packet using the specified protocol level.
+func (pp *PubackPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	n, pid, rc, props, err := ackPacketDecodeVersion(src, version, PUBACK)
+	pp.ID, pp.ReasonCode, pp.Properties = pid, rc, props
+	return n, err
+}
+
 // Encode writes the packet bytes into the byte slice from the argument. It
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
@@ -97,6 +263,11 @@ func (pp *PubackPacket) Encode(dst []byte) (int, error) {
 	return identifiedPacketEncode(dst, pp.ID, PUBACK)
 }
 
+// EncodeVersion encodes the packet using the specified protocol level.
+func (pp *PubackPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	return ackPacketEncodeVersion(dst, pp.ID, pp.ReasonCode, pp.Properties, version, PUBACK)
+}
+
 // String returns a string representation of the packet.
 func (pp *PubackPacket) String() string {
 	return fmt.Sprintf("<PubackPacket ID=%d>", pp.ID)
@@ -107,6 +278,12 @@ func (pp *PubackPacket) String() string {
 type PubcompPacket struct {
 	// The packet identifier.
 	ID ID
+
+	// The reason code. It is only transmitted using MQTT 5.
+	ReasonCode ReasonCode
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 var _ GenericPacket = (*PubcompPacket)(nil)
@@ -126,6 +303,12 @@ func (pp *PubcompPacket) Len() int {
 	return identifiedPacketLen()
 }
 
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (pp *PubcompPacket) LenVersion(version byte) int {
+	return ackPacketLenVersion(pp.ReasonCode, pp.Properties, version)
+}
+
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (pp *PubcompPacket) Decode(src []byte) (int, error) {
@@ -134,6 +317,13 @@ func (pp *PubcompPacket) Decode(src []byte) (int, error) {
 	return n, err
 }
 
+// DecodeVersion decodes the packet using the specified protocol level.
+func (pp *PubcompPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	n, pid, rc, props, err := ackPacketDecodeVersion(src, version, PUBCOMP)
+	pp.ID, pp.ReasonCode, pp.Properties = pid, rc, props
+	return n, err
+}
+
 // Encode writes the packet bytes into the byte slice from the argument. It
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
@@ -141,6 +331,11 @@ func (pp *PubcompPacket) Encode(dst []byte) (int, error) {
 	return identifiedPacketEncode(dst, pp.ID, PUBCOMP)
 }
 
+// EncodeVersion encodes the packet using the specified protocol level.
+func (pp *PubcompPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	return ackPacketEncodeVersion(dst, pp.ID, pp.ReasonCode, pp.Properties, version, PUBCOMP)
+}
+
 // String returns a string representation of the packet.
 func (pp *PubcompPacket) String() string {
 	return fmt.Sprintf("<PubcompPacket ID=%d>", pp.ID)
@@ -151,6 +346,12 @@ func (pp *PubcompPacket) String() string {
 type PubrecPacket struct {
 	// Shared packet identifier.
 	ID ID
+
+	// The reason code. It is only transmitted using MQTT 5.
+	ReasonCode ReasonCode
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 // NewPubrecPacket creates a new PubrecPacket.
@@ -168,6 +369,12 @@ func (pp *PubrecPacket) Len() int {
 	return identifiedPacketLen()
 }
 
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (pp *PubrecPacket) LenVersion(version byte) int {
+	return ackPacketLenVersion(pp.ReasonCode, pp.Properties, version)
+}
+
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (pp *PubrecPacket) Decode(src []byte) (int, error) {
@@ -176,6 +383,13 @@ func (pp *PubrecPacket) Decode(src []byte) (int, error) {
 	return n, err
 }
 
+// DecodeVersion decodes the packet using the specified protocol level.
+func (pp *PubrecPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	n, pid, rc, props, err := ackPacketDecodeVersion(src, version, PUBREC)
+	pp.ID, pp.ReasonCode, pp.Properties = pid, rc, props
+	return n, err
+}
+
 // Encode writes the packet bytes into the byte slice from the argument. It
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
@@ -183,6 +397,11 @@ func (pp *PubrecPacket) Encode(dst []byte) (int, error) {
 	return identifiedPacketEncode(dst, pp.ID, PUBREC)
 }
 
+// EncodeVersion encodes the packet using the specified protocol level.
+func (pp *PubrecPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	return ackPacketEncodeVersion(dst, pp.ID, pp.ReasonCode, pp.Properties, version, PUBREC)
+}
+
 // String returns a string representation of the packet.
 func (pp *PubrecPacket) String() string {
 	return fmt.Sprintf("<PubrecPacket ID=%d>", pp.ID)
@@ -193,6 +412,12 @@ func (pp *PubrecPacket) String() string {
 type PubrelPacket struct {
 	// Shared packet identifier.
 	ID ID
+
+	// The reason code. It is only transmitted using MQTT 5.
+	ReasonCode ReasonCode
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 var _ GenericPacket = (*PubrelPacket)(nil)
@@ -212,6 +437,12 @@ func (pp *PubrelPacket) Len() int {
 	return identifiedPacketLen()
 }
 
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (pp *PubrelPacket) LenVersion(version byte) int {
+	return ackPacketLenVersion(pp.ReasonCode, pp.Properties, version)
+}
+
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (pp *PubrelPacket) Decode(src []byte) (int, error) {
@@ -220,6 +451,13 @@ func (pp *PubrelPacket) Decode(src []byte) (int, error) {
 	return n, err
 }
 
+// DecodeVersion decodes the packet using the specified protocol level.
+func (pp *PubrelPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	n, pid, rc, props, err := ackPacketDecodeVersion(src, version, PUBREL)
+	pp.ID, pp.ReasonCode, pp.Properties = pid, rc, props
+	return n, err
+}
+
 // Encode writes the packet bytes into the byte slice from the argument. It
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
@@ -227,6 +465,11 @@ func (pp *PubrelPacket) Encode(dst []byte) (int, error) {
 	return identifiedPacketEncode(dst, pp.ID, PUBREL)
 }
 
+// EncodeVersion encodes the packet using the specified protocol level.
+func (pp *PubrelPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	return ackPacketEncodeVersion(dst, pp.ID, pp.ReasonCode, pp.Properties, version, PUBREL)
+}
+
 // String returns a string representation of the packet.
 func (pp *PubrelPacket) String() string {
 	return fmt.Sprintf("<PubrelPacket ID=%d>", pp.ID)
@@ -237,6 +480,13 @@ func (pp *PubrelPacket) String() string {
 type UnsubackPacket struct {
 	// Shared packet identifier.
 	ID ID
+
+	// The reason codes for the topics of the UnsubscribePacket. They are only
+	// transmitted using MQTT 5.
+	ReasonCodes []ReasonCode
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 // NewUnsubackPacket creates a new UnsubackPacket.
@@ -254,6 +504,17 @@ func (up *UnsubackPacket) Len() int {
 	return identifiedPacketLen()
 }
 
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (up *UnsubackPacket) LenVersion(version byte) int {
+	if version == Version5 {
+		ml := up.len5()
+		return headerLen(ml) + ml
+	}
+
+	return identifiedPacketLen()
+}
+
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (up *UnsubackPacket) Decode(src []byte) (int, error) {
@@ -262,6 +523,63 @@ func (up *UnsubackPacket) Decode(src []byte) (int, error) {
 	return n, err
 }
 
+// DecodeVersion decodes the packet using the specified protocol level.
+func (up *UnsubackPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	if version != Version5 {
+		return up.Decode(src)
+	}
+
+	total := 0
+
+	// decode header
+	hl, _, rl, err := headerDecode(src, UNSUBACK)
+	total += hl
+	if err != nil {
+		return total, err
+	}
+
+	// check remaining length
+	if rl < 3 {
+		return total, decodeError(up.Type(), "remaining length", 1, "expected remaining length to be at least 3")
+	}
+
+	// read packet id
+	up.ID = ID(binary.BigEndian.Uint16(src[total:]))
+	total += 2
+
+	// check packet id
+	if up.ID == 0 {
+		return total, decodeError(up.Type(), "packet id", total-2, "packet id must be grater than zero")
+	}
+
+	// read properties
+	var n int
+	up.Properties, n, err = readProperties(src[total:hl+rl], up.Type())
+	total += n
+	if err != nil {
+		return total, shiftError(err, total-n, "properties")
+	}
+
+	// read reason codes
+	up.ReasonCodes = make([]ReasonCode, hl+rl-total)
+	for i := range up.ReasonCodes {
+		up.ReasonCodes[i] = ReasonCode(src[total])
+		total++
+
+		// check reason code
+		if !up.ReasonCodes[i].Valid() {
+			return total, decodeError(up.Type(), "reason codes", total-1, "invalid reason code %d for topic %d", up.ReasonCodes[i], i)
+		}
+	}
+
+	// check for empty list
+	if len(up.ReasonCodes) == 0 {
+		return total, decodeError(up.Type(), "reason codes", total, "empty reason code list")
+	}
+
+	return total, nil
+}
+
 // Encode writes the packet bytes into the byte slice from the argument. It
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
@@ -269,6 +587,61 @@ func (up *UnsubackPacket) Encode(dst []byte) (int, error) {
 	return identifiedPacketEncode(dst, up.ID, UNSUBACK)
 }
 
+// EncodeVersion encodes the packet using the specified protocol level.
+func (up *UnsubackPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	if version != Version5 {
+		return up.Encode(dst)
+	}
+
+	total := 0
+
+	// check packet id
+	if up.ID == 0 {
+		return total, fmt.Errorf("[%s] packet id must be grater than zero", up.Type())
+	}
+
+	// check reason codes
+	if len(up.ReasonCodes) == 0 {
+		return total, fmt.Errorf("[%s] empty reason code list", up.Type())
+	}
+	for i, code := range up.ReasonCodes {
+		if !code.Valid() {
+			return total, fmt.Errorf("[%s] invalid reason code %d for topic %d", up.Type(), code, i)
+		}
+	}
+
+	// encode header
+	n, err := headerEncode(dst[total:], 0, up.len5(), up.LenVersion(Version5), UNSUBACK)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	// write packet id
+	binary.BigEndian.PutUint16(dst[total:], uint16(up.ID))
+	total += 2
+
+	// write properties
+	n, err = writeProperties(dst[total:], up.Properties, up.Type())
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	// write reason codes
+	for _, code := range up.ReasonCodes {
+		dst[total] = byte(code)
+		total++
+	}
+
+	return total, nil
+}
+
+// Returns the MQTT 5 payload length.
+func (up *UnsubackPacket) len5() int {
+	return 2 + propertiesLen(up.Properties) + len(up.ReasonCodes)
+}
+
 // String returns a string representation of the packet.
 func (up *UnsubackPacket) String() string {
 	return fmt.Sprintf("<UnsubackPacket ID=%d>", up.ID)
@@ -201,3 +201,63 @@ func TestUnsubackImplementation(t *testing.T) {
 
 	testIdentifiedPacketImplementation(t, pkt)
 }
+
+func TestIdentifiedPacketEncodeDecode5(t *testing.T) {
+	pkt := NewPubackPacket()
+	pkt.ID = 7
+
+	buf := roundTrip(t, pkt, NewPubackPacket(), Version5)
+	assert.Equal(t, []byte{byte(PUBACK << 4), 2, 0, 7}, buf)
+
+	pkt.ReasonCode = NoMatchingSubscribers
+
+	out := NewPubackPacket()
+	buf = roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, []byte{byte(PUBACK << 4), 3, 0, 7, byte(NoMatchingSubscribers)}, buf)
+	assert.Equal(t, pkt, out)
+
+	rec := &PubrecPacket{ID: 7, ReasonCode: QuotaExceeded, Properties: Properties{
+		{ID: ReasonStringProperty, Value: "full"},
+	}}
+	recOut := NewPubrecPacket()
+	roundTrip(t, rec, recOut, Version5)
+	assert.Equal(t, rec, recOut)
+
+	rel := &PubrelPacket{ID: 7, ReasonCode: PacketIdentifierNotFound}
+	relOut := NewPubrelPacket()
+	roundTrip(t, rel, relOut, Version5)
+	assert.Equal(t, rel, relOut)
+
+	comp := &PubcompPacket{ID: 7}
+	compOut := NewPubcompPacket()
+	roundTrip(t, comp, compOut, Version5)
+	assert.Equal(t, comp, compOut)
+
+	// invalid reason code
+	_, err := out.DecodeVersion([]byte{byte(PUBACK << 4), 3, 0, 7, 0x05}, Version5)
+	assert.Error(t, err)
+
+	pkt.ReasonCode = 0x05
+	_, err = pkt.EncodeVersion(make([]byte, 5), Version5)
+	assert.Error(t, err)
+}
+
+func TestUnsubackPacketEncodeDecode5(t *testing.T) {
+	pkt := NewUnsubackPacket()
+	pkt.ID = 7
+	pkt.ReasonCodes = []ReasonCode{Success, NoSubscriptionExisted}
+	pkt.Properties = Properties{
+		{ID: ReasonStringProperty, Value: "foo"},
+	}
+
+	out := NewUnsubackPacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+
+	pkt.ReasonCodes = nil
+	_, err := pkt.EncodeVersion(make([]byte, 20), Version5)
+	assert.Error(t, err)
+
+	_, err = out.DecodeVersion([]byte{byte(UNSUBACK << 4), 3, 0, 7, 0}, Version5)
+	assert.Error(t, err)
+}
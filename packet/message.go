@@ -1,6 +1,10 @@
 package packet
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+	"time"
+)
 
 // A Message bundles data that is published between brokers and clients.
 type Message struct {
@@ -17,6 +21,14 @@ type Message struct {
 	// so that it can be delivered to future subscribers whose subscriptions
 	// match its topic name.
 	Retain bool
+
+	// The Expiry defines the lifetime of the message. Servers must not deliver
+	// the message anymore after it has expired and should forward the remaining
+	// lifetime to subscribers. A zero value means that the message does not
+	// expire.
+	//
+	// Note: The value is only transmitted using MQTT 5 packets.
+	Expiry time.Duration
 }
 
 // String returns a string representation of the message.
@@ -29,3 +41,29 @@ func (m *Message) String() string {
 func (m Message) Copy() *Message {
 	return &m
 }
+
+// Returns the properties with an added MessageExpiryProperty if the expiry is
+// set. Partial seconds are rounded up.
+func injectExpiry(properties Properties, expiry time.Duration) Properties {
+	if expiry <= 0 {
+		return properties
+	}
+
+	seconds := (expiry + time.Second - 1) / time.Second
+	if seconds > math.MaxUint32 {
+		seconds = math.MaxUint32
+	}
+
+	return properties.Set(MessageExpiryProperty, uint32(seconds))
+}
+
+// Returns the properties without the MessageExpiryProperty and the expiry it
+// did define.
+func extractExpiry(properties Properties) (Properties, time.Duration) {
+	value, ok := properties.Get(MessageExpiryProperty)
+	if !ok {
+		return properties, 0
+	}
+
+	return properties.Remove(MessageExpiryProperty), time.Duration(value.(uint32)) * time.Second
+}
@@ -12,12 +12,16 @@ func nakedPacketDecode(src []byte, t Type) (int, error) {
 	// decode header
 	hl, _, rl, err := headerDecode(src, t)
 
+	if err != nil {
+		return hl, err
+	}
+
 	// check remaining length
 	if rl != 0 {
-		return hl, fmt.Errorf("[%s] expected zero remaining length", t)
+		return hl, decodeError(t, "remaining length", 1, "expected zero remaining length")
 	}
 
-	return hl, err
+	return hl, nil
 }
 
 // Encodes a naked packet.
@@ -26,9 +30,116 @@ func nakedPacketEncode(dst []byte, t Type) (int, error) {
 	return headerEncode(dst, 0, 0, nakedPacketLen(), t)
 }
 
+// Returns the remaining length of an MQTT 5 packet that only carries a reason
+// code and properties. Both are omitted if possible.
+func reasonPacketLen(reasonCode ReasonCode, properties Properties) int {
+	if len(properties) > 0 {
+		return 1 + propertiesLen(properties)
+	} else if reasonCode != Success {
+		return 1
+	}
+
+	return 0
+}
+
+// Decodes an MQTT 5 packet that only carries a reason code and properties.
+func reasonPacketDecode(src []byte, t Type) (int, ReasonCode, Properties, error) {
+	total := 0
+
+	// decode header
+	hl, _, rl, err := headerDecode(src, t)
+	total += hl
+	if err != nil {
+		return total, 0, nil, err
+	}
+
+	// the reason code defaults to success if omitted
+	if rl == 0 {
+		return total, Success, nil, nil
+	}
+
+	// read reason code
+	reasonCode := ReasonCode(src[total])
+	total++
+
+	// check reason code
+	if !reasonCode.Valid() {
+		return total, 0, nil, decodeError(t, "reason code", total-1, "invalid reason code (%d)", reasonCode)
+	}
+
+	// the properties may be omitted as well
+	if rl == 1 {
+		return total, reasonCode, nil, nil
+	}
+
+	// read properties
+	properties, n, err := readProperties(src[total:hl+rl], t)
+	total += n
+	if err != nil {
+		return total, 0, nil, shiftError(err, total-n, "properties")
+	}
+
+	return total, reasonCode, properties, nil
+}
+
+// Encodes an MQTT 5 packet that only carries a reason code and properties.
+func reasonPacketEncode(dst []byte, reasonCode ReasonCode, properties Properties, t Type) (int, error) {
+	total := 0
+
+	// check reason code
+	if !reasonCode.Valid() {
+		return total, fmt.Errorf("[%s] invalid reason code (%d)", t, reasonCode)
+	}
+
+	// encode header
+	rl := reasonPacketLen(reasonCode, properties)
+	n, err := headerEncode(dst[total:], 0, rl, headerLen(rl)+rl, t)
+	total += n
+	if err != nil {
+		return total, err
+	}
+
+	// write reason code
+	if rl > 0 {
+		dst[total] = byte(reasonCode)
+		total++
+	}
+
+	// write properties
+	if rl > 1 {
+		n, err = writeProperties(dst[total:], properties, t)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// A DisconnectCode is the reason code of a DisconnectPacket.
+type DisconnectCode uint8
+
+// All available DisconnectCodes.
+const (
+	NormalDisconnection              DisconnectCode = 0x00
+	ServerShuttingDown               DisconnectCode = 0x8B
+	ReceiveMaximumExceededDisconnect DisconnectCode = 0x93
+	PacketTooLargeDisconnect         DisconnectCode = 0x95
+	UseAnotherServerDisconnect       DisconnectCode = 0x9C
+	ServerMovedDisconnect            DisconnectCode = 0x9D
+)
+
 // A DisconnectPacket is sent from the client to the server.
 // It indicates that the client is disconnecting cleanly.
-type DisconnectPacket struct{}
+type DisconnectPacket struct {
+	// The reason for the disconnect. The reason code is only transmitted with
+	// MQTT 5 which allows the server to send a DisconnectPacket as well.
+	ReasonCode DisconnectCode
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
+}
 
 // NewDisconnectPacket creates a new DisconnectPacket.
 func NewDisconnectPacket() *DisconnectPacket {
@@ -45,12 +156,34 @@ func (dp *DisconnectPacket) Len() int {
 	return nakedPacketLen()
 }
 
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (dp *DisconnectPacket) LenVersion(version byte) int {
+	if version == Version5 {
+		ml := reasonPacketLen(ReasonCode(dp.ReasonCode), dp.Properties)
+		return headerLen(ml) + ml
+	}
+
+	return nakedPacketLen()
+}
+
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (dp *DisconnectPacket) Decode(src []byte) (int, error) {
 	return nakedPacketDecode(src, DISCONNECT)
 }
 
+// DecodeVersion decodes the packet using the specified protocol level.
+func (dp *DisconnectPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	if version != Version5 {
+		return dp.Decode(src)
+	}
+
+	n, rc, props, err := reasonPacketDecode(src, DISCONNECT)
+	dp.ReasonCode, dp.Properties = DisconnectCode(rc), props
+	return n, err
+}
+
 // Encode writes the packet bytes into the byte slice from the argument. It
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
@@ -58,6 +191,15 @@ func (dp *DisconnectPacket) Encode(dst []byte) (int, error) {
 	return nakedPacketEncode(dst, DISCONNECT)
 }
 
+// EncodeVersion encodes the packet using the specified protocol level.
+func (dp *DisconnectPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	if version != Version5 {
+		return dp.Encode(dst)
+	}
+
+	return reasonPacketEncode(dst, ReasonCode(dp.ReasonCode), dp.Properties, DISCONNECT)
+}
+
 // String returns a string representation of the packet.
 func (dp *DisconnectPacket) String() string {
 	return "<DisconnectPacket>"
@@ -122,3 +122,23 @@ func TestPingreqImplementation(t *testing.T) {
 func TestPingrespImplementation(t *testing.T) {
 	testNakedPacketImplementation(t, PINGRESP)
 }
+
+func TestDisconnectPacketEncodeDecode5(t *testing.T) {
+	pkt := NewDisconnectPacket()
+
+	buf := roundTrip(t, pkt, NewDisconnectPacket(), Version5)
+	assert.Equal(t, []byte{byte(DISCONNECT << 4), 0}, buf)
+
+	pkt.ReasonCode = ServerShuttingDown
+	pkt.Properties = Properties{
+		{ID: ServerReferenceProperty, Value: "other"},
+	}
+
+	out := NewDisconnectPacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+
+	// the reason code is not transmitted with MQTT 3
+	buf = roundTrip(t, pkt, NewDisconnectPacket(), Version311)
+	assert.Equal(t, []byte{byte(DISCONNECT << 4), 0}, buf)
+}
@@ -44,6 +44,51 @@ type GenericPacket interface {
 	String() string
 }
 
+// A VersionedPacket is a packet that is encoded differently depending on the
+// protocol level. All packets except the ConnectPacket, which carries its own
+// protocol level, and the ping packets implement the interface.
+type VersionedPacket interface {
+	GenericPacket
+
+	// LenVersion returns the byte length of the packet encoded using the
+	// specified protocol level.
+	LenVersion(version byte) int
+
+	// DecodeVersion decodes the packet using the specified protocol level.
+	DecodeVersion(src []byte, version byte) (int, error)
+
+	// EncodeVersion encodes the packet using the specified protocol level.
+	EncodeVersion(dst []byte, version byte) (int, error)
+}
+
+// Len returns the byte length of the packet encoded using the specified
+// protocol level.
+func Len(pkt GenericPacket, version byte) int {
+	if vp, ok := pkt.(VersionedPacket); ok {
+		return vp.LenVersion(version)
+	}
+
+	return pkt.Len()
+}
+
+// Decode decodes the packet using the specified protocol level.
+func Decode(pkt GenericPacket, src []byte, version byte) (int, error) {
+	if vp, ok := pkt.(VersionedPacket); ok {
+		return vp.DecodeVersion(src, version)
+	}
+
+	return pkt.Decode(src)
+}
+
+// Encode encodes the packet using the specified protocol level.
+func Encode(pkt GenericPacket, dst []byte, version byte) (int, error) {
+	if vp, ok := pkt.(VersionedPacket); ok {
+		return vp.EncodeVersion(dst, version)
+	}
+
+	return pkt.Encode(dst)
+}
+
 // DetectPacket tries to detect the next packet in a buffer. It returns a length
 // greater than zero if the packet has been detected as well as its Type.
 func DetectPacket(src []byte) (int, Type) {
@@ -33,6 +33,7 @@ func TestFixedHeaderFlags(t *testing.T) {
 		PINGREQ:     {"Pingreq", 0},
 		PINGRESP:    {"Pingresp", 0},
 		DISCONNECT:  {"Disconnect", 0},
+		AUTH:        {"Auth", 0},
 	}
 
 	for m, d := range details {
@@ -125,6 +126,7 @@ func TestGetID(t *testing.T) {
 		PINGREQ:     {NewPingreqPacket(), false},
 		PINGRESP:    {NewPingrespPacket(), false},
 		DISCONNECT:  {NewDisconnectPacket(), false},
+		AUTH:        {NewAuthPacket(), false},
 	}
 
 	for _, d := range details {
@@ -0,0 +1,274 @@
+package packet
+
+import "encoding/binary"
+
+const (
+	parseHeader = iota
+	parseBody
+	parseVariable
+	parsePayload
+)
+
+// A Parser incrementally decodes packets from bytes as they arrive. Unlike the
+// Decoder, which reads from an io.Reader, the parser is fed with the bytes
+// that are available e.g. from a single read of a net.Conn and never blocks.
+// Payloads of large publish packets are not buffered but returned in chunks
+// as they arrive, which keeps the memory usage low on slow links.
+//
+// Note: The parser is not safe for concurrent use. If an error is returned,
+// the parser is in an undefined state and must not be used anymore.
+type Parser struct {
+	// The maximum size of a packet. If the limit is greater than zero and a
+	// packet exceeds the limit, ErrReadLimitExceeded is returned.
+	Limit int64
+
+	// The payloads of publish packets that exceed the threshold are not
+	// buffered. Instead, the packet is returned without a payload once its
+	// header has been parsed and the payload is returned in chunks by the
+	// following calls to Parse. A negative threshold disables streaming.
+	Threshold int64
+
+	version   byte
+	state     int
+	buffer    []byte
+	hl        int
+	length    int
+	remaining int64
+}
+
+// NewParser returns a new Parser that does not stream payloads.
+func NewParser() *Parser {
+	return &Parser{
+		Threshold: -1,
+	}
+}
+
+// SetVersion sets the protocol level that is used to decode packets. Packets
+// are decoded using MQTT 3.1.1 unless protocol level 5 is set. The parser
+// switches to the protocol level of a parsed ConnectPacket.
+func (p *Parser) SetVersion(version byte) {
+	p.version = version
+}
+
+// Version returns the protocol level that is used to decode packets.
+func (p *Parser) Version() byte {
+	return p.version
+}
+
+// Remaining returns the number of payload bytes of the current streamed
+// publish packet that have not yet been returned.
+func (p *Parser) Remaining() int64 {
+	return p.remaining
+}
+
+// Parse consumes bytes from the slice until a packet or a chunk of a streamed
+// payload is complete or the bytes are exhausted. It returns the number of
+// consumed bytes and the completed packet or payload chunk, if any. The rest
+// of the bytes must be passed to the next call. A streamed publish packet is
+// returned without a payload and Remaining reports the size of the payload
+// that is returned by the following calls. The payload chunks alias the
+// passed slice.
+func (p *Parser) Parse(src []byte) (int, GenericPacket, []byte, error) {
+	n := 0
+
+	for {
+		switch p.state {
+		case parseHeader:
+			// check bytes
+			if n >= len(src) {
+				return n, nil, nil, nil
+			}
+
+			// add byte
+			p.buffer = append(p.buffer, src[n])
+			n++
+
+			// detect packet
+			length, packetType := DetectPacket(p.buffer)
+			if length <= 0 {
+				if len(p.buffer) >= 5 {
+					return n, nil, nil, ErrDetectionOverflow
+				}
+
+				continue
+			}
+
+			// check read limit
+			if p.Limit > 0 && int64(length) > p.Limit {
+				return n, nil, nil, ErrReadLimitExceeded
+			}
+
+			// set lengths
+			p.hl = len(p.buffer)
+			p.length = length
+
+			// stream payload of large publish packets
+			if packetType == PUBLISH && p.Threshold >= 0 && int64(length-p.hl) > p.Threshold {
+				p.state = parseVariable
+			} else {
+				p.state = parseBody
+			}
+
+		case parseBody:
+			// add bytes
+			c := p.length - len(p.buffer)
+			if c > len(src)-n {
+				c = len(src) - n
+			}
+			p.buffer = append(p.buffer, src[n:n+c]...)
+			n += c
+
+			// check packet
+			if len(p.buffer) < p.length {
+				return n, nil, nil, nil
+			}
+
+			// decode packet
+			pkt, err := p.decode(p.buffer)
+			if err != nil {
+				return n, nil, nil, err
+			}
+
+			// reset state
+			p.buffer = p.buffer[:0]
+			p.state = parseHeader
+
+			return n, pkt, nil, nil
+
+		case parseVariable:
+			// get flags and remaining length
+			flags := p.buffer[0]
+			rl := p.length - p.hl
+
+			// get variable header length
+			vl, err := publishHeaderLen(p.buffer[p.hl:], (flags>>1)&0x3, p.version)
+			if err != nil {
+				return n, nil, nil, shiftError(err, p.hl, "")
+			} else if vl > rl {
+				return n, nil, nil, decodeError(PUBLISH, "remaining length", 1, "remaining length (%d) is smaller than header", rl)
+			}
+
+			// add bytes until the variable header is complete
+			if len(p.buffer)-p.hl < vl {
+				if n >= len(src) {
+					return n, nil, nil, nil
+				}
+
+				c := vl - (len(p.buffer) - p.hl)
+				if c > len(src)-n {
+					c = len(src) - n
+				}
+				p.buffer = append(p.buffer, src[n:n+c]...)
+				n += c
+
+				continue
+			}
+
+			// buffer payload if it does not exceed the threshold
+			size := int64(rl - vl)
+			if size <= p.Threshold {
+				p.state = parseBody
+				continue
+			}
+
+			// write fixed header for the variable header in front of it
+			fl := headerLen(vl)
+			buf := p.buffer[p.hl-fl:]
+			buf[0] = flags
+			binary.PutUvarint(buf[1:], uint64(vl))
+
+			// decode packet
+			pkt := NewPublishPacket()
+			_, err = Decode(pkt, buf, p.version)
+			if err != nil {
+				return n, nil, nil, err
+			}
+
+			// prepare payload
+			p.buffer = p.buffer[:0]
+			p.remaining = size
+			p.state = parsePayload
+
+			return n, pkt, nil, nil
+
+		case parsePayload:
+			// check bytes
+			if n >= len(src) {
+				return n, nil, nil, nil
+			}
+
+			// get chunk
+			c := len(src) - n
+			if int64(c) > p.remaining {
+				c = int(p.remaining)
+			}
+			chunk := src[n : n+c]
+			n += c
+
+			// finish payload
+			p.remaining -= int64(c)
+			if p.remaining == 0 {
+				p.state = parseHeader
+			}
+
+			return n, nil, chunk, nil
+		}
+	}
+}
+
+// decode will decode a buffered packet
+func (p *Parser) decode(buf []byte) (GenericPacket, error) {
+	// create packet
+	pkt, err := Type(buf[0] >> 4).New()
+	if err != nil {
+		return nil, err
+	}
+
+	// decode packet
+	_, err = Decode(pkt, buf, p.version)
+	if err != nil {
+		return nil, err
+	}
+
+	// switch to the protocol level of the client
+	if connect, ok := pkt.(*ConnectPacket); ok {
+		p.version = connect.Version
+	}
+
+	return pkt, nil
+}
+
+// publishHeaderLen returns the length of the variable header of a publish
+// packet. If the bytes are not sufficient to determine the length, a length
+// that exceeds the bytes is returned.
+func publishHeaderLen(src []byte, qos, version byte) (int, error) {
+	// get topic
+	if len(src) < 2 {
+		return 2, nil
+	}
+	n := 2 + int(binary.BigEndian.Uint16(src))
+
+	// get packet id
+	if qos > 0 {
+		n += 2
+	}
+
+	// get properties
+	if version == Version5 {
+		if len(src) <= n {
+			return n + 1, nil
+		}
+
+		// read properties length
+		pl, vn := binary.Uvarint(src[n:])
+		if vn < 0 || (vn == 0 && len(src)-n >= 4) || vn > 4 {
+			return 0, decodeError(PUBLISH, "properties", n, "error reading properties length")
+		} else if vn == 0 {
+			return len(src) + 1, nil
+		}
+
+		n += vn + int(pl)
+	}
+
+	return n, nil
+}
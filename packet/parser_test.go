@@ -0,0 +1,206 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseAll(t *testing.T, p *Parser, src []byte, size int) ([]GenericPacket, [][]byte) {
+	var pkts []GenericPacket
+	var chunks [][]byte
+
+	for len(src) > 0 {
+		// get next part
+		part := src
+		if len(part) > size {
+			part = part[:size]
+		}
+		src = src[len(part):]
+
+		// parse part
+		for len(part) > 0 {
+			n, pkt, chunk, err := p.Parse(part)
+			assert.NoError(t, err)
+			part = part[n:]
+
+			if pkt != nil {
+				pkts = append(pkts, pkt)
+			}
+			if chunk != nil {
+				chunks = append(chunks, append([]byte{}, chunk...))
+			}
+		}
+	}
+
+	return pkts, chunks
+}
+
+func TestParser(t *testing.T) {
+	connect := NewConnectPacket()
+	connect.ClientID = "test"
+	connect.Version = Version5
+
+	publish := NewPublishPacket()
+	publish.ID = 1
+	publish.Message = Message{
+		Topic:   "foo/bar",
+		Payload: []byte("baz"),
+		QOS:     QOSAtLeastOnce,
+	}
+
+	var buf bytes.Buffer
+	stream := NewStream(nil, &buf)
+	for _, pkt := range []GenericPacket{connect, publish, NewPingreqPacket()} {
+		assert.NoError(t, stream.Write(pkt))
+	}
+	assert.NoError(t, stream.Flush())
+
+	for _, size := range []int{1, 2, 7, 1024} {
+		p := NewParser()
+		pkts, chunks := parseAll(t, p, buf.Bytes(), size)
+		assert.Equal(t, []GenericPacket{connect, publish, NewPingreqPacket()}, pkts)
+		assert.Empty(t, chunks)
+		assert.Equal(t, Version5, p.Version())
+	}
+}
+
+func TestParserStream(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		for _, qos := range []byte{0, 1, 2} {
+			publish := NewPublishPacket()
+			publish.Message = Message{
+				Topic:   "foo/bar",
+				Payload: bytes.Repeat([]byte("x"), 1000),
+				QOS:     qos,
+			}
+			if qos > 0 {
+				publish.ID = 7
+			}
+			if version == Version5 {
+				publish.Properties = Properties{}.Set(ContentTypeProperty, "text/plain")
+			}
+
+			small := NewPublishPacket()
+			small.Message = Message{
+				Topic:   "foo",
+				Payload: []byte("bar"),
+			}
+
+			src := make([]byte, Len(publish, version)+Len(small, version))
+			n, err := Encode(publish, src, version)
+			assert.NoError(t, err)
+			_, err = Encode(small, src[n:], version)
+			assert.NoError(t, err)
+
+			for _, size := range []int{1, 3, 100, 4096} {
+				p := NewParser()
+				p.SetVersion(version)
+				p.Threshold = 100
+
+				pkts, chunks := parseAll(t, p, src, size)
+				assert.Len(t, pkts, 2)
+				assert.Equal(t, publish.Message.Payload, bytes.Join(chunks, nil))
+				assert.Equal(t, int64(0), p.Remaining())
+
+				streamed := pkts[0].(*PublishPacket)
+				assert.Empty(t, streamed.Message.Payload)
+				streamed.Message.Payload = publish.Message.Payload
+				assert.Equal(t, publish, streamed)
+				assert.Equal(t, small, pkts[1])
+			}
+		}
+	}
+}
+
+func TestParserRemaining(t *testing.T) {
+	publish := NewPublishPacket()
+	publish.Message = Message{
+		Topic:   "foo",
+		Payload: []byte("0123456789"),
+	}
+
+	src := make([]byte, publish.Len())
+	_, err := publish.Encode(src)
+	assert.NoError(t, err)
+
+	p := NewParser()
+	p.Threshold = 0
+
+	n, pkt, chunk, err := p.Parse(src)
+	assert.NoError(t, err)
+	assert.Equal(t, len(src)-10, n)
+	assert.Equal(t, "foo", pkt.(*PublishPacket).Message.Topic)
+	assert.Nil(t, chunk)
+	assert.Equal(t, int64(10), p.Remaining())
+
+	n, pkt, chunk, err = p.Parse(src[len(src)-10 : len(src)-5])
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Nil(t, pkt)
+	assert.Equal(t, []byte("01234"), chunk)
+	assert.Equal(t, int64(5), p.Remaining())
+
+	n, pkt, chunk, err = p.Parse(src[len(src)-5:])
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Nil(t, pkt)
+	assert.Equal(t, []byte("56789"), chunk)
+	assert.Equal(t, int64(0), p.Remaining())
+
+	n, pkt, chunk, err = p.Parse(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Nil(t, pkt)
+	assert.Nil(t, chunk)
+}
+
+func TestParserErrors(t *testing.T) {
+	p := NewParser()
+	p.Limit = 2
+	_, _, _, err := p.Parse([]byte{byte(PUBLISH << 4), 10})
+	assert.Equal(t, ErrReadLimitExceeded, err)
+
+	p = NewParser()
+	_, _, _, err = p.Parse([]byte{0x10, 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, ErrDetectionOverflow, err)
+
+	p = NewParser()
+	_, _, _, err = p.Parse([]byte{0x00, 0x00})
+	assert.Error(t, err)
+
+	p = NewParser()
+	p.Threshold = 0
+	_, _, _, err = p.Parse([]byte{byte(PUBLISH << 4), 3, 0, 5, 'f'})
+	assert.Error(t, err)
+}
+
+func BenchmarkParser(b *testing.B) {
+	publish := NewPublishPacket()
+	publish.Message.Topic = "t"
+	publish.Message.Payload = make([]byte, 64*1024)
+
+	src := make([]byte, publish.Len())
+	_, err := publish.Encode(src)
+	if err != nil {
+		panic(err)
+	}
+
+	p := NewParser()
+	p.Threshold = 1024
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for off := 0; off < len(src); {
+			n, _, _, err := p.Parse(src[off:])
+			if err != nil {
+				panic(err)
+			}
+			off += n
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package packet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// A PublishPool manages reusable publish packets. It allows a broker to decode
+// a publish packet once and forward it to many subscribers without allocating
+// or copying the packet per recipient.
+type PublishPool struct {
+	pool sync.Pool
+}
+
+// NewPublishPool creates a new PublishPool.
+func NewPublishPool() *PublishPool {
+	p := &PublishPool{}
+	p.pool.New = func() interface{} {
+		pp := &PooledPublish{pool: p}
+		pp.Packet.pooled = pp
+		return pp
+	}
+
+	return p
+}
+
+// Acquire will return an empty pooled publish packet with a reference count
+// of one.
+func (p *PublishPool) Acquire() *PooledPublish {
+	pp := p.pool.Get().(*PooledPublish)
+	pp.refs = 1
+
+	return pp
+}
+
+// A PooledPublish is a reference counted publish packet that is returned to
+// its pool once the last reference has been released. The packet must be
+// treated as read-only while it is shared and must not be used after the
+// reference has been released. Users that keep the message beyond that point
+// must copy it.
+type PooledPublish struct {
+	// The shared publish packet.
+	Packet PublishPacket
+
+	pool *PublishPool
+	buf  []byte
+	refs int32
+}
+
+// Decode will decode the publish packet from the byte slice argument. The
+// payload buffer of earlier uses is reused if it has enough capacity.
+func (pp *PooledPublish) Decode(src []byte) (int, error) {
+	return pp.DecodeVersion(src, Version311)
+}
+
+// DecodeVersion will decode the publish packet using the specified protocol
+// level.
+func (pp *PooledPublish) DecodeVersion(src []byte, version byte) (int, error) {
+	n, err := pp.Packet.decode(src, pp.buf, version)
+
+	// keep payload buffer
+	if cap(pp.Packet.Message.Payload) > cap(pp.buf) {
+		pp.buf = pp.Packet.Message.Payload[:0]
+	}
+
+	return n, err
+}
+
+// Retain will increment the reference count and return the packet.
+func (pp *PooledPublish) Retain() *PooledPublish {
+	atomic.AddInt32(&pp.refs, 1)
+	return pp
+}
+
+// Release will decrement the reference count and return the packet to its
+// pool once the last reference has been released.
+func (pp *PooledPublish) Release() {
+	// decrement count
+	refs := atomic.AddInt32(&pp.refs, -1)
+	if refs > 0 {
+		return
+	} else if refs < 0 {
+		panic("release of unreferenced publish packet")
+	}
+
+	// reset packet
+	pp.Packet = PublishPacket{pooled: pp}
+
+	// recycle
+	pp.pool.pool.Put(pp)
+}
@@ -0,0 +1,98 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishPool(t *testing.T) {
+	src := NewPublishPacket()
+	src.Message.Topic = "gomqtt"
+	src.Message.Payload = []byte("send me home")
+	src.Message.QOS = 1
+	src.ID = 7
+
+	buf := make([]byte, src.Len())
+	_, err := src.Encode(buf)
+	assert.NoError(t, err)
+
+	pool := NewPublishPool()
+
+	pp := pool.Acquire()
+	n, err := pp.Decode(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	assert.Equal(t, src.Message, pp.Packet.Message)
+	assert.Equal(t, src.ID, pp.Packet.ID)
+	assert.Equal(t, pp, pp.Packet.Pooled())
+
+	assert.Equal(t, pp, pp.Retain())
+	pp.Release()
+	assert.Equal(t, "gomqtt", pp.Packet.Message.Topic)
+
+	pp.Release()
+	assert.Equal(t, PublishPacket{pooled: pp}, pp.Packet)
+	assert.Nil(t, src.Pooled())
+
+	assert.Panics(t, func() {
+		pp.Release()
+	})
+}
+
+func TestPooledPublishDecodeReuse(t *testing.T) {
+	src := NewPublishPacket()
+	src.Message.Topic = "gomqtt"
+	src.Message.Payload = []byte("send me home")
+
+	buf := make([]byte, src.Len())
+	_, err := src.Encode(buf)
+	assert.NoError(t, err)
+
+	pp := &PooledPublish{}
+
+	_, err = pp.Decode(buf)
+	assert.NoError(t, err)
+	payload := pp.Packet.Message.Payload
+
+	_, err = pp.Decode(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("send me home"), pp.Packet.Message.Payload)
+	assert.True(t, &payload[0] == &pp.Packet.Message.Payload[0])
+}
+
+func BenchmarkPublishPool(b *testing.B) {
+	src := NewPublishPacket()
+	src.Message.Topic = "gomqtt"
+	src.Message.Payload = make([]byte, 256)
+
+	buf := make([]byte, src.Len())
+	_, err := src.Encode(buf)
+	if err != nil {
+		panic(err)
+	}
+
+	pool := NewPublishPool()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pp := pool.Acquire()
+
+		_, err := pp.Decode(buf)
+		if err != nil {
+			panic(err)
+		}
+
+		// fan out to subscribers
+		for j := 0; j < 10; j++ {
+			pp.Retain()
+		}
+		for j := 0; j < 10; j++ {
+			pp.Release()
+		}
+
+		pp.Release()
+	}
+}
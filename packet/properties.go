@@ -0,0 +1,456 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const maxVarint = 268435455
+
+// A PropertyID identifies a property of an MQTT 5 packet.
+type PropertyID byte
+
+// All available property identifiers.
+const (
+	PayloadFormatProperty                   PropertyID = 0x01
+	MessageExpiryProperty                   PropertyID = 0x02
+	ContentTypeProperty                     PropertyID = 0x03
+	ResponseTopicProperty                   PropertyID = 0x08
+	CorrelationDataProperty                 PropertyID = 0x09
+	SubscriptionIdentifierProperty          PropertyID = 0x0B
+	SessionExpiryProperty                   PropertyID = 0x11
+	AssignedClientIDProperty                PropertyID = 0x12
+	ServerKeepAliveProperty                 PropertyID = 0x13
+	AuthMethodProperty                      PropertyID = 0x15
+	AuthDataProperty                        PropertyID = 0x16
+	RequestProblemInfoProperty              PropertyID = 0x17
+	WillDelayProperty                       PropertyID = 0x18
+	RequestResponseInfoProperty             PropertyID = 0x19
+	ResponseInfoProperty                    PropertyID = 0x1A
+	ServerReferenceProperty                 PropertyID = 0x1C
+	ReasonStringProperty                    PropertyID = 0x1F
+	ReceiveMaximumProperty                  PropertyID = 0x21
+	TopicAliasMaximumProperty               PropertyID = 0x22
+	TopicAliasProperty                      PropertyID = 0x23
+	MaximumQOSProperty                      PropertyID = 0x24
+	RetainAvailableProperty                 PropertyID = 0x25
+	UserProperty                            PropertyID = 0x26
+	MaximumPacketSizeProperty               PropertyID = 0x27
+	WildcardSubscriptionAvailableProperty   PropertyID = 0x28
+	SubscriptionIdentifierAvailableProperty PropertyID = 0x29
+	SharedSubscriptionAvailableProperty     PropertyID = 0x2A
+)
+
+// the encoding of property values
+type propertyKind int
+
+const (
+	byteProperty propertyKind = iota + 1
+	twoByteProperty
+	fourByteProperty
+	varintProperty
+	stringProperty
+	binaryProperty
+	pairProperty
+)
+
+// returns the kind of value the property holds
+func (id PropertyID) kind() propertyKind {
+	switch id {
+	case PayloadFormatProperty, RequestProblemInfoProperty, RequestResponseInfoProperty,
+		MaximumQOSProperty, RetainAvailableProperty, WildcardSubscriptionAvailableProperty,
+		SubscriptionIdentifierAvailableProperty, SharedSubscriptionAvailableProperty:
+		return byteProperty
+	case ServerKeepAliveProperty, ReceiveMaximumProperty, TopicAliasMaximumProperty,
+		TopicAliasProperty:
+		return twoByteProperty
+	case MessageExpiryProperty, SessionExpiryProperty, WillDelayProperty,
+		MaximumPacketSizeProperty:
+		return fourByteProperty
+	case SubscriptionIdentifierProperty:
+		return varintProperty
+	case ContentTypeProperty, ResponseTopicProperty, AssignedClientIDProperty,
+		AuthMethodProperty, ResponseInfoProperty, ServerReferenceProperty,
+		ReasonStringProperty:
+		return stringProperty
+	case CorrelationDataProperty, AuthDataProperty:
+		return binaryProperty
+	case UserProperty:
+		return pairProperty
+	}
+
+	return 0
+}
+
+// A StringPair is the value of a UserProperty.
+type StringPair struct {
+	Key   string
+	Value string
+}
+
+// A Property is a single property of an MQTT 5 packet. The type of the value
+// depends on the identifier: byte properties use uint8, two byte integers
+// uint16, four byte and variable byte integers uint32, strings string, binary
+// data []byte and user properties StringPair.
+type Property struct {
+	ID    PropertyID
+	Value interface{}
+}
+
+// String returns a string representation of the property.
+func (p Property) String() string {
+	return fmt.Sprintf("0x%02X=%v", byte(p.ID), p.Value)
+}
+
+// Properties is a list of properties of an MQTT 5 packet. The properties are
+// only transmitted when the packet is encoded using protocol level 5.
+type Properties []Property
+
+// Get returns the first property with the specified identifier.
+func (p Properties) Get(id PropertyID) (interface{}, bool) {
+	for _, property := range p {
+		if property.ID == id {
+			return property.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Set replaces all properties with the specified identifier with a single
+// property and returns the updated list.
+func (p Properties) Set(id PropertyID, value interface{}) Properties {
+	return append(p.Remove(id), Property{ID: id, Value: value})
+}
+
+// Remove returns the list without the properties that have the specified
+// identifier.
+func (p Properties) Remove(id PropertyID) Properties {
+	var list Properties
+	for _, property := range p {
+		if property.ID != id {
+			list = append(list, property)
+		}
+	}
+
+	return list
+}
+
+// UserProperties returns all user properties.
+func (p Properties) UserProperties() []StringPair {
+	var pairs []StringPair
+	for _, property := range p {
+		if pair, ok := property.Value.(StringPair); ok && property.ID == UserProperty {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	return pairs
+}
+
+// String returns a string representation of the properties.
+func (p Properties) String() string {
+	list := make([]string, 0, len(p))
+	for _, property := range p {
+		list = append(list, property.String())
+	}
+
+	return "[" + strings.Join(list, ", ") + "]"
+}
+
+// Returns the byte length of the encoded properties without the length prefix.
+func (p Properties) len() int {
+	total := 0
+
+	for _, property := range p {
+		// identifier
+		total++
+
+		// value
+		switch value := property.Value.(type) {
+		case uint8:
+			total++
+		case uint16:
+			total += 2
+		case uint32:
+			if property.ID.kind() == varintProperty {
+				total += varintLen(int(value))
+			} else {
+				total += 4
+			}
+		case string:
+			total += 2 + len(value)
+		case []byte:
+			total += 2 + len(value)
+		case StringPair:
+			total += 2 + len(value.Key) + 2 + len(value.Value)
+		}
+	}
+
+	return total
+}
+
+// Returns the byte length of the encoded properties including the length
+// prefix.
+func propertiesLen(p Properties) int {
+	l := p.len()
+	return varintLen(l) + l
+}
+
+// Returns the byte length of the encoded properties including the length
+// prefix if they are transmitted using the protocol level.
+func versionPropertiesLen(p Properties, version byte) int {
+	if version != Version5 {
+		return 0
+	}
+
+	return propertiesLen(p)
+}
+
+// Writes the properties including the length prefix if they are transmitted
+// using the protocol level.
+func writeVersionProperties(dst []byte, p Properties, version byte, t Type) (int, error) {
+	if version != Version5 {
+		return 0, nil
+	}
+
+	return writeProperties(dst, p, t)
+}
+
+// Reads the properties including the length prefix if they are transmitted
+// using the protocol level.
+func readVersionProperties(src []byte, version byte, t Type) (Properties, int, error) {
+	if version != Version5 {
+		return nil, 0, nil
+	}
+
+	return readProperties(src, t)
+}
+
+// Writes the properties including the length prefix.
+func writeProperties(dst []byte, p Properties, t Type) (int, error) {
+	// write length
+	total, err := writeVarint(dst, p.len(), t)
+	if err != nil {
+		return total, err
+	}
+
+	for _, property := range p {
+		// check buffer length
+		if len(dst) < total+1 {
+			return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", t, total+1, len(dst))
+		}
+
+		// check value
+		kind := property.ID.kind()
+		if !validPropertyValue(kind, property.Value) {
+			return total, fmt.Errorf("[%s] invalid value %v for property 0x%02X", t, property.Value, byte(property.ID))
+		}
+
+		// write identifier
+		dst[total] = byte(property.ID)
+		total++
+
+		// write value
+		n, err := writePropertyValue(dst[total:], kind, property.Value, t)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+func validPropertyValue(kind propertyKind, value interface{}) bool {
+	switch kind {
+	case byteProperty:
+		_, ok := value.(uint8)
+		return ok
+	case twoByteProperty:
+		_, ok := value.(uint16)
+		return ok
+	case fourByteProperty:
+		_, ok := value.(uint32)
+		return ok
+	case varintProperty:
+		v, ok := value.(uint32)
+		return ok && v <= maxVarint
+	case stringProperty:
+		_, ok := value.(string)
+		return ok
+	case binaryProperty:
+		_, ok := value.([]byte)
+		return ok
+	case pairProperty:
+		_, ok := value.(StringPair)
+		return ok
+	}
+
+	return false
+}
+
+func writePropertyValue(dst []byte, kind propertyKind, value interface{}, t Type) (int, error) {
+	// check fixed size buffer length
+	size := map[propertyKind]int{byteProperty: 1, twoByteProperty: 2, fourByteProperty: 4}[kind]
+	if len(dst) < size {
+		return 0, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", t, size, len(dst))
+	}
+
+	switch kind {
+	case byteProperty:
+		dst[0] = value.(uint8)
+		return 1, nil
+	case twoByteProperty:
+		binary.BigEndian.PutUint16(dst, value.(uint16))
+		return 2, nil
+	case fourByteProperty:
+		binary.BigEndian.PutUint32(dst, value.(uint32))
+		return 4, nil
+	case varintProperty:
+		return writeVarint(dst, int(value.(uint32)), t)
+	case stringProperty:
+		return writeLPString(dst, value.(string), t)
+	case binaryProperty:
+		return writeLPBytes(dst, value.([]byte), t)
+	case pairProperty:
+		pair := value.(StringPair)
+		total, err := writeLPString(dst, pair.Key, t)
+		if err != nil {
+			return total, err
+		}
+
+		n, err := writeLPString(dst[total:], pair.Value, t)
+		total += n
+
+		return total, err
+	}
+
+	return 0, nil
+}
+
+// Reads properties including the length prefix.
+func readProperties(src []byte, t Type) (Properties, int, error) {
+	// read length
+	l, total, err := readVarint(src, t)
+	if err != nil {
+		return nil, total, err
+	}
+
+	// check buffer length
+	if len(src) < total+l {
+		return nil, total, decodeError(t, "", 0, "insufficient buffer size, expected %d, got %d", total+l, len(src))
+	}
+
+	// read properties
+	var properties Properties
+	end := total + l
+	for total < end {
+		// read identifier
+		id := PropertyID(src[total])
+		total++
+
+		// read value
+		value, n, err := readPropertyValue(src[total:end], id.kind(), t)
+		total += n
+		if err != nil {
+			return nil, total, shiftError(err, total-n, fmt.Sprintf("property 0x%02X", byte(id)))
+		} else if value == nil {
+			return nil, total, decodeError(t, "", total-n-1, "invalid property identifier 0x%02X", byte(id))
+		}
+
+		properties = append(properties, Property{ID: id, Value: value})
+	}
+
+	return properties, total, nil
+}
+
+func readPropertyValue(src []byte, kind propertyKind, t Type) (interface{}, int, error) {
+	// check fixed size buffer length
+	size := map[propertyKind]int{byteProperty: 1, twoByteProperty: 2, fourByteProperty: 4}[kind]
+	if len(src) < size {
+		return nil, 0, decodeError(t, "", 0, "insufficient buffer size, expected %d, got %d", size, len(src))
+	}
+
+	switch kind {
+	case byteProperty:
+		return src[0], 1, nil
+	case twoByteProperty:
+		return binary.BigEndian.Uint16(src), 2, nil
+	case fourByteProperty:
+		return binary.BigEndian.Uint32(src), 4, nil
+	case varintProperty:
+		v, n, err := readVarint(src, t)
+		if err != nil {
+			return nil, n, err
+		}
+
+		return uint32(v), n, nil
+	case stringProperty:
+		str, n, err := readLPString(src, t)
+		if err != nil {
+			return nil, n, err
+		}
+
+		return str, n, nil
+	case binaryProperty:
+		data, n, err := readLPBytes(src, true, t)
+		if err != nil {
+			return nil, n, err
+		}
+
+		return data, n, nil
+	case pairProperty:
+		key, total, err := readLPString(src, t)
+		if err != nil {
+			return nil, total, err
+		}
+
+		value, n, err := readLPString(src[total:], t)
+		total += n
+		if err != nil {
+			return nil, total, shiftError(err, total-n, "")
+		}
+
+		return StringPair{Key: key, Value: value}, total, nil
+	}
+
+	return nil, 0, nil
+}
+
+// Returns the byte length of a variable byte integer.
+func varintLen(v int) int {
+	return headerLen(v) - 1
+}
+
+// Writes a variable byte integer.
+func writeVarint(dst []byte, v int, t Type) (int, error) {
+	// check value
+	if v < 0 || v > maxVarint {
+		return 0, fmt.Errorf("[%s] variable byte integer (%d) out of bound (max %d, min 0)", t, v, maxVarint)
+	}
+
+	// check buffer length
+	if len(dst) < varintLen(v) {
+		return 0, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", t, varintLen(v), len(dst))
+	}
+
+	return binary.PutUvarint(dst, uint64(v)), nil
+}
+
+// Reads a variable byte integer.
+func readVarint(src []byte, t Type) (int, int, error) {
+	// limit to four bytes
+	if len(src) > 4 {
+		src = src[:4]
+	}
+
+	// read value
+	v, n := binary.Uvarint(src)
+	if n <= 0 {
+		return 0, 0, decodeError(t, "", 0, "error reading variable byte integer")
+	}
+
+	return int(v), n, nil
+}
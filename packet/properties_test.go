@@ -0,0 +1,159 @@
+package packet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProperties(t *testing.T) {
+	props := Properties{
+		{ID: PayloadFormatProperty, Value: uint8(1)},
+		{ID: TopicAliasProperty, Value: uint16(7)},
+		{ID: MessageExpiryProperty, Value: uint32(60)},
+		{ID: SubscriptionIdentifierProperty, Value: uint32(300)},
+		{ID: ContentTypeProperty, Value: "text/plain"},
+		{ID: CorrelationDataProperty, Value: []byte{1, 2, 3}},
+		{ID: UserProperty, Value: StringPair{Key: "foo", Value: "bar"}},
+		{ID: UserProperty, Value: StringPair{Key: "baz", Value: "qux"}},
+	}
+
+	buf := make([]byte, propertiesLen(props))
+	n, err := writeProperties(buf, props, PUBLISH)
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+
+	decoded, n, err := readProperties(buf, PUBLISH)
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	assert.Equal(t, props, decoded)
+
+	value, ok := decoded.Get(SubscriptionIdentifierProperty)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(300), value)
+
+	_, ok = decoded.Get(ReasonStringProperty)
+	assert.False(t, ok)
+
+	assert.Equal(t, []StringPair{{"foo", "bar"}, {"baz", "qux"}}, decoded.UserProperties())
+	assert.Len(t, decoded.Remove(UserProperty), 6)
+	assert.Len(t, decoded.Set(UserProperty, StringPair{}), 7)
+	assert.Equal(t, "[0x01=1, 0x23=7]", decoded[:2].String())
+}
+
+func TestPropertiesEmpty(t *testing.T) {
+	buf := make([]byte, propertiesLen(nil))
+	assert.Equal(t, 1, len(buf))
+
+	n, err := writeProperties(buf, nil, PUBLISH)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	props, n, err := readProperties(buf, PUBLISH)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Nil(t, props)
+}
+
+func TestPropertiesErrors(t *testing.T) {
+	// invalid value type
+	props := Properties{{ID: TopicAliasProperty, Value: uint32(7)}}
+	_, err := writeProperties(make([]byte, 10), props, PUBLISH)
+	assert.Error(t, err)
+
+	// unknown identifier
+	props = Properties{{ID: 0x7F, Value: uint8(1)}}
+	_, err = writeProperties(make([]byte, 10), props, PUBLISH)
+	assert.Error(t, err)
+
+	// insufficient buffer
+	props = Properties{{ID: ContentTypeProperty, Value: "foo"}}
+	_, err = writeProperties(make([]byte, 4), props, PUBLISH)
+	assert.Error(t, err)
+
+	// unknown identifier
+	_, _, err = readProperties([]byte{2, 0x7F, 1}, PUBLISH)
+	assert.Error(t, err)
+
+	// length exceeds buffer
+	_, _, err = readProperties([]byte{5, 0x01, 1}, PUBLISH)
+	assert.Error(t, err)
+
+	// value exceeds length
+	_, _, err = readProperties([]byte{2, 0x02, 0, 0, 0, 1}, PUBLISH)
+	assert.Error(t, err)
+}
+
+func TestVarint(t *testing.T) {
+	for _, v := range []int{0, 127, 128, 16383, 16384, 2097151, 2097152, maxVarint} {
+		buf := make([]byte, varintLen(v))
+		n, err := writeVarint(buf, v, PUBLISH)
+		require.NoError(t, err)
+		assert.Equal(t, len(buf), n)
+
+		d, n, err := readVarint(buf, PUBLISH)
+		require.NoError(t, err)
+		assert.Equal(t, len(buf), n)
+		assert.Equal(t, v, d)
+	}
+
+	_, err := writeVarint(make([]byte, 4), maxVarint+1, PUBLISH)
+	assert.Error(t, err)
+
+	_, _, err = readVarint([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0x01}, PUBLISH)
+	assert.Error(t, err)
+}
+
+func TestVersionProperties(t *testing.T) {
+	props := Properties{{ID: ContentTypeProperty, Value: "text/plain"}}
+
+	for _, version := range []byte{Version31, Version311} {
+		assert.Equal(t, 0, versionPropertiesLen(props, version))
+
+		n, err := writeVersionProperties(nil, props, version, PUBLISH)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+
+		read, n, err := readVersionProperties([]byte{0x01}, version, PUBLISH)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assert.Nil(t, read)
+	}
+
+	buf := make([]byte, versionPropertiesLen(props, Version5))
+	n, err := writeVersionProperties(buf, props, Version5, PUBLISH)
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+
+	read, n, err := readVersionProperties(buf, Version5, PUBLISH)
+	assert.NoError(t, err)
+	assert.Equal(t, len(buf), n)
+	assert.Equal(t, props, read)
+}
+
+func TestExpiry(t *testing.T) {
+	props := injectExpiry(nil, 1500*time.Millisecond)
+	assert.Equal(t, Properties{{ID: MessageExpiryProperty, Value: uint32(2)}}, props)
+
+	props, expiry := extractExpiry(props)
+	assert.Empty(t, props)
+	assert.Equal(t, 2*time.Second, expiry)
+
+	assert.Nil(t, injectExpiry(nil, 0))
+}
+
+func TestReasonCode(t *testing.T) {
+	assert.True(t, Success.Valid())
+	assert.False(t, Success.Failed())
+	assert.True(t, QuotaExceeded.Failed())
+	assert.False(t, ReasonCode(0x05).Valid())
+	assert.Equal(t, "quota exceeded", QuotaExceeded.Error())
+	assert.Equal(t, "unknown reason code 0x05", ReasonCode(0x05).Error())
+
+	assert.Equal(t, ErrNotAuthorized, connackCode(NotAuthorized))
+	assert.Equal(t, ErrNotAuthorized, connackCode(Banned))
+	assert.Equal(t, ErrServerUnavailable, connackCode(ServerBusy))
+	assert.Equal(t, ConnectionAccepted, connackCode(Success))
+}
@@ -19,6 +19,12 @@ type PublishPacket struct {
 
 	// The packet identifier.
 	ID ID
+
+	// The publish properties. They are only transmitted using MQTT 5. The
+	// expiry of the message is transmitted as a MessageExpiryProperty.
+	Properties Properties
+
+	pooled *PooledPublish
 }
 
 // NewPublishPacket creates a new PublishPacket.
@@ -26,6 +32,12 @@ func NewPublishPacket() *PublishPacket {
 	return &PublishPacket{}
 }
 
+// Pooled returns the pooled publish the packet belongs to or nil if the packet
+// has not been acquired from a PublishPool.
+func (pp *PublishPacket) Pooled() *PooledPublish {
+	return pp.pooled
+}
+
 // Type returns the packets type.
 func (pp *PublishPacket) Type() Type {
 	return PUBLISH
@@ -39,13 +51,30 @@ func (pp *PublishPacket) String() string {
 
 // Len returns the byte length of the encoded packet.
 func (pp *PublishPacket) Len() int {
-	ml := pp.len()
+	return pp.LenVersion(Version311)
+}
+
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (pp *PublishPacket) LenVersion(version byte) int {
+	ml := pp.len(version)
 	return headerLen(ml) + ml
 }
 
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (pp *PublishPacket) Decode(src []byte) (int, error) {
+	return pp.decode(src, nil, Version311)
+}
+
+// DecodeVersion decodes the packet using the specified protocol level.
+func (pp *PublishPacket) DecodeVersion(src []byte, version byte) (int, error) {
+	return pp.decode(src, nil, version)
+}
+
+// decode will decode the packet and reuse the provided buffer for the payload
+// if it has enough capacity.
+func (pp *PublishPacket) decode(src, buf []byte, version byte) (int, error) {
 	total := 0
 
 	// decode header
@@ -62,12 +91,12 @@ func (pp *PublishPacket) Decode(src []byte) (int, error) {
 
 	// check qos
 	if !validQOS(pp.Message.QOS) {
-		return total, fmt.Errorf("[%s] invalid QOS level (%d)", pp.Type(), pp.Message.QOS)
+		return total, decodeError(pp.Type(), "flags", 0, "invalid QOS level (%d)", pp.Message.QOS)
 	}
 
 	// check buffer length
 	if len(src) < total+2 {
-		return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", pp.Type(), total+2, len(src))
+		return total, decodeError(pp.Type(), "topic", total, "insufficient buffer size, expected %d, got %d", total+2, len(src))
 	}
 
 	n := 0
@@ -76,13 +105,13 @@ func (pp *PublishPacket) Decode(src []byte) (int, error) {
 	pp.Message.Topic, n, err = readLPString(src[total:], pp.Type())
 	total += n
 	if err != nil {
-		return total, err
+		return total, shiftError(err, total-n, "topic")
 	}
 
 	if pp.Message.QOS != 0 {
 		// check buffer length
 		if len(src) < total+2 {
-			return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", pp.Type(), total+2, len(src))
+			return total, decodeError(pp.Type(), "packet id", total, "insufficient buffer size, expected %d, got %d", total+2, len(src))
 		}
 
 		// read packet id
@@ -91,16 +120,28 @@ func (pp *PublishPacket) Decode(src []byte) (int, error) {
 
 		// check packet id
 		if pp.ID == 0 {
-			return total, fmt.Errorf("[%s] packet id must be grater than zero", pp.Type())
+			return total, decodeError(pp.Type(), "packet id", total-2, "packet id must be grater than zero")
 		}
 	}
 
+	// read properties
+	pp.Properties, n, err = readVersionProperties(src[total:hl+rl], version, pp.Type())
+	total += n
+	if err != nil {
+		return total, shiftError(err, total-n, "properties")
+	}
+	pp.Properties, pp.Message.Expiry = extractExpiry(pp.Properties)
+
 	// calculate payload length
 	l := int(rl) - (total - hl)
 
 	// read payload
 	if l > 0 {
-		pp.Message.Payload = make([]byte, l)
+		if cap(buf) >= l {
+			pp.Message.Payload = buf[:l]
+		} else {
+			pp.Message.Payload = make([]byte, l)
+		}
 		copy(pp.Message.Payload, src[total:total+l])
 		total += len(pp.Message.Payload)
 	}
@@ -112,6 +153,40 @@ func (pp *PublishPacket) Decode(src []byte) (int, error) {
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
 func (pp *PublishPacket) Encode(dst []byte) (int, error) {
+	return pp.EncodeVersion(dst, Version311)
+}
+
+// EncodeVersion encodes the packet using the specified protocol level.
+func (pp *PublishPacket) EncodeVersion(dst []byte, version byte) (int, error) {
+	// encode header
+	total, err := pp.encodeHeader(dst, len(pp.Message.Payload), pp.LenVersion(version), version)
+	if err != nil {
+		return total, err
+	}
+
+	// write payload
+	copy(dst[total:], pp.Message.Payload)
+	total += len(pp.Message.Payload)
+
+	return total, nil
+}
+
+// HeaderLen returns the byte length of the packet encoded without the payload
+// using the specified protocol level. The payload of the packet is ignored.
+func (pp *PublishPacket) HeaderLen(payloadLen int, version byte) int {
+	rl := pp.remainingLen(payloadLen, version)
+	return headerLen(rl) + rl - payloadLen
+}
+
+// EncodeHeader encodes the packet without the payload using the specified
+// protocol level. The encoded remaining length announces payloadLen bytes of
+// payload that must be written directly after the header. The payload of the
+// packet is ignored. This allows payloads to be streamed.
+func (pp *PublishPacket) EncodeHeader(dst []byte, payloadLen int, version byte) (int, error) {
+	return pp.encodeHeader(dst, payloadLen, pp.HeaderLen(payloadLen, version), version)
+}
+
+func (pp *PublishPacket) encodeHeader(dst []byte, payloadLen, tl int, version byte) (int, error) {
 	total := 0
 
 	// check topic length
@@ -149,7 +224,7 @@ func (pp *PublishPacket) Encode(dst []byte) (int, error) {
 	flags = (flags & 249) | (pp.Message.QOS << 1) // 249 = 11111001
 
 	// encode header
-	n, err := headerEncode(dst[total:], flags, pp.len(), pp.Len(), PUBLISH)
+	n, err := headerEncode(dst[total:], flags, pp.remainingLen(payloadLen, version), tl, PUBLISH)
 	total += n
 	if err != nil {
 		return total, err
@@ -168,17 +243,42 @@ func (pp *PublishPacket) Encode(dst []byte) (int, error) {
 		total += 2
 	}
 
-	// write payload
-	copy(dst[total:], pp.Message.Payload)
-	total += len(pp.Message.Payload)
+	// write properties
+	n, err = writeVersionProperties(dst[total:], injectExpiry(pp.Properties, pp.Message.Expiry), version, pp.Type())
+	total += n
+	if err != nil {
+		return total, err
+	}
 
 	return total, nil
 }
 
 // Returns the payload length.
-func (pp *PublishPacket) len() int {
-	total := 2 + len(pp.Message.Topic) + len(pp.Message.Payload)
-	if pp.Message.QOS != 0 {
+func (pp *PublishPacket) len(version byte) int {
+	return pp.remainingLen(len(pp.Message.Payload), version)
+}
+
+// Returns the remaining length for a payload of the specified length.
+func (pp *PublishPacket) remainingLen(payloadLen int, version byte) int {
+	total := publishLen(pp.Message.Topic, payloadLen, pp.Message.QOS)
+
+	// add the properties length
+	total += versionPropertiesLen(injectExpiry(pp.Properties, pp.Message.Expiry), version)
+
+	return total
+}
+
+// TotalSize returns the byte length of an encoded PublishPacket with the
+// specified topic, payload length and QOS level. It can be used to check a
+// message against a maximum packet size before building the payload.
+func TotalSize(topic string, payloadLen int, qos byte) int {
+	ml := publishLen(topic, payloadLen, qos)
+	return headerLen(ml) + ml
+}
+
+func publishLen(topic string, payloadLen int, qos byte) int {
+	total := 2 + len(topic) + payloadLen
+	if qos != 0 {
 		total += 2
 	}
 
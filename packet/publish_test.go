@@ -2,6 +2,7 @@ package packet
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -280,6 +281,19 @@ func TestPublishEqualDecodeEncode(t *testing.T) {
 	assert.Equal(t, len(pktBytes), n3)
 }
 
+func TestTotalSize(t *testing.T) {
+	for _, qos := range []byte{0, 1, 2} {
+		for _, size := range []int{0, 100, 20000, 3000000} {
+			pkt := NewPublishPacket()
+			pkt.Message.Topic = "foo/bar"
+			pkt.Message.Payload = make([]byte, size)
+			pkt.Message.QOS = qos
+
+			assert.Equal(t, pkt.Len(), TotalSize("foo/bar", size, qos))
+		}
+	}
+}
+
 func BenchmarkPublishEncode(b *testing.B) {
 	pkt := NewPublishPacket()
 	pkt.Message.Topic = "t"
@@ -318,3 +332,30 @@ func BenchmarkPublishDecode(b *testing.B) {
 		}
 	}
 }
+
+func TestPublishPacketEncodeDecode5(t *testing.T) {
+	pkt := NewPublishPacket()
+	pkt.ID = 7
+	pkt.Message = Message{
+		Topic:   "foo",
+		Payload: []byte("bar"),
+		QOS:     QOSAtLeastOnce,
+		Expiry:  time.Minute,
+	}
+	pkt.Properties = Properties{
+		{ID: ResponseTopicProperty, Value: "baz"},
+		{ID: UserProperty, Value: StringPair{Key: "a", Value: "b"}},
+	}
+
+	out := NewPublishPacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+
+	// without properties
+	pkt = NewPublishPacket()
+	pkt.Message = Message{Topic: "foo", Payload: []byte("bar")}
+
+	buf := roundTrip(t, pkt, NewPublishPacket(), Version5)
+	assert.Equal(t, []byte{byte(PUBLISH << 4), 9, 0, 3, 'f', 'o', 'o', 0, 'b', 'a', 'r'}, buf)
+	assert.Equal(t, pkt.LenVersion(Version311)+1, pkt.LenVersion(Version5))
+}
@@ -0,0 +1,145 @@
+package packet
+
+import "fmt"
+
+// A ReasonCode indicates the result of an operation in MQTT 5 packets. Codes
+// below 0x80 indicate success and codes of 0x80 or greater indicate failure.
+type ReasonCode byte
+
+// All available ReasonCodes.
+const (
+	Success                             ReasonCode = 0x00
+	GrantedQOS1                         ReasonCode = 0x01
+	GrantedQOS2                         ReasonCode = 0x02
+	DisconnectWithWill                  ReasonCode = 0x04
+	NoMatchingSubscribers               ReasonCode = 0x10
+	NoSubscriptionExisted               ReasonCode = 0x11
+	ContinueAuthentication              ReasonCode = 0x18
+	ReAuthenticate                      ReasonCode = 0x19
+	UnspecifiedError                    ReasonCode = 0x80
+	MalformedPacket                     ReasonCode = 0x81
+	ProtocolError                       ReasonCode = 0x82
+	ImplementationSpecificError         ReasonCode = 0x83
+	UnsupportedProtocolVersion          ReasonCode = 0x84
+	ClientIdentifierNotValid            ReasonCode = 0x85
+	BadUsernameOrPassword               ReasonCode = 0x86
+	NotAuthorized                       ReasonCode = 0x87
+	ServerUnavailable                   ReasonCode = 0x88
+	ServerBusy                          ReasonCode = 0x89
+	Banned                              ReasonCode = 0x8A
+	ServerShuttingDownReason            ReasonCode = 0x8B
+	BadAuthenticationMethod             ReasonCode = 0x8C
+	KeepAliveTimeout                    ReasonCode = 0x8D
+	SessionTakenOver                    ReasonCode = 0x8E
+	TopicFilterInvalid                  ReasonCode = 0x8F
+	TopicNameInvalid                    ReasonCode = 0x90
+	PacketIdentifierInUse               ReasonCode = 0x91
+	PacketIdentifierNotFound            ReasonCode = 0x92
+	ReceiveMaximumExceeded              ReasonCode = 0x93
+	TopicAliasInvalid                   ReasonCode = 0x94
+	PacketTooLarge                      ReasonCode = 0x95
+	MessageRateTooHigh                  ReasonCode = 0x96
+	QuotaExceeded                       ReasonCode = 0x97
+	AdministrativeAction                ReasonCode = 0x98
+	PayloadFormatInvalid                ReasonCode = 0x99
+	RetainNotSupported                  ReasonCode = 0x9A
+	QOSNotSupported                     ReasonCode = 0x9B
+	UseAnotherServer                    ReasonCode = 0x9C
+	ServerMoved                         ReasonCode = 0x9D
+	SharedSubscriptionsNotSupported     ReasonCode = 0x9E
+	ConnectionRateExceeded              ReasonCode = 0x9F
+	MaximumConnectTime                  ReasonCode = 0xA0
+	SubscriptionIdentifiersNotSupported ReasonCode = 0xA1
+	WildcardSubscriptionsNotSupported   ReasonCode = 0xA2
+)
+
+var reasonCodeNames = map[ReasonCode]string{
+	Success:                             "success",
+	GrantedQOS1:                         "granted qos 1",
+	GrantedQOS2:                         "granted qos 2",
+	DisconnectWithWill:                  "disconnect with will message",
+	NoMatchingSubscribers:               "no matching subscribers",
+	NoSubscriptionExisted:               "no subscription existed",
+	ContinueAuthentication:              "continue authentication",
+	ReAuthenticate:                      "re-authenticate",
+	UnspecifiedError:                    "unspecified error",
+	MalformedPacket:                     "malformed packet",
+	ProtocolError:                       "protocol error",
+	ImplementationSpecificError:         "implementation specific error",
+	UnsupportedProtocolVersion:          "unsupported protocol version",
+	ClientIdentifierNotValid:            "client identifier not valid",
+	BadUsernameOrPassword:               "bad user name or password",
+	NotAuthorized:                       "not authorized",
+	ServerUnavailable:                   "server unavailable",
+	ServerBusy:                          "server busy",
+	Banned:                              "banned",
+	ServerShuttingDownReason:            "server shutting down",
+	BadAuthenticationMethod:             "bad authentication method",
+	KeepAliveTimeout:                    "keep alive timeout",
+	SessionTakenOver:                    "session taken over",
+	TopicFilterInvalid:                  "topic filter invalid",
+	TopicNameInvalid:                    "topic name invalid",
+	PacketIdentifierInUse:               "packet identifier in use",
+	PacketIdentifierNotFound:            "packet identifier not found",
+	ReceiveMaximumExceeded:              "receive maximum exceeded",
+	TopicAliasInvalid:                   "topic alias invalid",
+	PacketTooLarge:                      "packet too large",
+	MessageRateTooHigh:                  "message rate too high",
+	QuotaExceeded:                       "quota exceeded",
+	AdministrativeAction:                "administrative action",
+	PayloadFormatInvalid:                "payload format invalid",
+	RetainNotSupported:                  "retain not supported",
+	QOSNotSupported:                     "qos not supported",
+	UseAnotherServer:                    "use another server",
+	ServerMoved:                         "server moved",
+	SharedSubscriptionsNotSupported:     "shared subscriptions not supported",
+	ConnectionRateExceeded:              "connection rate exceeded",
+	MaximumConnectTime:                  "maximum connect time",
+	SubscriptionIdentifiersNotSupported: "subscription identifiers not supported",
+	WildcardSubscriptionsNotSupported:   "wildcard subscriptions not supported",
+}
+
+// Valid checks if the ReasonCode is defined by the specification.
+func (rc ReasonCode) Valid() bool {
+	_, ok := reasonCodeNames[rc]
+	return ok
+}
+
+// Failed returns whether the ReasonCode indicates a failure.
+func (rc ReasonCode) Failed() bool {
+	return rc >= UnspecifiedError
+}
+
+// Error returns the corresponding error string for the ReasonCode.
+func (rc ReasonCode) Error() string {
+	if name, ok := reasonCodeNames[rc]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown reason code 0x%02X", byte(rc))
+}
+
+// connackReasons maps the MQTT 3 return codes to MQTT 5 reason codes.
+var connackReasons = map[ConnackCode]ReasonCode{
+	ConnectionAccepted:        Success,
+	ErrInvalidProtocolVersion: UnsupportedProtocolVersion,
+	ErrIdentifierRejected:     ClientIdentifierNotValid,
+	ErrServerUnavailable:      ServerUnavailable,
+	ErrBadUsernameOrPassword:  BadUsernameOrPassword,
+	ErrNotAuthorized:          NotAuthorized,
+}
+
+// returns the MQTT 3 return code that best matches the reason code
+func connackCode(rc ReasonCode) ConnackCode {
+	for code, reason := range connackReasons {
+		if reason == rc {
+			return code
+		}
+	}
+
+	if rc == Banned || rc == BadAuthenticationMethod {
+		return ErrNotAuthorized
+	}
+
+	return ErrServerUnavailable
+}
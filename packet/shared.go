@@ -0,0 +1,46 @@
+package packet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SharePrefix is the prefix of MQTT 5 shared subscription filters.
+const SharePrefix = "$share/"
+
+// SharedFilter returns the shared subscription filter for the group. Messages
+// matching the filter are distributed among the subscribers of the group.
+func SharedFilter(group, filter string) string {
+	return SharePrefix + group + "/" + filter
+}
+
+// ParseShared splits a shared subscription filter into the share name and the
+// topic filter. If the filter is not a shared subscription, an empty group
+// and the unchanged filter are returned. An error is returned if the share
+// name is empty or contains wildcards or the topic filter is missing.
+func ParseShared(filter string) (string, string, error) {
+	// check prefix
+	if !strings.HasPrefix(filter, SharePrefix) {
+		return "", filter, nil
+	}
+
+	// split group and filter
+	rest := filter[len(SharePrefix):]
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		return "", "", fmt.Errorf("shared subscription %q has no topic filter", filter)
+	}
+	group, topic := rest[:i], rest[i+1:]
+
+	// check group
+	if group == "" || strings.ContainsAny(group, "+#") {
+		return "", "", fmt.Errorf("shared subscription %q has an invalid share name", filter)
+	}
+
+	// check topic
+	if topic == "" {
+		return "", "", fmt.Errorf("shared subscription %q has no topic filter", filter)
+	}
+
+	return group, topic, nil
+}
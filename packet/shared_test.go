@@ -0,0 +1,24 @@
+package packet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseShared(t *testing.T) {
+	group, filter, err := ParseShared(SharedFilter("group", "foo/#"))
+	assert.NoError(t, err)
+	assert.Equal(t, "group", group)
+	assert.Equal(t, "foo/#", filter)
+
+	group, filter, err = ParseShared("foo/#")
+	assert.NoError(t, err)
+	assert.Equal(t, "", group)
+	assert.Equal(t, "foo/#", filter)
+
+	for _, str := range []string{"$share/", "$share/group", "$share//foo", "$share/+/foo", "$share/g#/foo", "$share/group/"} {
+		_, _, err = ParseShared(str)
+		assert.Error(t, err, str)
+	}
+}
@@ -3,8 +3,13 @@ package packet
 import (
 	"bufio"
 	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ErrDetectionOverflow is returned by the Decoder if the next packet couldn't
@@ -14,13 +19,23 @@ var ErrDetectionOverflow = errors.New("detection overflow")
 // ErrReadLimitExceeded can be returned during a Receive if the connection
 // exceeded its read limit.
 //
-// Note: this error is wrapped in an Error with a NetworkError code.
+// Note: this error is wrapped in a transport.Error with the ErrDecode kind when
+// returned by a transport connection.
 var ErrReadLimitExceeded = errors.New("read limit exceeded")
 
 // An Encoder wraps a Writer and continuously encodes packets.
 type Encoder struct {
 	writer *bufio.Writer
 	buffer bytes.Buffer
+
+	flushInterval time.Duration
+	flushTimer    *time.Timer
+	flushPending  bool
+	flushError    error
+
+	version uint32
+
+	mutex sync.Mutex
 }
 
 // NewEncoder creates a new Encoder.
@@ -30,16 +45,76 @@ func NewEncoder(writer io.Writer) *Encoder {
 	}
 }
 
+// NewEncoderSize creates a new Encoder with a write buffer of at least the
+// specified size. Packets are written to the underlying writer once the buffer
+// is full or Flush is called.
+func NewEncoderSize(writer io.Writer, size int) *Encoder {
+	return &Encoder{
+		writer: bufio.NewWriterSize(writer, size),
+	}
+}
+
+// SetAutoFlush enables flushing the write buffer automatically at most the
+// specified interval after a packet has been written. Errors that occur while
+// flushing in the background are returned by the next call to Write or Flush.
+// A zero interval disables automatic flushing. The encoder is only synchronized
+// if automatic flushing is enabled, the method must therefore not be called
+// concurrently with other methods.
+func (e *Encoder) SetAutoFlush(interval time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// cancel scheduled flush
+	if interval == 0 && e.flushPending {
+		e.flushTimer.Stop()
+		e.flushPending = false
+	}
+
+	e.flushInterval = interval
+}
+
+// SetVersion sets the protocol level that is used to encode packets. Packets
+// are encoded using MQTT 3.1.1 unless protocol level 5 is set.
+func (e *Encoder) SetVersion(version byte) {
+	atomic.StoreUint32(&e.version, uint32(version))
+}
+
+// Buffered returns the number of bytes that have been written to the write
+// buffer but not yet flushed.
+func (e *Encoder) Buffered() int {
+	// synchronize with background flushes
+	if e.flushInterval > 0 {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+	}
+
+	return e.writer.Buffered()
+}
+
 // Write encodes and writes the passed packet to the write buffer.
 func (e *Encoder) Write(pkt GenericPacket) error {
+	// synchronize with background flushes
+	if e.flushInterval > 0 {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+	}
+
+	// return any error from the background flush
+	if e.flushError != nil {
+		return e.flushError
+	}
+
+	// get version
+	version := byte(atomic.LoadUint32(&e.version))
+
 	// reset and eventually grow buffer
-	packetLength := pkt.Len()
+	packetLength := Len(pkt, version)
 	e.buffer.Reset()
 	e.buffer.Grow(packetLength)
 	buf := e.buffer.Bytes()[0:packetLength]
 
 	// encode packet
-	_, err := pkt.Encode(buf)
+	_, err := Encode(pkt, buf, version)
 	if err != nil {
 		return err
 	}
@@ -50,20 +125,136 @@ func (e *Encoder) Write(pkt GenericPacket) error {
 		return err
 	}
 
+	// schedule flush
+	e.scheduleFlush()
+
 	return nil
 }
 
+// WriteStream encodes the publish packet and writes it to the write buffer
+// while the payload of the specified size is copied from the reader. The
+// payload of the packet is ignored. Large payloads bypass the write buffer and
+// are passed to the underlying writer directly.
+//
+// Note: If the reader fails or does not provide enough bytes, the packet has
+// been written partially and the stream must not be used anymore.
+func (e *Encoder) WriteStream(pkt *PublishPacket, payload io.Reader, size int64) error {
+	// synchronize with background flushes
+	if e.flushInterval > 0 {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+	}
+
+	// return any error from the background flush
+	if e.flushError != nil {
+		return e.flushError
+	}
+
+	// get version
+	version := byte(atomic.LoadUint32(&e.version))
+
+	// check size
+	if size < 0 || size > maxRemainingLength {
+		return fmt.Errorf("[%s] payload size (%d) out of bound (max %d, min 0)", pkt.Type(), size, maxRemainingLength)
+	}
+
+	// reset and eventually grow buffer
+	headerLength := pkt.HeaderLen(int(size), version)
+	e.buffer.Reset()
+	e.buffer.Grow(headerLength)
+	buf := e.buffer.Bytes()[0:headerLength]
+
+	// encode header
+	_, err := pkt.EncodeHeader(buf, int(size), version)
+	if err != nil {
+		return err
+	}
+
+	// write header
+	_, err = e.writer.Write(buf)
+	if err != nil {
+		return err
+	}
+
+	// copy payload
+	_, err = io.CopyN(e.writer, payload, size)
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	} else if err != nil {
+		return err
+	}
+
+	// schedule flush
+	e.scheduleFlush()
+
+	return nil
+}
+
+// schedules a flush if enabled
+func (e *Encoder) scheduleFlush() {
+	if e.flushInterval > 0 && !e.flushPending && e.writer.Buffered() > 0 {
+		if e.flushTimer == nil {
+			e.flushTimer = time.AfterFunc(e.flushInterval, e.autoFlush)
+		} else {
+			e.flushTimer.Reset(e.flushInterval)
+		}
+
+		e.flushPending = true
+	}
+}
+
 // Flush flushes the writer buffer.
 func (e *Encoder) Flush() error {
+	// synchronize with background flushes
+	if e.flushInterval > 0 {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+	}
+
+	// cancel scheduled flush
+	if e.flushPending {
+		e.flushTimer.Stop()
+		e.flushPending = false
+	}
+
+	// return any error from the background flush
+	if e.flushError != nil {
+		return e.flushError
+	}
+
 	return e.writer.Flush()
 }
 
+func (e *Encoder) autoFlush() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// check if still pending
+	if !e.flushPending {
+		return
+	}
+
+	// flush buffer and save an eventual error
+	e.flushPending = false
+	err := e.writer.Flush()
+	if err != nil {
+		e.flushError = err
+	}
+}
+
 // A Decoder wraps a Reader and continuously decodes packets.
 type Decoder struct {
 	Limit int64
 
-	reader *bufio.Reader
-	buffer bytes.Buffer
+	// If set, publish packets are decoded into packets acquired from the
+	// pool. The receiver owns the initial reference and should release it
+	// using Pooled once the packet is not used anymore.
+	Pool *PublishPool
+
+	reader  *bufio.Reader
+	buffer  bytes.Buffer
+	version uint32
+	payload *PayloadReader
 }
 
 // NewDecoder returns a new Decoder.
@@ -73,24 +264,53 @@ func NewDecoder(reader io.Reader) *Decoder {
 	}
 }
 
+// SetVersion sets the protocol level that is used to decode packets. Packets
+// are decoded using MQTT 3.1.1 unless protocol level 5 is set.
+func (d *Decoder) SetVersion(version byte) {
+	atomic.StoreUint32(&d.version, uint32(version))
+}
+
 // Read reads the next packet from the buffered reader.
 func (d *Decoder) Read() (GenericPacket, error) {
+	pkt, _, err := d.read(0, false)
+	return pkt, err
+}
+
+// ReadStream reads the next packet like Read. However, the payload of QOS 0
+// publish packets that exceeds the threshold is not buffered. These packets
+// are returned without a payload together with a PayloadReader that reads
+// the payload from the underlying reader. The payload must be read before the
+// next packet is read, any unread rest is discarded by the next read.
+func (d *Decoder) ReadStream(threshold int64) (GenericPacket, *PayloadReader, error) {
+	return d.read(threshold, true)
+}
+
+func (d *Decoder) read(threshold int64, stream bool) (GenericPacket, *PayloadReader, error) {
+	// discard unread rest of previous payload
+	if d.payload != nil {
+		_, err := io.Copy(io.Discard, d.payload)
+		d.payload = nil
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// initial detection length
 	detectionLength := 2
 
 	for {
 		// check length
 		if detectionLength > 5 {
-			return nil, ErrDetectionOverflow
+			return nil, nil, ErrDetectionOverflow
 		}
 
 		// try read detection bytes
 		header, err := d.reader.Peek(detectionLength)
 		if err == io.EOF && len(header) != 0 {
 			// an EOF with some data is unexpected
-			return nil, io.ErrUnexpectedEOF
+			return nil, nil, io.ErrUnexpectedEOF
 		} else if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		// detect packet
@@ -105,13 +325,13 @@ func (d *Decoder) Read() (GenericPacket, error) {
 
 		// check read limit
 		if d.Limit > 0 && int64(packetLength) > d.Limit {
-			return nil, ErrReadLimitExceeded
+			return nil, nil, ErrReadLimitExceeded
 		}
 
-		// create packet
-		pkt, err := packetType.New()
-		if err != nil {
-			return nil, err
+		// stream payload of large qos 0 publish packets, the detection bytes
+		// contain exactly the fixed header at this point
+		if stream && packetType == PUBLISH && (header[0]>>1)&0x3 == 0 && int64(packetLength-detectionLength) > threshold {
+			return d.readStream(header[0], detectionLength, packetLength-detectionLength, threshold)
 		}
 
 		// reset and eventually grow buffer
@@ -122,25 +342,254 @@ func (d *Decoder) Read() (GenericPacket, error) {
 		// read whole packet (will not return EOF)
 		_, err = io.ReadFull(d.reader, buf)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		// decode publish packet using the pool if available
+		if packetType == PUBLISH && d.Pool != nil {
+			pp := d.Pool.Acquire()
+			_, err = pp.DecodeVersion(buf, byte(atomic.LoadUint32(&d.version)))
+			if err != nil {
+				pp.Release()
+				return nil, nil, err
+			}
+
+			return &pp.Packet, nil, nil
+		}
+
+		// create packet
+		pkt, err := packetType.New()
+		if err != nil {
+			return nil, nil, err
 		}
 
 		// decode buffer
-		_, err = pkt.Decode(buf)
+		_, err = Decode(pkt, buf, byte(atomic.LoadUint32(&d.version)))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return pkt, nil, nil
+	}
+}
+
+// readStream reads the fixed and variable header of a qos 0 publish packet
+// and returns a reader for its payload if it exceeds the threshold
+func (d *Decoder) readStream(typeAndFlags byte, hl, rl int, threshold int64) (GenericPacket, *PayloadReader, error) {
+	// skip fixed header
+	_, err := d.reader.Discard(hl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// prepare buffer with space for a fixed header
+	d.buffer.Reset()
+	d.buffer.Write(make([]byte, 5))
+
+	// read topic length and topic
+	err = d.readN(2, rl)
+	if err != nil {
+		return nil, nil, err
+	}
+	tl := int(binary.BigEndian.Uint16(d.buffer.Bytes()[5:]))
+	err = d.readN(tl, rl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// read properties
+	if byte(atomic.LoadUint32(&d.version)) == Version5 {
+		pl := 0
+		for i := 0; ; i++ {
+			// check length
+			if i >= 4 {
+				return nil, nil, decodeError(PUBLISH, "properties", hl+d.buffer.Len()-5, "error reading properties length")
+			}
+
+			// read byte
+			err = d.readN(1, rl)
+			if err != nil {
+				return nil, nil, err
+			}
+			b := d.buffer.Bytes()[d.buffer.Len()-1]
+
+			// add value
+			pl |= int(b&0x7f) << (7 * i)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+
+		err = d.readN(pl, rl)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
+	}
+
+	// get variable header and payload size
+	vl := d.buffer.Len() - 5
+	size := int64(rl - vl)
+
+	// read payload if it does not exceed the threshold
+	if size <= threshold {
+		err = d.readN(int(size), rl)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// write fixed header in front of the read bytes
+	buf := d.buffer.Bytes()
+	fl := headerLen(len(buf) - 5)
+	buf[5-fl] = typeAndFlags
+	binary.PutUvarint(buf[5-fl+1:], uint64(len(buf)-5))
+
+	// decode packet
+	pkt := NewPublishPacket()
+	_, err = Decode(pkt, buf[5-fl:], byte(atomic.LoadUint32(&d.version)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// return packet if payload has been read
+	if size <= threshold {
+		return pkt, nil, nil
+	}
+
+	// prepare payload reader
+	d.payload = &PayloadReader{
+		reader:    d.reader,
+		size:      size,
+		remaining: size,
+	}
+
+	return pkt, d.payload, nil
+}
+
+// readN reads the specified amount of bytes into the buffer while ensuring
+// that the remaining length is not exceeded
+func (d *Decoder) readN(n, rl int) error {
+	// check remaining length
+	if d.buffer.Len()-5+n > rl {
+		return decodeError(PUBLISH, "remaining length", 1, "remaining length (%d) is smaller than header", rl)
+	}
+
+	// read bytes
+	_, err := io.CopyN(&d.buffer, d.reader, int64(n))
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
 
-		return pkt, nil
+// A PayloadReader reads the payload of a streamed publish packet from the
+// underlying reader of a Decoder.
+type PayloadReader struct {
+	reader    io.Reader
+	size      int64
+	remaining int64
+}
+
+// Size returns the total size of the payload.
+func (r *PayloadReader) Size() int64 {
+	return r.size
+}
+
+// Read reads from the payload. It returns io.EOF once the whole payload has
+// been read and io.ErrUnexpectedEOF if the underlying reader ends early.
+func (r *PayloadReader) Read(p []byte) (int, error) {
+	// check remaining
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	// limit read
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
 	}
+
+	// read bytes
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	} else if err == io.EOF {
+		return n, nil
+	}
+
+	return n, err
 }
 
-// A Stream combines an Encoder and Decoder
+// A Stream combines an Encoder and Decoder. The stream switches to the
+// protocol level of a ConnectPacket that is read or written.
 type Stream struct {
 	Decoder
 	Encoder
 }
 
+// SetVersion sets the protocol level that is used to encode and decode
+// packets.
+func (s *Stream) SetVersion(version byte) {
+	s.Decoder.SetVersion(version)
+	s.Encoder.SetVersion(version)
+}
+
+// Version returns the protocol level that is used to encode and decode
+// packets.
+func (s *Stream) Version() byte {
+	return byte(atomic.LoadUint32(&s.Decoder.version))
+}
+
+// Read reads the next packet from the buffered reader.
+func (s *Stream) Read() (GenericPacket, error) {
+	// read packet
+	pkt, err := s.Decoder.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	// switch to the protocol level of the client
+	if connect, ok := pkt.(*ConnectPacket); ok {
+		s.SetVersion(connect.Version)
+	}
+
+	return pkt, nil
+}
+
+// ReadStream reads the next packet from the buffered reader while streaming
+// large payloads. See Decoder.ReadStream for details.
+func (s *Stream) ReadStream(threshold int64) (GenericPacket, *PayloadReader, error) {
+	// read packet
+	pkt, payload, err := s.Decoder.ReadStream(threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// switch to the protocol level of the client
+	if connect, ok := pkt.(*ConnectPacket); ok {
+		s.SetVersion(connect.Version)
+	}
+
+	return pkt, payload, nil
+}
+
+// Write encodes and writes the passed packet to the write buffer.
+func (s *Stream) Write(pkt GenericPacket) error {
+	// switch to the protocol level of the client
+	if connect, ok := pkt.(*ConnectPacket); ok {
+		s.SetVersion(connect.Version)
+	}
+
+	return s.Encoder.Write(pkt)
+}
+
+// WriteStream encodes the publish packet while streaming the payload from the
+// reader. See Encoder.WriteStream for details.
+func (s *Stream) WriteStream(pkt *PublishPacket, payload io.Reader, size int64) error {
+	return s.Encoder.WriteStream(pkt, payload, size)
+}
+
 // NewStream creates a new Stream.
 func NewStream(reader io.Reader, writer io.Writer) *Stream {
 	return &Stream{
@@ -152,3 +601,16 @@ func NewStream(reader io.Reader, writer io.Writer) *Stream {
 		},
 	}
 }
+
+// NewStreamSize creates a new Stream with a write buffer of at least the
+// specified size.
+func NewStreamSize(reader io.Reader, writer io.Writer, writeSize int) *Stream {
+	return &Stream{
+		Decoder: Decoder{
+			reader: bufio.NewReader(reader),
+		},
+		Encoder: Encoder{
+			writer: bufio.NewWriterSize(writer, writeSize),
+		},
+	}
+}
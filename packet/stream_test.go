@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -46,6 +47,104 @@ func TestEncoderWriterError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestEncoderSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoderSize(buf, 32)
+
+	err := enc.Write(NewConnectPacket())
+	assert.NoError(t, err)
+	assert.Equal(t, 14, enc.Buffered())
+	assert.Len(t, buf.Bytes(), 0)
+
+	err = enc.Write(NewConnectPacket())
+	assert.NoError(t, err)
+	assert.Equal(t, 28, enc.Buffered())
+
+	err = enc.Write(NewConnectPacket())
+	assert.NoError(t, err)
+	assert.Len(t, buf.Bytes(), 32)
+
+	err = enc.Flush()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, enc.Buffered())
+	assert.Len(t, buf.Bytes(), 42)
+}
+
+func TestEncoderAutoFlush(t *testing.T) {
+	r, w := io.Pipe()
+
+	enc := NewEncoder(w)
+	enc.SetAutoFlush(10 * time.Millisecond)
+
+	err := enc.Write(NewConnectPacket())
+	assert.NoError(t, err)
+
+	buf := make([]byte, 14)
+	_, err = io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, enc.Buffered())
+}
+
+func TestEncoderAutoFlushDisable(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	enc := NewEncoder(buf)
+	enc.SetAutoFlush(10 * time.Millisecond)
+
+	err := enc.Write(NewConnectPacket())
+	assert.NoError(t, err)
+
+	enc.SetAutoFlush(0)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 14, enc.Buffered())
+	assert.Len(t, buf.Bytes(), 0)
+}
+
+func TestEncoderAutoFlushError(t *testing.T) {
+	enc := NewEncoder(&errorWriter{
+		err: errors.New("foo"),
+	})
+	enc.SetAutoFlush(time.Millisecond)
+
+	err := enc.Write(NewConnectPacket())
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+
+	err = enc.Write(NewConnectPacket())
+	assert.Equal(t, "foo", err.Error())
+
+	err = enc.Flush()
+	assert.Equal(t, "foo", err.Error())
+}
+
+func TestEncoderWriteStream(t *testing.T) {
+	buf := new(bytes.Buffer)
+	enc := NewEncoder(buf)
+
+	pkt := NewPublishPacket()
+	pkt.Message.Topic = "foo"
+
+	err := enc.WriteStream(pkt, bytes.NewReader([]byte("bar")), 3)
+	assert.NoError(t, err)
+
+	err = enc.Flush()
+	assert.NoError(t, err)
+
+	pkt.Message.Payload = []byte("bar")
+	data := make([]byte, pkt.Len())
+	_, err = pkt.Encode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, data, buf.Bytes())
+
+	err = enc.WriteStream(pkt, bytes.NewReader([]byte("ba")), 3)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	err = enc.WriteStream(pkt, bytes.NewReader(nil), -1)
+	assert.Error(t, err)
+}
+
 func TestDecoder(t *testing.T) {
 	buf := new(bytes.Buffer)
 	dec := NewDecoder(buf)
@@ -60,6 +159,120 @@ func TestDecoder(t *testing.T) {
 	assert.NotNil(t, pkt)
 }
 
+func TestDecoderPool(t *testing.T) {
+	buf := new(bytes.Buffer)
+	dec := NewDecoder(buf)
+	dec.Pool = NewPublishPool()
+
+	publish := NewPublishPacket()
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+
+	var pkt GenericPacket = publish
+	b := make([]byte, pkt.Len())
+	pkt.Encode(b)
+	buf.Write(b)
+
+	pkt = NewConnectPacket()
+	b = make([]byte, pkt.Len())
+	pkt.Encode(b)
+	buf.Write(b)
+
+	pkt, err := dec.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, publish.Message, pkt.(*PublishPacket).Message)
+	assert.NotNil(t, pkt.(*PublishPacket).Pooled())
+	pkt.(*PublishPacket).Pooled().Release()
+
+	pkt, err = dec.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, CONNECT, pkt.Type())
+}
+
+func TestDecoderReadStream(t *testing.T) {
+	for _, version := range []byte{Version311, Version5} {
+		buf := new(bytes.Buffer)
+		enc := NewEncoder(buf)
+		enc.SetVersion(version)
+		dec := NewDecoder(buf)
+		dec.SetVersion(version)
+
+		pkt := NewPublishPacket()
+		pkt.Message.Topic = "foo"
+		pkt.Message.Payload = []byte("bar")
+		pkt.Properties = Properties{{ID: ContentTypeProperty, Value: "text/plain"}}
+		if version != Version5 {
+			pkt.Properties = nil
+		}
+
+		qos1 := NewPublishPacket()
+		qos1.Message = pkt.Message
+		qos1.Message.QOS = 1
+		qos1.ID = 1
+
+		for _, p := range []GenericPacket{pkt, pkt, qos1, pkt, NewPingreqPacket()} {
+			assert.NoError(t, enc.Write(p))
+		}
+		assert.NoError(t, enc.Flush())
+
+		// streamed payload
+		read, payload, err := dec.ReadStream(2)
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", read.(*PublishPacket).Message.Topic)
+		assert.Empty(t, read.(*PublishPacket).Message.Payload)
+		assert.Equal(t, pkt.Properties, read.(*PublishPacket).Properties)
+		assert.Equal(t, int64(3), payload.Size())
+
+		data, err := io.ReadAll(payload)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("bar"), data)
+
+		// unread payload
+		read, payload, err = dec.ReadStream(2)
+		assert.NoError(t, err)
+		assert.NotNil(t, payload)
+
+		// qos 1 packet
+		read, payload, err = dec.ReadStream(2)
+		assert.NoError(t, err)
+		assert.Nil(t, payload)
+		assert.Equal(t, qos1, read)
+
+		// payload below threshold
+		read, payload, err = dec.ReadStream(3)
+		assert.NoError(t, err)
+		assert.Nil(t, payload)
+		assert.Equal(t, pkt, read)
+
+		read, err = dec.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, PINGREQ, read.Type())
+	}
+}
+
+func TestDecoderReadStreamUnexpectedEOF(t *testing.T) {
+	pkt := NewPublishPacket()
+	pkt.Message.Topic = "foo"
+	pkt.Message.Payload = []byte("bar")
+
+	buf := make([]byte, pkt.Len())
+	_, err := pkt.Encode(buf)
+	assert.NoError(t, err)
+
+	dec := NewDecoder(bytes.NewReader(buf[:len(buf)-1]))
+
+	_, payload, err := dec.ReadStream(0)
+	assert.NoError(t, err)
+
+	_, err = io.ReadAll(payload)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	dec = NewDecoder(bytes.NewReader(buf[:4]))
+
+	_, _, err = dec.ReadStream(0)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
 func TestDecoderDetectionOverflowError(t *testing.T) {
 	buf := new(bytes.Buffer)
 	dec := NewDecoder(buf)
@@ -157,3 +370,81 @@ func TestStream(t *testing.T) {
 	assert.NotNil(t, pkt)
 	assert.NoError(t, err)
 }
+
+func TestStreamSize(t *testing.T) {
+	in := new(bytes.Buffer)
+	out := new(bytes.Buffer)
+
+	s := NewStreamSize(in, out, 1024)
+
+	err := s.Write(NewConnectPacket())
+	assert.NoError(t, err)
+	assert.Equal(t, 14, s.Buffered())
+
+	err = s.Flush()
+	assert.NoError(t, err)
+	assert.Len(t, out.Bytes(), 14)
+}
+
+func TestStreamWriteStream(t *testing.T) {
+	in := new(bytes.Buffer)
+	out := new(bytes.Buffer)
+
+	s := NewStream(in, out)
+
+	pkt := NewPublishPacket()
+	pkt.Message.Topic = "foo"
+
+	err := s.WriteStream(pkt, bytes.NewReader(make([]byte, 1024)), 1024)
+	assert.NoError(t, err)
+
+	err = s.Flush()
+	assert.NoError(t, err)
+
+	_, err = io.Copy(in, out)
+	assert.NoError(t, err)
+
+	read, err := s.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", read.(*PublishPacket).Message.Topic)
+	assert.Len(t, read.(*PublishPacket).Message.Payload, 1024)
+}
+
+func TestStreamVersion(t *testing.T) {
+	buf := new(bytes.Buffer)
+	stream := NewStream(buf, buf)
+	assert.Equal(t, byte(0), stream.Version())
+
+	connect := NewConnectPacket()
+	connect.Version = Version5
+	connect.ClientID = "foo"
+
+	err := stream.Write(connect)
+	assert.NoError(t, err)
+	assert.Equal(t, Version5, stream.Version())
+
+	puback := NewPubackPacket()
+	puback.ID = 7
+	puback.ReasonCode = QuotaExceeded
+
+	err = stream.Write(puback)
+	assert.NoError(t, err)
+
+	err = stream.Flush()
+	assert.NoError(t, err)
+
+	other := NewStream(buf, buf)
+
+	pkt, err := other.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, connect, pkt)
+	assert.Equal(t, Version5, other.Version())
+
+	pkt, err = other.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, puback, pkt)
+
+	// auth packets require MQTT 5
+	_, err = NewDecoder(bytes.NewReader([]byte{byte(AUTH << 4), 0})).Read()
+	assert.Error(t, err)
+}
@@ -10,7 +10,7 @@ const maxLPLength uint16 = 65535
 // read length prefixed bytes
 func readLPBytes(buf []byte, safe bool, t Type) ([]byte, int, error) {
 	if len(buf) < 2 {
-		return nil, 0, fmt.Errorf("[%s] insufficient buffer size, expected 2, got %d", t, len(buf))
+		return nil, 0, decodeError(t, "", 0, "insufficient buffer size, expected 2, got %d", len(buf))
 	}
 
 	n, total := 0, 0
@@ -20,7 +20,7 @@ func readLPBytes(buf []byte, safe bool, t Type) ([]byte, int, error) {
 	total += n
 
 	if len(buf) < total {
-		return nil, total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", t, total, len(buf))
+		return nil, total, decodeError(t, "", 0, "insufficient buffer size, expected %d, got %d", total, len(buf))
 	}
 
 	// copy buffer in safe mode
@@ -36,7 +36,7 @@ func readLPBytes(buf []byte, safe bool, t Type) ([]byte, int, error) {
 // read length prefixed string
 func readLPString(buf []byte, t Type) (string, int, error) {
 	if len(buf) < 2 {
-		return "", 0, fmt.Errorf("[%s] insufficient buffer size, expected 2, got %d", t, len(buf))
+		return "", 0, decodeError(t, "", 0, "insufficient buffer size, expected 2, got %d", len(buf))
 	}
 
 	n, total := 0, 0
@@ -46,7 +46,7 @@ func readLPString(buf []byte, t Type) (string, int, error) {
 	total += n
 
 	if len(buf) < total {
-		return "", total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", t, total, len(buf))
+		return "", total, decodeError(t, "", 0, "insufficient buffer size, expected %d, got %d", total, len(buf))
 	}
 
 	return string(buf[2:total]), total, nil
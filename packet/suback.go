@@ -15,6 +15,9 @@ type SubackPacket struct {
 
 	// The packet identifier.
 	ID ID
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 // NewSubackPacket creates a new SubackPacket.
@@ -41,13 +44,25 @@ func (sp *SubackPacket) String() string {
 
 // Len returns the byte length of the encoded packet.
 func (sp *SubackPacket) Len() int {
-	ml := sp.len()
+	return sp.LenVersion(Version311)
+}
+
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (sp *SubackPacket) LenVersion(version byte) int {
+	ml := sp.len(version)
 	return headerLen(ml) + ml
 }
 
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (sp *SubackPacket) Decode(src []byte) (int, error) {
+	return sp.DecodeVersion(src, Version311)
+}
+
+// DecodeVersion decodes the packet using the specified protocol level. Using
+// MQTT 5, the return codes may contain any failure reason code.
+func (sp *SubackPacket) DecodeVersion(src []byte, version byte) (int, error) {
 	total := 0
 
 	// decode header
@@ -59,12 +74,12 @@ func (sp *SubackPacket) Decode(src []byte) (int, error) {
 
 	// check buffer length
 	if len(src) < total+2 {
-		return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", sp.Type(), total+2, len(src))
+		return total, decodeError(sp.Type(), "packet id", total, "insufficient buffer size, expected %d, got %d", total+2, len(src))
 	}
 
 	// check remaining length
 	if rl <= 2 {
-		return total, fmt.Errorf("[%s] expected remaining length to be greater than 2, got %d", sp.Type(), rl)
+		return total, decodeError(sp.Type(), "remaining length", 1, "expected remaining length to be greater than 2, got %d", rl)
 	}
 
 	// read packet id
@@ -73,11 +88,24 @@ func (sp *SubackPacket) Decode(src []byte) (int, error) {
 
 	// check packet id
 	if sp.ID == 0 {
-		return total, fmt.Errorf("[%s] packet id must be grater than zero", sp.Type())
+		return total, decodeError(sp.Type(), "packet id", total-2, "packet id must be grater than zero")
+	}
+
+	// read properties
+	var n int
+	sp.Properties, n, err = readVersionProperties(src[total:hl+rl], version, sp.Type())
+	total += n
+	if err != nil {
+		return total, shiftError(err, total-n, "properties")
 	}
 
 	// calculate number of return codes
-	rcl := int(rl) - 2
+	rcl := int(rl) - (total - hl)
+
+	// check for empty list
+	if rcl <= 0 {
+		return total, decodeError(sp.Type(), "return codes", total, "empty return code list")
+	}
 
 	// read return codes
 	sp.ReturnCodes = make([]uint8, rcl)
@@ -86,8 +114,8 @@ func (sp *SubackPacket) Decode(src []byte) (int, error) {
 
 	// validate return codes
 	for i, code := range sp.ReturnCodes {
-		if !validQOS(code) && code != QOSFailure {
-			return total, fmt.Errorf("[%s] invalid return code %d for topic %d", sp.Type(), code, i)
+		if !validReturnCode(code, version) {
+			return total, decodeError(sp.Type(), "return codes", total-rcl+i, "invalid return code %d for topic %d", code, i)
 		}
 	}
 
@@ -98,11 +126,16 @@ func (sp *SubackPacket) Decode(src []byte) (int, error) {
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
 func (sp *SubackPacket) Encode(dst []byte) (int, error) {
+	return sp.EncodeVersion(dst, Version311)
+}
+
+// EncodeVersion encodes the packet using the specified protocol level.
+func (sp *SubackPacket) EncodeVersion(dst []byte, version byte) (int, error) {
 	total := 0
 
 	// check return codes
 	for i, code := range sp.ReturnCodes {
-		if !validQOS(code) && code != QOSFailure {
+		if !validReturnCode(code, version) {
 			return total, fmt.Errorf("[%s] invalid return code %d for topic %d", sp.Type(), code, i)
 		}
 	}
@@ -113,7 +146,7 @@ func (sp *SubackPacket) Encode(dst []byte) (int, error) {
 	}
 
 	// encode header
-	n, err := headerEncode(dst[total:], 0, sp.len(), sp.Len(), SUBACK)
+	n, err := headerEncode(dst[total:], 0, sp.len(version), sp.LenVersion(version), SUBACK)
 	total += n
 	if err != nil {
 		return total, err
@@ -123,6 +156,13 @@ func (sp *SubackPacket) Encode(dst []byte) (int, error) {
 	binary.BigEndian.PutUint16(dst[total:], uint16(sp.ID))
 	total += 2
 
+	// write properties
+	n, err = writeVersionProperties(dst[total:], sp.Properties, version, sp.Type())
+	total += n
+	if err != nil {
+		return total, err
+	}
+
 	// write return codes
 	copy(dst[total:], sp.ReturnCodes)
 	total += len(sp.ReturnCodes)
@@ -131,6 +171,20 @@ func (sp *SubackPacket) Encode(dst []byte) (int, error) {
 }
 
 // Returns the payload length.
-func (sp *SubackPacket) len() int {
-	return 2 + len(sp.ReturnCodes)
+func (sp *SubackPacket) len(version byte) int {
+	total := 2 + len(sp.ReturnCodes)
+
+	// add the properties length
+	total += versionPropertiesLen(sp.Properties, version)
+
+	return total
+}
+
+// checks whether the return code is a granted QOS level or a failure
+func validReturnCode(code uint8, version byte) bool {
+	if version == Version5 && code >= QOSFailure {
+		return ReasonCode(code).Valid()
+	}
+
+	return validQOS(code) || code == QOSFailure
 }
@@ -234,3 +234,23 @@ func BenchmarkSubackDecode(b *testing.B) {
 		}
 	}
 }
+
+func TestSubackPacketEncodeDecode5(t *testing.T) {
+	pkt := NewSubackPacket()
+	pkt.ID = 7
+	pkt.ReturnCodes = []uint8{0, 1, 2, QOSFailure, uint8(NotAuthorized)}
+	pkt.Properties = Properties{
+		{ID: ReasonStringProperty, Value: "foo"},
+	}
+
+	out := NewSubackPacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+
+	// reason codes are only valid with MQTT 5
+	_, err := pkt.Encode(make([]byte, 20))
+	assert.Error(t, err)
+
+	_, err = out.DecodeVersion([]byte{byte(SUBACK << 4), 3, 0, 7, 0}, Version5)
+	assert.Error(t, err)
+}
@@ -13,6 +13,68 @@ type Subscription struct {
 
 	// The requested maximum QOS level.
 	QOS uint8
+
+	// If set, messages published by the subscribing client itself are not
+	// forwarded to it.
+	NoLocal bool
+
+	// If set, forwarded messages keep the retain flag they have been published
+	// with. Otherwise, the flag is only set for retained messages that are
+	// sent as a result of the subscription.
+	RetainAsPublished bool
+
+	// The RetainHandling defines whether retained messages are sent when the
+	// subscription is established.
+	RetainHandling RetainHandling
+}
+
+// RetainHandling defines when retained messages are sent for a subscription.
+type RetainHandling uint8
+
+// All available retain handling options.
+const (
+	// SendRetained sends retained messages every time the subscription is
+	// established.
+	SendRetained RetainHandling = iota
+
+	// SendRetainedIfNew sends retained messages only if the subscription did
+	// not exist before.
+	SendRetainedIfNew
+
+	// DontSendRetained never sends retained messages.
+	DontSendRetained
+)
+
+// Returns the MQTT 5 subscription options byte.
+func (s *Subscription) options() byte {
+	options := s.QOS & 0x3
+
+	if s.NoLocal {
+		options |= 0x4 // 00000100
+	}
+
+	if s.RetainAsPublished {
+		options |= 0x8 // 00001000
+	}
+
+	return options | byte(s.RetainHandling&0x3)<<4
+}
+
+// Returns an error if the subscription is a malformed shared subscription or
+// a shared subscription that requests NoLocal using MQTT 5.
+func (s *Subscription) checkShared(version byte, t Type) error {
+	// parse filter
+	group, _, err := ParseShared(s.Topic)
+	if err != nil {
+		return fmt.Errorf("[%s] %s", t, err.Error())
+	}
+
+	// check no local
+	if group != "" && s.NoLocal && version == Version5 {
+		return fmt.Errorf("[%s] no local set for shared subscription %q", t, s.Topic)
+	}
+
+	return nil
 }
 
 func (s *Subscription) String() string {
@@ -28,6 +90,9 @@ type SubscribePacket struct {
 
 	// The packet identifier.
 	ID ID
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 // NewSubscribePacket creates a new SUBSCRIBE packet.
@@ -54,13 +119,25 @@ func (sp *SubscribePacket) String() string {
 
 // Len returns the byte length of the encoded packet.
 func (sp *SubscribePacket) Len() int {
-	ml := sp.len()
+	return sp.LenVersion(Version311)
+}
+
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (sp *SubscribePacket) LenVersion(version byte) int {
+	ml := sp.len(version)
 	return headerLen(ml) + ml
 }
 
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (sp *SubscribePacket) Decode(src []byte) (int, error) {
+	return sp.DecodeVersion(src, Version311)
+}
+
+// DecodeVersion decodes the packet using the specified protocol level. Using
+// MQTT 5, the subscription options are decoded as well.
+func (sp *SubscribePacket) DecodeVersion(src []byte, version byte) (int, error) {
 	total := 0
 
 	// decode header
@@ -72,7 +149,7 @@ func (sp *SubscribePacket) Decode(src []byte) (int, error) {
 
 	// check buffer length
 	if len(src) < total+2 {
-		return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", sp.Type(), total+2, len(src))
+		return total, decodeError(sp.Type(), "packet id", total, "insufficient buffer size, expected %d, got %d", total+2, len(src))
 	}
 
 	// read packet id
@@ -81,39 +158,67 @@ func (sp *SubscribePacket) Decode(src []byte) (int, error) {
 
 	// check packet id
 	if sp.ID == 0 {
-		return total, fmt.Errorf("[%s] packet id must be grater than zero", sp.Type())
+		return total, decodeError(sp.Type(), "packet id", total-2, "packet id must be grater than zero")
+	}
+
+	// read properties
+	var n int
+	sp.Properties, n, err = readVersionProperties(src[total:hl+rl], version, sp.Type())
+	total += n
+	if err != nil {
+		return total, shiftError(err, total-n, "properties")
 	}
 
 	// reset subscriptions
 	sp.Subscriptions = sp.Subscriptions[:0]
 
 	// calculate number of subscriptions
-	sl := int(rl) - 2
+	sl := int(rl) - (total - hl)
 
 	for sl > 0 {
 		// read topic
 		t, n, err := readLPString(src[total:], sp.Type())
 		total += n
 		if err != nil {
-			return total, err
+			return total, shiftError(err, total-n, "topic filter")
 		}
 
 		// check buffer length
 		if len(src) < total+1 {
-			return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", sp.Type(), total+1, len(src))
+			return total, decodeError(sp.Type(), "subscription options", total, "insufficient buffer size, expected %d, got %d", total+1, len(src))
 		}
 
-		// read qos and add subscription
-		sp.Subscriptions = append(sp.Subscriptions, Subscription{t, src[total]})
+		// read qos or options and add subscription
+		subscription := Subscription{Topic: t, QOS: src[total]}
+		if version == Version5 {
+			options := src[total]
+			subscription.QOS = options & 0x3
+			subscription.NoLocal = options&0x4 != 0
+			subscription.RetainAsPublished = options&0x8 != 0
+			subscription.RetainHandling = RetainHandling((options >> 4) & 0x3)
+
+			// check reserved bits and retain handling
+			if options&0xC0 != 0 || subscription.RetainHandling > DontSendRetained {
+				return total, decodeError(sp.Type(), "subscription options", total, "invalid subscription options %d", options)
+			}
+		}
 		total++
 
+		// check shared subscription
+		err = subscription.checkShared(version, sp.Type())
+		if err != nil {
+			return total, err
+		}
+
+		sp.Subscriptions = append(sp.Subscriptions, subscription)
+
 		// decrement counter
 		sl = sl - n - 1
 	}
 
 	// check for empty subscription list
 	if len(sp.Subscriptions) == 0 {
-		return total, fmt.Errorf("[%s] empty subscription list", sp.Type())
+		return total, decodeError(sp.Type(), "subscriptions", total, "empty subscription list")
 	}
 
 	return total, nil
@@ -123,6 +228,11 @@ func (sp *SubscribePacket) Decode(src []byte) (int, error) {
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
 func (sp *SubscribePacket) Encode(dst []byte) (int, error) {
+	return sp.EncodeVersion(dst, Version311)
+}
+
+// EncodeVersion encodes the packet using the specified protocol level.
+func (sp *SubscribePacket) EncodeVersion(dst []byte, version byte) (int, error) {
 	total := 0
 
 	// check packet id
@@ -131,7 +241,7 @@ func (sp *SubscribePacket) Encode(dst []byte) (int, error) {
 	}
 
 	// encode header
-	n, err := headerEncode(dst[total:], 0, sp.len(), sp.Len(), SUBSCRIBE)
+	n, err := headerEncode(dst[total:], 0, sp.len(version), sp.LenVersion(version), SUBSCRIBE)
 	total += n
 	if err != nil {
 		return total, err
@@ -141,7 +251,20 @@ func (sp *SubscribePacket) Encode(dst []byte) (int, error) {
 	binary.BigEndian.PutUint16(dst[total:], uint16(sp.ID))
 	total += 2
 
+	// write properties
+	n, err = writeVersionProperties(dst[total:], sp.Properties, version, sp.Type())
+	total += n
+	if err != nil {
+		return total, err
+	}
+
 	for _, t := range sp.Subscriptions {
+		// check shared subscription
+		err = t.checkShared(version, sp.Type())
+		if err != nil {
+			return total, err
+		}
+
 		// write topic
 		n, err := writeLPString(dst[total:], t.Topic, sp.Type())
 		total += n
@@ -149,8 +272,12 @@ func (sp *SubscribePacket) Encode(dst []byte) (int, error) {
 			return total, err
 		}
 
-		// write qos
-		dst[total] = t.QOS
+		// write qos or options
+		if version == Version5 {
+			dst[total] = t.options()
+		} else {
+			dst[total] = t.QOS
+		}
 
 		total++
 	}
@@ -159,10 +286,13 @@ func (sp *SubscribePacket) Encode(dst []byte) (int, error) {
 }
 
 // Returns the payload length.
-func (sp *SubscribePacket) len() int {
+func (sp *SubscribePacket) len(version byte) int {
 	// packet ID
 	total := 2
 
+	// add the properties length
+	total += versionPropertiesLen(sp.Properties, version)
+
 	for _, t := range sp.Subscriptions {
 		total += 2 + len(t.Topic) + 1
 	}
@@ -167,9 +167,9 @@ func TestSubscribePacketEncode(t *testing.T) {
 	pkt := NewSubscribePacket()
 	pkt.ID = 7
 	pkt.Subscriptions = []Subscription{
-		{"gomqtt", 0},
-		{"/a/b/#/c", 1},
-		{"/a/b/#/cdd", 2},
+		{Topic: "gomqtt", QOS: 0},
+		{Topic: "/a/b/#/c", QOS: 1},
+		{Topic: "/a/b/#/cdd", QOS: 2},
 	}
 
 	dst := make([]byte, pkt.Len())
@@ -194,7 +194,7 @@ func TestSubscribePacketEncodeError2(t *testing.T) {
 	pkt := NewSubscribePacket()
 	pkt.ID = 7
 	pkt.Subscriptions = []Subscription{
-		{string(make([]byte, 65536)), 0}, // too big
+		{Topic: string(make([]byte, 65536)), QOS: 0}, // too big
 	}
 
 	dst := make([]byte, pkt.Len())
@@ -256,7 +256,7 @@ func BenchmarkSubscribeEncode(b *testing.B) {
 	pkt := NewSubscribePacket()
 	pkt.ID = 7
 	pkt.Subscriptions = []Subscription{
-		{"t", 0},
+		{Topic: "t", QOS: 0},
 	}
 
 	buf := make([]byte, pkt.Len())
@@ -290,3 +290,65 @@ func BenchmarkSubscribeDecode(b *testing.B) {
 		}
 	}
 }
+
+func TestSubscribePacketEncodeDecode5(t *testing.T) {
+	pkt := NewSubscribePacket()
+	pkt.ID = 7
+	pkt.Subscriptions = []Subscription{
+		{Topic: "foo", QOS: 1, NoLocal: true},
+		{Topic: "bar", QOS: 2, RetainAsPublished: true, RetainHandling: DontSendRetained},
+	}
+	pkt.Properties = Properties{
+		{ID: SubscriptionIdentifierProperty, Value: uint32(42)},
+	}
+
+	out := NewSubscribePacket()
+	buf := roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+	assert.Equal(t, byte(0x05), buf[12])
+	assert.Equal(t, byte(0x2A), buf[18])
+
+	// reserved bits
+	buf[18] |= 0x40
+	_, err := out.DecodeVersion(buf, Version5)
+	assert.Error(t, err)
+}
+
+func TestSubscribePacketShared(t *testing.T) {
+	pkt := NewSubscribePacket()
+	pkt.ID = 7
+	pkt.Subscriptions = []Subscription{
+		{Topic: SharedFilter("group", "foo/+"), QOS: 1},
+	}
+
+	out := NewSubscribePacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+
+	// no local
+	pkt.Subscriptions[0].NoLocal = true
+	_, err := pkt.EncodeVersion(make([]byte, pkt.LenVersion(Version5)), Version5)
+	assert.Error(t, err)
+
+	// ignored for MQTT 3
+	roundTrip(t, pkt, out, Version311)
+
+	// malformed filter
+	pkt.Subscriptions[0] = Subscription{Topic: "$share/group"}
+	_, err = pkt.Encode(make([]byte, pkt.Len()))
+	assert.Error(t, err)
+
+	pktBytes := []byte{
+		byte(SUBSCRIBE<<4) | 2,
+		12,
+		0, // packet ID MSB
+		7, // packet ID LSB
+		0, // topic name MSB
+		7, // topic name LSB
+		'$', 's', 'h', 'a', 'r', 'e', '/',
+		0, // QOS
+	}
+
+	_, err = out.Decode(pktBytes)
+	assert.Error(t, err)
+}
@@ -29,6 +29,7 @@ func TestTypeNew(t *testing.T) {
 		PINGREQ,
 		PINGRESP,
 		DISCONNECT,
+		AUTH,
 	}
 
 	for _, tt := range list {
@@ -13,6 +13,9 @@ type UnsubscribePacket struct {
 
 	// The packet identifier.
 	ID ID
+
+	// The properties. They are only transmitted using MQTT 5.
+	Properties Properties
 }
 
 // NewUnsubscribePacket creates a new UnsubscribePacket.
@@ -39,13 +42,24 @@ func (up *UnsubscribePacket) String() string {
 
 // Len returns the byte length of the encoded packet.
 func (up *UnsubscribePacket) Len() int {
-	ml := up.len()
+	return up.LenVersion(Version311)
+}
+
+// LenVersion returns the byte length of the packet encoded using the specified
+// protocol level.
+func (up *UnsubscribePacket) LenVersion(version byte) int {
+	ml := up.len(version)
 	return headerLen(ml) + ml
 }
 
 // Decode reads from the byte slice argument. It returns the total number of
 // bytes decoded, and whether there have been any errors during the process.
 func (up *UnsubscribePacket) Decode(src []byte) (int, error) {
+	return up.DecodeVersion(src, Version311)
+}
+
+// DecodeVersion decodes the packet using the specified protocol level.
+func (up *UnsubscribePacket) DecodeVersion(src []byte, version byte) (int, error) {
 	total := 0
 
 	// decode header
@@ -57,7 +71,7 @@ func (up *UnsubscribePacket) Decode(src []byte) (int, error) {
 
 	// check buffer length
 	if len(src) < total+2 {
-		return total, fmt.Errorf("[%s] insufficient buffer size, expected %d, got %d", up.Type(), total+2, len(src))
+		return total, decodeError(up.Type(), "packet id", total, "insufficient buffer size, expected %d, got %d", total+2, len(src))
 	}
 
 	// read packet id
@@ -66,11 +80,19 @@ func (up *UnsubscribePacket) Decode(src []byte) (int, error) {
 
 	// check packet id
 	if up.ID == 0 {
-		return total, fmt.Errorf("[%s] packet id must be grater than zero", up.Type())
+		return total, decodeError(up.Type(), "packet id", total-2, "packet id must be grater than zero")
+	}
+
+	// read properties
+	var n int
+	up.Properties, n, err = readVersionProperties(src[total:hl+rl], version, up.Type())
+	total += n
+	if err != nil {
+		return total, shiftError(err, total-n, "properties")
 	}
 
 	// prepare counter
-	tl := int(rl) - 2
+	tl := int(rl) - (total - hl)
 
 	// reset topics
 	up.Topics = up.Topics[:0]
@@ -80,19 +102,19 @@ func (up *UnsubscribePacket) Decode(src []byte) (int, error) {
 		t, n, err := readLPString(src[total:], up.Type())
 		total += n
 		if err != nil {
-			return total, err
+			return total, shiftError(err, total-n, "topic filter")
 		}
 
 		// append to list
 		up.Topics = append(up.Topics, t)
 
 		// decrement counter
-		tl = tl - n - 1
+		tl = tl - n
 	}
 
 	// check for empty list
 	if len(up.Topics) == 0 {
-		return total, fmt.Errorf("[%s] empty topic list", up.Type())
+		return total, decodeError(up.Type(), "topics", total, "empty topic list")
 	}
 
 	return total, nil
@@ -102,6 +124,11 @@ func (up *UnsubscribePacket) Decode(src []byte) (int, error) {
 // returns the number of bytes encoded and whether there's any errors along
 // the way. If there is an error, the byte slice should be considered invalid.
 func (up *UnsubscribePacket) Encode(dst []byte) (int, error) {
+	return up.EncodeVersion(dst, Version311)
+}
+
+// EncodeVersion encodes the packet using the specified protocol level.
+func (up *UnsubscribePacket) EncodeVersion(dst []byte, version byte) (int, error) {
 	total := 0
 
 	// check packet id
@@ -110,7 +137,7 @@ func (up *UnsubscribePacket) Encode(dst []byte) (int, error) {
 	}
 
 	// encode header
-	n, err := headerEncode(dst[total:], 0, up.len(), up.Len(), UNSUBSCRIBE)
+	n, err := headerEncode(dst[total:], 0, up.len(version), up.LenVersion(version), UNSUBSCRIBE)
 	total += n
 	if err != nil {
 		return total, err
@@ -120,6 +147,13 @@ func (up *UnsubscribePacket) Encode(dst []byte) (int, error) {
 	binary.BigEndian.PutUint16(dst[total:], uint16(up.ID))
 	total += 2
 
+	// write properties
+	n, err = writeVersionProperties(dst[total:], up.Properties, version, up.Type())
+	total += n
+	if err != nil {
+		return total, err
+	}
+
 	for _, t := range up.Topics {
 		// write topic
 		n, err := writeLPString(dst[total:], t, up.Type())
@@ -133,10 +167,13 @@ func (up *UnsubscribePacket) Encode(dst []byte) (int, error) {
 }
 
 // Returns the payload length.
-func (up *UnsubscribePacket) len() int {
+func (up *UnsubscribePacket) len(version byte) int {
 	// packet ID
 	total := 2
 
+	// add the properties length
+	total += versionPropertiesLen(up.Properties, version)
+
 	for _, t := range up.Topics {
 		total += 2 + len(t)
 	}
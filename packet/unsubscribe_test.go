@@ -257,3 +257,22 @@ func BenchmarkUnsubscribeDecode(b *testing.B) {
 		}
 	}
 }
+
+func TestUnsubscribePacketEncodeDecode5(t *testing.T) {
+	pkt := NewUnsubscribePacket()
+	pkt.ID = 7
+	pkt.Topics = []string{"a", "b", "c", "d"}
+	pkt.Properties = Properties{
+		{ID: UserProperty, Value: StringPair{Key: "foo", Value: "bar"}},
+	}
+
+	out := NewUnsubscribePacket()
+	roundTrip(t, pkt, out, Version5)
+	assert.Equal(t, pkt, out)
+
+	// without properties
+	pkt.Properties = nil
+	out = NewUnsubscribePacket()
+	roundTrip(t, pkt, out, Version311)
+	assert.Equal(t, pkt, out)
+}
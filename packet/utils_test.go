@@ -1,6 +1,11 @@
 package packet
 
-import "io"
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
 
 type errorWriter struct {
 	writer io.Writer
@@ -30,3 +35,16 @@ func (r *errorReader) Read(p []byte) (int, error) {
 	r.after--
 	return r.reader.Read(p)
 }
+
+func roundTrip(t *testing.T, in, out GenericPacket, version byte) []byte {
+	buf := make([]byte, Len(in, version))
+	n, err := Encode(in, buf, version)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+
+	n, err = Decode(out, buf, version)
+	require.NoError(t, err)
+	require.Equal(t, len(buf), n)
+
+	return buf
+}
@@ -0,0 +1,29 @@
+package router
+
+import (
+	"encoding/json"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// HandleJSON will register a handler for the specified topic filter that
+// decodes the JSON payload of received messages into a value of type T before
+// calling the function. Payloads that cannot be decoded are acknowledged and
+// the error is reported to the ErrorCallback of the router. Predicates are
+// checked before the payload is decoded.
+func HandleJSON[T any](r *Router, filter string, fn func(topic string, v T), predicates ...Predicate) *Route {
+	return r.Handle(filter, func(msg *packet.Message) error {
+		// decode payload
+		var v T
+		err := json.Unmarshal(msg.Payload, &v)
+		if err != nil {
+			r.errorCallback(err)
+			return nil
+		}
+
+		// call function
+		fn(msg.Topic, v)
+
+		return nil
+	}, predicates...)
+}
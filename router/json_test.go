@@ -0,0 +1,49 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+type testValue struct {
+	Foo string `json:"foo"`
+}
+
+func TestHandleJSON(t *testing.T) {
+	r := New(client.NewService())
+
+	var errs []error
+	r.ErrorCallback = func(err error) {
+		errs = append(errs, err)
+	}
+
+	var values []testValue
+	HandleJSON(r, "foo/+", func(topic string, v testValue) {
+		assert.Equal(t, "foo/bar", topic)
+		values = append(values, v)
+	})
+
+	err := r.messageCallback(&packet.Message{
+		Topic:   "foo/bar",
+		Payload: []byte(`{"foo":"bar"}`),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []testValue{{Foo: "bar"}}, values)
+	assert.Empty(t, errs)
+
+	err = r.messageCallback(&packet.Message{
+		Topic:   "foo/bar",
+		Payload: []byte(`invalid`),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, values, 1)
+	assert.Len(t, errs, 1)
+
+	var syntaxErr *json.SyntaxError
+	assert.True(t, errors.As(errs[0], &syntaxErr))
+}
@@ -0,0 +1,22 @@
+package router
+
+import (
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+)
+
+// HandleParams will register a handler for the specified topic filter that
+// passes the segments captured by the wildcards of the filter to the function.
+// For example, a message received on "devices/1/status" for the filter
+// "devices/+/status" is passed with the params []string{"1"}.
+func HandleParams(r *Router, filter string, fn func(msg *packet.Message, params []string) error, predicates ...Predicate) *Route {
+	// get filter that matches the received topics
+	match := matchFilter(filter)
+
+	return r.Handle(filter, func(msg *packet.Message) error {
+		// extract params
+		params, _ := topic.Extract(match, msg.Topic)
+
+		return fn(msg, params)
+	}, predicates...)
+}
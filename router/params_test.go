@@ -0,0 +1,61 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleParams(t *testing.T) {
+	r := New(client.NewService())
+
+	var params [][]string
+	HandleParams(r, "devices/+/status/#", func(msg *packet.Message, p []string) error {
+		params = append(params, p)
+		return nil
+	})
+
+	err := r.messageCallback(&packet.Message{
+		Topic: "devices/1/status/online",
+	})
+	assert.NoError(t, err)
+
+	err = r.messageCallback(&packet.Message{
+		Topic: "devices/2/status/battery/level",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][]string{
+		{"1", "online"},
+		{"2", "battery/level"},
+	}, params)
+}
+
+func TestHandleParamsShared(t *testing.T) {
+	r := New(client.NewService())
+
+	var params [][]string
+	route := HandleParams(r, packet.SharedFilter("group", "devices/+/status"), func(msg *packet.Message, p []string) error {
+		params = append(params, p)
+		return nil
+	})
+	assert.Equal(t, "$share/group/devices/+/status", route.Filter)
+	assert.Equal(t, []string{"$share/group/devices/+/status"}, r.filters())
+
+	err := r.messageCallback(&packet.Message{
+		Topic: "devices/1/status",
+	})
+	assert.NoError(t, err)
+
+	r.Remove(route)
+	assert.Empty(t, r.filters())
+
+	err = r.messageCallback(&packet.Message{
+		Topic: "devices/2/status",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, [][]string{{"1"}}, params)
+}
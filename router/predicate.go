@@ -0,0 +1,74 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A Predicate is a function that is called with received messages before the
+// handler of a route is invoked. Messages are dropped for that route if any of
+// its predicates returns false.
+type Predicate func(*packet.Message) bool
+
+// PayloadPrefix returns a predicate that matches messages with a payload that
+// begins with the specified prefix.
+func PayloadPrefix(prefix []byte) Predicate {
+	return func(msg *packet.Message) bool {
+		return bytes.HasPrefix(msg.Payload, prefix)
+	}
+}
+
+// MinSize returns a predicate that matches messages with a payload of at
+// least the specified size in bytes.
+func MinSize(size int) Predicate {
+	return func(msg *packet.Message) bool {
+		return len(msg.Payload) >= size
+	}
+}
+
+// MaxSize returns a predicate that matches messages with a payload of at most
+// the specified size in bytes.
+func MaxSize(size int) Predicate {
+	return func(msg *packet.Message) bool {
+		return len(msg.Payload) <= size
+	}
+}
+
+// JSONField returns a predicate that matches messages with a JSON object
+// payload that contains the specified top level field with a value that is
+// semantically equal to the specified value. Numbers are compared by value and
+// objects regardless of their key order.
+func JSONField(field string, value interface{}) Predicate {
+	// encode expected value
+	buf, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+
+	// decode expected value
+	var expected interface{}
+	err = json.Unmarshal(buf, &expected)
+	if err != nil {
+		panic(err)
+	}
+
+	return func(msg *packet.Message) bool {
+		// decode object
+		var object map[string]interface{}
+		err := json.Unmarshal(msg.Payload, &object)
+		if err != nil {
+			return false
+		}
+
+		// get field
+		actual, ok := object[field]
+		if !ok {
+			return false
+		}
+
+		return reflect.DeepEqual(actual, expected)
+	}
+}
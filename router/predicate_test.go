@@ -0,0 +1,57 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPredicates(t *testing.T) {
+	msg := &packet.Message{Topic: "foo", Payload: []byte(`{"type": "temp", "value": 42}`)}
+
+	assert.True(t, PayloadPrefix([]byte(`{"type"`))(msg))
+	assert.False(t, PayloadPrefix([]byte(`[`))(msg))
+
+	assert.True(t, MinSize(10)(msg))
+	assert.False(t, MinSize(100)(msg))
+
+	assert.True(t, MaxSize(100)(msg))
+	assert.False(t, MaxSize(10)(msg))
+
+	assert.True(t, JSONField("type", "temp")(msg))
+	assert.True(t, JSONField("value", 42)(msg))
+	assert.False(t, JSONField("type", "humidity")(msg))
+	assert.False(t, JSONField("missing", "temp")(msg))
+	assert.False(t, JSONField("type", "temp")(&packet.Message{Payload: []byte("invalid")}))
+
+	msg = &packet.Message{Payload: []byte(`{"value": 1.0, "text": "a<b&c>d", "nested": {"b": [1, 2], "a": "x"}}`)}
+	assert.True(t, JSONField("value", 1)(msg))
+	assert.True(t, JSONField("text", "a<b&c>d")(msg))
+	assert.True(t, JSONField("nested", map[string]interface{}{"a": "x", "b": []int{1, 2}})(msg))
+	assert.False(t, JSONField("nested", map[string]interface{}{"a": "x"})(msg))
+}
+
+func TestRouterPredicates(t *testing.T) {
+	r := New(client.NewService())
+
+	var calls []string
+	r.Handle("foo", func(msg *packet.Message) error {
+		calls = append(calls, "all")
+		return nil
+	})
+	r.Handle("foo", func(msg *packet.Message) error {
+		calls = append(calls, "temp")
+		return nil
+	}, JSONField("type", "temp"), MaxSize(100))
+
+	err := r.messageCallback(&packet.Message{Topic: "foo", Payload: []byte(`{"type":"temp"}`)})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"all", "temp"}, calls)
+
+	calls = nil
+	err = r.messageCallback(&packet.Message{Topic: "foo", Payload: []byte(`{"type":"other"}`)})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"all"}, calls)
+}
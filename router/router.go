@@ -0,0 +1,305 @@
+// Package router implements a router on top of the client service that
+// dispatches incoming messages to handlers registered with topic filters.
+package router
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/topic"
+)
+
+// A Handler is a function that is called with messages that match the topic
+// filter of the route it has been registered with. If an error is returned
+// the underlying client will be prevented from acknowledging the message and
+// closes immediately.
+//
+// Note: Execution of the router is resumed after the handler returns. This
+// means that waiting on a future inside the handler will deadlock the router.
+type Handler func(*packet.Message) error
+
+// A Route is returned by Handle and identifies a registered handler.
+type Route struct {
+	// The topic filter of the route.
+	Filter string
+
+	// The handler of the route.
+	Handler Handler
+
+	// The predicates that must match before the handler is called.
+	Predicates []Predicate
+
+	match   string
+	sub     *Subscription
+	removed bool
+}
+
+func (r *Route) matches(msg *packet.Message) bool {
+	for _, predicate := range r.Predicates {
+		if !predicate(msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Router is an abstraction for Service that dispatches incoming messages to
+// multiple handlers. Handlers that are registered with the same topic filter
+// share a single subscription: the filter is subscribed once the first handler
+// is added and unsubscribed when the last handler has been removed. All
+// filters are subscribed again if the session could not be resumed after a
+// reconnect. If the session has been resumed, only the filters that have been
+// added while offline are subscribed and the filters that have been removed
+// while offline are unsubscribed.
+type Router struct {
+	// The QOS level used for subscriptions.
+	SubscribeQOS uint8
+
+	// The callback to be called by the router upon encountering an error.
+	ErrorCallback client.ErrorCallback
+
+	service *client.Service
+	tree    *topic.Tree
+
+	counts  map[string]int
+	added   map[string]bool
+	removed map[string]bool
+	online  bool
+
+	mutex sync.Mutex
+}
+
+// New will allocate and return a new router that uses the specified service.
+// The callbacks of the service are managed by the router and must not be
+// changed.
+func New(service *client.Service) *Router {
+	r := &Router{
+		service: service,
+		tree:    topic.NewTree(),
+		counts:  make(map[string]int),
+		added:   make(map[string]bool),
+		removed: make(map[string]bool),
+	}
+
+	// set callbacks
+	service.OnlineCallback = r.onlineCallback
+	service.MessageCallback = r.messageCallback
+	service.ErrorCallback = r.errorCallback
+	service.OfflineCallback = r.offlineCallback
+
+	return r
+}
+
+// Handle will register the handler for the specified topic filter and return
+// the created route. The filter is subscribed if it is not yet used by another
+// route. The handler is only called for messages that match all predicates.
+func (r *Router) Handle(filter string, handler Handler, predicates ...Predicate) *Route {
+	return r.handle(filter, handler, predicates, nil)
+}
+
+func (r *Router) handle(filter string, handler Handler, predicates []Predicate, sub *Subscription) *Route {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// create route
+	route := &Route{
+		Filter:     filter,
+		Handler:    handler,
+		Predicates: predicates,
+		match:      matchFilter(filter),
+		sub:        sub,
+	}
+
+	// add route
+	r.tree.Add(route.match, route)
+
+	// increment reference count
+	r.counts[filter]++
+
+	// check if first
+	if r.counts[filter] > 1 {
+		return route
+	}
+
+	// subscribe filter if online or remember it for a resumed session
+	if r.online {
+		r.service.Subscribe(filter, r.SubscribeQOS)
+	} else if r.removed[filter] {
+		delete(r.removed, filter)
+	} else {
+		r.added[filter] = true
+	}
+
+	return route
+}
+
+// Remove will remove the specified route. The filter is unsubscribed if it is
+// not used anymore by another route.
+func (r *Router) Remove(route *Route) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// check flag and reference count
+	if route.removed || r.counts[route.Filter] == 0 {
+		return
+	}
+
+	// remove route
+	r.tree.Remove(route.match, route)
+
+	// set flag
+	route.removed = true
+
+	// signal subscription
+	if route.sub != nil {
+		close(route.sub.done)
+	}
+
+	// decrement reference count
+	r.counts[route.Filter]--
+	if r.counts[route.Filter] > 0 {
+		return
+	}
+
+	// remove filter
+	delete(r.counts, route.Filter)
+
+	// unsubscribe filter if online or remember it for a resumed session
+	if r.online {
+		r.service.Unsubscribe(route.Filter)
+	} else if r.added[route.Filter] {
+		delete(r.added, route.Filter)
+	} else {
+		r.removed[route.Filter] = true
+	}
+}
+
+// Publish will publish the specified message using the underlying service.
+func (r *Router) Publish(topic string, payload []byte, qos uint8, retain bool) client.PublishFuture {
+	return r.service.Publish(topic, payload, qos, retain)
+}
+
+// Start will start the underlying service with the specified configuration.
+func (r *Router) Start(config *client.Config) {
+	r.service.Start(config)
+}
+
+// Stop will stop the underlying service and cancel all futures if requested.
+func (r *Router) Stop(clearFutures bool) {
+	r.service.Stop(clearFutures)
+}
+
+func (r *Router) onlineCallback(resumed bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// set flag
+	r.online = true
+
+	// get filters to subscribe
+	filters := r.filters()
+	if resumed {
+		filters = sortedKeys(r.added)
+	}
+
+	// subscribe filters
+	if len(filters) > 0 {
+		subscriptions := make([]packet.Subscription, 0, len(filters))
+		for _, filter := range filters {
+			subscriptions = append(subscriptions, packet.Subscription{
+				Topic: filter,
+				QOS:   r.SubscribeQOS,
+			})
+		}
+
+		r.service.SubscribeMultiple(subscriptions)
+	}
+
+	// unsubscribe filters that have been removed while offline
+	if resumed && len(r.removed) > 0 {
+		r.service.UnsubscribeMultiple(sortedKeys(r.removed))
+	}
+
+	// reset sets
+	r.added = make(map[string]bool)
+	r.removed = make(map[string]bool)
+}
+
+func (r *Router) messageCallback(msg *packet.Message) error {
+	// call all matching handlers
+	for _, value := range r.tree.Match(msg.Topic) {
+		route := value.(*Route)
+
+		// check predicates
+		if !route.matches(msg) {
+			continue
+		}
+
+		// count delivery
+		if route.sub != nil {
+			atomic.AddUint64(&route.sub.delivered, 1)
+		}
+
+		err := route.Handler(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Router) errorCallback(err error) {
+	if r.ErrorCallback != nil {
+		r.ErrorCallback(err)
+	}
+}
+
+func (r *Router) offlineCallback() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// set flag
+	r.online = false
+}
+
+func (r *Router) filters() []string {
+	// collect filters
+	filters := make([]string, 0, len(r.counts))
+	for filter := range r.counts {
+		filters = append(filters, filter)
+	}
+
+	// sort filters
+	sort.Strings(filters)
+
+	return filters
+}
+
+func sortedKeys(set map[string]bool) []string {
+	// collect keys
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+
+	// sort keys
+	sort.Strings(keys)
+
+	return keys
+}
+
+// matchFilter returns the filter without the shared subscription prefix as
+// messages are delivered with their original topic
+func matchFilter(filter string) string {
+	_, match, err := packet.ParseShared(filter)
+	if err != nil {
+		return filter
+	}
+
+	return match
+}
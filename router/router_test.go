@@ -0,0 +1,212 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport/flow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterSubscriptionCoalescing(t *testing.T) {
+	subscribe1 := packet.NewSubscribePacket()
+	subscribe1.Subscriptions = []packet.Subscription{{Topic: "foo"}}
+	subscribe1.ID = 1
+
+	suback1 := packet.NewSubackPacket()
+	suback1.ReturnCodes = []uint8{0}
+	suback1.ID = 1
+
+	publish := packet.NewPublishPacket()
+	publish.Message.Topic = "foo"
+	publish.Message.Payload = []byte("foo")
+
+	subscribe2 := packet.NewSubscribePacket()
+	subscribe2.Subscriptions = []packet.Subscription{{Topic: "bar"}}
+	subscribe2.ID = 2
+
+	suback2 := packet.NewSubackPacket()
+	suback2.ReturnCodes = []uint8{0}
+	suback2.ID = 2
+
+	unsubscribe := packet.NewUnsubscribePacket()
+	unsubscribe.Topics = []string{"foo"}
+	unsubscribe.ID = 3
+
+	unsuback := packet.NewUnsubackPacket()
+	unsuback.ID = 3
+
+	unsubscribed := make(chan struct{})
+
+	broker := flow.New().
+		Receive(connectPacket()).
+		Send(connackPacket()).
+		Receive(subscribe1).
+		Send(suback1).
+		Send(publish).
+		Receive(subscribe2).
+		Send(suback2).
+		Receive(unsubscribe).
+		Send(unsuback).
+		Run(func() {
+			close(unsubscribed)
+		}).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker)
+
+	message1 := make(chan struct{})
+	message2 := make(chan struct{})
+
+	r := New(client.NewService())
+
+	r.ErrorCallback = func(err error) {
+		assert.Fail(t, "error callback should not have been called")
+	}
+
+	route1 := r.Handle("foo", func(msg *packet.Message) error {
+		assert.Equal(t, "foo", msg.Topic)
+		close(message1)
+		return nil
+	})
+
+	route2 := r.Handle("foo", func(msg *packet.Message) error {
+		assert.Equal(t, "foo", msg.Topic)
+		close(message2)
+		return nil
+	})
+
+	r.Remove(r.Handle("baz", func(*packet.Message) error {
+		assert.Fail(t, "handler should not have been called")
+		return nil
+	}))
+
+	r.Start(client.NewConfig("tcp://localhost:" + port))
+
+	safeReceive(message1)
+	safeReceive(message2)
+
+	r.Remove(route1)
+
+	r.Handle("bar", func(*packet.Message) error {
+		return nil
+	})
+
+	r.Remove(route2)
+	r.Remove(route2)
+
+	safeReceive(unsubscribed)
+
+	r.Stop(true)
+
+	safeReceive(done)
+}
+
+func TestRouterResumedSessionDiff(t *testing.T) {
+	connect := connectPacket()
+	connect.ClientID = "test"
+	connect.CleanSession = false
+
+	subscribe1 := packet.NewSubscribePacket()
+	subscribe1.Subscriptions = []packet.Subscription{{Topic: "bar"}, {Topic: "foo"}}
+	subscribe1.ID = 1
+
+	suback1 := packet.NewSubackPacket()
+	suback1.ReturnCodes = []uint8{0, 0}
+	suback1.ID = 1
+
+	connack := connackPacket()
+	connack.SessionPresent = true
+
+	subscribe2 := packet.NewSubscribePacket()
+	subscribe2.Subscriptions = []packet.Subscription{{Topic: "baz"}}
+	subscribe2.ID = 2
+
+	suback2 := packet.NewSubackPacket()
+	suback2.ReturnCodes = []uint8{0}
+	suback2.ID = 2
+
+	unsubscribe := packet.NewUnsubscribePacket()
+	unsubscribe.Topics = []string{"foo"}
+	unsubscribe.ID = 3
+
+	unsuback := packet.NewUnsubackPacket()
+	unsuback.ID = 3
+
+	subscribed := make(chan struct{})
+	disconnect := make(chan struct{})
+	unsubscribed := make(chan struct{})
+
+	broker1 := flow.New().
+		Receive(connect).
+		Send(connackPacket()).
+		Receive(subscribe1).
+		Send(suback1).
+		Run(func() {
+			close(subscribed)
+		}).
+		Wait(disconnect).
+		Close()
+
+	broker2 := flow.New().
+		Receive(connect).
+		Send(connack).
+		Receive(subscribe2).
+		Send(suback2).
+		Receive(unsubscribe).
+		Send(unsuback).
+		Run(func() {
+			close(unsubscribed)
+		}).
+		Receive(disconnectPacket()).
+		End()
+
+	done, port := fakeBroker(t, broker1, broker2)
+
+	s := client.NewService()
+	s.MinReconnectDelay = 500 * time.Millisecond
+
+	r := New(s)
+
+	handler := func(*packet.Message) error {
+		return nil
+	}
+
+	foo := r.Handle("foo", handler)
+	bar := r.Handle("bar", handler)
+
+	config := client.NewConfig("tcp://localhost:" + port)
+	config.ClientID = "test"
+	config.CleanSession = false
+
+	r.Start(config)
+
+	safeReceive(subscribed)
+
+	// wait until offline
+	close(disconnect)
+	for {
+		r.mutex.Lock()
+		online := r.online
+		r.mutex.Unlock()
+		if !online {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	r.Remove(foo)
+	r.Remove(bar)
+	r.Handle("bar", handler)
+	r.Handle("baz", handler)
+
+	safeReceive(unsubscribed)
+
+	r.Stop(true)
+
+	safeReceive(done)
+}
@@ -0,0 +1,56 @@
+package router
+
+import "sync/atomic"
+
+// A Subscription is returned by Subscribe and manages the lifecycle of a
+// single route. Closing the subscription removes the route and unsubscribes
+// the filter if it is not used anymore by another route.
+type Subscription struct {
+	router    *Router
+	route     *Route
+	done      chan struct{}
+	delivered uint64
+}
+
+// Subscribe will register the handler for the specified topic filter like
+// Handle and return a subscription that can be used to remove it again.
+func (r *Router) Subscribe(filter string, handler Handler, predicates ...Predicate) *Subscription {
+	// prepare subscription
+	sub := &Subscription{
+		router: r,
+		done:   make(chan struct{}),
+	}
+
+	// add route
+	sub.route = r.handle(filter, handler, predicates, sub)
+
+	return sub
+}
+
+// Filter returns the topic filter of the subscription.
+func (s *Subscription) Filter() string {
+	return s.route.Filter
+}
+
+// Route returns the underlying route of the subscription.
+func (s *Subscription) Route() *Route {
+	return s.route
+}
+
+// Delivered returns the number of messages that have been delivered to the
+// handler of the subscription.
+func (s *Subscription) Delivered() uint64 {
+	return atomic.LoadUint64(&s.delivered)
+}
+
+// Done returns a channel that is closed once the subscription has been closed
+// or its route has been removed.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close will remove the route of the subscription. It is safe to call Close
+// multiple times.
+func (s *Subscription) Close() {
+	s.router.Remove(s.route)
+}
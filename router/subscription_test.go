@@ -0,0 +1,79 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscription(t *testing.T) {
+	r := New(client.NewService())
+
+	var received []string
+	sub := r.Subscribe("foo/+", func(msg *packet.Message) error {
+		received = append(received, msg.Topic)
+		return nil
+	}, func(msg *packet.Message) bool {
+		return msg.QOS == 0
+	})
+	assert.Equal(t, "foo/+", sub.Filter())
+	assert.Equal(t, "foo/+", sub.Route().Filter)
+	assert.Equal(t, uint64(0), sub.Delivered())
+
+	err := r.messageCallback(&packet.Message{Topic: "foo/bar"})
+	assert.NoError(t, err)
+
+	err = r.messageCallback(&packet.Message{Topic: "foo/baz", QOS: 1})
+	assert.NoError(t, err)
+
+	err = r.messageCallback(&packet.Message{Topic: "bar/baz"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"foo/bar"}, received)
+	assert.Equal(t, uint64(1), sub.Delivered())
+
+	select {
+	case <-sub.Done():
+		assert.Fail(t, "subscription should not be done")
+	default:
+	}
+
+	sub.Close()
+	sub.Close()
+
+	select {
+	case <-sub.Done():
+	default:
+		assert.Fail(t, "subscription should be done")
+	}
+
+	assert.Empty(t, r.counts)
+	assert.Empty(t, r.added)
+	assert.Empty(t, r.removed)
+
+	err = r.messageCallback(&packet.Message{Topic: "foo/bar"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), sub.Delivered())
+}
+
+func TestSubscriptionRemove(t *testing.T) {
+	r := New(client.NewService())
+
+	sub1 := r.Subscribe("foo", func(*packet.Message) error { return nil })
+	sub2 := r.Subscribe("foo", func(*packet.Message) error { return nil })
+
+	r.Remove(sub1.Route())
+	r.Remove(sub1.Route())
+	assert.Equal(t, 1, r.counts["foo"])
+
+	select {
+	case <-sub1.Done():
+	default:
+		assert.Fail(t, "subscription should be done")
+	}
+
+	sub2.Close()
+	assert.Empty(t, r.counts)
+}
@@ -0,0 +1,114 @@
+// Package routines implements a registry of the goroutines started by the
+// client and transport packages to verify that they terminate properly. The
+// registry is only maintained once tracking has been enabled.
+package routines
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var enabled atomic.Bool
+var counters sync.Map
+
+// Enable will enable the tracking of goroutines. Tracking is disabled by
+// default and should be enabled by tests before any goroutines are started.
+func Enable() {
+	enabled.Store(true)
+}
+
+// Track will register a running goroutine with the specified name and return a
+// function that must be called once the goroutine returns.
+func Track(name string) func() {
+	// check flag
+	if !enabled.Load() {
+		return func() {}
+	}
+
+	// get counter
+	value, ok := counters.Load(name)
+	if !ok {
+		value, _ = counters.LoadOrStore(name, new(atomic.Int64))
+	}
+	counter := value.(*atomic.Int64)
+
+	// increment counter
+	counter.Add(1)
+
+	return func() {
+		// decrement counter
+		counter.Add(-1)
+	}
+}
+
+// Go will run the function in a new goroutine that is tracked using the
+// specified name.
+func Go(name string, fn func()) {
+	done := Track(name)
+
+	go func() {
+		defer done()
+		fn()
+	}()
+}
+
+// Wrap will return a function that runs the specified function and is tracked
+// using the specified name from now on. It is intended to be used with
+// functions like tomb.Go that run the returned function in a new goroutine.
+func Wrap(name string, fn func() error) func() error {
+	done := Track(name)
+
+	return func() error {
+		defer done()
+		return fn()
+	}
+}
+
+// Active will return the number of running goroutines per name.
+func Active() map[string]int {
+	// collect counters
+	m := make(map[string]int)
+	counters.Range(func(key, value interface{}) bool {
+		if count := value.(*atomic.Int64).Load(); count > 0 {
+			m[key.(string)] = int(count)
+		}
+		return true
+	})
+
+	return m
+}
+
+// Wait will wait until all tracked goroutines have returned. It will return an
+// error that lists the running goroutines if the timeout has been reached.
+func Wait(timeout time.Duration) error {
+	// calculate deadline
+	deadline := time.Now().Add(timeout)
+
+	for {
+		// check goroutines
+		running := Active()
+		if len(running) == 0 {
+			return nil
+		}
+
+		// check deadline
+		if time.Now().After(deadline) {
+			// list goroutines
+			list := make([]string, 0, len(running))
+			for name, count := range running {
+				list = append(list, fmt.Sprintf("%s (%d)", name, count))
+			}
+
+			// sort list
+			sort.Strings(list)
+
+			return fmt.Errorf("goroutines still running: %s", strings.Join(list, ", "))
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,38 @@
+package routines
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutines(t *testing.T) {
+	Go("disabled", func() {})
+	assert.Empty(t, Active())
+
+	Enable()
+
+	stop := make(chan struct{})
+
+	Go("foo", func() {
+		<-stop
+	})
+
+	fn := Wrap("bar", func() error {
+		<-stop
+		return nil
+	})
+
+	go fn()
+
+	assert.Equal(t, map[string]int{"foo": 1, "bar": 1}, Active())
+
+	err := Wait(10 * time.Millisecond)
+	assert.EqualError(t, err, "goroutines still running: bar (1), foo (1)")
+
+	close(stop)
+
+	assert.NoError(t, Wait(time.Second))
+	assert.Empty(t, Active())
+}
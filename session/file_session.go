@@ -0,0 +1,359 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A FileSession stores packets in a directory so that unacknowledged packets
+// survive restarts of the process. Every packet is written to its own file
+// in a subdirectory for each direction. Files are written to a temporary file,
+// synced to disk and then renamed, a crash will therefore never leave a
+// partially written packet behind. Packets are stored using MQTT 5 to retain
+// their properties and message expiries.
+//
+// Note: Subscriptions and will messages are not persisted.
+type FileSession struct {
+	dir      string
+	counter  *IDCounter
+	recorder recorder
+	mutex    sync.Mutex
+}
+
+// NewFileSession opens or creates a FileSession in the specified directory.
+// The packet id counter continues after the highest stored outgoing packet id.
+func NewFileSession(dir string) (*FileSession, error) {
+	// prepare session
+	s := &FileSession{
+		dir:     dir,
+		counter: NewIDCounter(),
+	}
+
+	// create directories
+	for _, d := range []Direction{Incoming, Outgoing} {
+		err := os.MkdirAll(s.path(d), 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// continue counter
+	ids, err := s.ids(Outgoing)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) > 0 {
+		s.counter.Set(ids[len(ids)-1] + 1)
+	}
+
+	return s, nil
+}
+
+// NextID will return the next id for outgoing packets.
+func (s *FileSession) NextID() packet.ID {
+	return s.counter.NextID()
+}
+
+// SavePacket will store a packet in the session. An eventual existing
+// packet with the same id gets quietly overwritten.
+func (s *FileSession) SavePacket(dir Direction, pkt packet.GenericPacket) (err error) {
+	// record operation
+	defer s.recorder.record(time.Now(), &err)
+
+	// get id
+	id, ok := packet.GetID(pkt)
+	if !ok {
+		return nil
+	}
+
+	// encode packet
+	buf, err := encodePacket(pkt)
+	if err != nil {
+		return err
+	}
+
+	// acquire mutex
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// write temporary file
+	file := s.file(dir, id)
+	err = writeFile(file+".tmp", buf)
+	if err != nil {
+		return err
+	}
+
+	// replace file
+	err = os.Rename(file+".tmp", file)
+	if err != nil {
+		return err
+	}
+
+	// sync directory to persist rename
+	return syncDir(s.path(dir))
+}
+
+// LookupPacket will retrieve a packet from the session using a packet id.
+func (s *FileSession) LookupPacket(dir Direction, id packet.ID) (pkt packet.GenericPacket, err error) {
+	// record operation
+	defer s.recorder.record(time.Now(), &err)
+
+	// acquire mutex
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// read packet
+	pkt, err = s.read(dir, id)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return pkt, err
+}
+
+// DeletePacket will remove a packet from the session. The method must not
+// return an error if no packet with the specified id does exists.
+func (s *FileSession) DeletePacket(dir Direction, id packet.ID) (err error) {
+	// record operation
+	defer s.recorder.record(time.Now(), &err)
+
+	// acquire mutex
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// remove file
+	err = os.Remove(s.file(dir, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// AllPackets will return all packets currently saved in the session ordered
+// by their id.
+func (s *FileSession) AllPackets(dir Direction) (pkts []packet.GenericPacket, err error) {
+	// record operation
+	defer s.recorder.record(time.Now(), &err)
+
+	// acquire mutex
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// get ids
+	ids, err := s.ids(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// read packets
+	pkts = make([]packet.GenericPacket, 0, len(ids))
+	for _, id := range ids {
+		pkt, err := s.read(dir, id)
+		if err != nil {
+			return nil, err
+		}
+
+		pkts = append(pkts, pkt)
+	}
+
+	return pkts, nil
+}
+
+// Reset will completely reset the session.
+func (s *FileSession) Reset() error {
+	// acquire mutex
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// remove all packets
+	for _, d := range []Direction{Incoming, Outgoing} {
+		ids, err := s.ids(d)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			err = os.Remove(s.file(d, id))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	// reset counter
+	s.counter.Reset()
+
+	return nil
+}
+
+// Health checks that the directories of the session are writable and that all
+// stored packets can be read and decoded. It returns an error if the storage
+// became unavailable or a stored packet has been corrupted.
+func (s *FileSession) Health() error {
+	// acquire mutex
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, d := range []Direction{Incoming, Outgoing} {
+		name := filepath.Base(s.path(d))
+
+		// write and remove probe file
+		probe := filepath.Join(s.path(d), "health.tmp")
+		err := os.WriteFile(probe, nil, 0600)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		err = os.Remove(probe)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		// get ids
+		ids, err := s.ids(d)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		// read packets
+		for _, id := range ids {
+			_, err = s.read(d, id)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Stats will return the number of stored packets and the latency of the
+// storage operations performed since the session has been opened.
+func (s *FileSession) Stats() (Stats, error) {
+	// acquire mutex
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// get ids
+	incoming, err := s.ids(Incoming)
+	if err != nil {
+		return Stats{}, err
+	}
+	outgoing, err := s.ids(Outgoing)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	// prepare stats
+	stats := Stats{
+		IncomingPackets: len(incoming),
+		OutgoingPackets: len(outgoing),
+	}
+
+	// fill metrics
+	s.recorder.fill(&stats)
+
+	return stats, nil
+}
+
+func (s *FileSession) path(dir Direction) string {
+	if dir == Incoming {
+		return filepath.Join(s.dir, "incoming")
+	}
+
+	return filepath.Join(s.dir, "outgoing")
+}
+
+func (s *FileSession) file(dir Direction, id packet.ID) string {
+	return filepath.Join(s.path(dir), strconv.Itoa(int(id)))
+}
+
+func (s *FileSession) read(dir Direction, id packet.ID) (packet.GenericPacket, error) {
+	// read file
+	buf, err := os.ReadFile(s.file(dir, id))
+	if err != nil {
+		return nil, err
+	}
+
+	// decode packet
+	pkt, err := decodePacket(buf)
+	if err != nil {
+		return nil, fmt.Errorf("packet %d: %w", id, err)
+	}
+
+	return pkt, nil
+}
+
+// writes and syncs the file
+func writeFile(name string, buf []byte) error {
+	// create file
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	// write and sync file
+	_, err = f.Write(buf)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	// close file
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+// syncs the directory
+func syncDir(name string) error {
+	// open directory
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	// sync directory
+	err = d.Sync()
+
+	// close directory
+	if err1 := d.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+// returns the sorted ids of all stored packets
+func (s *FileSession) ids(dir Direction) ([]packet.ID, error) {
+	// read directory
+	entries, err := os.ReadDir(s.path(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	// parse names and skip temporary files
+	ids := make([]packet.ID, 0, len(entries))
+	for _, entry := range entries {
+		id, err := strconv.ParseUint(entry.Name(), 10, 16)
+		if err == nil && id > 0 {
+			ids = append(ids, packet.ID(id))
+		}
+	}
+
+	// sort ids
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	return ids, nil
+}
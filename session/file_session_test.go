@@ -0,0 +1,185 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSessionPacketStore(t *testing.T) {
+	session, err := NewFileSession(t.TempDir())
+	require.NoError(t, err)
+
+	publish := packet.NewPublishPacket()
+	publish.ID = 1
+	publish.Message.Topic = "foo"
+	publish.Message.Payload = []byte("bar")
+	publish.Message.QOS = 1
+
+	pkt, err := session.LookupPacket(Incoming, 1)
+	assert.NoError(t, err)
+	assert.Nil(t, pkt)
+
+	err = session.SavePacket(Incoming, publish)
+	assert.NoError(t, err)
+
+	pkt, err = session.LookupPacket(Incoming, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, publish, pkt)
+
+	list, err := session.AllPackets(Incoming)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(list))
+
+	list, err = session.AllPackets(Outgoing)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+
+	err = session.DeletePacket(Incoming, 1)
+	assert.NoError(t, err)
+
+	err = session.DeletePacket(Incoming, 1)
+	assert.NoError(t, err)
+
+	pkt, err = session.LookupPacket(Incoming, 1)
+	assert.NoError(t, err)
+	assert.Nil(t, pkt)
+}
+
+func TestFileSessionVersion5(t *testing.T) {
+	dir := t.TempDir()
+
+	session, err := NewFileSession(dir)
+	require.NoError(t, err)
+
+	publish := packet.NewPublishPacket()
+	publish.ID = 1
+	publish.Message.Topic = "foo"
+	publish.Message.QOS = 1
+	publish.Message.Expiry = time.Minute
+	publish.Properties = publish.Properties.Set(packet.ContentTypeProperty, "text/plain")
+
+	err = session.SavePacket(Outgoing, publish)
+	assert.NoError(t, err)
+
+	session, err = NewFileSession(dir)
+	require.NoError(t, err)
+
+	pkt, err := session.LookupPacket(Outgoing, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, publish, pkt)
+
+	// packets stored without a protocol level are still supported
+	legacy := packet.NewPublishPacket()
+	legacy.ID = 2
+	legacy.Message.Topic = "bar"
+	legacy.Message.QOS = 1
+
+	buf := make([]byte, legacy.Len())
+	_, err = legacy.Encode(buf)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "outgoing", "2"), buf, 0600))
+
+	pkt, err = session.LookupPacket(Outgoing, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy, pkt)
+}
+
+func TestFileSessionPersistence(t *testing.T) {
+	dir := t.TempDir()
+
+	session, err := NewFileSession(dir)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		publish := packet.NewPublishPacket()
+		publish.ID = session.NextID()
+		publish.Message.Topic = "foo"
+		publish.Message.QOS = 2
+
+		err = session.SavePacket(Outgoing, publish)
+		assert.NoError(t, err)
+	}
+
+	pubrel := packet.NewPubrelPacket()
+	pubrel.ID = 2
+
+	err = session.SavePacket(Outgoing, pubrel)
+	assert.NoError(t, err)
+
+	session, err = NewFileSession(dir)
+	require.NoError(t, err)
+
+	list, err := session.AllPackets(Outgoing)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, packet.PUBLISH, list[0].Type())
+	assert.Equal(t, pubrel, list[1])
+	assert.Equal(t, packet.PUBLISH, list[2].Type())
+	assert.Equal(t, packet.ID(4), session.NextID())
+
+	err = session.Reset()
+	assert.NoError(t, err)
+
+	session, err = NewFileSession(dir)
+	require.NoError(t, err)
+
+	list, err = session.AllPackets(Outgoing)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(list))
+	assert.Equal(t, packet.ID(1), session.NextID())
+}
+
+func TestFileSessionHealthAndStats(t *testing.T) {
+	dir := t.TempDir()
+
+	session, err := NewFileSession(dir)
+	require.NoError(t, err)
+	assert.NoError(t, session.Health())
+
+	publish := packet.NewPublishPacket()
+	publish.ID = 1
+	publish.Message.Topic = "foo"
+	publish.Message.QOS = 1
+
+	err = session.SavePacket(Outgoing, publish)
+	assert.NoError(t, err)
+
+	_, err = session.LookupPacket(Outgoing, 1)
+	assert.NoError(t, err)
+
+	stats, err := session.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.IncomingPackets)
+	assert.Equal(t, 1, stats.OutgoingPackets)
+	assert.Equal(t, uint64(2), stats.Operations)
+	assert.Equal(t, uint64(0), stats.Failures)
+	assert.True(t, stats.MaxLatency > 0)
+	assert.True(t, stats.AverageLatency <= stats.MaxLatency)
+
+	err = os.WriteFile(filepath.Join(dir, "outgoing", "1"), []byte{0xFF}, 0600)
+	require.NoError(t, err)
+
+	assert.Error(t, session.Health())
+
+	_, err = session.LookupPacket(Outgoing, 1)
+	assert.Error(t, err)
+
+	stats, err = session.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), stats.Operations)
+	assert.Equal(t, uint64(1), stats.Failures)
+
+	err = os.RemoveAll(filepath.Join(dir, "incoming"))
+	require.NoError(t, err)
+
+	assert.Error(t, session.Health())
+
+	_, err = session.Stats()
+	assert.Error(t, err)
+}
@@ -38,6 +38,24 @@ func (c *IDCounter) NextID() packet.ID {
 	return id
 }
 
+// Current will return the next id without incrementing the counter.
+func (c *IDCounter) Current() packet.ID {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.current
+}
+
+// Set will set the next id. A zero id is ignored.
+func (c *IDCounter) Set(id packet.ID) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if id != 0 {
+		c.current = id
+	}
+}
+
 // Reset will reset the counter.
 func (c *IDCounter) Reset() {
 	c.mutex.Lock()
@@ -146,6 +146,67 @@ func (s *MemorySession) Reset() error {
 	return nil
 }
 
+// Health will always return nil as the session is kept in memory.
+func (s *MemorySession) Health() error {
+	return nil
+}
+
+// Stats will return the number of stored packets and subscriptions. As the
+// session is kept in memory, storage operations are not recorded.
+func (s *MemorySession) Stats() (Stats, error) {
+	return Stats{
+		IncomingPackets: s.incStore.Len(),
+		OutgoingPackets: s.outStore.Len(),
+		Subscriptions:   len(s.subscriptions.All()),
+	}, nil
+}
+
+// Snapshot will return a snapshot of the next packet id, the stored packets and
+// subscriptions.
+func (s *MemorySession) Snapshot() (*Snapshot, error) {
+	// prepare snapshot
+	snapshot := &Snapshot{
+		NextID:   s.counter.Current(),
+		Incoming: s.incStore.All(),
+		Outgoing: s.outStore.All(),
+	}
+
+	// add subscriptions
+	for _, value := range s.subscriptions.All() {
+		snapshot.Subscriptions = append(snapshot.Subscriptions, *value.(*packet.Subscription))
+	}
+
+	return snapshot, nil
+}
+
+// Restore will reset the session and restore the state from the snapshot.
+func (s *MemorySession) Restore(snapshot *Snapshot) error {
+	// reset session
+	err := s.Reset()
+	if err != nil {
+		return err
+	}
+
+	// restore counter
+	s.counter.Set(snapshot.NextID)
+
+	// restore packets
+	for _, pkt := range snapshot.Incoming {
+		s.incStore.Save(pkt)
+	}
+	for _, pkt := range snapshot.Outgoing {
+		s.outStore.Save(pkt)
+	}
+
+	// restore subscriptions
+	for i := range snapshot.Subscriptions {
+		sub := snapshot.Subscriptions[i]
+		s.subscriptions.Set(sub.Topic, &sub)
+	}
+
+	return nil
+}
+
 func (s *MemorySession) storeForDirection(dir Direction) *PacketStore {
 	if dir == Incoming {
 		return s.incStore
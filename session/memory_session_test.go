@@ -164,3 +164,24 @@ func TestMemorySessionWillStore(t *testing.T) {
 	assert.Nil(t, will)
 	assert.NoError(t, err)
 }
+
+func TestMemorySessionHealthAndStats(t *testing.T) {
+	session := NewMemorySession()
+	assert.NoError(t, session.Health())
+
+	publish := packet.NewPublishPacket()
+	publish.ID = 1
+
+	err := session.SavePacket(Incoming, publish)
+	assert.NoError(t, err)
+
+	err = session.SaveSubscription(&packet.Subscription{Topic: "foo"})
+	assert.NoError(t, err)
+
+	stats, err := session.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{
+		IncomingPackets: 1,
+		Subscriptions:   1,
+	}, stats)
+}
@@ -61,6 +61,14 @@ func (s *PacketStore) All() []packet.GenericPacket {
 	return all
 }
 
+// Len will return the number of packets currently saved in the store.
+func (s *PacketStore) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.packets)
+}
+
 // Reset will reset the store.
 func (s *PacketStore) Reset() {
 	s.mutex.Lock()
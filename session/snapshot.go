@@ -0,0 +1,162 @@
+package session
+
+import (
+	"encoding/json"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// A Snapshot captures the state of a session at a specific time. It can be
+// persisted using MarshalBinary and restored after a crash.
+type Snapshot struct {
+	// The next packet id.
+	NextID packet.ID
+
+	// The stored incoming and outgoing packets.
+	Incoming []packet.GenericPacket
+	Outgoing []packet.GenericPacket
+
+	// The stored subscriptions.
+	Subscriptions []packet.Subscription
+}
+
+// the encoded form of a snapshot
+type snapshotData struct {
+	NextID        packet.ID             `json:"next_id"`
+	Incoming      [][]byte              `json:"incoming,omitempty"`
+	Outgoing      [][]byte              `json:"outgoing,omitempty"`
+	Subscriptions []packet.Subscription `json:"subscriptions,omitempty"`
+}
+
+// MarshalBinary encodes the snapshot.
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	// prepare data
+	data := snapshotData{
+		NextID:        s.NextID,
+		Subscriptions: s.Subscriptions,
+	}
+
+	// encode packets
+	var err error
+	data.Incoming, err = encodePackets(s.Incoming)
+	if err != nil {
+		return nil, err
+	}
+	data.Outgoing, err = encodePackets(s.Outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(data)
+}
+
+// UnmarshalBinary decodes a snapshot that has been encoded using
+// MarshalBinary.
+func (s *Snapshot) UnmarshalBinary(buf []byte) error {
+	// decode data
+	var data snapshotData
+	err := json.Unmarshal(buf, &data)
+	if err != nil {
+		return err
+	}
+
+	// decode packets
+	incoming, err := decodePackets(data.Incoming)
+	if err != nil {
+		return err
+	}
+	outgoing, err := decodePackets(data.Outgoing)
+	if err != nil {
+		return err
+	}
+
+	// set fields
+	s.NextID = data.NextID
+	s.Incoming = incoming
+	s.Outgoing = outgoing
+	s.Subscriptions = data.Subscriptions
+
+	return nil
+}
+
+func encodePackets(pkts []packet.GenericPacket) ([][]byte, error) {
+	list := make([][]byte, 0, len(pkts))
+	for _, pkt := range pkts {
+		buf, err := encodePacket(pkt)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, buf)
+	}
+
+	return list, nil
+}
+
+func decodePackets(list [][]byte) ([]packet.GenericPacket, error) {
+	pkts := make([]packet.GenericPacket, 0, len(list))
+	for _, buf := range list {
+		pkt, err := decodePacket(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		pkts = append(pkts, pkt)
+	}
+
+	return pkts, nil
+}
+
+// encodes the packet using MQTT 5 so that properties and message expiries are
+// retained and prefixes it with the used protocol level
+func encodePacket(pkt packet.GenericPacket) ([]byte, error) {
+	// check packet
+	versioned, ok := pkt.(packet.VersionedPacket)
+	if !ok {
+		buf := make([]byte, pkt.Len())
+		_, err := pkt.Encode(buf)
+		return buf, err
+	}
+
+	// encode packet
+	buf := make([]byte, 1+versioned.LenVersion(packet.Version5))
+	buf[0] = packet.Version5
+	_, err := versioned.EncodeVersion(buf[1:], packet.Version5)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// decodes a packet that has been encoded using encodePacket or without a
+// protocol level prefix by earlier versions
+func decodePacket(buf []byte) (packet.GenericPacket, error) {
+	// get protocol level, packet types start at 0x10
+	var version byte
+	if len(buf) > 0 && buf[0] < 0x10 {
+		version = buf[0]
+		buf = buf[1:]
+	}
+
+	// detect packet
+	_, typ := packet.DetectPacket(buf)
+
+	// create packet
+	pkt, err := typ.New()
+	if err != nil {
+		return nil, err
+	}
+
+	// decode packet
+	if versioned, ok := pkt.(packet.VersionedPacket); ok && version != 0 {
+		_, err = versioned.DecodeVersion(buf, version)
+	} else {
+		_, err = pkt.Decode(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pkt, nil
+}
@@ -0,0 +1,68 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySessionSnapshot(t *testing.T) {
+	session := NewMemorySession()
+	session.NextID()
+	session.NextID()
+
+	publish := packet.NewPublishPacket()
+	publish.ID = 1
+	publish.Message.Topic = "test"
+	publish.Message.Payload = []byte("test")
+	publish.Message.QOS = 2
+	publish.Message.Expiry = time.Minute
+	publish.Properties = publish.Properties.Set(packet.ContentTypeProperty, "text/plain")
+	assert.NoError(t, session.SavePacket(Incoming, publish))
+
+	pubrel := packet.NewPubrelPacket()
+	pubrel.ID = 2
+	assert.NoError(t, session.SavePacket(Outgoing, pubrel))
+
+	sub := &packet.Subscription{Topic: "foo/#", QOS: 1}
+	assert.NoError(t, session.SaveSubscription(sub))
+
+	snapshot, err := session.Snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, &Snapshot{
+		NextID:        3,
+		Incoming:      []packet.GenericPacket{publish},
+		Outgoing:      []packet.GenericPacket{pubrel},
+		Subscriptions: []packet.Subscription{*sub},
+	}, snapshot)
+
+	buf, err := snapshot.MarshalBinary()
+	assert.NoError(t, err)
+
+	var decoded Snapshot
+	assert.NoError(t, decoded.UnmarshalBinary(buf))
+	assert.Equal(t, snapshot.NextID, decoded.NextID)
+	assert.Equal(t, snapshot.Subscriptions, decoded.Subscriptions)
+	assert.Equal(t, publish, decoded.Incoming[0])
+	assert.Equal(t, pubrel.String(), decoded.Outgoing[0].String())
+
+	restored := NewMemorySession()
+	assert.NoError(t, restored.Restore(&decoded))
+	assert.Equal(t, packet.ID(3), restored.NextID())
+
+	pkt, err := restored.LookupPacket(Incoming, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, publish.String(), pkt.String())
+
+	pkt, err = restored.LookupPacket(Outgoing, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, pubrel.String(), pkt.String())
+
+	found, err := restored.LookupSubscription("foo/bar")
+	assert.NoError(t, err)
+	assert.Equal(t, sub, found)
+
+	assert.Error(t, decoded.UnmarshalBinary([]byte(`{"incoming":["AA=="]}`)))
+}
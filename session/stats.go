@@ -0,0 +1,68 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats describes the size of a session and the latency of the operations
+// performed on its storage.
+type Stats struct {
+	// The number of stored incoming and outgoing packets.
+	IncomingPackets int
+	OutgoingPackets int
+
+	// The number of stored subscriptions.
+	Subscriptions int
+
+	// The number of storage operations and how many of them failed.
+	Operations uint64
+	Failures   uint64
+
+	// The average and maximum latency of the storage operations.
+	AverageLatency time.Duration
+	MaxLatency     time.Duration
+}
+
+// a recorder collects the latency and failures of storage operations
+type recorder struct {
+	operations uint64
+	failures   uint64
+	total      time.Duration
+	max        time.Duration
+	mutex      sync.Mutex
+}
+
+// records an operation that started at the specified time, it is meant to be
+// deferred with a pointer to the named error result
+func (r *recorder) record(start time.Time, err *error) {
+	latency := time.Since(start)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// update counters
+	r.operations++
+	if *err != nil {
+		r.failures++
+	}
+
+	// update latencies
+	r.total += latency
+	if latency > r.max {
+		r.max = latency
+	}
+}
+
+// fills the operation metrics of the stats
+func (r *recorder) fill(stats *Stats) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	stats.Operations = r.operations
+	stats.Failures = r.failures
+	stats.MaxLatency = r.max
+	if r.operations > 0 {
+		stats.AverageLatency = r.total / time.Duration(r.operations)
+	}
+}
@@ -0,0 +1,182 @@
+package shadow
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/router"
+)
+
+// A Client gets and updates the shadow of a device.
+//
+// Note: The methods of the client block until a response has been received.
+// They must not be called from a router handler as this would deadlock the
+// router.
+type Client struct {
+	// The QOS level used to publish requests.
+	QOS uint8
+
+	// The callback that is called with deltas published by the server.
+	DeltaCallback func(delta Delta)
+
+	router *router.Router
+	base   string
+	prefix string
+
+	counter uint64
+	pending map[string]chan Response
+	routes  []*router.Route
+	mutex   sync.Mutex
+}
+
+// NewClient will create and return a new client for the shadow of the
+// specified device below the prefix.
+func NewClient(r *router.Router, prefix, device string) *Client {
+	// generate token prefix
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+
+	return &Client{
+		QOS:     1,
+		router:  r,
+		base:    prefix + "/" + device,
+		prefix:  hex.EncodeToString(buf),
+		pending: make(map[string]chan Response),
+	}
+}
+
+// Start will start receiving responses and deltas.
+func (c *Client) Start() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.routes = []*router.Route{
+		c.router.Handle(c.base+"/response", c.handleResponse),
+		c.router.Handle(c.base+"/delta", c.handleDelta),
+	}
+}
+
+// Stop will stop receiving responses and deltas.
+func (c *Client) Stop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, route := range c.routes {
+		c.router.Remove(route)
+	}
+
+	c.routes = nil
+}
+
+// Get will return the current document.
+func (c *Client) Get(timeout time.Duration) (*Document, error) {
+	return c.request("get", Request{}, timeout)
+}
+
+// Report will merge the specified values into the reported state and return
+// the updated document.
+func (c *Client) Report(reported map[string]interface{}, timeout time.Duration) (*Document, error) {
+	return c.Update(State{Reported: reported}, 0, timeout)
+}
+
+// Desire will merge the specified values into the desired state and return
+// the updated document.
+func (c *Client) Desire(desired map[string]interface{}, timeout time.Duration) (*Document, error) {
+	return c.Update(State{Desired: desired}, 0, timeout)
+}
+
+// Update will merge the specified state into the document and return the
+// updated document. If the version is not zero, the update is rejected with
+// ErrVersionConflict if the document has a different version.
+func (c *Client) Update(state State, version int64, timeout time.Duration) (*Document, error) {
+	return c.request("update", Request{
+		State:   state,
+		Version: version,
+	}, timeout)
+}
+
+func (c *Client) request(op string, req Request, timeout time.Duration) (*Document, error) {
+	// prepare response channel
+	res := make(chan Response, 1)
+
+	// register request
+	c.mutex.Lock()
+	c.counter++
+	req.Token = c.prefix + "-" + strconv.FormatUint(c.counter, 10)
+	c.pending[req.Token] = res
+	c.mutex.Unlock()
+
+	// ensure removal
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, req.Token)
+		c.mutex.Unlock()
+	}()
+
+	// publish request
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	c.router.Publish(c.base+"/"+op, payload, c.QOS, false)
+
+	// await response
+	select {
+	case r := <-res:
+		if r.Error == ErrVersionConflict.Error() {
+			return nil, ErrVersionConflict
+		} else if r.Error != "" {
+			return nil, errors.New(r.Error)
+		}
+
+		return r.Document, nil
+	case <-time.After(timeout):
+		return nil, ErrTimeout
+	}
+}
+
+func (c *Client) handleResponse(msg *packet.Message) error {
+	// decode response and ignore invalid payloads
+	var res Response
+	err := json.Unmarshal(msg.Payload, &res)
+	if err != nil {
+		return nil
+	}
+
+	// get pending request
+	c.mutex.Lock()
+	ch, ok := c.pending[res.Token]
+	c.mutex.Unlock()
+
+	// deliver response
+	if ok {
+		select {
+		case ch <- res:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) handleDelta(msg *packet.Message) error {
+	// decode delta and ignore invalid payloads
+	var delta Delta
+	err := json.Unmarshal(msg.Payload, &delta)
+	if err != nil {
+		return nil
+	}
+
+	// call callback
+	if c.DeltaCallback != nil {
+		c.DeltaCallback(delta)
+	}
+
+	return nil
+}
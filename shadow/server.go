@@ -0,0 +1,178 @@
+package shadow
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/router"
+)
+
+// A Server maintains the shadows of all devices below a prefix.
+type Server struct {
+	// The QOS level used to publish documents, responses and deltas.
+	QOS uint8
+
+	router *router.Router
+	prefix string
+
+	documents map[string]*Document
+	routes    []*router.Route
+	mutex     sync.Mutex
+}
+
+// NewServer will create and return a new server that maintains the shadows
+// below the specified prefix.
+func NewServer(r *router.Router, prefix string) *Server {
+	return &Server{
+		QOS:       1,
+		router:    r,
+		prefix:    prefix,
+		documents: make(map[string]*Document),
+	}
+}
+
+// Start will start handling requests. Documents that have been retained by a
+// previous server are restored once they are received.
+func (s *Server) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.routes = []*router.Route{
+		router.HandleParams(s.router, s.prefix+"/+", s.handleDocument),
+		router.HandleParams(s.router, s.prefix+"/+/get", s.handleGet),
+		router.HandleParams(s.router, s.prefix+"/+/update", s.handleUpdate),
+	}
+}
+
+// Stop will stop handling requests.
+func (s *Server) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, route := range s.routes {
+		s.router.Remove(route)
+	}
+
+	s.routes = nil
+}
+
+// Document returns a copy of the document of the specified device.
+func (s *Server) Document(device string) (*Document, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// get document
+	doc, ok := s.documents[device]
+	if !ok {
+		return nil, false
+	}
+
+	return doc.copy(), true
+}
+
+func (s *Server) handleDocument(msg *packet.Message, params []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// decode document and ignore invalid payloads
+	var doc Document
+	err := json.Unmarshal(msg.Payload, &doc)
+	if err != nil {
+		return nil
+	}
+
+	// restore newer documents
+	if existing, ok := s.documents[params[0]]; !ok || existing.Version < doc.Version {
+		s.documents[params[0]] = &doc
+	}
+
+	return nil
+}
+
+func (s *Server) handleGet(msg *packet.Message, params []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// decode request and ignore invalid payloads
+	var req Request
+	err := json.Unmarshal(msg.Payload, &req)
+	if err != nil {
+		return nil
+	}
+
+	// get document
+	doc, ok := s.documents[params[0]]
+	if !ok {
+		doc = &Document{}
+	}
+
+	// respond
+	s.respond(params[0], Response{
+		Token:    req.Token,
+		Document: doc,
+	})
+
+	return nil
+}
+
+func (s *Server) handleUpdate(msg *packet.Message, params []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// decode request and ignore invalid payloads
+	var req Request
+	err := json.Unmarshal(msg.Payload, &req)
+	if err != nil {
+		return nil
+	}
+
+	// get device
+	device := params[0]
+
+	// get document
+	doc, ok := s.documents[device]
+	if !ok {
+		doc = &Document{}
+		s.documents[device] = doc
+	}
+
+	// check version
+	if req.Version != 0 && req.Version != doc.Version {
+		s.respond(device, Response{
+			Token: req.Token,
+			Error: ErrVersionConflict.Error(),
+		})
+
+		return nil
+	}
+
+	// apply update
+	doc.apply(req.State)
+
+	// publish document
+	payload, _ := json.Marshal(doc)
+	s.router.Publish(s.prefix+"/"+device, payload, s.QOS, true)
+
+	// respond
+	s.respond(device, Response{
+		Token:    req.Token,
+		Document: doc,
+	})
+
+	// publish delta
+	if diff := doc.Delta(); diff != nil {
+		payload, _ := json.Marshal(Delta{
+			State:   diff,
+			Version: doc.Version,
+		})
+		s.router.Publish(s.prefix+"/"+device+"/delta", payload, s.QOS, false)
+	}
+
+	return nil
+}
+
+func (s *Server) respond(device string, res Response) {
+	payload, _ := json.Marshal(res)
+	s.router.Publish(s.prefix+"/"+device+"/response", payload, s.QOS, false)
+}
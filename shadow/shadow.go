@@ -0,0 +1,179 @@
+// Package shadow implements device shadows on top of the router.
+//
+// A shadow is a JSON document that holds the desired and reported state of a
+// device. The Server maintains the documents and publishes them as retained
+// messages to keep the last known state available. Devices and applications
+// use a Client to get and update the document and receive the delta between
+// the desired and reported state.
+//
+// The following topics below the prefix are used for every device:
+//
+//	<prefix>/<device>           the retained document
+//	<prefix>/<device>/get       the get requests
+//	<prefix>/<device>/update    the update requests
+//	<prefix>/<device>/response  the responses to requests
+//	<prefix>/<device>/delta     the delta after an update
+//
+// Requests and responses are correlated using a token. As there is no generic
+// request and response mechanism, the token is generated by the Client and
+// echoed by the Server.
+package shadow
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+// ErrVersionConflict is returned if an update specified a version that does
+// not match the current version of the document.
+var ErrVersionConflict = errors.New("version conflict")
+
+// ErrTimeout is returned if no response has been received in time.
+var ErrTimeout = errors.New("timeout")
+
+// State holds the desired and reported state of a device.
+type State struct {
+	// The state requested by applications.
+	Desired map[string]interface{} `json:"desired,omitempty"`
+
+	// The state reported by the device.
+	Reported map[string]interface{} `json:"reported,omitempty"`
+}
+
+// A Document is the shadow of a device.
+type Document struct {
+	// The state of the device.
+	State State `json:"state"`
+
+	// The version that is incremented with every update.
+	Version int64 `json:"version"`
+}
+
+// Delta returns the desired values that differ from the reported values.
+func (d *Document) Delta() map[string]interface{} {
+	return delta(d.State.Desired, d.State.Reported)
+}
+
+// copy returns a deep copy of the document
+func (d *Document) copy() *Document {
+	buf, _ := json.Marshal(d)
+	var doc Document
+	_ = json.Unmarshal(buf, &doc)
+	return &doc
+}
+
+// apply merges the update into the document
+func (d *Document) apply(update State) {
+	d.State.Desired = merge(d.State.Desired, update.Desired)
+	d.State.Reported = merge(d.State.Reported, update.Reported)
+	d.Version++
+}
+
+// A Request is sent by the client to get or update a document.
+type Request struct {
+	// The token that is echoed in the response.
+	Token string `json:"token"`
+
+	// The state that is merged into the document. Values set to null are
+	// removed from the document.
+	State State `json:"state"`
+
+	// The expected version of the document if not zero.
+	Version int64 `json:"version,omitempty"`
+}
+
+// A Response is sent by the server to answer a request.
+type Response struct {
+	// The token of the request.
+	Token string `json:"token"`
+
+	// The current document if the request succeeded.
+	Document *Document `json:"document,omitempty"`
+
+	// The error if the request failed.
+	Error string `json:"error,omitempty"`
+}
+
+// A Delta is published by the server if the desired state differs from the
+// reported state after an update.
+type Delta struct {
+	// The desired values that differ from the reported values.
+	State map[string]interface{} `json:"state"`
+
+	// The version of the document.
+	Version int64 `json:"version"`
+}
+
+// merge applies the update recursively and removes null values
+func merge(dst, update map[string]interface{}) map[string]interface{} {
+	// check update
+	if len(update) == 0 {
+		return dst
+	}
+
+	// ensure map
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+
+	for key, value := range update {
+		// remove null values
+		if value == nil {
+			delete(dst, key)
+			continue
+		}
+
+		// merge objects
+		if object, ok := value.(map[string]interface{}); ok {
+			existing, _ := dst[key].(map[string]interface{})
+			if merged := merge(existing, object); merged != nil {
+				dst[key] = merged
+			} else {
+				delete(dst, key)
+			}
+
+			continue
+		}
+
+		dst[key] = value
+	}
+
+	// remove empty maps
+	if len(dst) == 0 {
+		return nil
+	}
+
+	return dst
+}
+
+// delta returns the desired values that differ from the reported values
+func delta(desired, reported map[string]interface{}) map[string]interface{} {
+	var diff map[string]interface{}
+
+	for key, value := range desired {
+		// compare objects recursively
+		object, ok1 := value.(map[string]interface{})
+		existing, ok2 := reported[key].(map[string]interface{})
+		if ok1 && ok2 {
+			if d := delta(object, existing); d != nil {
+				if diff == nil {
+					diff = make(map[string]interface{})
+				}
+				diff[key] = d
+			}
+
+			continue
+		}
+
+		// compare values
+		if !reflect.DeepEqual(value, reported[key]) {
+			if diff == nil {
+				diff = make(map[string]interface{})
+			}
+			diff[key] = value
+		}
+	}
+
+	return diff
+}
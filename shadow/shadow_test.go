@@ -0,0 +1,180 @@
+package shadow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/router"
+	"github.com/256dpi/gomqtt/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startRouter(t *testing.T, url string) *router.Router {
+	r := router.New(client.NewService())
+	r.SubscribeQOS = 1
+	r.Start(client.NewConfig(url))
+
+	t.Cleanup(func() {
+		r.Stop(true)
+	})
+
+	return r
+}
+
+func TestMerge(t *testing.T) {
+	doc := &Document{}
+
+	doc.apply(State{
+		Desired: map[string]interface{}{
+			"color": "red",
+			"light": map[string]interface{}{"on": true, "level": 10.0},
+		},
+	})
+	assert.Equal(t, int64(1), doc.Version)
+
+	doc.apply(State{
+		Desired: map[string]interface{}{
+			"color": nil,
+			"light": map[string]interface{}{"level": 20.0},
+		},
+		Reported: map[string]interface{}{
+			"light": map[string]interface{}{"on": true},
+		},
+	})
+	assert.Equal(t, int64(2), doc.Version)
+	assert.Equal(t, State{
+		Desired: map[string]interface{}{
+			"light": map[string]interface{}{"on": true, "level": 20.0},
+		},
+		Reported: map[string]interface{}{
+			"light": map[string]interface{}{"on": true},
+		},
+	}, doc.State)
+
+	doc.apply(State{
+		Reported: map[string]interface{}{
+			"light": map[string]interface{}{"on": nil},
+		},
+	})
+	assert.Nil(t, doc.State.Reported)
+}
+
+func TestDelta(t *testing.T) {
+	doc := &Document{
+		State: State{
+			Desired: map[string]interface{}{
+				"color": "red",
+				"size":  1.0,
+				"light": map[string]interface{}{"on": true, "level": 20.0},
+			},
+			Reported: map[string]interface{}{
+				"color": "blue",
+				"size":  1.0,
+				"light": map[string]interface{}{"on": true, "level": 10.0},
+			},
+		},
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"color": "red",
+		"light": map[string]interface{}{"level": 20.0},
+	}, doc.Delta())
+
+	doc.State.Reported = doc.State.Desired
+	assert.Nil(t, doc.Delta())
+}
+
+func TestShadow(t *testing.T) {
+	url := testutil.Embedded(t)
+
+	server := NewServer(startRouter(t, url), "shadows")
+	server.Start()
+
+	deltas := make(chan Delta, 1)
+
+	device := NewClient(startRouter(t, url), "shadows", "device")
+	device.DeltaCallback = func(delta Delta) {
+		deltas <- delta
+	}
+	device.Start()
+
+	app := NewClient(startRouter(t, url), "shadows", "device")
+	app.Start()
+
+	// wait for server
+	var doc *Document
+	var err error
+	for i := 0; i < 20; i++ {
+		doc, err = device.Get(50 * time.Millisecond)
+		if err == nil {
+			break
+		}
+	}
+	require.NoError(t, err)
+	assert.Equal(t, &Document{}, doc)
+
+	doc, err = device.Report(map[string]interface{}{"on": false}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), doc.Version)
+
+	doc, err = app.Desire(map[string]interface{}{"on": true}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), doc.Version)
+
+	select {
+	case delta := <-deltas:
+		assert.Equal(t, Delta{
+			State:   map[string]interface{}{"on": true},
+			Version: 2,
+		}, delta)
+	case <-time.After(time.Second):
+		t.Fatal("no delta received")
+	}
+
+	_, err = app.Update(State{Desired: map[string]interface{}{"on": false}}, 1, time.Second)
+	assert.Equal(t, ErrVersionConflict, err)
+
+	doc, err = app.Get(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, &Document{
+		State: State{
+			Desired:  map[string]interface{}{"on": true},
+			Reported: map[string]interface{}{"on": false},
+		},
+		Version: 2,
+	}, doc)
+
+	// restore from retained document
+	server.Stop()
+	restored := NewServer(startRouter(t, url), "shadows")
+	restored.Start()
+
+	for i := 0; ; i++ {
+		doc, ok := restored.Document("device")
+		if ok && doc.Version == 2 {
+			break
+		} else if i == 100 {
+			t.Fatal("document not restored")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	device.Stop()
+	app.Stop()
+	restored.Stop()
+}
+
+func TestClientTimeout(t *testing.T) {
+	url := testutil.Embedded(t)
+
+	c := NewClient(startRouter(t, url), "shadows", "device")
+	c.Start()
+
+	_, err := c.Get(50 * time.Millisecond)
+	assert.Equal(t, ErrTimeout, err)
+
+	c.Stop()
+}
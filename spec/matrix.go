@@ -0,0 +1,225 @@
+package spec
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/client"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// MatrixVariable is the environment variable that lists the brokers used by
+// the compatibility matrix in the form "name=url,name=url".
+const MatrixVariable = "GOMQTT_MATRIX"
+
+// An Endpoint is a broker that is tested by the compatibility matrix.
+type Endpoint struct {
+	// The name used in the report.
+	Name string
+
+	// The config used to test the broker. The features enabled in the config
+	// are ignored as all features are tested.
+	Config *Config
+}
+
+// ParseEndpoints parses a list of endpoints in the form "name=url,name=url".
+// The returned endpoints use generous wait times suitable for remote brokers.
+func ParseEndpoints(str string) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	for _, item := range strings.Split(str, ",") {
+		// skip empty items
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		// split item
+		name, url, ok := strings.Cut(item, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("invalid endpoint %q", item)
+		}
+
+		endpoints = append(endpoints, Endpoint{
+			Name: name,
+			Config: &Config{
+				URL:               url,
+				ProcessWait:       100 * time.Millisecond,
+				MessageRetainWait: 500 * time.Millisecond,
+				NoMessageWait:     200 * time.Millisecond,
+			},
+		})
+	}
+
+	return endpoints, nil
+}
+
+// A Feature is a capability that is tested by the compatibility matrix.
+type Feature struct {
+	// The name used in the report.
+	Name string
+
+	// The test that checks the feature.
+	Test func(t *testing.T, config *Config)
+}
+
+// MatrixFeatures returns the features tested by the compatibility matrix.
+func MatrixFeatures() []Feature {
+	return []Feature{
+		{Name: "QOS0", Test: func(t *testing.T, config *Config) {
+			PublishSubscribeTest(t, config, "matrix/qos/0", "matrix/qos/0", 0, 0)
+		}},
+		{Name: "QOS1", Test: func(t *testing.T, config *Config) {
+			PublishSubscribeTest(t, config, "matrix/qos/1", "matrix/qos/1", 1, 1)
+		}},
+		{Name: "QOS2", Test: func(t *testing.T, config *Config) {
+			PublishSubscribeTest(t, config, "matrix/qos/2", "matrix/qos/2", 2, 2)
+		}},
+		{Name: "Wildcards", Test: func(t *testing.T, config *Config) {
+			PublishSubscribeTest(t, config, "matrix/wildcard/foo", "matrix/wildcard/#", 0, 0)
+		}},
+		{Name: "Retained", Test: func(t *testing.T, config *Config) {
+			RetainedMessageTest(t, config, "matrix/retained", "matrix/retained", 1, 1)
+		}},
+		{Name: "Will", Test: func(t *testing.T, config *Config) {
+			WillTest(t, config, "matrix/will", 1, 1)
+		}},
+		{Name: "StoredSubscriptions", Test: func(t *testing.T, config *Config) {
+			StoredSubscriptionsTest(t, config, "gomqtt-matrix-1", "matrix/stored", 1)
+		}},
+		{Name: "OfflineSubscriptions", Test: func(t *testing.T, config *Config) {
+			OfflineSubscriptionTest(t, config, "gomqtt-matrix-2", "matrix/offline", 1)
+		}},
+		{Name: "V5", Test: Version5Test},
+	}
+}
+
+// A Report lists the results of the compatibility matrix.
+type Report struct {
+	// The tested endpoints and features in order.
+	Endpoints []string
+	Features  []string
+
+	// The results per endpoint and feature.
+	Results map[string]map[string]bool
+}
+
+// Passed returns whether the feature passed on the endpoint.
+func (r *Report) Passed(endpoint, feature string) bool {
+	return r.Results[endpoint][feature]
+}
+
+// String returns the report formatted as a table.
+func (r *Report) String() string {
+	// prepare rows
+	rows := [][]string{append([]string{"Feature"}, r.Endpoints...)}
+	for _, feature := range r.Features {
+		row := []string{feature}
+		for _, endpoint := range r.Endpoints {
+			result, ok := r.Results[endpoint][feature]
+			if !ok {
+				row = append(row, "-")
+			} else if result {
+				row = append(row, "ok")
+			} else {
+				row = append(row, "fail")
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	// measure columns
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	// format rows
+	var b strings.Builder
+	for _, row := range rows {
+		for i, cell := range row {
+			if i > 0 {
+				b.WriteString("  ")
+			}
+			b.WriteString(cell)
+			if i < len(row)-1 {
+				b.WriteString(strings.Repeat(" ", widths[i]-len(cell)))
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// RunMatrix will test all features against all endpoints and return a report.
+// Every endpoint and feature is run as a subtest. Features that are not
+// supported by a broker therefore fail the test, the report lists the results
+// nevertheless.
+func RunMatrix(t *testing.T, endpoints []Endpoint, features []Feature) *Report {
+	// prepare report
+	report := &Report{
+		Results: map[string]map[string]bool{},
+	}
+	for _, feature := range features {
+		report.Features = append(report.Features, feature.Name)
+	}
+
+	for _, endpoint := range endpoints {
+		report.Endpoints = append(report.Endpoints, endpoint.Name)
+		report.Results[endpoint.Name] = map[string]bool{}
+
+		t.Run(endpoint.Name, func(t *testing.T) {
+			for _, feature := range features {
+				report.Results[endpoint.Name][feature.Name] = t.Run(feature.Name, func(t *testing.T) {
+					feature.Test(t, endpoint.Config)
+				})
+			}
+		})
+	}
+
+	return report
+}
+
+// Version5Test tests the broker for basic MQTT 5 support.
+func Version5Test(t *testing.T, config *Config) {
+	c := client.New()
+	wait := make(chan struct{})
+
+	c.Callback = func(msg *packet.Message, err error) error {
+		assert.NoError(t, err)
+		assert.Equal(t, "matrix/v5", msg.Topic)
+		assert.Equal(t, testPayload, msg.Payload)
+
+		close(wait)
+		return nil
+	}
+
+	opts := client.NewConfig(config.URL)
+	opts.Version = packet.Version5
+
+	connectFuture, err := c.Connect(opts)
+	if !assert.NoError(t, err) || !assert.NoError(t, connectFuture.Wait(10*time.Second)) {
+		return
+	}
+	assert.Equal(t, packet.ConnectionAccepted, connectFuture.ReturnCode())
+
+	subscribeFuture, err := c.Subscribe("matrix/v5", 1)
+	assert.NoError(t, err)
+	assert.NoError(t, subscribeFuture.Wait(10*time.Second))
+
+	publishFuture, err := c.Publish("matrix/v5", testPayload, 1, false)
+	assert.NoError(t, err)
+	assert.NoError(t, publishFuture.Wait(10*time.Second))
+
+	safeReceive(wait)
+
+	err = c.Disconnect()
+	assert.NoError(t, err)
+}
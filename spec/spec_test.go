@@ -1,13 +1,17 @@
 package spec
 
 import (
+	"os"
 	"testing"
 	"time"
+
+	"github.com/256dpi/gomqtt/testutil"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSpec(t *testing.T) {
 	config := AllFeatures()
-	config.URL = "tcp://localhost:1883"
+	config.URL = testutil.Broker(t, testutil.Mosquitto)
 
 	// mosquitto specific config
 	config.Authentication = false
@@ -17,3 +21,48 @@ func TestSpec(t *testing.T) {
 
 	Run(t, config)
 }
+
+func TestMatrix(t *testing.T) {
+	// get endpoints
+	str := os.Getenv(MatrixVariable)
+	if str == "" {
+		t.Skipf("%s not set", MatrixVariable)
+	}
+	endpoints, err := ParseEndpoints(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := RunMatrix(t, endpoints, MatrixFeatures())
+	t.Log("\n" + report.String())
+}
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := ParseEndpoints("mosquitto=tcp://localhost:1883, emqx=tcp://localhost:1884,")
+	assert.NoError(t, err)
+	assert.Len(t, endpoints, 2)
+	assert.Equal(t, "mosquitto", endpoints[0].Name)
+	assert.Equal(t, "tcp://localhost:1883", endpoints[0].Config.URL)
+	assert.Equal(t, "emqx", endpoints[1].Name)
+	assert.Equal(t, "tcp://localhost:1884", endpoints[1].Config.URL)
+
+	_, err = ParseEndpoints("tcp://localhost:1883")
+	assert.Error(t, err)
+}
+
+func TestReportString(t *testing.T) {
+	report := &Report{
+		Endpoints: []string{"mosquitto", "emqx"},
+		Features:  []string{"QOS2", "V5"},
+		Results: map[string]map[string]bool{
+			"mosquitto": {"QOS2": true, "V5": false},
+			"emqx":      {"QOS2": true},
+		},
+	}
+
+	assert.True(t, report.Passed("mosquitto", "QOS2"))
+	assert.False(t, report.Passed("mosquitto", "V5"))
+	assert.Equal(t, "Feature  mosquitto  emqx\n"+
+		"QOS2     ok         ok\n"+
+		"V5       fail       -\n", report.String())
+}
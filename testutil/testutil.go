@@ -0,0 +1,193 @@
+// Package testutil provides helpers to run tests against external brokers
+// that are started in Docker containers or an embedded in-memory broker.
+package testutil
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/broker"
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/256dpi/gomqtt/transport"
+)
+
+// URLVariable is the environment variable that can be set to use an already
+// running broker instead of starting a container.
+const URLVariable = "GOMQTT_BROKER_URL"
+
+// An Image describes a broker that can be started in a Docker container.
+type Image struct {
+	// The name of the Docker image.
+	Name string
+
+	// The MQTT port exposed by the container.
+	Port int
+
+	// The time to wait for the broker to accept connections.
+	Timeout time.Duration
+}
+
+// Mosquitto is the Eclipse Mosquitto broker. Version 1.6 is used as later
+// versions do not allow anonymous connections by default.
+var Mosquitto = Image{
+	Name:    "eclipse-mosquitto:1.6",
+	Port:    1883,
+	Timeout: 10 * time.Second,
+}
+
+// EMQX is the EMQX broker.
+var EMQX = Image{
+	Name:    "emqx/emqx:latest",
+	Port:    1883,
+	Timeout: 60 * time.Second,
+}
+
+// Broker returns the URL of a broker that is ready to accept connections. If
+// the URLVariable environment variable is set, its value is returned.
+// Otherwise, a container is started from the specified image and removed once
+// the test finishes. The test is skipped if Docker is not available.
+func Broker(t testing.TB, image Image) string {
+	t.Helper()
+
+	// check variable
+	if url := os.Getenv(URLVariable); url != "" {
+		return url
+	}
+
+	// check docker
+	if exec.Command("docker", "info").Run() != nil {
+		t.Skipf("docker not available and %s not set", URLVariable)
+	}
+
+	// start container
+	out, err := exec.Command("docker", "run", "-d", "--rm", "-p", fmt.Sprintf("127.0.0.1::%d", image.Port), image.Name).Output()
+	if err != nil {
+		t.Fatalf("failed to start %s: %s", image.Name, describe(err))
+	}
+
+	// get id
+	id := strings.TrimSpace(string(out))
+
+	// remove container
+	t.Cleanup(func() {
+		_ = exec.Command("docker", "rm", "-f", id).Run()
+	})
+
+	// get mapped address
+	out, err = exec.Command("docker", "port", id, fmt.Sprintf("%d/tcp", image.Port)).Output()
+	if err != nil {
+		t.Fatalf("failed to get port of %s: %s", image.Name, describe(err))
+	}
+
+	// use first mapping
+	addr := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("invalid port mapping %q: %s", addr, err)
+	}
+
+	// prepare url
+	url := "tcp://localhost:" + port
+
+	// wait for broker
+	err = Wait(url, image.Timeout)
+	if err != nil {
+		t.Fatalf("%s not ready: %s", image.Name, err)
+	}
+
+	return url
+}
+
+// Embedded returns the URL of an in-process broker that uses the in-memory
+// backend. It supports all quality of service levels, retained messages and
+// persistent sessions and is closed once the test finishes.
+func Embedded(t testing.TB) string {
+	t.Helper()
+
+	// run broker
+	port, quit, done := broker.Run(broker.NewEngine(), "tcp")
+
+	// close broker
+	t.Cleanup(func() {
+		close(quit)
+		<-done
+	})
+
+	return "tcp://localhost:" + port
+}
+
+// Wait will repeatedly connect to the broker at the specified URL until it
+// accepts a connection or the timeout has been reached.
+func Wait(url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		// attempt connection
+		err := probe(url)
+		if err == nil {
+			return nil
+		}
+
+		// check deadline
+		if time.Now().After(deadline) {
+			return err
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// connects to the broker and waits for a successful connack
+func probe(url string) error {
+	// dial broker
+	conn, err := transport.Dial(url)
+	if err != nil {
+		return err
+	}
+
+	// ensure close
+	defer conn.Close()
+
+	// set read timeout
+	conn.SetReadTimeout(time.Second)
+
+	// send connect
+	connect := packet.NewConnectPacket()
+	connect.ClientID = "gomqtt-testutil"
+	connect.CleanSession = true
+	err = conn.Send(connect)
+	if err != nil {
+		return err
+	}
+
+	// receive connack
+	pkt, err := conn.Receive()
+	if err != nil {
+		return err
+	}
+
+	// check connack
+	connack, ok := pkt.(*packet.ConnackPacket)
+	if !ok {
+		return fmt.Errorf("expected connack, got %s", pkt.Type())
+	} else if connack.ReturnCode != packet.ConnectionAccepted {
+		return connack.ReturnCode
+	}
+
+	// disconnect
+	return conn.Send(packet.NewDisconnectPacket())
+}
+
+// adds the output of failed commands to the error
+func describe(err error) string {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return strings.TrimSpace(string(exitErr.Stderr))
+	}
+
+	return err.Error()
+}
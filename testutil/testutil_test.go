@@ -0,0 +1,32 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/broker"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerVariable(t *testing.T) {
+	t.Setenv(URLVariable, "tcp://localhost:1234")
+
+	assert.Equal(t, "tcp://localhost:1234", Broker(t, Mosquitto))
+}
+
+func TestEmbedded(t *testing.T) {
+	url := Embedded(t)
+
+	assert.NoError(t, Wait(url, time.Second))
+}
+
+func TestWait(t *testing.T) {
+	port, quit, done := broker.Run(broker.NewEngine(), "tcp")
+
+	assert.NoError(t, Wait("tcp://localhost:"+port, time.Second))
+
+	close(quit)
+	<-done
+
+	assert.Error(t, Wait("tcp://localhost:"+port, 200*time.Millisecond))
+}
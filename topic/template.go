@@ -0,0 +1,205 @@
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidTemplate is returned by NewTemplate if the pattern is invalid.
+var ErrInvalidTemplate = errors.New("invalid template")
+
+// ErrInvalidParam is returned by Template.Format if a value is missing or
+// contains illegal characters.
+var ErrInvalidParam = errors.New("invalid param")
+
+// ErrNoMatch is returned by Template.Parse if the topic does not match the
+// template.
+var ErrNoMatch = errors.New("no match")
+
+// A Template formats and parses topics using a pattern with named
+// placeholders like "devices/{id}/cmd/{cmd}". Placeholders must span a whole
+// segment and match exactly one segment.
+type Template struct {
+	pattern  string
+	segments []string
+	names    []string
+}
+
+// NewTemplate parses the specified pattern and returns a new Template.
+func NewTemplate(pattern string) (*Template, error) {
+	// check for zero length
+	if pattern == "" {
+		return nil, fmt.Errorf("%w: zero length pattern", ErrInvalidTemplate)
+	}
+
+	// prepare template
+	t := &Template{
+		pattern:  pattern,
+		segments: strings.Split(pattern, "/"),
+	}
+
+	// check segments
+	seen := make(map[string]bool)
+	for _, segment := range t.segments {
+		// check placeholder
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := segment[1 : len(segment)-1]
+			if !validName(name) {
+				return nil, fmt.Errorf("%w: invalid placeholder %q", ErrInvalidTemplate, segment)
+			} else if seen[name] {
+				return nil, fmt.Errorf("%w: duplicate placeholder %q", ErrInvalidTemplate, segment)
+			}
+
+			seen[name] = true
+			t.names = append(t.names, name)
+
+			continue
+		}
+
+		// check literal
+		if strings.ContainsAny(segment, "{}") {
+			return nil, fmt.Errorf("%w: invalid segment %q", ErrInvalidTemplate, segment)
+		} else if !validValue(segment, true) {
+			return nil, fmt.Errorf("%w: wildcards in segment %q", ErrInvalidTemplate, segment)
+		}
+	}
+
+	return t, nil
+}
+
+// MustTemplate calls NewTemplate and panics if the pattern is invalid. It is
+// intended to be used when declaring package level variables.
+func MustTemplate(pattern string) *Template {
+	t, err := NewTemplate(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+// Pattern returns the pattern of the template.
+func (t *Template) Pattern() string {
+	return t.pattern
+}
+
+// Names returns the placeholder names in the order they appear.
+func (t *Template) Names() []string {
+	return append([]string(nil), t.names...)
+}
+
+// Filter returns a topic filter that matches all topics of the template by
+// replacing the placeholders with single level wildcards.
+func (t *Template) Filter() string {
+	return t.build(func(string) string {
+		return "+"
+	})
+}
+
+// Format returns the topic with all placeholders replaced by the values of the
+// map. Values must be non-empty and not contain slashes, wildcards or null
+// characters.
+func (t *Template) Format(params map[string]string) (string, error) {
+	// check params
+	for _, name := range t.names {
+		value, ok := params[name]
+		if !ok {
+			return "", fmt.Errorf("%w: missing %q", ErrInvalidParam, name)
+		} else if !validValue(value, false) {
+			return "", fmt.Errorf("%w: illegal value %q for %q", ErrInvalidParam, value, name)
+		}
+	}
+
+	// check unused params
+	if len(params) > len(t.names) {
+		for name := range params {
+			if !t.has(name) {
+				return "", fmt.Errorf("%w: unknown %q", ErrInvalidParam, name)
+			}
+		}
+	}
+
+	return t.build(func(name string) string {
+		return params[name]
+	}), nil
+}
+
+// Parse matches the topic against the template and returns the values of the
+// placeholders.
+func (t *Template) Parse(topic string) (map[string]string, error) {
+	// split topic
+	segments := strings.Split(topic, "/")
+	if len(segments) != len(t.segments) {
+		return nil, ErrNoMatch
+	}
+
+	// match segments
+	params := make(map[string]string, len(t.names))
+	for i, segment := range t.segments {
+		if name, ok := placeholder(segment); ok {
+			if segments[i] == "" {
+				return nil, ErrNoMatch
+			}
+
+			params[name] = segments[i]
+		} else if segment != segments[i] {
+			return nil, ErrNoMatch
+		}
+	}
+
+	return params, nil
+}
+
+func (t *Template) build(fn func(name string) string) string {
+	segments := make([]string, len(t.segments))
+	for i, segment := range t.segments {
+		if name, ok := placeholder(segment); ok {
+			segments[i] = fn(name)
+		} else {
+			segments[i] = segment
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func (t *Template) has(name string) bool {
+	for _, n := range t.names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func placeholder(segment string) (string, bool) {
+	if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+		return segment[1 : len(segment)-1], true
+	}
+
+	return "", false
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validValue(value string, allowEmpty bool) bool {
+	if value == "" {
+		return allowEmpty
+	}
+
+	return !strings.ContainsAny(value, "/+#\x00")
+}
@@ -0,0 +1,74 @@
+package topic
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := NewTemplate("devices/{id}/cmd/{cmd}")
+	assert.NoError(t, err)
+	assert.Equal(t, "devices/{id}/cmd/{cmd}", tmpl.Pattern())
+	assert.Equal(t, []string{"id", "cmd"}, tmpl.Names())
+	assert.Equal(t, "devices/+/cmd/+", tmpl.Filter())
+
+	topic, err := tmpl.Format(map[string]string{"id": "1", "cmd": "reboot"})
+	assert.NoError(t, err)
+	assert.Equal(t, "devices/1/cmd/reboot", topic)
+
+	params, err := tmpl.Parse("devices/1/cmd/reboot")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"id": "1", "cmd": "reboot"}, params)
+}
+
+func TestTemplateFormatErrors(t *testing.T) {
+	tmpl := MustTemplate("devices/{id}")
+
+	for _, params := range []map[string]string{
+		{},
+		{"id": ""},
+		{"id": "a/b"},
+		{"id": "+"},
+		{"id": "#"},
+		{"id": "a\x00"},
+		{"id": "1", "foo": "bar"},
+	} {
+		_, err := tmpl.Format(params)
+		assert.True(t, errors.Is(err, ErrInvalidParam), params)
+	}
+}
+
+func TestTemplateParseErrors(t *testing.T) {
+	tmpl := MustTemplate("devices/{id}/status")
+
+	for _, topic := range []string{
+		"devices/1",
+		"devices/1/status/foo",
+		"devices//status",
+		"devices/1/info",
+	} {
+		_, err := tmpl.Parse(topic)
+		assert.Equal(t, ErrNoMatch, err, topic)
+	}
+}
+
+func TestTemplateInvalid(t *testing.T) {
+	for _, pattern := range []string{
+		"",
+		"devices/{}",
+		"devices/{a-b}",
+		"devices/{id}/{id}",
+		"devices/x{id}",
+		"devices/+",
+		"devices/#",
+	} {
+		_, err := NewTemplate(pattern)
+		assert.True(t, errors.Is(err, ErrInvalidTemplate), pattern)
+	}
+
+	assert.Panics(t, func() {
+		MustTemplate("")
+	})
+}
@@ -5,14 +5,31 @@ import (
 	"errors"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
+// the maximum byte length of a topic
+const maxLength = 65535
+
 // ErrZeroLength is returned by Parse if a topics has a zero length.
 var ErrZeroLength = errors.New("zero length topic")
 
 // ErrWildcards is returned by Parse if a topic contains invalid wildcards.
 var ErrWildcards = errors.New("invalid use of wildcards")
 
+// ErrTooManyLevels is returned by CheckLevels if a topic has too many levels.
+var ErrTooManyLevels = errors.New("too many topic levels")
+
+// ErrLevelTooLong is returned by CheckLevels if a topic level is too long.
+var ErrLevelTooLong = errors.New("topic level too long")
+
+// ErrTooLong is returned by Validate if a topic exceeds 65535 bytes.
+var ErrTooLong = errors.New("topic too long")
+
+// ErrInvalidCharacters is returned by Parse and Validate if a topic is not
+// valid UTF-8 or contains a null character.
+var ErrInvalidCharacters = errors.New("invalid characters")
+
 var multiSlashRegex = regexp.MustCompile(`/+`)
 
 // Parse removes duplicate and trailing slashes from the supplied
@@ -23,6 +40,11 @@ func Parse(topic string, allowWildcards bool) (string, error) {
 		return "", ErrZeroLength
 	}
 
+	// check characters
+	if !validCharacters(topic) {
+		return "", ErrInvalidCharacters
+	}
+
 	// normalize topic
 	topic = multiSlashRegex.ReplaceAllString(topic, "/")
 
@@ -58,8 +80,177 @@ func Parse(topic string, allowWildcards bool) (string, error) {
 	return topic, nil
 }
 
+// Validate checks the topic filter according to the MQTT specification without
+// normalizing it. The filter must not be empty, exceed 65535 bytes or contain
+// invalid UTF-8 or null characters. Wildcards must occupy an entire level and
+// the multi level wildcard must be the last level. Empty levels are allowed
+// and significant e.g. "a//b" and "a/b" are different filters.
+func Validate(filter string) error {
+	return validate(filter, true)
+}
+
+// ValidateName checks the topic name like Validate but does not allow any
+// wildcards.
+func ValidateName(name string) error {
+	return validate(name, false)
+}
+
+func validate(topic string, allowWildcards bool) error {
+	// check length
+	if topic == "" {
+		return ErrZeroLength
+	} else if len(topic) > maxLength {
+		return ErrTooLong
+	}
+
+	// check characters
+	if !validCharacters(topic) {
+		return ErrInvalidCharacters
+	}
+
+	// check wildcards
+	for i := 0; i < len(topic); i++ {
+		// get character
+		c := topic[i]
+		if c != '+' && c != '#' {
+			continue
+		}
+
+		// check if wildcards are allowed
+		if !allowWildcards {
+			return ErrWildcards
+		}
+
+		// check if wildcard occupies the entire level
+		if (i > 0 && topic[i-1] != '/') || (i < len(topic)-1 && topic[i+1] != '/') {
+			return ErrWildcards
+		}
+
+		// check if hash is the last character
+		if c == '#' && i != len(topic)-1 {
+			return ErrWildcards
+		}
+	}
+
+	return nil
+}
+
+// Match returns whether the topic name matches the topic filter. Wildcards at
+// the first level of a filter do not match topics that begin with a "$"
+// e.g. "#" does not match "$SYS/uptime". Both arguments are expected to be
+// valid.
+func Match(filter, name string) bool {
+	// check system topics
+	if strings.HasPrefix(name, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	for {
+		// get filter level
+		fl, frest, fmore := strings.Cut(filter, "/")
+
+		// match remaining levels
+		if fl == "#" {
+			return true
+		}
+
+		// get name level
+		nl, nrest, nmore := strings.Cut(name, "/")
+
+		// check level
+		if fl != "+" && fl != nl {
+			return false
+		}
+
+		// check end
+		if !fmore || !nmore {
+			// the multi level wildcard also matches the parent level
+			return fmore == nmore || (!nmore && frest == "#")
+		}
+
+		filter, name = frest, nrest
+	}
+}
+
+// CheckLevels returns ErrTooManyLevels if the topic has more than maxLevels
+// levels and ErrLevelTooLong if a level is longer than maxLength bytes. A zero
+// value disables the respective check.
+func CheckLevels(topic string, maxLevels, maxLength int) error {
+	levels, length := 1, 0
+	for i := 0; i < len(topic); i++ {
+		// count levels
+		if topic[i] == '/' {
+			levels++
+			length = 0
+			if maxLevels > 0 && levels > maxLevels {
+				return ErrTooManyLevels
+			}
+
+			continue
+		}
+
+		// check length
+		length++
+		if maxLength > 0 && length > maxLength {
+			return ErrLevelTooLong
+		}
+	}
+
+	return nil
+}
+
 // ContainsWildcards tests if the supplied topic contains wildcards. The topics
 // is expected to be tested and normalized using Parse beforehand.
 func ContainsWildcards(topic string) bool {
 	return strings.Contains(topic, "+") || strings.Contains(topic, "#")
 }
+
+// Extract matches the topic against the filter and returns the segments that
+// have been captured by the wildcards of the filter. A single level wildcard
+// captures one segment while a multi level wildcard captures all remaining
+// segments joined by slashes. The second return value is false if the topic
+// does not match the filter.
+func Extract(filter, topic string) ([]string, bool) {
+	// split filter and topic
+	filterSegments := strings.Split(filter, "/")
+	topicSegments := strings.Split(topic, "/")
+
+	// prepare params
+	var params []string
+
+	for i, segment := range filterSegments {
+		// capture remaining segments
+		if segment == "#" {
+			return append(params, strings.Join(topicSegments[i:], "/")), true
+		}
+
+		// check length
+		if i >= len(topicSegments) {
+			return nil, false
+		}
+
+		// capture single segment
+		if segment == "+" {
+			params = append(params, topicSegments[i])
+			continue
+		}
+
+		// check segment
+		if segment != topicSegments[i] {
+			return nil, false
+		}
+	}
+
+	// check length
+	if len(filterSegments) != len(topicSegments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+// validCharacters returns whether the topic is valid UTF-8 without null
+// characters
+func validCharacters(topic string) bool {
+	return utf8.ValidString(topic) && strings.IndexByte(topic, 0) < 0
+}
@@ -1,6 +1,7 @@
 package topic
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -98,3 +99,120 @@ func TestTopicContainsWildcards(t *testing.T) {
 	assert.True(t, ContainsWildcards("topic/#"))
 	assert.False(t, ContainsWildcards("topic/hello"))
 }
+
+func TestCheckLevels(t *testing.T) {
+	assert.NoError(t, CheckLevels("a/b/c", 0, 0))
+	assert.NoError(t, CheckLevels("a/b/c", 3, 1))
+	assert.NoError(t, CheckLevels("/a//", 4, 1))
+	assert.Equal(t, ErrTooManyLevels, CheckLevels("a/b/c", 2, 0))
+	assert.Equal(t, ErrTooManyLevels, CheckLevels("a/b/", 2, 0))
+	assert.Equal(t, ErrLevelTooLong, CheckLevels("a/bc/d", 0, 1))
+	assert.Equal(t, ErrLevelTooLong, CheckLevels("abc", 0, 2))
+}
+
+func TestExtract(t *testing.T) {
+	table := []struct {
+		filter string
+		topic  string
+		params []string
+		ok     bool
+	}{
+		{"devices/+/status", "devices/1/status", []string{"1"}, true},
+		{"devices/+/+", "devices/1/status", []string{"1", "status"}, true},
+		{"devices/+/#", "devices/1/a/b", []string{"1", "a/b"}, true},
+		{"devices/#", "devices", []string{""}, true},
+		{"#", "devices/1", []string{"devices/1"}, true},
+		{"devices/status", "devices/status", nil, true},
+		{"devices/+/status", "devices/1/info", nil, false},
+		{"devices/+/status", "devices/1", nil, false},
+		{"devices/+", "devices/1/status", nil, false},
+	}
+
+	for _, entry := range table {
+		params, ok := Extract(entry.filter, entry.topic)
+		assert.Equal(t, entry.ok, ok, entry.filter)
+		assert.Equal(t, entry.params, params, entry.filter)
+	}
+}
+
+func TestInvalidCharacters(t *testing.T) {
+	_, err := Parse("foo/\x00", true)
+	assert.Equal(t, ErrInvalidCharacters, err)
+
+	_, err = Parse("foo/\xff", true)
+	assert.Equal(t, ErrInvalidCharacters, err)
+
+	str, err := Parse("设备/温度", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "设备/温度", str)
+}
+
+func TestValidate(t *testing.T) {
+	table := map[string]error{
+		"foo":                            nil,
+		"foo/bar":                        nil,
+		"foo//bar/":                      nil,
+		"/":                              nil,
+		"设备/温度":                          nil,
+		"$SYS/#":                         nil,
+		"+":                              nil,
+		"#":                              nil,
+		"+/+/#":                          nil,
+		"foo/+/bar":                      nil,
+		"":                               ErrZeroLength,
+		strings.Repeat("a", maxLength+1): ErrTooLong,
+		"foo\x00":                        ErrInvalidCharacters,
+		"foo/\xc3":                       ErrInvalidCharacters,
+		"foo+":                           ErrWildcards,
+		"foo/+bar":                       ErrWildcards,
+		"foo/#/bar":                      ErrWildcards,
+		"foo#":                           ErrWildcards,
+		"##":                             ErrWildcards,
+	}
+
+	for filter, result := range table {
+		assert.Equal(t, result, Validate(filter), filter)
+	}
+
+	assert.NoError(t, ValidateName("foo//bar"))
+	assert.NoError(t, ValidateName(strings.Repeat("a", maxLength)))
+	assert.Equal(t, ErrWildcards, ValidateName("foo/+"))
+	assert.Equal(t, ErrWildcards, ValidateName("#"))
+}
+
+func TestMatch(t *testing.T) {
+	table := []struct {
+		filter string
+		name   string
+		ok     bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "bar", false},
+		{"foo/bar", "foo/bar", true},
+		{"foo/bar", "foo", false},
+		{"foo", "foo/bar", false},
+		{"foo/+", "foo/bar", true},
+		{"foo/+", "foo", false},
+		{"foo/+", "foo/", true},
+		{"foo/+/baz", "foo/bar/baz", true},
+		{"+/+", "/foo", true},
+		{"+", "/foo", false},
+		{"/+", "/foo", true},
+		{"foo/#", "foo", true},
+		{"foo/#", "foo/bar/baz", true},
+		{"foo/+/#", "foo/bar", true},
+		{"#", "foo/bar", true},
+		{"#", "/", true},
+		{"foo//bar", "foo/bar", false},
+		{"foo//bar", "foo//bar", true},
+		{"设备/+", "设备/温度", true},
+		{"#", "$SYS/uptime", false},
+		{"+/uptime", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+		{"$SYS/+", "$SYS/uptime", true},
+	}
+
+	for _, entry := range table {
+		assert.Equal(t, entry.ok, Match(entry.filter, entry.name), entry.filter+" "+entry.name)
+	}
+}
@@ -370,7 +370,7 @@ func (t *Tree) Count() int {
 func (t *Tree) count(counter int, node *node) int {
 	// add children to results
 	for _, child := range node.children {
-		counter += t.count(counter, child)
+		counter = t.count(counter, child)
 	}
 
 	// add values to result
@@ -264,6 +264,11 @@ func TestTreeCount(t *testing.T) {
 	tree.Add("foo/bar/baz", 4)
 
 	assert.Equal(t, 4, tree.Count())
+
+	tree.Add("foo/qux", 5)
+	tree.Add("quz", 6)
+
+	assert.Equal(t, 6, tree.Count())
 }
 
 func TestTreeAll(t *testing.T) {
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the first file descriptor passed by systemd
+const listenFDsStart = 3
+
+// Activated returns the listeners that have been passed to the process using
+// systemd socket activation together with their names as configured by
+// FileDescriptorName. The listeners are returned in the order of the sockets
+// in the unit file. If the process has not been socket activated, no listeners
+// are returned. The related environment variables are unset to not pass the
+// listeners on to child processes. Listeners passed by the parent process
+// using Handoff are returned as well.
+//
+// The listeners can be served using NewNetServerWithListener,
+// NewWebSocketServerWithListener or wrapped using tls.NewListener beforehand.
+// As the sockets are kept open by systemd, a restarted process can accept
+// connections without refusing clients in between.
+func Activated() ([]net.Listener, []string, error) {
+	// get listeners
+	listeners, names, err := activated(os.Getenv, listenFDsStart)
+
+	// unset variables
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+	_ = os.Unsetenv(handoffPID)
+
+	return listeners, names, err
+}
+
+func activated(getenv func(string) string, start int) ([]net.Listener, []string, error) {
+	// check pid, the pid is unknown to the parent if the listeners have been
+	// handed off and the parent may already have exited
+	if pid, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		if _, err := strconv.Atoi(getenv(handoffPID)); err != nil {
+			return nil, nil, nil
+		}
+	}
+
+	// get count
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil, nil
+	}
+
+	// get names, systemd uses "unknown" if no name is configured
+	var names []string
+	if value := getenv("LISTEN_FDNAMES"); value != "" {
+		names = strings.Split(value, ":")
+	}
+	if len(names) != count {
+		names = make([]string, count)
+		for i := range names {
+			names[i] = "unknown"
+		}
+	}
+
+	// prepare listeners
+	listeners := make([]net.Listener, 0, count)
+
+	for fd := start; fd < start+count; fd++ {
+		// create listener (the descriptor is duplicated)
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			// close created listeners
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+
+			return nil, nil, wrapError(OpLaunch, fmt.Errorf("file descriptor %d: %w", fd, err), ErrNetwork)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, names, nil
+}
@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivated(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("socket activation is not supported on windows")
+	}
+
+	// not activated
+	listeners, names, err := Activated()
+	assert.NoError(t, err)
+	assert.Empty(t, listeners)
+	assert.Empty(t, names)
+
+	// prepare listener
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+
+	file, err := listener.(*net.TCPListener).File()
+	assert.NoError(t, err)
+	assert.NoError(t, listener.Close())
+
+	env := map[string]string{
+		"LISTEN_PID":     strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS":     "1",
+		"LISTEN_FDNAMES": "mqtt",
+	}
+
+	// other process
+	listeners, names, err = activated(func(key string) string {
+		if key == "LISTEN_PID" {
+			return "1"
+		}
+		return env[key]
+	}, int(file.Fd()))
+	assert.NoError(t, err)
+	assert.Empty(t, listeners)
+	assert.Empty(t, names)
+
+	// activated
+	listeners, names, err = activated(func(key string) string {
+		return env[key]
+	}, int(file.Fd()))
+	_ = file.Close() // descriptor has already been closed
+	assert.NoError(t, err)
+	assert.Len(t, listeners, 1)
+	assert.Equal(t, []string{"mqtt"}, names)
+
+	// serve listener
+	server := NewNetServerWithListener(listeners[0])
+
+	done := make(chan struct{})
+	go func() {
+		conn, err := server.Accept()
+		assert.NoError(t, err)
+
+		pkt, err := conn.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, packet.CONNECT, pkt.Type())
+
+		assert.NoError(t, conn.Close())
+		close(done)
+	}()
+
+	conn, err := Dial("tcp://" + server.Addr().String())
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Send(packet.NewConnectPacket()))
+
+	safeReceive(done)
+
+	assert.NoError(t, server.Close())
+}
+
+func TestActivatedInvalid(t *testing.T) {
+	listeners, names, err := activated(func(key string) string {
+		switch key {
+		case "LISTEN_PID":
+			return strconv.Itoa(os.Getpid())
+		case "LISTEN_FDS":
+			return "1"
+		}
+		return ""
+	}, 1<<20)
+	assert.Error(t, err)
+	assert.Empty(t, listeners)
+	assert.Empty(t, names)
+}
@@ -1,8 +1,12 @@
 package transport
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/256dpi/gomqtt/packet"
@@ -13,6 +17,7 @@ type Carrier interface {
 	io.ReadWriteCloser
 
 	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
 }
 
 // A BaseConn manages the low-level plumbing between the Carrier and the packet
@@ -22,13 +27,29 @@ type BaseConn struct {
 
 	stream *packet.Stream
 
+	packetWrites bool
+	writeBuffer  bytes.Buffer
+
 	flushTimer *time.Timer
 	flushError error
 
 	sMutex sync.Mutex
 	rMutex sync.Mutex
 
-	readTimeout time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	capture atomic.Pointer[captureFlow]
+
+	idleTimeout  time.Duration
+	idleCallback func()
+	idleTimer    *time.Timer
+	idleEpoch    uint64
+	idleMutex    sync.Mutex
+	lastActivity atomic.Int64
+
+	closeReason CloseReason
+	closeMutex  sync.Mutex
 }
 
 // NewBaseConn creates a new BaseConn using the specified Carrier.
@@ -96,11 +117,64 @@ func (c *BaseConn) BufferedSend(pkt packet.GenericPacket) error {
 	return nil
 }
 
+// SendStream will write the publish packet to the underlying connection while
+// streaming the payload of the specified size from the reader. The payload of
+// the packet itself is ignored. It will return an Error if there was an error
+// while encoding, reading the payload or writing to the underlying connection.
+// As the packet may have been written partially, failures close the connection.
+//
+// Note: Only one goroutine can Send at the same time.
+func (c *BaseConn) SendStream(pkt *packet.PublishPacket, payload io.Reader, size int64) error {
+	c.sMutex.Lock()
+	defer c.sMutex.Unlock()
+
+	// write packet
+	err := c.writeStream(pkt, payload, size)
+	if err != nil {
+		return err
+	}
+
+	// stop the timer if existing
+	if c.flushTimer != nil {
+		c.flushTimer.Stop()
+	}
+
+	// flush buffer
+	return c.flush()
+}
+
 func (c *BaseConn) write(pkt packet.GenericPacket) error {
+	// set write deadline
+	c.resetWriteTimeout()
+
+	// record activity
+	c.touch()
+
+	// write packet directly if requested
+	if c.packetWrites {
+		return c.writePacket(pkt)
+	}
+
+	// capture packet if requested
+	if flow := c.capture.Load(); flow != nil {
+		flow.record(pkt, true, c.stream.Version())
+	}
+
 	err := c.stream.Write(pkt)
 	if err != nil {
+		// wrap error
+		err = wrapError(OpSend, err, ErrEncode)
+
+		// save reason
+		if errors.Is(err, ErrEncode) {
+			c.setCloseReason(ProtocolError)
+		} else {
+			c.setCloseReason(WriteError)
+		}
+
 		// ensure connection gets closed
 		c.carrier.Close()
+		c.stopIdle()
 
 		return err
 	}
@@ -108,13 +182,130 @@ func (c *BaseConn) write(pkt packet.GenericPacket) error {
 	return nil
 }
 
+func (c *BaseConn) writeStream(pkt *packet.PublishPacket, payload io.Reader, size int64) error {
+	// buffer payload if packets are written directly
+	if c.packetWrites {
+		// check size
+		if size < 0 {
+			return &Error{Op: OpSend, Kind: ErrEncode, Err: fmt.Errorf("payload size (%d) negative", size)}
+		}
+
+		// read payload
+		buf, err := io.ReadAll(io.LimitReader(payload, size))
+		if err == nil && int64(len(buf)) < size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return &Error{Op: OpSend, Kind: ErrEncode, Err: err}
+		}
+
+		// copy packet
+		pub := *pkt
+		pub.Message.Payload = buf
+
+		return c.write(&pub)
+	}
+
+	// set write deadline
+	c.resetWriteTimeout()
+
+	// record activity
+	c.touch()
+
+	// capture packet if requested
+	if flow := c.capture.Load(); flow != nil {
+		flow.record(pkt, true, c.stream.Version())
+	}
+
+	// write packet while tracking payload errors
+	reader := &payloadReader{reader: payload}
+	err := c.stream.WriteStream(pkt, reader, size)
+	if err != nil {
+		// wrap error
+		if reader.err != nil || err == io.ErrUnexpectedEOF {
+			err = &Error{Op: OpSend, Kind: ErrEncode, Err: err}
+		} else {
+			err = wrapError(OpSend, err, ErrEncode)
+		}
+
+		// save reason
+		if errors.Is(err, ErrEncode) {
+			c.setCloseReason(ProtocolError)
+		} else {
+			c.setCloseReason(WriteError)
+		}
+
+		// ensure connection gets closed
+		c.carrier.Close()
+		c.stopIdle()
+
+		return err
+	}
+
+	return nil
+}
+
+func (c *BaseConn) writePacket(pkt packet.GenericPacket) error {
+	// capture packet if requested
+	if flow := c.capture.Load(); flow != nil {
+		flow.record(pkt, true, c.stream.Version())
+	}
+
+	// switch to the protocol level of the client
+	if connect, ok := pkt.(*packet.ConnectPacket); ok {
+		c.stream.SetVersion(connect.Version)
+	}
+
+	// reset and eventually grow buffer
+	version := c.stream.Version()
+	packetLength := packet.Len(pkt, version)
+	c.writeBuffer.Reset()
+	c.writeBuffer.Grow(packetLength)
+	buf := c.writeBuffer.Bytes()[0:packetLength]
+
+	// encode packet
+	_, err := packet.Encode(pkt, buf, version)
+	if err != nil {
+		// save reason
+		c.setCloseReason(ProtocolError)
+
+		// ensure connection gets closed
+		c.carrier.Close()
+		c.stopIdle()
+
+		return &Error{Op: OpSend, Kind: ErrEncode, Err: err}
+	}
+
+	// write packet in one call
+	_, err = c.carrier.Write(buf)
+	if err != nil {
+		// save reason
+		c.setCloseReason(WriteError)
+
+		// ensure connection gets closed
+		c.carrier.Close()
+		c.stopIdle()
+
+		return wrapError(OpSend, err, ErrNetwork)
+	}
+
+	return nil
+}
+
 func (c *BaseConn) flush() error {
+	// set write deadline
+	c.resetWriteTimeout()
+
 	err := c.stream.Flush()
 	if err != nil {
+		// save reason
+		c.setCloseReason(WriteError)
+
 		// ensure connection gets closed
 		c.carrier.Close()
+		c.stopIdle()
 
-		return err
+		return wrapError(OpSend, err, ErrNetwork)
 	}
 
 	return nil
@@ -137,22 +328,97 @@ func (c *BaseConn) asyncFlush() {
 //
 // Note: Only one goroutine can Receive at the same time.
 func (c *BaseConn) Receive() (packet.GenericPacket, error) {
+	pkt, _, err := c.receive(0, false)
+	return pkt, err
+}
+
+// ReceiveStream will read from the underlying connection like Receive. However,
+// QOS 0 publish packets with a payload that exceeds the threshold are returned
+// without a payload together with a Payload that streams it from the
+// connection. The payload must be read before the next call to Receive or
+// ReceiveStream, any unread rest is discarded.
+//
+// Note: Only one goroutine can Receive at the same time.
+func (c *BaseConn) ReceiveStream(threshold int64) (packet.GenericPacket, *Payload, error) {
+	return c.receive(threshold, true)
+}
+
+func (c *BaseConn) receive(threshold int64, stream bool) (packet.GenericPacket, *Payload, error) {
 	c.rMutex.Lock()
 	defer c.rMutex.Unlock()
 
 	// read next packet
-	pkt, err := c.stream.Read()
+	var pkt packet.GenericPacket
+	var reader *packet.PayloadReader
+	var err error
+	if stream {
+		pkt, reader, err = c.stream.ReadStream(threshold)
+	} else {
+		pkt, err = c.stream.Read()
+	}
 	if err != nil {
+		// wrap error
+		err = wrapError(OpReceive, err, ErrDecode)
+
+		// save reason
+		if err == io.EOF {
+			c.setCloseReason(RemoteClose)
+		} else if errors.Is(err, ErrDecode) || errors.Is(err, ErrWebSocketClose) {
+			c.setCloseReason(ProtocolError)
+		} else {
+			c.setCloseReason(ReadError)
+		}
+
+		// notify peer about a too large packet
+		if errors.Is(err, packet.ErrReadLimitExceeded) {
+			c.disconnectTooLarge()
+		}
+
 		// ensure connection gets closed
 		c.carrier.Close()
+		c.stopIdle()
 
-		return nil, err
+		return nil, nil, err
 	}
 
-	// reset timeout
+	// reset timeout and record activity
 	c.resetTimeout()
+	c.touch()
+
+	// capture packet if requested
+	if flow := c.capture.Load(); flow != nil {
+		flow.record(pkt, false, c.stream.Version())
+	}
+
+	// wrap payload reader
+	var payload *Payload
+	if reader != nil {
+		payload = &Payload{conn: c, reader: reader}
+	}
+
+	return pkt, payload, nil
+}
 
-	return pkt, nil
+// disconnectTooLarge will send a DisconnectPacket that signals a too large
+// packet if MQTT 5 is used. Errors are ignored as the connection is closed
+// anyway.
+func (c *BaseConn) disconnectTooLarge() {
+	// check version
+	if c.stream.Version() != packet.Version5 {
+		return
+	}
+
+	c.sMutex.Lock()
+	defer c.sMutex.Unlock()
+
+	// prepare packet
+	disconnect := packet.NewDisconnectPacket()
+	disconnect.ReasonCode = packet.PacketTooLargeDisconnect
+
+	// write packet
+	if c.write(disconnect) == nil {
+		_ = c.flush()
+	}
 }
 
 // Close will close the underlying connection and cleanup resources. It will
@@ -162,6 +428,12 @@ func (c *BaseConn) Close() error {
 	c.sMutex.Lock()
 	defer c.sMutex.Unlock()
 
+	// save reason
+	c.setCloseReason(LocalClose)
+
+	// stop idle timer
+	c.stopIdle()
+
 	// flush any cached writes
 	err := c.flush()
 	if err != nil {
@@ -171,19 +443,49 @@ func (c *BaseConn) Close() error {
 	// close carrier
 	err = c.carrier.Close()
 	if err != nil {
-		return err
+		return wrapError(OpClose, err, ErrNetwork)
 	}
 
 	return nil
 }
 
+// CloseReason will return the reason why the connection has been closed.
+// Only the first reason is recorded, subsequent failures caused by the closed
+// connection are not reported.
+func (c *BaseConn) CloseReason() CloseReason {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+
+	return c.closeReason
+}
+
+func (c *BaseConn) setCloseReason(reason CloseReason) {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+
+	// keep first reason
+	if c.closeReason == NotClosed {
+		c.closeReason = reason
+	}
+}
+
 // SetReadLimit sets the maximum size of a packet that can be received.
 // If the limit is greater than zero, Receive will close the connection and
-// return an Error if receiving the next packet will exceed the limit.
+// return an Error if receiving the next packet will exceed the limit. Using
+// MQTT 5, a DisconnectPacket with the PacketTooLargeDisconnect reason code is
+// sent before the connection is closed.
 func (c *BaseConn) SetReadLimit(limit int64) {
 	c.stream.Decoder.Limit = limit
 }
 
+// SetPublishPool sets the pool that is used to decode received publish
+// packets. The receiver owns the initial reference of the returned packets and
+// should release it once the packet is not used anymore. A nil value disables
+// pooling.
+func (c *BaseConn) SetPublishPool(pool *packet.PublishPool) {
+	c.stream.Decoder.Pool = pool
+}
+
 // SetReadTimeout sets the maximum time that can pass between reads.
 // If no data is received in the set duration the connection will be closed
 // and Read returns an error.
@@ -192,6 +494,65 @@ func (c *BaseConn) SetReadTimeout(timeout time.Duration) {
 	c.resetTimeout()
 }
 
+// SetWriteTimeout sets the maximum time that can pass while writing a packet.
+// If a packet could not be written in the set duration the connection will be
+// closed and Send returns an Error of the kind ErrWriteTimeout.
+func (c *BaseConn) SetWriteTimeout(timeout time.Duration) {
+	c.sMutex.Lock()
+	defer c.sMutex.Unlock()
+
+	c.writeTimeout = timeout
+}
+
+// SetIdleTimeout sets the maximum time that can pass without sending or
+// receiving a packet. If the time elapses, the callback is called and the
+// timeout starts again. Without a callback the connection is closed and
+// Receive returns an error. A zero timeout disables the idle timeout.
+//
+// Note: The callback is called from a separate goroutine.
+func (c *BaseConn) SetIdleTimeout(timeout time.Duration, onIdle func()) {
+	c.idleMutex.Lock()
+	defer c.idleMutex.Unlock()
+
+	// stop existing timer
+	c.stopIdleTimer()
+
+	// set timeout
+	c.idleTimeout = timeout
+	c.idleCallback = onIdle
+
+	// check timeout
+	if timeout <= 0 {
+		return
+	}
+
+	// start timer
+	c.touch()
+	epoch := c.idleEpoch
+	c.idleTimer = time.AfterFunc(timeout, func() {
+		c.checkIdle(epoch)
+	})
+}
+
+// SetCapture enables mirroring all sent and received packets into the
+// specified Capture. A nil value disables capturing.
+func (c *BaseConn) SetCapture(capture *Capture) {
+	// set flow
+	if capture != nil {
+		c.capture.Store(capture.flow())
+	} else {
+		c.capture.Store(nil)
+	}
+}
+
+func (c *BaseConn) resetWriteTimeout() {
+	if c.writeTimeout > 0 {
+		c.carrier.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	} else {
+		c.carrier.SetWriteDeadline(time.Time{})
+	}
+}
+
 func (c *BaseConn) resetTimeout() {
 	if c.readTimeout > 0 {
 		c.carrier.SetReadDeadline(time.Now().Add(c.readTimeout))
@@ -199,3 +560,115 @@ func (c *BaseConn) resetTimeout() {
 		c.carrier.SetReadDeadline(time.Time{})
 	}
 }
+
+func (c *BaseConn) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (c *BaseConn) checkIdle(epoch uint64) {
+	c.idleMutex.Lock()
+
+	// ignore timers that have been replaced or stopped
+	if epoch != c.idleEpoch || c.idleTimer == nil {
+		c.idleMutex.Unlock()
+		return
+	}
+
+	// reschedule if there has been activity in the meantime
+	idle := time.Since(time.Unix(0, c.lastActivity.Load()))
+	if idle < c.idleTimeout {
+		c.idleTimer.Reset(c.idleTimeout - idle)
+		c.idleMutex.Unlock()
+		return
+	}
+
+	// close connection if no callback is set
+	callback := c.idleCallback
+	if callback == nil {
+		c.stopIdleTimer()
+		c.idleMutex.Unlock()
+
+		c.setCloseReason(IdleTimeout)
+		c.carrier.Close()
+
+		return
+	}
+
+	// restart timeout
+	c.touch()
+	c.idleTimer.Reset(c.idleTimeout)
+	c.idleMutex.Unlock()
+
+	// call callback
+	callback()
+}
+
+func (c *BaseConn) stopIdle() {
+	c.idleMutex.Lock()
+	c.stopIdleTimer()
+	c.idleMutex.Unlock()
+}
+
+func (c *BaseConn) stopIdleTimer() {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+
+	c.idleEpoch++
+}
+
+// payloadReader records errors returned by the wrapped payload reader.
+type payloadReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *payloadReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// A Payload streams the payload of a received publish packet from the
+// connection. Errors while reading close the connection.
+type Payload struct {
+	conn   *BaseConn
+	reader *packet.PayloadReader
+}
+
+// Size returns the total size of the payload.
+func (p *Payload) Size() int64 {
+	return p.reader.Size()
+}
+
+// Read reads from the payload. It returns io.EOF once the whole payload has
+// been read and an Error if reading from the connection failed.
+func (p *Payload) Read(b []byte) (int, error) {
+	// read bytes
+	n, err := p.reader.Read(b)
+
+	// reset timeout and record activity
+	if n > 0 {
+		p.conn.resetTimeout()
+		p.conn.touch()
+	}
+
+	// handle errors
+	if err != nil && err != io.EOF {
+		// wrap error
+		err = wrapError(OpReceive, err, ErrDecode)
+
+		// save reason
+		p.conn.setCloseReason(ReadError)
+
+		// ensure connection gets closed
+		p.conn.carrier.Close()
+		p.conn.stopIdle()
+	}
+
+	return n, err
+}
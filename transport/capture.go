@@ -0,0 +1,243 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+)
+
+// the pcapng block types
+const (
+	captureSectionHeader   = 0x0A0D0D0A
+	captureInterface       = 0x00000001
+	captureEnhancedPacket  = 0x00000006
+	captureByteOrderMagic  = 0x1A2B3C4D
+	captureLinkTypeRaw     = 101
+	captureHeadersLen      = 20 + 20
+	captureMaxSegmentLen   = 0xFFFF - captureHeadersLen
+	captureBrokerPort      = 1883
+	captureFirstClientPort = 49152
+)
+
+// A Capture mirrors the packets of one or more connections into a pcapng
+// stream that can be analyzed with Wireshark. Every packet is wrapped in
+// synthesized IPv4 and TCP headers and annotated with a comment that marks
+// the packet boundary and direction. As packets are captured after decryption,
+// this also allows the inspection of TLS and WebSocket connections.
+//
+// The remote side of every connection uses the port 1883 to enable the MQTT
+// dissector while the local side uses a unique port per connection.
+type Capture struct {
+	writer io.Writer
+	port   uint16
+	err    error
+	mutex  sync.Mutex
+}
+
+// NewCapture writes the pcapng header to the specified writer and returns a
+// new Capture.
+func NewCapture(writer io.Writer) (*Capture, error) {
+	// prepare buffer
+	buf := make([]byte, 28+20)
+
+	// encode section header block
+	binary.LittleEndian.PutUint32(buf, captureSectionHeader)
+	binary.LittleEndian.PutUint32(buf[4:], 28)
+	binary.LittleEndian.PutUint32(buf[8:], captureByteOrderMagic)
+	binary.LittleEndian.PutUint16(buf[12:], 1)
+	binary.LittleEndian.PutUint16(buf[14:], 0)
+	binary.LittleEndian.PutUint64(buf[16:], 0xFFFFFFFFFFFFFFFF)
+	binary.LittleEndian.PutUint32(buf[24:], 28)
+
+	// encode interface description block
+	binary.LittleEndian.PutUint32(buf[28:], captureInterface)
+	binary.LittleEndian.PutUint32(buf[32:], 20)
+	binary.LittleEndian.PutUint16(buf[36:], captureLinkTypeRaw)
+	binary.LittleEndian.PutUint32(buf[40:], 0)
+	binary.LittleEndian.PutUint32(buf[44:], 20)
+
+	// write header
+	_, err := writer.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Capture{
+		writer: writer,
+		port:   captureFirstClientPort,
+	}, nil
+}
+
+// Err returns the first error encountered while writing to the underlying
+// writer. Capturing stops after an error has occurred.
+func (c *Capture) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
+
+// returns a new flow that uses the next free client port
+func (c *Capture) flow() *captureFlow {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// get port
+	port := c.port
+	c.port++
+	if c.port == 0 {
+		c.port = captureFirstClientPort
+	}
+
+	return &captureFlow{
+		capture: c,
+		port:    port,
+		seq:     [2]uint32{1, 1},
+	}
+}
+
+// a captureFlow tracks the TCP sequence numbers of a captured connection
+type captureFlow struct {
+	capture *Capture
+	port    uint16
+	seq     [2]uint32
+}
+
+// writes the packet to the capture
+func (f *captureFlow) record(pkt packet.GenericPacket, sent bool, version byte) {
+	// encode packet
+	data := make([]byte, packet.Len(pkt, version))
+	_, err := packet.Encode(pkt, data, version)
+	if err != nil {
+		return
+	}
+
+	// prepare comment
+	direction := "received"
+	if sent {
+		direction = "sent"
+	}
+	comment := fmt.Sprintf("%s %s", pkt.Type(), direction)
+
+	// acquire mutex
+	f.capture.mutex.Lock()
+	defer f.capture.mutex.Unlock()
+
+	// check error
+	if f.capture.err != nil {
+		return
+	}
+
+	// write segments
+	now := time.Now()
+	for len(data) > 0 {
+		// get segment
+		segment := data
+		if len(segment) > captureMaxSegmentLen {
+			segment = segment[:captureMaxSegmentLen]
+		}
+		data = data[len(segment):]
+
+		// write block
+		err = f.writeBlock(now, segment, comment, sent)
+		if err != nil {
+			f.capture.err = err
+			return
+		}
+
+		// only comment first segment
+		comment = ""
+	}
+}
+
+func (f *captureFlow) writeBlock(now time.Time, segment []byte, comment string, sent bool) error {
+	// get lengths
+	dataLen := captureHeadersLen + len(segment)
+	optionsLen := 0
+	if comment != "" {
+		optionsLen = 4 + pad4(len(comment)) + 4
+	}
+	blockLen := 28 + pad4(dataLen) + optionsLen + 4
+
+	// prepare buffer
+	buf := make([]byte, blockLen)
+
+	// encode enhanced packet block header
+	ts := uint64(now.UnixNano() / int64(time.Microsecond))
+	binary.LittleEndian.PutUint32(buf, captureEnhancedPacket)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(blockLen))
+	binary.LittleEndian.PutUint32(buf[8:], 0)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(buf[16:], uint32(ts))
+	binary.LittleEndian.PutUint32(buf[20:], uint32(dataLen))
+	binary.LittleEndian.PutUint32(buf[24:], uint32(dataLen))
+
+	// get direction
+	src, dst, out, in := f.port, uint16(captureBrokerPort), 0, 1
+	if !sent {
+		src, dst, out, in = dst, src, 1, 0
+	}
+
+	// encode ipv4 header
+	ip := buf[28:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(dataLen))
+	binary.BigEndian.PutUint16(ip[6:], 0x4000)
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:], []byte{127, 0, 0, byte(out + 1)})
+	copy(ip[16:], []byte{127, 0, 0, byte(in + 1)})
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip[:20]))
+
+	// encode tcp header
+	tcp := ip[20:]
+	binary.BigEndian.PutUint16(tcp, src)
+	binary.BigEndian.PutUint16(tcp[2:], dst)
+	binary.BigEndian.PutUint32(tcp[4:], f.seq[out])
+	binary.BigEndian.PutUint32(tcp[8:], f.seq[in])
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18
+	binary.BigEndian.PutUint16(tcp[14:], 0xFFFF)
+
+	// copy segment
+	copy(tcp[20:], segment)
+
+	// advance sequence
+	f.seq[out] += uint32(len(segment))
+
+	// encode comment option
+	if comment != "" {
+		opt := buf[28+pad4(dataLen):]
+		binary.LittleEndian.PutUint16(opt, 1)
+		binary.LittleEndian.PutUint16(opt[2:], uint16(len(comment)))
+		copy(opt[4:], comment)
+	}
+
+	// encode trailing length
+	binary.LittleEndian.PutUint32(buf[blockLen-4:], uint32(blockLen))
+
+	// write block
+	_, err := f.capture.writer.Write(buf)
+
+	return err
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+func checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+
+	return ^uint16(sum)
+}
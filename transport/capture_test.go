@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapture(t *testing.T) {
+	var buf bytes.Buffer
+	capture, err := NewCapture(&buf)
+	assert.NoError(t, err)
+
+	c1, c2 := net.Pipe()
+
+	conn1 := Wrap(c1)
+	conn1.SetCapture(capture)
+	conn2 := Wrap(c2)
+
+	connect := packet.NewConnectPacket()
+	connect.ClientID = "test"
+
+	done := make(chan struct{})
+
+	go func() {
+		pkt, err := conn2.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, connect.String(), pkt.String())
+
+		assert.NoError(t, conn2.Send(packet.NewConnackPacket()))
+
+		close(done)
+	}()
+
+	assert.NoError(t, conn1.Send(connect))
+
+	pkt, err := conn1.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.CONNACK, pkt.Type())
+
+	safeReceive(done)
+
+	assert.NoError(t, capture.Err())
+
+	data := buf.Bytes()
+	assert.Equal(t, uint32(captureSectionHeader), binary.LittleEndian.Uint32(data))
+	assert.Equal(t, uint32(captureInterface), binary.LittleEndian.Uint32(data[28:]))
+	assert.Equal(t, uint16(captureLinkTypeRaw), binary.LittleEndian.Uint16(data[36:]))
+
+	var comments []string
+	var payloads [][]byte
+	var ports []uint16
+
+	for data = data[48:]; len(data) > 0; {
+		assert.Equal(t, uint32(captureEnhancedPacket), binary.LittleEndian.Uint32(data))
+
+		blockLen := int(binary.LittleEndian.Uint32(data[4:]))
+		dataLen := int(binary.LittleEndian.Uint32(data[20:]))
+		assert.Equal(t, uint32(blockLen), binary.LittleEndian.Uint32(data[blockLen-4:]))
+
+		frame := data[28 : 28+dataLen]
+		assert.Equal(t, uint16(0), checksum(frame[:20]))
+		ports = append(ports, binary.BigEndian.Uint16(frame[22:]))
+		payloads = append(payloads, frame[captureHeadersLen:])
+
+		opt := data[28+pad4(dataLen):]
+		assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(opt))
+		comments = append(comments, string(opt[4:4+binary.LittleEndian.Uint16(opt[2:])]))
+
+		data = data[blockLen:]
+	}
+
+	assert.Equal(t, []string{"Connect sent", "Connack received"}, comments)
+	assert.Equal(t, []uint16{captureBrokerPort, captureFirstClientPort}, ports)
+
+	encoded := make([]byte, connect.Len())
+	_, err = connect.Encode(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, encoded, payloads[0])
+}
@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// A CertificateReloader holds a TLS certificate that has been loaded from a
+// certificate and key file and can be reloaded at runtime. Its GetCertificate
+// method should be assigned to the GetCertificate field of a tls.Config.
+// Connections that are already established are not affected by a reload.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	cert  *tls.Certificate
+	mutex sync.RWMutex
+}
+
+// NewCertificateReloader returns a new CertificateReloader that has loaded the
+// specified certificate and key file.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	// load certificate
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload will load the certificate and key file again. If the files cannot be
+// loaded the previous certificate is kept and the error is returned.
+func (r *CertificateReloader) Reload() error {
+	// load certificate
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// set certificate
+	r.cert = &cert
+
+	return nil
+}
+
+// GetCertificate returns the currently loaded certificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.cert, nil
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	_, err := NewCertificateReloader(certFile, keyFile)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, []byte(testCert), 0600))
+	require.NoError(t, os.WriteFile(keyFile, []byte(testKey), 0600))
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	cert1, err := reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, cert1)
+
+	assert.NoError(t, reloader.Reload())
+
+	cert2, err := reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.True(t, cert1 != cert2)
+
+	require.NoError(t, os.Remove(keyFile))
+	assert.Error(t, reloader.Reload())
+
+	cert3, err := reloader.GetCertificate(nil)
+	assert.NoError(t, err)
+	assert.True(t, cert2 == cert3)
+
+	server, err := NewSecureNetServer("localhost:0", &tls.Config{GetCertificate: reloader.GetCertificate})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		conn, err := server.Accept()
+		assert.NoError(t, err)
+
+		pkt, err := conn.Receive()
+		assert.Nil(t, pkt)
+		assert.Error(t, err)
+
+		close(done)
+	}()
+
+	conn, err := testDialer.Dial(getURL(server, "tls"))
+	require.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	safeReceive(done)
+
+	assert.NoError(t, server.Close())
+}
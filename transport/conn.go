@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"io"
 	"net"
 	"time"
 
@@ -9,6 +10,60 @@ import (
 
 var flushTimeout = time.Millisecond
 
+// A CloseReason describes why a connection has been closed.
+type CloseReason int
+
+// All available close reasons.
+const (
+	// NotClosed is returned while the connection is still open.
+	NotClosed CloseReason = iota
+
+	// LocalClose is returned if the connection has been closed by calling Close.
+	LocalClose
+
+	// RemoteClose is returned if the remote host closed the connection
+	// regularly.
+	RemoteClose
+
+	// ReadError is returned if the connection has been closed because of a
+	// network error or timeout while reading.
+	ReadError
+
+	// WriteError is returned if the connection has been closed because of a
+	// network error or timeout while writing.
+	WriteError
+
+	// ProtocolError is returned if the connection has been closed because a
+	// packet could not be encoded or decoded.
+	ProtocolError
+
+	// IdleTimeout is returned if the connection has been closed because no
+	// packets have been exchanged within the idle timeout.
+	IdleTimeout
+)
+
+// String returns the close reason as a string.
+func (r CloseReason) String() string {
+	switch r {
+	case NotClosed:
+		return "NotClosed"
+	case LocalClose:
+		return "LocalClose"
+	case RemoteClose:
+		return "RemoteClose"
+	case ReadError:
+		return "ReadError"
+	case WriteError:
+		return "WriteError"
+	case ProtocolError:
+		return "ProtocolError"
+	case IdleTimeout:
+		return "IdleTimeout"
+	}
+
+	return "Unknown"
+}
+
 // A Conn is a connection between a client and a broker. It abstracts an
 // existing underlying stream connection.
 type Conn interface {
@@ -27,6 +82,13 @@ type Conn interface {
 	// Note: Only one goroutine can call BufferedSend at the same time.
 	BufferedSend(pkt packet.GenericPacket) error
 
+	// SendStream will write the publish packet to the underlying connection
+	// while streaming the payload of the specified size from the reader. As the
+	// packet may have been written partially, failures close the connection.
+	//
+	// Note: Only one goroutine can Send at the same time.
+	SendStream(pkt *packet.PublishPacket, payload io.Reader, size int64) error
+
 	// Receive will read from the underlying connection and return a fully read
 	// packet. It will return an Error if there was an error while decoding or
 	// reading from the underlying connection.
@@ -34,6 +96,15 @@ type Conn interface {
 	// Note: Only one goroutine can Receive at the same time.
 	Receive() (packet.GenericPacket, error)
 
+	// ReceiveStream will read from the underlying connection like Receive.
+	// However, QOS 0 publish packets with a payload that exceeds the threshold
+	// are returned without a payload together with a Payload that streams it
+	// from the connection. The payload must be read before the next call to
+	// Receive or ReceiveStream, any unread rest is discarded.
+	//
+	// Note: Only one goroutine can Receive at the same time.
+	ReceiveStream(threshold int64) (packet.GenericPacket, *Payload, error)
+
 	// Close will close the underlying connection and cleanup resources. It will
 	// return an Error if there was an error while closing the underlying
 	// connection.
@@ -44,11 +115,37 @@ type Conn interface {
 	// return an Error if receiving the next packet will exceed the limit.
 	SetReadLimit(limit int64)
 
+	// SetPublishPool sets the pool that is used to decode received publish
+	// packets. The receiver owns the initial reference of the returned
+	// packets. A nil value disables pooling.
+	SetPublishPool(pool *packet.PublishPool)
+
 	// SetReadTimeout sets the maximum time that can pass between reads.
 	// If no data is received in the set duration the connection will be closed
 	// and Read returns an error.
 	SetReadTimeout(timeout time.Duration)
 
+	// SetWriteTimeout sets the maximum time that can pass while writing a
+	// packet. If a packet could not be written in the set duration the
+	// connection will be closed and Send returns an Error of the kind
+	// ErrWriteTimeout.
+	SetWriteTimeout(timeout time.Duration)
+
+	// SetIdleTimeout sets the maximum time that can pass without sending or
+	// receiving a packet. If the time elapses, the callback is called and the
+	// timeout starts again. Without a callback the connection is closed and
+	// Receive returns an error. A zero timeout disables the idle timeout.
+	SetIdleTimeout(timeout time.Duration, onIdle func())
+
+	// SetCapture enables mirroring all sent and received packets into the
+	// specified Capture. A nil value disables capturing.
+	SetCapture(capture *Capture)
+
+	// CloseReason will return the reason why the connection has been closed.
+	// Only the first reason is recorded, subsequent failures caused by the
+	// closed connection are not reported.
+	CloseReason() CloseReason
+
 	// LocalAddr will return the underlying connection's local net address.
 	LocalAddr() net.Addr
 
@@ -1,7 +1,9 @@
 package transport
 
 import (
+	"errors"
 	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -39,13 +41,17 @@ func abstractConnConnectTest(t *testing.T, protocol string) {
 
 func abstractConnCloseTest(t *testing.T, protocol string) {
 	conn2, done := connectionPair(protocol, func(conn1 Conn) {
+		assert.Equal(t, NotClosed, conn1.CloseReason())
+
 		err := conn1.Close()
 		assert.NoError(t, err)
+		assert.Equal(t, LocalClose, conn1.CloseReason())
 	})
 
 	pkt, err := conn2.Receive()
 	assert.Nil(t, pkt)
 	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, RemoteClose, conn2.CloseReason())
 
 	safeReceive(done)
 }
@@ -66,6 +72,76 @@ func abstractConnEncodeErrorTest(t *testing.T, protocol string) {
 	safeReceive(done)
 }
 
+func abstractConnSendStreamTest(t *testing.T, protocol string) {
+	conn2, done := connectionPair(protocol, func(conn1 Conn) {
+		pkt := packet.NewPublishPacket()
+		pkt.Message.Topic = "foo"
+
+		err := conn1.SendStream(pkt, strings.NewReader("bar"), 3)
+		assert.NoError(t, err)
+
+		err = conn1.SendStream(pkt, strings.NewReader("ba"), 3)
+		assert.True(t, errors.Is(err, ErrEncode))
+		assert.Equal(t, ProtocolError, conn1.CloseReason())
+	})
+
+	pkt, err := conn2.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", pkt.(*packet.PublishPacket).Message.Topic)
+	assert.Equal(t, []byte("bar"), pkt.(*packet.PublishPacket).Message.Payload)
+
+	pkt, err = conn2.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+
+	safeReceive(done)
+}
+
+func abstractConnReceiveStreamTest(t *testing.T, protocol string) {
+	conn2, done := connectionPair(protocol, func(conn1 Conn) {
+		pkt := packet.NewPublishPacket()
+		pkt.Message.Topic = "foo"
+		pkt.Message.Payload = make([]byte, 8192)
+
+		err := conn1.Send(pkt)
+		assert.NoError(t, err)
+
+		err = conn1.Send(pkt)
+		assert.NoError(t, err)
+
+		err = conn1.Send(packet.NewPingreqPacket())
+		assert.NoError(t, err)
+
+		err = conn1.Close()
+		assert.NoError(t, err)
+	})
+
+	pkt, payload, err := conn2.ReceiveStream(1024)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", pkt.(*packet.PublishPacket).Message.Topic)
+	assert.Equal(t, int64(8192), payload.Size())
+
+	data, err := io.ReadAll(payload)
+	assert.NoError(t, err)
+	assert.Len(t, data, 8192)
+
+	// leave payload unread
+	_, payload, err = conn2.ReceiveStream(1024)
+	assert.NoError(t, err)
+	assert.NotNil(t, payload)
+
+	pkt, err = conn2.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.PINGREQ, pkt.Type())
+
+	pkt, payload, err = conn2.ReceiveStream(1024)
+	assert.Nil(t, pkt)
+	assert.Nil(t, payload)
+	assert.Equal(t, io.EOF, err)
+
+	safeReceive(done)
+}
+
 func abstractConnDecodeErrorTest(t *testing.T, protocol string) {
 	conn2, done := connectionPair(protocol, func(conn1 Conn) {
 		buf := []byte{0x00, 0x00} // < too small
@@ -84,6 +160,7 @@ func abstractConnDecodeErrorTest(t *testing.T, protocol string) {
 	pkt, err := conn2.Receive()
 	assert.Nil(t, pkt)
 	assert.Error(t, err)
+	assert.Equal(t, ProtocolError, conn2.CloseReason())
 
 	safeReceive(done)
 }
@@ -162,7 +239,9 @@ func abstractConnReadLimitTest(t *testing.T, protocol string) {
 		pkt, err := conn1.Receive()
 		assert.Nil(t, pkt)
 		assert.Error(t, err)
-		assert.Equal(t, packet.ErrReadLimitExceeded, err)
+		assert.True(t, errors.Is(err, packet.ErrReadLimitExceeded))
+		assert.True(t, errors.Is(err, ErrDecode))
+		assert.Equal(t, ProtocolError, conn1.CloseReason())
 	})
 
 	err := conn2.Send(packet.NewConnectPacket())
@@ -175,6 +254,39 @@ func abstractConnReadLimitTest(t *testing.T, protocol string) {
 	safeReceive(done)
 }
 
+func abstractConnReadLimit5Test(t *testing.T, protocol string) {
+	conn2, done := connectionPair(protocol, func(conn1 Conn) {
+		pkt, err := conn1.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, packet.CONNECT, pkt.Type())
+
+		conn1.SetReadLimit(1)
+
+		pkt, err = conn1.Receive()
+		assert.Nil(t, pkt)
+		assert.True(t, errors.Is(err, packet.ErrReadLimitExceeded))
+		assert.Equal(t, ProtocolError, conn1.CloseReason())
+	})
+
+	connect := packet.NewConnectPacket()
+	connect.Version = packet.Version5
+	err := conn2.Send(connect)
+	assert.NoError(t, err)
+
+	err = conn2.Send(packet.NewPingreqPacket())
+	assert.NoError(t, err)
+
+	pkt, err := conn2.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.PacketTooLargeDisconnect, pkt.(*packet.DisconnectPacket).ReasonCode)
+
+	pkt, err = conn2.Receive()
+	assert.Nil(t, pkt)
+	assert.Equal(t, io.EOF, err)
+
+	safeReceive(done)
+}
+
 func abstractConnReadTimeoutTest(t *testing.T, protocol string) {
 	conn2, done := connectionPair(protocol, func(conn1 Conn) {
 		conn1.SetReadTimeout(10 * time.Millisecond)
@@ -182,6 +294,25 @@ func abstractConnReadTimeoutTest(t *testing.T, protocol string) {
 		pkt, err := conn1.Receive()
 		assert.Nil(t, pkt)
 		assert.Error(t, err)
+		assert.True(t, errors.Is(err, ErrTimeout))
+		assert.Equal(t, ReadError, conn1.CloseReason())
+	})
+
+	pkt, err := conn2.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+
+	safeReceive(done)
+}
+
+func abstractConnIdleTimeoutTest(t *testing.T, protocol string) {
+	conn2, done := connectionPair(protocol, func(conn1 Conn) {
+		conn1.SetIdleTimeout(10*time.Millisecond, nil)
+
+		pkt, err := conn1.Receive()
+		assert.Nil(t, pkt)
+		assert.Error(t, err)
+		assert.Equal(t, IdleTimeout, conn1.CloseReason())
 	})
 
 	pkt, err := conn2.Receive()
@@ -191,6 +322,64 @@ func abstractConnReadTimeoutTest(t *testing.T, protocol string) {
 	safeReceive(done)
 }
 
+func abstractConnIdleCallbackTest(t *testing.T, protocol string) {
+	conn2, done := connectionPair(protocol, func(conn1 Conn) {
+		idle := make(chan struct{}, 1)
+		conn1.SetIdleTimeout(10*time.Millisecond, func() {
+			select {
+			case idle <- struct{}{}:
+			default:
+			}
+		})
+
+		safeReceive(idle)
+
+		err := conn1.Send(packet.NewConnectPacket())
+		assert.NoError(t, err)
+
+		conn1.SetIdleTimeout(0, nil)
+
+		err = conn1.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, LocalClose, conn1.CloseReason())
+	})
+
+	pkt, err := conn2.Receive()
+	assert.Equal(t, pkt.Type(), packet.CONNECT)
+	assert.NoError(t, err)
+
+	pkt, err = conn2.Receive()
+	assert.Nil(t, pkt)
+	assert.Error(t, err)
+
+	safeReceive(done)
+}
+
+func abstractConnWriteTimeoutTest(t *testing.T, protocol string) {
+	conn2, done := connectionPair(protocol, func(conn1 Conn) {
+		conn1.SetWriteTimeout(10 * time.Millisecond)
+
+		pkt := packet.NewPublishPacket()
+		pkt.Message.Topic = "foo"
+		pkt.Message.Payload = make([]byte, 1024*1024)
+
+		var err error
+		for i := 0; i < 100 && err == nil; i++ {
+			err = conn1.Send(pkt)
+		}
+
+		assert.Error(t, err)
+		assert.True(t, errors.Is(err, ErrWriteTimeout))
+		assert.True(t, errors.Is(err, ErrTimeout))
+		assert.Equal(t, WriteError, conn1.CloseReason())
+	})
+
+	safeReceive(done)
+
+	err := conn2.Close()
+	assert.NoError(t, err)
+}
+
 func abstractConnCloseAfterCloseTest(t *testing.T, protocol string) {
 	conn2, done := connectionPair(protocol, func(conn1 Conn) {
 		err := conn1.Close()
@@ -198,6 +387,8 @@ func abstractConnCloseAfterCloseTest(t *testing.T, protocol string) {
 
 		err = conn1.Close()
 		assert.Error(t, err)
+		assert.True(t, errors.Is(err, ErrClosed))
+		assert.Equal(t, LocalClose, conn1.CloseReason())
 	})
 
 	pkt, err := conn2.Receive()
@@ -1,6 +1,7 @@
 package transport
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -15,25 +16,72 @@ type Dialer struct {
 	TLSConfig     *tls.Config
 	RequestHeader http.Header
 
-	DefaultTCPPort string
-	DefaultTLSPort string
-	DefaultWSPort  string
-	DefaultWSSPort string
+	DefaultTCPPort  string
+	DefaultTLSPort  string
+	DefaultWSPort   string
+	DefaultWSSPort  string
+	DefaultQUICPort string
 
+	// The framing used for outgoing packets of WebSocket connections.
+	WebSocketFraming WebSocketFraming
+
+	// The baud rate used for serial connections if the URL does not specify
+	// one (e.g. serial:///dev/ttyUSB0?baud=115200&parity=even&stopbits=2).
+	DefaultBaudRate int
+
+	// If enabled, TLS sessions are cached and resumed on subsequent connections
+	// to the same server. This skips the certificate exchange on reconnects.
+	//
+	// Note: TLS 1.3 early data (0-RTT) is only used by QUIC connections, see
+	// EarlyData.
+	ResumeTLS bool
+
+	// If enabled, QUIC connections cache sessions and send the first packet,
+	// usually the ConnectPacket, as 0-RTT early data if the server has been
+	// connected before. This saves a round trip on reconnects. Further packets
+	// are sent once the handshake has been completed. If the server rejects
+	// the early data, the connection fails and has to be established again.
+	EarlyData bool
+
+	// If set, the revocation status of the server certificate is checked
+	// during the TLS handshake.
+	Revocation *RevocationChecker
+
+	// The function that returns the proxy used for WebSocket connections. It
+	// defaults to http.ProxyFromEnvironment which honors the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables. A nil function disables
+	// the use of proxies.
+	WebSocketProxy func(*http.Request) (*url.URL, error)
+
+	// If set, WebSocket connections are closed if the server did not select
+	// the mqtt subprotocol during the handshake.
+	RequireSubprotocol bool
+
+	// If set, the function is used to establish the underlying network
+	// connections of all protocols except serial and quic e.g. to tunnel
+	// connections or bind to a specific interface. Connections to WebSocket
+	// proxies are established using the function as well.
+	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	netDialer       net.Dialer
 	webSocketDialer *websocket.Dialer
+	sessionCache    tls.ClientSessionCache
 }
 
 // NewDialer returns a new Dialer.
 func NewDialer() *Dialer {
 	return &Dialer{
-		DefaultTCPPort: "1883",
-		DefaultTLSPort: "8883",
-		DefaultWSPort:  "80",
-		DefaultWSSPort: "443",
+		DefaultTCPPort:  "1883",
+		DefaultTLSPort:  "8883",
+		DefaultWSPort:   "80",
+		DefaultWSSPort:  "443",
+		DefaultQUICPort: "14567",
+		DefaultBaudRate: 9600,
+		WebSocketProxy:  http.ProxyFromEnvironment,
 		webSocketDialer: &websocket.Dialer{
-			Proxy:        http.ProxyFromEnvironment,
 			Subprotocols: []string{"mqtt"},
 		},
+		sessionCache: tls.NewLRUClientSessionCache(0),
 	}
 }
 
@@ -48,8 +96,19 @@ func Dial(urlString string) (Conn, error) {
 	return sharedDialer.Dial(urlString)
 }
 
+// DialContext is a shorthand function.
+func DialContext(ctx context.Context, urlString string) (Conn, error) {
+	return sharedDialer.DialContext(ctx, urlString)
+}
+
 // Dial initiates a connection based in information extracted from an URL.
 func (d *Dialer) Dial(urlString string) (Conn, error) {
+	return d.DialContext(context.Background(), urlString)
+}
+
+// DialContext initiates a connection like Dial. The DNS lookup, the dial and
+// the TLS or WebSocket handshake are aborted if the context is canceled.
+func (d *Dialer) DialContext(ctx context.Context, urlString string) (Conn, error) {
 	urlParts, err := url.ParseRequestURI(urlString)
 	if err != nil {
 		return nil, err
@@ -67,9 +126,9 @@ func (d *Dialer) Dial(urlString string) (Conn, error) {
 			port = d.DefaultTCPPort
 		}
 
-		conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+		conn, err := d.dial(ctx, "tcp", net.JoinHostPort(host, port))
 		if err != nil {
-			return nil, err
+			return nil, wrapError(OpDial, err, ErrNetwork)
 		}
 
 		return NewNetConn(conn), nil
@@ -78,40 +137,154 @@ func (d *Dialer) Dial(urlString string) (Conn, error) {
 			port = d.DefaultTLSPort
 		}
 
-		conn, err := tls.Dial("tcp", net.JoinHostPort(host, port), d.TLSConfig)
+		conn, err := d.dial(ctx, "tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return nil, wrapError(OpDial, err, ErrNetwork)
+		}
+
+		tlsConn, err := d.handshake(ctx, conn, host)
 		if err != nil {
 			return nil, err
 		}
 
-		return NewNetConn(conn), nil
+		return NewNetConn(tlsConn), nil
 	case "ws":
 		if port == "" {
 			port = d.DefaultWSPort
 		}
 
-		wsURL := fmt.Sprintf("ws://%s:%s%s", host, port, urlParts.Path)
-
-		conn, _, err := d.webSocketDialer.Dial(wsURL, d.RequestHeader)
-		if err != nil {
-			return nil, err
-		}
+		wsURL := webSocketURL("ws", host, port, urlParts)
 
-		return NewWebSocketConn(conn), nil
+		return d.dialWebSocket(ctx, wsURL, nil)
 	case "wss":
 		if port == "" {
 			port = d.DefaultWSSPort
 		}
 
-		wsURL := fmt.Sprintf("wss://%s:%s%s", host, port, urlParts.Path)
+		wsURL := webSocketURL("wss", host, port, urlParts)
+
+		return d.dialWebSocket(ctx, wsURL, d.tlsConfig())
+	case "quic":
+		if port == "" {
+			port = d.DefaultQUICPort
+		}
 
-		d.webSocketDialer.TLSClientConfig = d.TLSConfig
-		conn, _, err := d.webSocketDialer.Dial(wsURL, d.RequestHeader)
+		return d.dialQUIC(ctx, host, port)
+	case "serial":
+		config, err := parseSerialConfig(urlParts.Query(), d.DefaultBaudRate)
 		if err != nil {
 			return nil, err
 		}
 
-		return NewWebSocketConn(conn), nil
+		return OpenSerialConn(urlParts.Path, config)
 	}
 
 	return nil, ErrUnsupportedProtocol
 }
+
+// dial will establish a network connection using the configured function
+func (d *Dialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.NetDial != nil {
+		return d.NetDial(ctx, network, addr)
+	}
+
+	return d.netDialer.DialContext(ctx, network, addr)
+}
+
+// dialWebSocket will establish a WebSocket connection and check the selected
+// subprotocol if required
+func (d *Dialer) dialWebSocket(ctx context.Context, wsURL string, tlsConfig *tls.Config) (Conn, error) {
+	// copy dialer to not share the configuration between dials
+	wsDialer := *d.webSocketDialer
+	wsDialer.TLSClientConfig = tlsConfig
+	wsDialer.Proxy = d.WebSocketProxy
+	wsDialer.NetDialContext = d.dial
+
+	conn, _, err := wsDialer.DialContext(ctx, wsURL, d.RequestHeader)
+	if err != nil {
+		return nil, wrapError(OpDial, err, ErrNetwork)
+	}
+
+	// check subprotocol
+	if d.RequireSubprotocol && conn.Subprotocol() != "mqtt" {
+		_ = conn.Close()
+		return nil, wrapError(OpDial, ErrMissingSubprotocol, ErrNetwork)
+	}
+
+	return NewWebSocketConnWithFraming(conn, d.WebSocketFraming), nil
+}
+
+// returns the WebSocket URL including the query that may carry credentials
+// e.g. of pre-signed URLs
+func webSocketURL(scheme, host, port string, urlParts *url.URL) string {
+	wsURL := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, port), urlParts.Path)
+	if urlParts.RawQuery != "" {
+		wsURL += "?" + urlParts.RawQuery
+	}
+
+	return wsURL
+}
+
+// handshake will perform the TLS handshake on the passed connection. The
+// connection is closed if the handshake fails.
+func (d *Dialer) handshake(ctx context.Context, conn net.Conn, host string) (*tls.Conn, error) {
+	// prepare config
+	config := d.tlsConfig()
+	if config == nil {
+		config = &tls.Config{}
+	}
+
+	// set server name if missing
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	// perform handshake
+	tlsConn := tls.Client(conn, config)
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, &Error{Op: OpDial, Kind: ErrTLSHandshake, Err: err}
+	}
+
+	return tlsConn, nil
+}
+
+// returns the TLS config with the session cache set if resumption is enabled
+// and the revocation check added if configured
+func (d *Dialer) tlsConfig() *tls.Config {
+	// check options
+	resume := d.ResumeTLS && (d.TLSConfig == nil || d.TLSConfig.ClientSessionCache == nil)
+	if !resume && d.Revocation == nil {
+		return d.TLSConfig
+	}
+
+	// clone config
+	config := &tls.Config{}
+	if d.TLSConfig != nil {
+		config = d.TLSConfig.Clone()
+	}
+
+	// set session cache
+	if resume {
+		config.ClientSessionCache = d.sessionCache
+	}
+
+	// chain revocation check
+	if d.Revocation != nil {
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if verify != nil {
+				err := verify(state)
+				if err != nil {
+					return err
+				}
+			}
+
+			return d.Revocation.VerifyConnection(state)
+		}
+	}
+
+	return config
+}
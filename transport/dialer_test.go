@@ -1,9 +1,20 @@
 package transport
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -111,6 +122,10 @@ func TestTLSDefaultPort(t *testing.T) {
 	abstractDefaultPortTest(t, "tls")
 }
 
+func TestMQTTSDefaultPort(t *testing.T) {
+	abstractDefaultPortTest(t, "mqtts")
+}
+
 func TestWSDefaultPort(t *testing.T) {
 	abstractDefaultPortTest(t, "ws")
 }
@@ -118,3 +133,145 @@ func TestWSDefaultPort(t *testing.T) {
 func TestWSSDefaultPort(t *testing.T) {
 	abstractDefaultPortTest(t, "wss")
 }
+
+func TestDialerResumeTLS(t *testing.T) {
+	// resumption requires an unexpired certificate
+	cert, _ := generateCertificate("server")
+
+	launcher := NewLauncher()
+	launcher.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	server, err := launcher.Launch("tls://localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := server.Accept()
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.NoError(t, conn.Send(packet.NewConnackPacket()))
+		}
+	}()
+
+	dialer := NewDialer()
+	dialer.TLSConfig = clientTLSConfig
+	dialer.ResumeTLS = true
+
+	var resumed []bool
+
+	for i := 0; i < 2; i++ {
+		conn, err := dialer.Dial(getURL(server, "tls"))
+		require.NoError(t, err)
+
+		pkt, err := conn.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, packet.CONNACK, pkt.Type())
+
+		tlsConn := conn.(*NetConn).UnderlyingConn().(*tls.Conn)
+		resumed = append(resumed, tlsConn.ConnectionState().DidResume)
+
+		assert.NoError(t, conn.Close())
+	}
+
+	assert.Equal(t, []bool{false, true}, resumed)
+	assert.Nil(t, clientTLSConfig.ClientSessionCache)
+
+	assert.NoError(t, server.Close())
+}
+
+func TestDialerDialContextHandshake(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	dialer := NewDialer()
+	dialer.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+	conn, err := dialer.DialContext(ctx, "tls://"+listener.Addr().String())
+	assert.Nil(t, conn)
+	assert.Error(t, err)
+
+	err = listener.Close()
+	assert.NoError(t, err)
+}
+
+func TestDialerNetDial(t *testing.T) {
+	server, err := testLauncher.Launch("tcp://localhost:0")
+	require.NoError(t, err)
+
+	var addrs []string
+
+	dialer := NewDialer()
+	dialer.NetDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		addrs = append(addrs, addr)
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+
+	conn, err := dialer.Dial("tcp://localhost:" + getPort(server))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost:" + getPort(server)}, addrs)
+
+	err = conn.Close()
+	assert.NoError(t, err)
+
+	err = server.Close()
+	assert.NoError(t, err)
+}
+
+func TestWebSocketURL(t *testing.T) {
+	urlParts, err := url.ParseRequestURI("wss://example.com/mqtt?X-Amz-Signature=abc&x=1")
+	require.NoError(t, err)
+	assert.Equal(t, "wss://example.com:443/mqtt?X-Amz-Signature=abc&x=1", webSocketURL("wss", "example.com", "443", urlParts))
+
+	urlParts, err = url.ParseRequestURI("ws://[::1]")
+	require.NoError(t, err)
+	assert.Equal(t, "ws://[::1]:80", webSocketURL("ws", "::1", "80", urlParts))
+}
+
+func TestDialerWebSocketHandshake(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+
+	upgrader := &websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	dialer := NewDialer()
+	dialer.RequestHeader = http.Header{"Authorization": []string{"Bearer token"}}
+
+	conn, err := dialer.Dial("ws" + strings.TrimPrefix(server.URL, "http") + "/mqtt?token=foo")
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	req := <-requests
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+	assert.Equal(t, "mqtt", req.Header.Get("Sec-WebSocket-Protocol"))
+	assert.Equal(t, "/mqtt", req.URL.Path)
+	assert.Equal(t, "foo", req.URL.Query().Get("token"))
+
+	dialer.RequireSubprotocol = true
+
+	conn, err = dialer.Dial("ws" + strings.TrimPrefix(server.URL, "http") + "/mqtt")
+	assert.Nil(t, conn)
+	assert.True(t, errors.Is(err, ErrMissingSubprotocol))
+	<-requests
+}
@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+// ErrConnectionRefused is the kind of an Error that is returned if the remote
+// host actively refused the connection.
+var ErrConnectionRefused = errors.New("connection refused")
+
+// ErrConnectionReset is the kind of an Error that is returned if the
+// connection has been reset or aborted by the remote host or the stream ended
+// in the middle of a packet.
+var ErrConnectionReset = errors.New("connection reset")
+
+// ErrClosed is the kind of an Error that is returned if a connection or server
+// is used after it has been closed.
+var ErrClosed = errors.New("use of closed connection")
+
+// ErrTimeout is the kind of an Error that is returned if a deadline has been
+// exceeded while reading from or writing to the connection.
+var ErrTimeout = errors.New("timeout")
+
+// ErrWriteTimeout is the kind of an Error that is returned if a packet could
+// not be written before the write timeout of the connection elapsed. Errors of
+// this kind also match ErrTimeout.
+var ErrWriteTimeout = errors.New("write timeout")
+
+// ErrTLSHandshake is the kind of an Error that is returned if the TLS
+// handshake with the remote host failed.
+var ErrTLSHandshake = errors.New("tls handshake failed")
+
+// ErrWebSocketClose is the kind of an Error that is returned if the remote
+// host closed the WebSocket connection with a close code that does not
+// indicate a regular closure. The close code can be retrieved by using
+// errors.As with a *websocket.CloseError.
+var ErrWebSocketClose = errors.New("websocket closed")
+
+// ErrEncode is the kind of an Error that is returned if a packet could not be
+// encoded.
+var ErrEncode = errors.New("encode error")
+
+// ErrDecode is the kind of an Error that is returned if a received packet
+// could not be decoded.
+var ErrDecode = errors.New("decode error")
+
+// ErrNetwork is the kind of an Error that is returned for all other failures
+// of the underlying connection.
+var ErrNetwork = errors.New("network error")
+
+// The operations reported in an Error.
+const (
+	OpDial    = "dial"
+	OpLaunch  = "launch"
+	OpAccept  = "accept"
+	OpSend    = "send"
+	OpReceive = "receive"
+	OpClose   = "close"
+)
+
+// An Error is returned by connections, dialers and servers to annotate a
+// failure with the operation during which it occurred and a sentinel that
+// classifies the failure. The kind can be tested using errors.Is and the
+// underlying error can be accessed using errors.As or errors.Unwrap.
+//
+// Note: A regular close of the connection by the remote host is still
+// reported as an unwrapped io.EOF.
+type Error struct {
+	// The operation that failed.
+	Op string
+
+	// The sentinel that classifies the failure.
+	Kind error
+
+	// The underlying error.
+	Err error
+}
+
+// Error returns a string representation of the error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Op, e.Kind.Error(), e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is returns whether the target is the kind of the error.
+func (e *Error) Is(target error) bool {
+	return e.Kind == target || (e.Kind == ErrWriteTimeout && target == ErrTimeout)
+}
+
+// wrapError will wrap the passed error in an Error using the classified kind
+// or the fallback if the error could not be classified. Clean closes and
+// already wrapped errors are returned as is.
+func wrapError(op string, err error, fallback error) error {
+	// check for nil and clean closes
+	if err == nil || err == io.EOF {
+		return err
+	}
+
+	// check for already wrapped errors
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+
+	// classify error
+	kind := classifyError(err, fallback)
+
+	// report timeouts while sending as write timeouts
+	if op == OpSend && kind == ErrTimeout {
+		kind = ErrWriteTimeout
+	}
+
+	return &Error{
+		Op:   op,
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// classifyError will return the kind of the error or the fallback.
+func classifyError(err error, fallback error) error {
+	// check for well known errors
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrConnectionReset
+	case errors.Is(err, net.ErrClosed), errors.Is(err, ErrAcceptAfterClose):
+		return ErrClosed
+	}
+
+	// check for tls errors
+	if isTLSError(err) {
+		return ErrTLSHandshake
+	}
+
+	// check for network errors
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrTimeout
+		}
+
+		return ErrNetwork
+	}
+
+	return fallback
+}
+
+// isTLSError returns whether the error has been caused during a TLS handshake.
+func isTLSError(err error) bool {
+	var recordHeaderErr tls.RecordHeaderError
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+
+	return errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &verificationErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &certificateInvalidErr)
+}
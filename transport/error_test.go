@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorClassification(t *testing.T) {
+	table := []struct {
+		err  error
+		kind error
+	}{
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, ErrConnectionRefused},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, ErrConnectionReset},
+		{&net.OpError{Op: "write", Err: syscall.EPIPE}, ErrConnectionReset},
+		{io.ErrUnexpectedEOF, ErrConnectionReset},
+		{&net.OpError{Op: "read", Err: net.ErrClosed}, ErrClosed},
+		{ErrAcceptAfterClose, ErrClosed},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, ErrTimeout},
+		{tls.RecordHeaderError{Msg: "bad"}, ErrTLSHandshake},
+		{&net.OpError{Op: "read", Err: errors.New("foo")}, ErrNetwork},
+		{errors.New("foo"), ErrDecode},
+	}
+
+	for _, item := range table {
+		err := wrapError(OpReceive, item.err, ErrDecode)
+		assert.True(t, errors.Is(err, item.kind), item.err.Error())
+		assert.True(t, errors.Is(err, item.err), item.err.Error())
+
+		var transportErr *Error
+		assert.True(t, errors.As(err, &transportErr))
+		assert.Equal(t, OpReceive, transportErr.Op)
+	}
+}
+
+func TestErrorWriteTimeout(t *testing.T) {
+	err := wrapError(OpSend, &net.OpError{Op: "write", Err: timeoutError{}}, ErrNetwork)
+	assert.True(t, errors.Is(err, ErrWriteTimeout))
+	assert.True(t, errors.Is(err, ErrTimeout))
+
+	err = wrapError(OpReceive, &net.OpError{Op: "read", Err: timeoutError{}}, ErrNetwork)
+	assert.False(t, errors.Is(err, ErrWriteTimeout))
+	assert.True(t, errors.Is(err, ErrTimeout))
+}
+
+func TestErrorPassThrough(t *testing.T) {
+	assert.Nil(t, wrapError(OpReceive, nil, ErrDecode))
+	assert.Equal(t, io.EOF, wrapError(OpReceive, io.EOF, ErrDecode))
+
+	err := &Error{Op: OpSend, Kind: ErrEncode, Err: errors.New("foo")}
+	assert.Equal(t, err, wrapError(OpReceive, err, ErrDecode))
+	assert.Equal(t, "send: encode error: foo", err.Error())
+}
+
+func TestDialerConnectionRefused(t *testing.T) {
+	server, err := testLauncher.Launch("tcp://localhost:0")
+	require.NoError(t, err)
+
+	url := getURL(server, "tcp")
+
+	err = server.Close()
+	assert.NoError(t, err)
+
+	conn, err := testDialer.Dial(url)
+	assert.Nil(t, conn)
+	assert.True(t, errors.Is(err, ErrConnectionRefused))
+}
+
+func TestDialerTLSHandshakeError(t *testing.T) {
+	server, err := testLauncher.Launch("tls://localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := server.Accept()
+		if err == nil {
+			conn.Receive()
+		}
+	}()
+
+	conn, err := NewDialer().Dial(getURL(server, "tls"))
+	assert.Nil(t, conn)
+	assert.True(t, errors.Is(err, ErrTLSHandshake))
+
+	err = server.Close()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// the variable that holds the pid of the process that handed off listeners
+const handoffPID = "GOMQTT_HANDOFF_PID"
+
+// Handoff starts the command as the successor of the current process and
+// passes the listeners as inherited file descriptors using the systemd socket
+// activation protocol. The successor obtains the listeners using Activated
+// and accepts connections on the same sockets without refusing clients in
+// between. Afterwards, the current process should stop accepting and close
+// its connections gracefully e.g. using Engine.Drain of the broker.
+//
+// The listeners must provide their file descriptors like net.TCPListener and
+// net.UnixListener do. TLS listeners must be handed off unwrapped. The names
+// are passed as LISTEN_FDNAMES if provided. The environment of the command
+// defaults to the environment of the current process.
+func Handoff(cmd *exec.Cmd, listeners []net.Listener, names []string) error {
+	// check names
+	if names != nil && len(names) != len(listeners) {
+		return fmt.Errorf("expected %d names, got %d", len(listeners), len(names))
+	}
+
+	// get files
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		for _, file := range files {
+			_ = file.Close()
+		}
+	}()
+	for _, listener := range listeners {
+		// check listener
+		filer, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s does not provide a file descriptor", listener.Addr())
+		}
+
+		// get file (the descriptor is duplicated)
+		file, err := filer.File()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, file)
+	}
+
+	// prepare environment
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(filterEnv(env, "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", handoffPID),
+		handoffPID+"="+strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+	)
+	if names != nil {
+		env = append(env, "LISTEN_FDNAMES="+strings.Join(names, ":"))
+	}
+
+	// prepare command, the extra files start at descriptor 3
+	cmd.Env = env
+	cmd.ExtraFiles = files
+
+	return cmd.Start()
+}
+
+// filterEnv returns the environment without the specified variables
+func filterEnv(env []string, keys ...string) []string {
+	list := make([]string, 0, len(env))
+	for _, kv := range env {
+		// check keys
+		keep := true
+		for _, key := range keys {
+			if strings.HasPrefix(kv, key+"=") {
+				keep = false
+				break
+			}
+		}
+
+		if keep {
+			list = append(list, kv)
+		}
+	}
+
+	return list
+}
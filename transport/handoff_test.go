@@ -0,0 +1,90 @@
+package transport
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandoff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("handoff is not supported on windows")
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestHandoffHelper")
+	cmd.Env = append(os.Environ(), "GOMQTT_TEST_HANDOFF=1")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = Handoff(cmd, []net.Listener{listener}, []string{"mqtt"})
+	assert.NoError(t, err)
+
+	// stop accepting in this process
+	assert.NoError(t, listener.Close())
+
+	conn, err := Dial("tcp://" + listener.Addr().String())
+	assert.NoError(t, err)
+
+	err = conn.Send(packet.NewConnectPacket())
+	assert.NoError(t, err)
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.CONNACK, pkt.Type())
+
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, cmd.Wait())
+}
+
+func TestHandoffHelper(t *testing.T) {
+	if os.Getenv("GOMQTT_TEST_HANDOFF") == "" {
+		return
+	}
+
+	listeners, names, err := Activated()
+	assert.NoError(t, err)
+	assert.Len(t, listeners, 1)
+	assert.Equal(t, []string{"mqtt"}, names)
+	assert.Empty(t, os.Getenv(handoffPID))
+
+	server := NewNetServerWithListener(listeners[0])
+
+	conn, err := server.Accept()
+	assert.NoError(t, err)
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.CONNECT, pkt.Type())
+
+	err = conn.Send(packet.NewConnackPacket())
+	assert.NoError(t, err)
+
+	_, err = conn.Receive()
+	assert.Error(t, err)
+
+	assert.NoError(t, server.Close())
+}
+
+func TestHandoffInvalid(t *testing.T) {
+	err := Handoff(exec.Command("true"), []net.Listener{nil}, []string{})
+	assert.Error(t, err)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	err = Handoff(exec.Command("true"), []net.Listener{&wrappedListener{listener}}, nil)
+	assert.Error(t, err)
+}
+
+type wrappedListener struct {
+	net.Listener
+}
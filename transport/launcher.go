@@ -2,12 +2,18 @@ package transport
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"net/url"
 )
 
 // The Launcher helps with launching a server and accepting connections.
 type Launcher struct {
 	TLSConfig *tls.Config
+
+	// The callback that is called during the TLS handshake with the verified
+	// client certificate or nil if the client did not present a certificate.
+	// Returning an error aborts the handshake before any packet is read.
+	VerifyClient func(cert *x509.Certificate) error
 }
 
 // NewLauncher returns a new Launcher.
@@ -26,7 +32,13 @@ func Launch(urlString string) (Server, error) {
 	return sharedLauncher.Launch(urlString)
 }
 
-// Launch will launch a server based on information extracted from an URL.
+// Launch will launch a server based on information extracted from an URL. The
+// client certificate policy of TLS servers can be set per listener using the
+// "clientauth" query parameter with the values "require" (a verified
+// certificate is required), "optional" (a certificate is verified if given) or
+// "none" (e.g. tls://0.0.0.0:8883?clientauth=require). QUIC servers that
+// accept 0-RTT early data are launched using the "quic" scheme if the package
+// has been built with the quic build tag.
 func (l *Launcher) Launch(urlString string) (Server, error) {
 	urlParts, err := url.ParseRequestURI(urlString)
 	if err != nil {
@@ -37,12 +49,86 @@ func (l *Launcher) Launch(urlString string) (Server, error) {
 	case "tcp", "mqtt":
 		return NewNetServer(urlParts.Host)
 	case "tls", "mqtts":
-		return NewSecureNetServer(urlParts.Host, l.TLSConfig)
+		config, err := l.tlsConfig(urlParts.Query())
+		if err != nil {
+			return nil, err
+		}
+
+		return NewSecureNetServer(urlParts.Host, config)
 	case "ws":
 		return NewWebSocketServer(urlParts.Host)
 	case "wss":
-		return NewSecureWebSocketServer(urlParts.Host, l.TLSConfig)
+		config, err := l.tlsConfig(urlParts.Query())
+		if err != nil {
+			return nil, err
+		}
+
+		return NewSecureWebSocketServer(urlParts.Host, config)
+	case "quic":
+		config, err := l.tlsConfig(urlParts.Query())
+		if err != nil {
+			return nil, err
+		}
+
+		return launchQUIC(urlParts.Host, config)
 	}
 
 	return nil, ErrUnsupportedProtocol
 }
+
+// returns the TLS config with the client auth policy and verification applied
+func (l *Launcher) tlsConfig(query url.Values) (*tls.Config, error) {
+	// get config
+	config := l.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+
+	// get policy
+	policy := query.Get("clientauth")
+
+	// return config if unchanged
+	if policy == "" && l.VerifyClient == nil {
+		return l.TLSConfig, nil
+	}
+
+	// copy config
+	config = config.Clone()
+
+	// set policy
+	switch policy {
+	case "":
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "none":
+		config.ClientAuth = tls.NoClientCert
+	default:
+		return nil, ErrUnsupportedClientAuth
+	}
+
+	// set verification
+	if l.VerifyClient != nil {
+		verifyConnection := config.VerifyConnection
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			// run existing verification
+			if verifyConnection != nil {
+				err := verifyConnection(state)
+				if err != nil {
+					return err
+				}
+			}
+
+			// get certificate
+			var cert *x509.Certificate
+			if len(state.PeerCertificates) > 0 {
+				cert = state.PeerCertificates[0]
+			}
+
+			return l.VerifyClient(cert)
+		}
+	}
+
+	return config, nil
+}
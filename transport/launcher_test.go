@@ -1,8 +1,13 @@
 package transport
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"testing"
 
+	"github.com/256dpi/gomqtt/packet"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,3 +31,72 @@ func TestLauncherUnsupportedProtocol(t *testing.T) {
 	assert.Nil(t, conn)
 	assert.Equal(t, ErrUnsupportedProtocol, err)
 }
+
+func TestLauncherUnsupportedClientAuth(t *testing.T) {
+	server, err := Launch("tls://localhost:0?clientauth=foo")
+	assert.Nil(t, server)
+	assert.Equal(t, ErrUnsupportedClientAuth, err)
+}
+
+func TestLauncherClientAuth(t *testing.T) {
+	serverCert, _ := generateCertificate("server")
+	allowedCert, allowed := generateCertificate("allowed")
+	blockedCert, blocked := generateCertificate("blocked")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(allowed)
+	pool.AddCert(blocked)
+
+	launcher := NewLauncher()
+	launcher.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+	}
+	launcher.VerifyClient = func(cert *x509.Certificate) error {
+		if cert == nil || cert.Subject.CommonName != "allowed" {
+			return errors.New("denied")
+		}
+
+		return nil
+	}
+
+	server, err := launcher.Launch("tls://localhost:0?clientauth=optional")
+	require.NoError(t, err)
+
+	attempt := func(certs ...tls.Certificate) error {
+		result := make(chan error, 1)
+
+		go func() {
+			conn, err := server.Accept()
+			if !assert.NoError(t, err) {
+				result <- err
+				return
+			}
+
+			_, err = conn.Receive()
+			result <- err
+
+			conn.Close()
+		}()
+
+		dialer := NewDialer()
+		dialer.TLSConfig = &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}
+
+		conn, err := dialer.Dial(getURL(server, "tls"))
+		if err == nil {
+			_ = conn.Send(packet.NewConnectPacket())
+			defer conn.Close()
+		}
+
+		return <-result
+	}
+
+	assert.Error(t, attempt())
+	assert.Error(t, attempt(blockedCert))
+	assert.NoError(t, attempt(allowedCert))
+
+	assert.NoError(t, server.Close())
+}
@@ -20,6 +20,14 @@ func TestNetConnEncodeError(t *testing.T) {
 	abstractConnEncodeErrorTest(t, "tcp")
 }
 
+func TestNetConnSendStream(t *testing.T) {
+	abstractConnSendStreamTest(t, "tcp")
+}
+
+func TestNetConnReceiveStream(t *testing.T) {
+	abstractConnReceiveStreamTest(t, "tcp")
+}
+
 func TestNetConnDecodeError(t *testing.T) {
 	abstractConnDecodeErrorTest(t, "tcp")
 }
@@ -40,10 +48,26 @@ func TestNetConnReadLimit(t *testing.T) {
 	abstractConnReadLimitTest(t, "tcp")
 }
 
+func TestNetConnReadLimit5(t *testing.T) {
+	abstractConnReadLimit5Test(t, "tcp")
+}
+
 func TestNetConnReadTimeout(t *testing.T) {
 	abstractConnReadTimeoutTest(t, "tcp")
 }
 
+func TestNetConnIdleTimeout(t *testing.T) {
+	abstractConnIdleTimeoutTest(t, "tcp")
+}
+
+func TestNetConnIdleCallback(t *testing.T) {
+	abstractConnIdleCallbackTest(t, "tcp")
+}
+
+func TestNetConnWriteTimeout(t *testing.T) {
+	abstractConnWriteTimeoutTest(t, "tcp")
+}
+
 func TestNetConnCloseAfterClose(t *testing.T) {
 	abstractConnCloseAfterCloseTest(t, "tcp")
 }
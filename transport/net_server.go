@@ -14,7 +14,7 @@ type NetServer struct {
 func NewNetServer(address string) (*NetServer, error) {
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(OpLaunch, err, ErrNetwork)
 	}
 
 	return &NetServer{
@@ -26,7 +26,7 @@ func NewNetServer(address string) (*NetServer, error) {
 func NewSecureNetServer(address string, config *tls.Config) (*NetServer, error) {
 	listener, err := tls.Listen("tcp", address, config)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(OpLaunch, err, ErrNetwork)
 	}
 
 	return &NetServer{
@@ -34,12 +34,21 @@ func NewSecureNetServer(address string, config *tls.Config) (*NetServer, error)
 	}, nil
 }
 
+// NewNetServerWithListener creates a new server that accepts connections from
+// the provided listener e.g. one returned by Activated. The listener may be
+// wrapped using tls.NewListener to accept secure connections.
+func NewNetServerWithListener(listener net.Listener) *NetServer {
+	return &NetServer{
+		listener: listener,
+	}
+}
+
 // Accept will return the next available connection or block until a
 // connection becomes available, otherwise returns an Error.
 func (s *NetServer) Accept() (Conn, error) {
 	conn, err := s.listener.Accept()
 	if err != nil {
-		return nil, err
+		return nil, wrapError(OpAccept, err, ErrNetwork)
 	}
 
 	return NewNetConn(conn), nil
@@ -50,7 +59,7 @@ func (s *NetServer) Accept() (Conn, error) {
 func (s *NetServer) Close() error {
 	err := s.listener.Close()
 	if err != nil {
-		return err
+		return wrapError(OpClose, err, ErrNetwork)
 	}
 
 	return nil
@@ -0,0 +1,287 @@
+//go:build quic
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/256dpi/gomqtt/routines"
+	"github.com/quic-go/quic-go"
+	"gopkg.in/tomb.v2"
+)
+
+// the ALPN protocol negotiated for QUIC connections
+const quicProtocol = "mqtt"
+
+// the maximum time a closing QUIC connection waits for the peer to finish its
+// stream before the connection is closed
+const quicCloseTimeout = time.Second
+
+// the maximum time an accepted QUIC connection may take to open its stream
+// and complete the handshake
+const quicAcceptTimeout = 60 * time.Second
+
+// The QUICServer accepts QUIC based connections. Every connection carries the
+// packets on a single bidirectional stream that is opened by the client.
+type QUICServer struct {
+	listener *quic.EarlyListener
+	incoming chan Conn
+
+	tomb tomb.Tomb
+}
+
+// NewQUICServer creates a new QUIC server that listens on the provided
+// address. The QUIC config may be nil or enable 0-RTT using Allow0RTT.
+// Connections are returned once the handshake has been completed, which
+// prevents replayed early data from being processed.
+func NewQUICServer(address string, tlsConfig *tls.Config, config *quic.Config) (*QUICServer, error) {
+	// set protocol if missing
+	if tlsConfig != nil && len(tlsConfig.NextProtos) == 0 {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{quicProtocol}
+	}
+
+	listener, err := quic.ListenAddrEarly(address, tlsConfig, config)
+	if err != nil {
+		return nil, wrapError(OpLaunch, err, ErrNetwork)
+	}
+
+	s := &QUICServer{
+		listener: listener,
+		incoming: make(chan Conn),
+	}
+
+	s.tomb.Go(routines.Wrap("transport.quic", s.acceptor))
+
+	return s, nil
+}
+
+func (s *QUICServer) acceptor() error {
+	for {
+		// accept connection
+		conn, err := s.listener.Accept(s.tomb.Context(nil))
+		if err != nil {
+			return err
+		}
+
+		// await stream in the background
+		s.tomb.Go(routines.Wrap("transport.quic.accept", func() error {
+			s.accept(conn)
+			return nil
+		}))
+	}
+}
+
+func (s *QUICServer) accept(conn *quic.Conn) {
+	ctx, cancel := context.WithTimeout(s.tomb.Context(nil), quicAcceptTimeout)
+	defer cancel()
+
+	// accept stream
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		return
+	}
+
+	// await handshake
+	select {
+	case <-conn.HandshakeComplete():
+	case <-ctx.Done():
+		_ = conn.CloseWithError(0, "")
+		return
+	}
+
+	// check connection
+	if conn.Context().Err() != nil {
+		return
+	}
+
+	// create connection
+	quicConn := Wrap(newQUICStream(conn, stream, false))
+
+	select {
+	case s.incoming <- quicConn:
+	case <-s.tomb.Dying():
+		quicConn.Close()
+	}
+}
+
+// Accept will return the next available connection or block until a
+// connection becomes available, otherwise returns an Error.
+func (s *QUICServer) Accept() (Conn, error) {
+	select {
+	case <-s.tomb.Dying():
+		if s.tomb.Err() == errManualClose {
+			// server has been closed manually
+			return nil, wrapError(OpAccept, ErrAcceptAfterClose, ErrClosed)
+		}
+
+		// return the previously caught error
+		return nil, wrapError(OpAccept, s.tomb.Err(), ErrNetwork)
+	case conn := <-s.incoming:
+		return conn, nil
+	}
+}
+
+// Close will close the underlying listener and cleanup resources. It will
+// return an Error if the underlying listener didn't close cleanly.
+func (s *QUICServer) Close() error {
+	s.tomb.Kill(errManualClose)
+
+	err := s.listener.Close()
+	s.tomb.Wait()
+
+	if err != nil {
+		return wrapError(OpClose, err, ErrNetwork)
+	}
+
+	return nil
+}
+
+// Addr returns the server's network address.
+func (s *QUICServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// dialQUIC will establish a QUIC connection and open the stream
+func (d *Dialer) dialQUIC(ctx context.Context, host, port string) (Conn, error) {
+	// prepare config
+	config := &tls.Config{}
+	if tlsConfig := d.tlsConfig(); tlsConfig != nil {
+		config = tlsConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{quicProtocol}
+	}
+	if d.EarlyData && config.ClientSessionCache == nil {
+		config.ClientSessionCache = d.sessionCache
+	}
+
+	// dial connection, early connections are returned before the handshake
+	// has been completed
+	var conn *quic.Conn
+	var err error
+	if d.EarlyData {
+		conn, err = quic.DialAddrEarly(ctx, net.JoinHostPort(host, port), config, nil)
+	} else {
+		conn, err = quic.DialAddr(ctx, net.JoinHostPort(host, port), config, nil)
+	}
+	if err != nil {
+		return nil, wrapError(OpDial, err, ErrNetwork)
+	}
+
+	// open stream
+	stream, err := conn.OpenStream()
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		return nil, wrapError(OpDial, err, ErrNetwork)
+	}
+
+	return Wrap(newQUICStream(conn, stream, d.EarlyData)), nil
+}
+
+// launchQUIC will launch a QUIC server that accepts early data
+func launchQUIC(address string, config *tls.Config) (Server, error) {
+	server, err := NewQUICServer(address, config, &quic.Config{Allow0RTT: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// a quicStream carries packets over a QUIC stream and closes the connection
+// with the stream
+type quicStream struct {
+	*quic.Stream
+
+	conn  *quic.Conn
+	early bool
+	wrote bool
+
+	finished chan struct{}
+	finish   sync.Once
+
+	closeErr error
+	close    sync.Once
+}
+
+func newQUICStream(conn *quic.Conn, stream *quic.Stream, early bool) *quicStream {
+	return &quicStream{
+		Stream:   stream,
+		conn:     conn,
+		early:    early,
+		finished: make(chan struct{}),
+	}
+}
+
+func (s *quicStream) Read(p []byte) (int, error) {
+	// read stream
+	n, err := s.Stream.Read(p)
+	if err != nil {
+		s.finish.Do(func() {
+			close(s.finished)
+		})
+	}
+
+	// treat a regular close of the connection by the peer like the end of
+	// the stream
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.Remote && appErr.ErrorCode == 0 {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (s *quicStream) Write(p []byte) (int, error) {
+	// only the first write, usually the connect packet, may be sent as early
+	// data, further writes wait for the handshake to be completed
+	if s.early && s.wrote {
+		select {
+		case <-s.conn.HandshakeComplete():
+		case <-s.conn.Context().Done():
+			return 0, context.Cause(s.conn.Context())
+		}
+	}
+
+	// write stream
+	s.wrote = true
+	return s.Stream.Write(p)
+}
+
+func (s *quicStream) Close() error {
+	s.close.Do(func() {
+		// close stream
+		s.closeErr = s.Stream.Close()
+
+		// give the peer a chance to receive the remaining data
+		select {
+		case <-s.finished:
+		case <-s.conn.Context().Done():
+		case <-time.After(quicCloseTimeout):
+		}
+
+		// close connection
+		_ = s.conn.CloseWithError(0, "")
+	})
+
+	return s.closeErr
+}
+
+func (s *quicStream) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *quicStream) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
@@ -0,0 +1,16 @@
+//go:build !quic
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+)
+
+func (d *Dialer) dialQUIC(ctx context.Context, host, port string) (Conn, error) {
+	return nil, ErrUnsupportedQUIC
+}
+
+func launchQUIC(address string, config *tls.Config) (Server, error) {
+	return nil, ErrUnsupportedQUIC
+}
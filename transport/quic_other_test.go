@@ -0,0 +1,17 @@
+//go:build !quic
+
+package transport
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQUICUnsupported(t *testing.T) {
+	_, err := Dial("quic://localhost")
+	assert.Equal(t, ErrUnsupportedQUIC, err)
+
+	_, err = Launch("quic://localhost:0")
+	assert.Equal(t, ErrUnsupportedQUIC, err)
+}
@@ -0,0 +1,115 @@
+//go:build quic
+
+package transport
+
+import (
+	"crypto/tls"
+	"io"
+	"testing"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQUICConnection(t *testing.T) {
+	server, err := testLauncher.Launch("quic://localhost:0")
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		conn, err := server.Accept()
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		pkt, err := conn.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, packet.CONNECT, pkt.Type())
+
+		assert.NoError(t, conn.Send(packet.NewConnackPacket()))
+
+		pkt, err = conn.Receive()
+		assert.Nil(t, pkt)
+		assert.Equal(t, io.EOF, err)
+		assert.Equal(t, RemoteClose, conn.CloseReason())
+	}()
+
+	conn, err := testDialer.Dial(getURL(server, "quic"))
+	require.NoError(t, err)
+	assert.Equal(t, server.Addr().String(), conn.RemoteAddr().String())
+
+	assert.NoError(t, conn.Send(packet.NewConnectPacket()))
+
+	pkt, err := conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, packet.CONNACK, pkt.Type())
+
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, LocalClose, conn.CloseReason())
+
+	safeReceive(done)
+
+	assert.NoError(t, server.Close())
+
+	_, err = server.Accept()
+	assert.ErrorIs(t, err, ErrAcceptAfterClose)
+}
+
+func TestQUICEarlyData(t *testing.T) {
+	// resumption requires an unexpired certificate
+	cert, _ := generateCertificate("localhost")
+
+	launcher := NewLauncher()
+	launcher.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	server, err := launcher.Launch("quic://localhost:0")
+	require.NoError(t, err)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			conn, err := server.Accept()
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			_, err = conn.Receive()
+			assert.NoError(t, err)
+
+			assert.NoError(t, conn.Send(packet.NewConnackPacket()))
+
+			_, err = conn.Receive()
+			assert.Equal(t, io.EOF, err)
+		}
+	}()
+
+	dialer := NewDialer()
+	dialer.TLSConfig = clientTLSConfig
+	dialer.EarlyData = true
+
+	var early []bool
+
+	for i := 0; i < 2; i++ {
+		conn, err := dialer.Dial(getURL(server, "quic"))
+		require.NoError(t, err)
+
+		assert.NoError(t, conn.Send(packet.NewConnectPacket()))
+
+		pkt, err := conn.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, packet.CONNACK, pkt.Type())
+
+		stream := conn.(*StreamConn).UnderlyingStream().(*quicStream)
+		early = append(early, stream.conn.ConnectionState().Used0RTT)
+
+		assert.NoError(t, conn.Close())
+	}
+
+	assert.Equal(t, []bool{false, true}, early)
+	assert.Nil(t, clientTLSConfig.ClientSessionCache)
+
+	assert.NoError(t, server.Close())
+}
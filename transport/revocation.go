@@ -0,0 +1,423 @@
+package transport
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	// register hash functions used by OCSP
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// ErrCertificateRevoked is returned by RevocationChecker if the certificate of
+// the remote host has been revoked.
+var ErrCertificateRevoked = errors.New("certificate revoked")
+
+// ErrRevocationUnknown is returned by RevocationChecker if the revocation
+// status of the certificate could not be determined and soft failing is
+// disabled.
+var ErrRevocationUnknown = errors.New("revocation status unknown")
+
+// A RevocationChecker verifies that the certificate of the remote host has not
+// been revoked. A stapled OCSP response is verified first. If the remote host
+// did not staple a response, the CRLs listed in the certificate are fetched
+// and cached until their next update.
+//
+// The checker can be assigned to the Revocation field of a Dialer or its
+// VerifyConnection method can be used directly in a tls.Config.
+type RevocationChecker struct {
+	// The HTTP client used to fetch CRLs. Defaults to a client with a ten
+	// second timeout.
+	Client *http.Client
+
+	// The duration for which CRLs without a next update are cached. Defaults
+	// to one hour.
+	CacheDuration time.Duration
+
+	// If enabled, certificates are accepted if no revocation information is
+	// available or the CRLs cannot be fetched.
+	SoftFail bool
+
+	cache   map[string]*cachedCRL
+	fetches map[string]*crlFetch
+	mutex   sync.Mutex
+}
+
+type cachedCRL struct {
+	list    *x509.RevocationList
+	expires time.Time
+}
+
+type crlFetch struct {
+	done chan struct{}
+	list *x509.RevocationList
+	err  error
+}
+
+// NewRevocationChecker returns a new RevocationChecker.
+func NewRevocationChecker() *RevocationChecker {
+	return &RevocationChecker{
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		CacheDuration: time.Hour,
+		cache:         make(map[string]*cachedCRL),
+		fetches:       make(map[string]*crlFetch),
+	}
+}
+
+// VerifyConnection checks the revocation status of the verified leaf
+// certificate. It can be assigned to the VerifyConnection field of a
+// tls.Config.
+func (c *RevocationChecker) VerifyConnection(state tls.ConnectionState) error {
+	// get leaf and issuer
+	leaf, issuer := revocationPair(state)
+	if leaf == nil || issuer == nil {
+		return c.fail(fmt.Errorf("%w: missing issuer certificate", ErrRevocationUnknown))
+	}
+
+	// check stapled response
+	if len(state.OCSPResponse) > 0 {
+		revoked, err := verifyOCSP(state.OCSPResponse, leaf, issuer, time.Now())
+		if err != nil {
+			return c.fail(err)
+		} else if revoked {
+			return ErrCertificateRevoked
+		}
+
+		return nil
+	}
+
+	// check distribution points
+	if len(leaf.CRLDistributionPoints) == 0 {
+		return c.fail(fmt.Errorf("%w: no ocsp response or crl available", ErrRevocationUnknown))
+	}
+
+	// check crls
+	for _, url := range leaf.CRLDistributionPoints {
+		list, err := c.fetchCRL(url, issuer)
+		if err != nil {
+			return c.fail(err)
+		}
+
+		for _, entry := range list.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				return ErrCertificateRevoked
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *RevocationChecker) fail(err error) error {
+	if c.SoftFail {
+		return nil
+	}
+
+	return err
+}
+
+func (c *RevocationChecker) fetchCRL(url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	c.mutex.Lock()
+
+	// check cache
+	if cached, ok := c.cache[url]; ok && time.Now().Before(cached.expires) {
+		c.mutex.Unlock()
+		return cached.list, nil
+	}
+
+	// join running fetch
+	if fetch, ok := c.fetches[url]; ok {
+		c.mutex.Unlock()
+		<-fetch.done
+		return fetch.list, fetch.err
+	}
+
+	// start fetch
+	fetch := &crlFetch{done: make(chan struct{})}
+	if c.fetches == nil {
+		c.fetches = make(map[string]*crlFetch)
+	}
+	c.fetches[url] = fetch
+
+	c.mutex.Unlock()
+
+	// load list without holding the mutex
+	fetch.list, fetch.err = c.loadCRL(url, issuer)
+
+	c.mutex.Lock()
+
+	// cache list
+	delete(c.fetches, url)
+	if fetch.err == nil {
+		// get expiry
+		expires := fetch.list.NextUpdate
+		if expires.IsZero() {
+			expires = time.Now().Add(c.CacheDuration)
+		}
+
+		if c.cache == nil {
+			c.cache = make(map[string]*cachedCRL)
+		}
+		c.cache[url] = &cachedCRL{
+			list:    fetch.list,
+			expires: expires,
+		}
+	}
+
+	c.mutex.Unlock()
+
+	// release waiting callers
+	close(fetch.done)
+
+	return fetch.list, fetch.err
+}
+
+func (c *RevocationChecker) loadCRL(url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	// fetch list
+	res, err := c.Client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+	}
+	defer res.Body.Close()
+
+	// check status
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: crl fetch failed with status %d", ErrRevocationUnknown, res.StatusCode)
+	}
+
+	// read body
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+	}
+
+	// parse list
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+	}
+
+	// verify signature
+	err = list.CheckSignatureFrom(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+	}
+
+	return list, nil
+}
+
+// returns the leaf and issuer certificate of the connection
+func revocationPair(state tls.ConnectionState) (*x509.Certificate, *x509.Certificate) {
+	// prefer verified chains
+	for _, chain := range state.VerifiedChains {
+		if len(chain) >= 2 {
+			return chain[0], chain[1]
+		}
+	}
+
+	// otherwise use peer certificates
+	if len(state.PeerCertificates) >= 2 {
+		return state.PeerCertificates[0], state.PeerCertificates[1]
+	}
+
+	return nil, nil
+}
+
+// the following types implement the subset of RFC 6960 needed to verify
+// stapled OCSP responses
+
+var oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+var ocspHashes = map[string]crypto.Hash{
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+var ocspSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+	"1.3.101.112":           x509.PureEd25519,
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    ocspResponseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseData struct {
+	Raw            asn1.RawContent
+	Version        int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID asn1.RawValue
+	ProducedAt     time.Time `asn1:"generalized"`
+	Responses      []ocspSingleResponse
+}
+
+type ocspSingleResponse struct {
+	CertID     ocspCertID
+	Good       asn1.Flag       `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag       `asn1:"tag:2,optional"`
+	ThisUpdate time.Time       `asn1:"generalized"`
+	NextUpdate time.Time       `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspCertID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// verifyOCSP verifies the OCSP response for the leaf certificate and returns
+// whether the certificate has been revoked
+func verifyOCSP(data []byte, leaf, issuer *x509.Certificate, now time.Time) (bool, error) {
+	// parse response
+	var res ocspResponse
+	_, err := asn1.Unmarshal(data, &res)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+	}
+
+	// check status and type
+	if res.Status != 0 {
+		return false, fmt.Errorf("%w: ocsp response status %d", ErrRevocationUnknown, res.Status)
+	} else if !res.Response.ResponseType.Equal(oidOCSPBasic) {
+		return false, fmt.Errorf("%w: unsupported ocsp response type", ErrRevocationUnknown)
+	}
+
+	// parse basic response
+	var basic ocspBasicResponse
+	_, err = asn1.Unmarshal(res.Response.Response, &basic)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+	}
+
+	// get responder, which is either the issuer or a delegated responder
+	responder := issuer
+	if len(basic.Certificates) > 0 {
+		responder, err = x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return false, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+		}
+
+		// check delegation
+		if !responder.Equal(issuer) {
+			err = responder.CheckSignatureFrom(issuer)
+			if err != nil {
+				return false, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+			}
+
+			delegated := false
+			for _, usage := range responder.ExtKeyUsage {
+				delegated = delegated || usage == x509.ExtKeyUsageOCSPSigning
+			}
+			if !delegated {
+				return false, fmt.Errorf("%w: ocsp responder not authorized", ErrRevocationUnknown)
+			}
+
+			// check validity
+			if now.Before(responder.NotBefore) || now.After(responder.NotAfter) {
+				return false, fmt.Errorf("%w: ocsp responder certificate expired", ErrRevocationUnknown)
+			}
+		}
+	}
+
+	// verify signature
+	algorithm, ok := ocspSignatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return false, fmt.Errorf("%w: unsupported ocsp signature algorithm", ErrRevocationUnknown)
+	}
+	err = responder.CheckSignature(algorithm, basic.TBSResponseData.Raw, basic.Signature.RightAlign())
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+	}
+
+	// get issuer public key
+	var spki subjectPublicKeyInfo
+	_, err = asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrRevocationUnknown, err)
+	}
+
+	// find response for leaf
+	for _, single := range basic.TBSResponseData.Responses {
+		// check serial number
+		if single.CertID.SerialNumber == nil || single.CertID.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			continue
+		}
+
+		// check issuer hashes
+		hash, ok := ocspHashes[single.CertID.HashAlgorithm.Algorithm.String()]
+		if !ok || !hash.Available() {
+			continue
+		}
+		if !bytes.Equal(single.CertID.NameHash, hashBytes(hash, issuer.RawSubject)) ||
+			!bytes.Equal(single.CertID.IssuerKeyHash, hashBytes(hash, spki.PublicKey.RightAlign())) {
+			continue
+		}
+
+		// check validity
+		if now.Before(single.ThisUpdate) || (!single.NextUpdate.IsZero() && now.After(single.NextUpdate)) {
+			return false, fmt.Errorf("%w: ocsp response expired", ErrRevocationUnknown)
+		}
+
+		// check status
+		if !single.Revoked.RevocationTime.IsZero() {
+			return true, nil
+		} else if single.Unknown {
+			return false, fmt.Errorf("%w: ocsp status unknown", ErrRevocationUnknown)
+		}
+
+		return false, nil
+	}
+
+	return false, fmt.Errorf("%w: ocsp response does not cover certificate", ErrRevocationUnknown)
+}
+
+func hashBytes(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
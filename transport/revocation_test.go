@@ -0,0 +1,361 @@
+package transport
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testChain struct {
+	ca      *x509.Certificate
+	caKey   *ecdsa.PrivateKey
+	leaf    *x509.Certificate
+	leafTLS tls.Certificate
+}
+
+func generateChain(t *testing.T, crlURL string) *testChain {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if crlURL != "" {
+		leafTemplate.CRLDistributionPoints = []string{crlURL}
+	}
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(leafDER)
+	require.NoError(t, err)
+
+	return &testChain{
+		ca:    ca,
+		caKey: caKey,
+		leaf:  leaf,
+		leafTLS: tls.Certificate{
+			Certificate: [][]byte{leafDER, caDER},
+			PrivateKey:  leafKey,
+		},
+	}
+}
+
+func (c *testChain) state(staple []byte) tls.ConnectionState {
+	return tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{c.leaf, c.ca},
+		OCSPResponse:     staple,
+	}
+}
+
+func (c *testChain) crl(t *testing.T, revoked bool) []byte {
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	if revoked {
+		template.RevokedCertificateEntries = []x509.RevocationListEntry{{
+			SerialNumber:   c.leaf.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute),
+		}}
+	}
+
+	crl, err := x509.CreateRevocationList(rand.Reader, template, c.ca, c.caKey)
+	require.NoError(t, err)
+
+	return crl
+}
+
+func (c *testChain) responder(t *testing.T, notAfter time.Time, usage x509.ExtKeyUsage) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "responder"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, c.ca, &key.PublicKey, c.caKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert, key
+}
+
+func (c *testChain) ocsp(t *testing.T, revoked bool, nextUpdate time.Time) []byte {
+	return c.delegatedOCSP(t, revoked, nextUpdate, nil, c.caKey)
+}
+
+func (c *testChain) delegatedOCSP(t *testing.T, revoked bool, nextUpdate time.Time, responder *x509.Certificate, key *ecdsa.PrivateKey) []byte {
+	var spki subjectPublicKeyInfo
+	_, err := asn1.Unmarshal(c.ca.RawSubjectPublicKeyInfo, &spki)
+	require.NoError(t, err)
+
+	nameHash := sha1.Sum(c.ca.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+
+	single := ocspSingleResponse{
+		CertID: ocspCertID{
+			HashAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26},
+				Parameters: asn1.NullRawValue,
+			},
+			NameHash:      nameHash[:],
+			IssuerKeyHash: keyHash[:],
+			SerialNumber:  c.leaf.SerialNumber,
+		},
+		ThisUpdate: time.Now().Add(-time.Minute).UTC(),
+		NextUpdate: nextUpdate.UTC(),
+	}
+	if revoked {
+		single.Revoked.RevocationTime = time.Now().Add(-time.Minute).UTC()
+	} else {
+		single.Good = true
+	}
+
+	responderID, err := asn1.Marshal(keyHash[:])
+	require.NoError(t, err)
+
+	data := ocspResponseData{
+		RawResponderID: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        2,
+			IsCompound: true,
+			Bytes:      responderID,
+		},
+		ProducedAt: time.Now().UTC(),
+		Responses:  []ocspSingleResponse{single},
+	}
+
+	tbs, err := asn1.Marshal(data)
+	require.NoError(t, err)
+	data.Raw = tbs
+
+	digest := sha256.Sum256(tbs)
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+
+	basicResponse := ocspBasicResponse{
+		TBSResponseData: data,
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{
+			Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2},
+		},
+		Signature: asn1.BitString{
+			Bytes:     signature,
+			BitLength: len(signature) * 8,
+		},
+	}
+	if responder != nil {
+		basicResponse.Certificates = []asn1.RawValue{{FullBytes: responder.Raw}}
+	}
+
+	basic, err := asn1.Marshal(basicResponse)
+	require.NoError(t, err)
+
+	res, err := asn1.Marshal(ocspResponse{
+		Response: ocspResponseBytes{
+			ResponseType: oidOCSPBasic,
+			Response:     basic,
+		},
+	})
+	require.NoError(t, err)
+
+	return res
+}
+
+func TestRevocationCheckerOCSP(t *testing.T) {
+	chain := generateChain(t, "")
+	checker := NewRevocationChecker()
+
+	err := checker.VerifyConnection(chain.state(chain.ocsp(t, false, time.Now().Add(time.Hour))))
+	assert.NoError(t, err)
+
+	err = checker.VerifyConnection(chain.state(chain.ocsp(t, true, time.Now().Add(time.Hour))))
+	assert.Equal(t, ErrCertificateRevoked, err)
+
+	err = checker.VerifyConnection(chain.state(chain.ocsp(t, false, time.Now().Add(-time.Second))))
+	assert.True(t, errors.Is(err, ErrRevocationUnknown))
+
+	other := generateChain(t, "")
+	err = checker.VerifyConnection(chain.state(other.ocsp(t, false, time.Now().Add(time.Hour))))
+	assert.True(t, errors.Is(err, ErrRevocationUnknown))
+
+	err = checker.VerifyConnection(chain.state([]byte("foo")))
+	assert.True(t, errors.Is(err, ErrRevocationUnknown))
+
+	err = checker.VerifyConnection(chain.state(nil))
+	assert.True(t, errors.Is(err, ErrRevocationUnknown))
+
+	checker.SoftFail = true
+	err = checker.VerifyConnection(chain.state(nil))
+	assert.NoError(t, err)
+}
+
+func TestRevocationCheckerDelegatedOCSP(t *testing.T) {
+	chain := generateChain(t, "")
+	checker := NewRevocationChecker()
+
+	responder, key := chain.responder(t, time.Now().Add(time.Hour), x509.ExtKeyUsageOCSPSigning)
+	err := checker.VerifyConnection(chain.state(chain.delegatedOCSP(t, true, time.Now().Add(time.Hour), responder, key)))
+	assert.Equal(t, ErrCertificateRevoked, err)
+
+	responder, key = chain.responder(t, time.Now().Add(-time.Minute), x509.ExtKeyUsageOCSPSigning)
+	err = checker.VerifyConnection(chain.state(chain.delegatedOCSP(t, false, time.Now().Add(time.Hour), responder, key)))
+	assert.True(t, errors.Is(err, ErrRevocationUnknown))
+
+	responder, key = chain.responder(t, time.Now().Add(time.Hour), x509.ExtKeyUsageServerAuth)
+	err = checker.VerifyConnection(chain.state(chain.delegatedOCSP(t, false, time.Now().Add(time.Hour), responder, key)))
+	assert.True(t, errors.Is(err, ErrRevocationUnknown))
+}
+
+func TestRevocationCheckerCRL(t *testing.T) {
+	var revoked uint32
+	var fetches uint32
+
+	var chain *testChain
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint32(&fetches, 1)
+		_, _ = w.Write(chain.crl(t, atomic.LoadUint32(&revoked) == 1))
+	}))
+	defer server.Close()
+
+	chain = generateChain(t, server.URL)
+	checker := NewRevocationChecker()
+
+	err := checker.VerifyConnection(chain.state(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&fetches))
+
+	atomic.StoreUint32(&revoked, 1)
+
+	err = checker.VerifyConnection(chain.state(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&fetches))
+
+	checker = NewRevocationChecker()
+	err = checker.VerifyConnection(chain.state(nil))
+	assert.Equal(t, ErrCertificateRevoked, err)
+	assert.Equal(t, uint32(2), atomic.LoadUint32(&fetches))
+}
+
+func TestRevocationCheckerCRLConcurrent(t *testing.T) {
+	var fetches uint32
+	release := make(chan struct{})
+
+	var slow, fast *testChain
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fast" {
+			_, _ = w.Write(fast.crl(t, false))
+			return
+		}
+
+		atomic.AddUint32(&fetches, 1)
+		<-release
+		_, _ = w.Write(slow.crl(t, false))
+	}))
+	defer server.Close()
+
+	slow = generateChain(t, server.URL+"/slow")
+	fast = generateChain(t, server.URL+"/fast")
+	checker := NewRevocationChecker()
+
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			errs <- checker.VerifyConnection(slow.state(nil))
+		}()
+	}
+
+	// other lists are not blocked by a pending fetch
+	err := checker.VerifyConnection(fast.state(nil))
+	assert.NoError(t, err)
+
+	close(release)
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&fetches))
+}
+
+func TestDialerRevocation(t *testing.T) {
+	chain := generateChain(t, "")
+	chain.leafTLS.OCSPStaple = chain.ocsp(t, true, time.Now().Add(time.Hour))
+
+	launcher := NewLauncher()
+	launcher.TLSConfig = &tls.Config{Certificates: []tls.Certificate{chain.leafTLS}}
+
+	server, err := launcher.Launch("tls://localhost:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	go func() {
+		conn, err := server.Accept()
+		if err == nil {
+			_, _ = conn.Receive()
+			conn.Close()
+		}
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(chain.ca)
+
+	dialer := NewDialer()
+	dialer.TLSConfig = &tls.Config{RootCAs: pool}
+	dialer.Revocation = NewRevocationChecker()
+
+	conn, err := dialer.Dial("tls://localhost:" + getPort(server))
+	assert.Nil(t, conn)
+	assert.True(t, errors.Is(err, ErrTLSHandshake))
+	assert.True(t, errors.Is(err, ErrCertificateRevoked))
+}
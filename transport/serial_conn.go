@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// ErrUnsupportedBaudRate is returned by OpenSerialConn if the baud rate is not
+// supported by the platform.
+var ErrUnsupportedBaudRate = errors.New("unsupported baud rate")
+
+// ErrUnsupportedParity is returned by Dial if the parity of a serial URL is
+// not one of "none", "odd" or "even".
+var ErrUnsupportedParity = errors.New("unsupported parity")
+
+// ErrUnsupportedSerial is returned by OpenSerialConn if serial ports are not
+// supported on the platform.
+var ErrUnsupportedSerial = errors.New("unsupported serial port")
+
+// Parity is the parity mode of a serial port.
+type Parity int
+
+// The available parity modes.
+const (
+	NoParity Parity = iota
+	OddParity
+	EvenParity
+)
+
+// A SerialConfig holds the settings of a serial port.
+type SerialConfig struct {
+	// The baud rate of the port.
+	BaudRate int
+
+	// The parity mode of the port.
+	Parity Parity
+
+	// Whether two stop bits should be used instead of one.
+	TwoStopBits bool
+}
+
+// NewSerialConfig returns a new SerialConfig using the specified baud rate, no
+// parity and one stop bit.
+func NewSerialConfig(baudRate int) SerialConfig {
+	return SerialConfig{
+		BaudRate: baudRate,
+	}
+}
+
+// A SerialConn is a connection over a serial port (e.g. RS-232 or RS-485). It
+// is used by gateways that tunnel MQTT over serial lines and radio modems.
+// The device is configured for raw 8 bit transmission.
+type SerialConn struct {
+	BaseConn
+
+	file   *os.File
+	device string
+}
+
+// OpenSerialConn opens and configures the specified serial device and returns
+// a new SerialConn.
+func OpenSerialConn(device string, config SerialConfig) (*SerialConn, error) {
+	// open device
+	file, err := openSerial(device)
+	if err != nil {
+		return nil, wrapError(OpDial, err, ErrNetwork)
+	}
+
+	// configure device
+	err = configureSerial(file, config)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &SerialConn{
+		BaseConn: *NewBaseConn(file),
+		file:     file,
+		device:   device,
+	}, nil
+}
+
+// LocalAddr returns the address of the serial device.
+func (c *SerialConn) LocalAddr() net.Addr {
+	return serialAddr(c.device)
+}
+
+// RemoteAddr returns the address of the serial device.
+func (c *SerialConn) RemoteAddr() net.Addr {
+	return serialAddr(c.device)
+}
+
+// UnderlyingFile returns the underlying os.File.
+func (c *SerialConn) UnderlyingFile() *os.File {
+	return c.file
+}
+
+// parses the serial config from the query of a serial URL
+func parseSerialConfig(query url.Values, defaultBaudRate int) (SerialConfig, error) {
+	// prepare config
+	config := NewSerialConfig(defaultBaudRate)
+
+	// parse baud rate
+	if baud := query.Get("baud"); baud != "" {
+		baudRate, err := strconv.Atoi(baud)
+		if err != nil {
+			return config, ErrUnsupportedBaudRate
+		}
+
+		config.BaudRate = baudRate
+	}
+
+	// parse parity
+	switch query.Get("parity") {
+	case "", "none":
+		config.Parity = NoParity
+	case "odd":
+		config.Parity = OddParity
+	case "even":
+		config.Parity = EvenParity
+	default:
+		return config, ErrUnsupportedParity
+	}
+
+	// parse stop bits
+	config.TwoStopBits = query.Get("stopbits") == "2"
+
+	return config, nil
+}
+
+type serialAddr string
+
+func (a serialAddr) Network() string {
+	return "serial"
+}
+
+func (a serialAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// the mask of the baud rate bits in the control flags (CBAUD)
+const baudRateMask = 0010017
+
+var baudRates = map[int]uint32{
+	1200:   syscall.B1200,
+	2400:   syscall.B2400,
+	4800:   syscall.B4800,
+	9600:   syscall.B9600,
+	19200:  syscall.B19200,
+	38400:  syscall.B38400,
+	57600:  syscall.B57600,
+	115200: syscall.B115200,
+	230400: syscall.B230400,
+	460800: syscall.B460800,
+	921600: syscall.B921600,
+}
+
+func openSerial(device string) (*os.File, error) {
+	return os.OpenFile(device, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+}
+
+func configureSerial(file *os.File, config SerialConfig) error {
+	// get baud rate
+	speed, ok := baudRates[config.BaudRate]
+	if !ok {
+		return ErrUnsupportedBaudRate
+	}
+
+	// get raw connection
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// configure terminal
+	var ioctlErr error
+	err = raw.Control(func(fd uintptr) {
+		// get attributes
+		var t syscall.Termios
+		ioctlErr = ioctl(fd, syscall.TCGETS, &t)
+		if ioctlErr != nil {
+			return
+		}
+
+		// set raw mode
+		t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+			syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.INPCK
+		t.Oflag &^= syscall.OPOST
+		t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB | baudRateMask
+		t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+		t.Ispeed = speed
+		t.Ospeed = speed
+
+		// set parity
+		switch config.Parity {
+		case OddParity:
+			t.Cflag |= syscall.PARENB | syscall.PARODD
+			t.Iflag |= syscall.INPCK
+		case EvenParity:
+			t.Cflag |= syscall.PARENB
+			t.Iflag |= syscall.INPCK
+		}
+
+		// set stop bits
+		if config.TwoStopBits {
+			t.Cflag |= syscall.CSTOPB
+		}
+
+		// return reads as soon as data is available
+		t.Cc[syscall.VMIN] = 1
+		t.Cc[syscall.VTIME] = 0
+
+		// set attributes
+		ioctlErr = ioctl(fd, syscall.TCSETS, &t)
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioctlErr
+}
+
+func ioctl(fd uintptr, req uint, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
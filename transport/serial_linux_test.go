@@ -0,0 +1,77 @@
+package transport
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("pseudo terminals not available")
+	}
+
+	var unlock int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+	require.Zero(t, errno)
+
+	var n uint32
+	_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+	require.Zero(t, errno)
+
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestSerialConn(t *testing.T) {
+	master, device := openPty(t)
+
+	conn, err := Dial("serial://" + device + "?baud=115200&parity=even")
+	if err != nil {
+		master.Close()
+		t.Skip("pseudo terminal not accessible")
+	}
+
+	assert.Equal(t, device, conn.LocalAddr().String())
+	assert.Equal(t, "serial", conn.RemoteAddr().Network())
+
+	peer := NewBaseConn(master)
+
+	pkt := packet.NewPublishPacket()
+	pkt.Message.Topic = "foo"
+	pkt.Message.Payload = []byte("bar")
+
+	err = conn.Send(pkt)
+	assert.NoError(t, err)
+
+	in, err := peer.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, pkt.String(), in.String())
+
+	err = peer.Send(pkt)
+	assert.NoError(t, err)
+
+	in, err = conn.Receive()
+	assert.NoError(t, err)
+	assert.Equal(t, pkt.String(), in.String())
+
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, master.Close())
+}
+
+func TestSerialConfigErrors(t *testing.T) {
+	_, err := Dial("serial:///dev/null?baud=foo")
+	assert.Equal(t, ErrUnsupportedBaudRate, err)
+
+	_, err = Dial("serial:///dev/null?parity=mark")
+	assert.Equal(t, ErrUnsupportedParity, err)
+
+	_, err = OpenSerialConn("/dev/null", NewSerialConfig(12345))
+	assert.Equal(t, ErrUnsupportedBaudRate, err)
+}
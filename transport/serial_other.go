@@ -0,0 +1,13 @@
+//go:build !linux
+
+package transport
+
+import "os"
+
+func openSerial(device string) (*os.File, error) {
+	return nil, ErrUnsupportedSerial
+}
+
+func configureSerial(file *os.File, config SerialConfig) error {
+	return ErrUnsupportedSerial
+}
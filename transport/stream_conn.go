@@ -0,0 +1,80 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// A StreamConn is a connection over a generic bidirectional byte stream like a
+// Bluetooth bridge, a virtio socket or the standard input and output.
+type StreamConn struct {
+	BaseConn
+
+	rwc io.ReadWriteCloser
+}
+
+// Wrap returns a new StreamConn that carries packets over the specified
+// stream. Read and write timeouts are only supported if the stream implements
+// SetReadDeadline and SetWriteDeadline. The addresses are taken from the stream
+// if it implements LocalAddr and RemoteAddr.
+func Wrap(rwc io.ReadWriteCloser) *StreamConn {
+	return &StreamConn{
+		BaseConn: *NewBaseConn(&streamCarrier{rwc}),
+		rwc:      rwc,
+	}
+}
+
+// LocalAddr returns the local address of the stream if available.
+func (c *StreamConn) LocalAddr() net.Addr {
+	if s, ok := c.rwc.(interface{ LocalAddr() net.Addr }); ok {
+		return s.LocalAddr()
+	}
+
+	return streamAddr{}
+}
+
+// RemoteAddr returns the remote address of the stream if available.
+func (c *StreamConn) RemoteAddr() net.Addr {
+	if s, ok := c.rwc.(interface{ RemoteAddr() net.Addr }); ok {
+		return s.RemoteAddr()
+	}
+
+	return streamAddr{}
+}
+
+// UnderlyingStream returns the underlying stream.
+func (c *StreamConn) UnderlyingStream() io.ReadWriteCloser {
+	return c.rwc
+}
+
+// a streamCarrier forwards deadlines if supported by the stream
+type streamCarrier struct {
+	io.ReadWriteCloser
+}
+
+func (c *streamCarrier) SetReadDeadline(t time.Time) error {
+	if s, ok := c.ReadWriteCloser.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return s.SetReadDeadline(t)
+	}
+
+	return nil
+}
+
+func (c *streamCarrier) SetWriteDeadline(t time.Time) error {
+	if s, ok := c.ReadWriteCloser.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return s.SetWriteDeadline(t)
+	}
+
+	return nil
+}
+
+type streamAddr struct{}
+
+func (streamAddr) Network() string {
+	return "stream"
+}
+
+func (streamAddr) String() string {
+	return "stream"
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+type pipeStream struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func TestStreamConn(t *testing.T) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+
+	conn1 := Wrap(&pipeStream{r1, w2})
+	conn2 := Wrap(&pipeStream{r2, w1})
+
+	assert.Equal(t, "stream", conn1.LocalAddr().Network())
+	assert.Equal(t, "stream", conn1.RemoteAddr().String())
+
+	pkt := packet.NewPublishPacket()
+	pkt.Message.Topic = "foo"
+	pkt.Message.Payload = []byte("bar")
+
+	done := make(chan struct{})
+
+	go func() {
+		in, err := conn2.Receive()
+		assert.NoError(t, err)
+		assert.Equal(t, pkt.String(), in.String())
+
+		in, err = conn2.Receive()
+		assert.Nil(t, in)
+		assert.Equal(t, io.EOF, err)
+
+		close(done)
+	}()
+
+	assert.NoError(t, conn1.Send(pkt))
+	assert.NoError(t, conn1.Close())
+
+	safeReceive(done)
+}
+
+func TestStreamConnDeadlines(t *testing.T) {
+	c1, c2 := net.Pipe()
+
+	conn := Wrap(c1)
+	assert.Equal(t, c1.LocalAddr(), conn.LocalAddr())
+	assert.Equal(t, c1.RemoteAddr(), conn.RemoteAddr())
+
+	conn.SetReadTimeout(10 * time.Millisecond)
+
+	pkt, err := conn.Receive()
+	assert.Nil(t, pkt)
+	assert.True(t, errors.Is(err, ErrTimeout))
+
+	assert.NoError(t, c2.Close())
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"net"
+
+	"github.com/juju/ratelimit"
+)
+
+// A ThrottledConn is a wrapper around a net.Conn that limits the number of
+// bytes that can be read and written per second using token buckets. It can
+// be used to simulate constrained links in tests or to cap the bandwidth used
+// by a connection. The throttled connection can be used with NewNetConn.
+type ThrottledConn struct {
+	net.Conn
+
+	readBucket  *ratelimit.Bucket
+	writeBucket *ratelimit.Bucket
+}
+
+// NewThrottledConn returns a new ThrottledConn that reads and writes at most
+// the specified number of bytes per second. A zero rate disables the limit for
+// that direction. The buckets allow bursts of up to one second worth of bytes.
+func NewThrottledConn(conn net.Conn, readRate, writeRate int64) *ThrottledConn {
+	return &ThrottledConn{
+		Conn:        conn,
+		readBucket:  newBucket(readRate),
+		writeBucket: newBucket(writeRate),
+	}
+}
+
+// Read reads from the underlying connection and waits until the read bytes
+// are covered by the read rate.
+func (c *ThrottledConn) Read(b []byte) (int, error) {
+	// check bucket
+	if c.readBucket == nil {
+		return c.Conn.Read(b)
+	}
+
+	// limit read to bucket capacity
+	if int64(len(b)) > c.readBucket.Capacity() {
+		b = b[:c.readBucket.Capacity()]
+	}
+
+	// read data
+	n, err := c.Conn.Read(b)
+
+	// take tokens
+	c.readBucket.Wait(int64(n))
+
+	return n, err
+}
+
+// Write waits until the bytes are covered by the write rate and writes them
+// in chunks to the underlying connection.
+func (c *ThrottledConn) Write(b []byte) (int, error) {
+	// check bucket
+	if c.writeBucket == nil {
+		return c.Conn.Write(b)
+	}
+
+	var total int
+	for len(b) > 0 {
+		// get chunk
+		chunk := b
+		if int64(len(chunk)) > c.writeBucket.Capacity() {
+			chunk = chunk[:c.writeBucket.Capacity()]
+		}
+
+		// take tokens
+		c.writeBucket.Wait(int64(len(chunk)))
+
+		// write chunk
+		n, err := c.Conn.Write(chunk)
+		total += n
+		if err != nil {
+			return total, err
+		}
+
+		b = b[n:]
+	}
+
+	return total, nil
+}
+
+func newBucket(rate int64) *ratelimit.Bucket {
+	if rate <= 0 {
+		return nil
+	}
+
+	return ratelimit.NewBucketWithRate(float64(rate), rate)
+}
@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/256dpi/gomqtt/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottledConn(t *testing.T) {
+	for _, write := range []bool{true, false} {
+		c1, c2 := net.Pipe()
+
+		var conn1, conn2 Conn
+		if write {
+			conn1 = NewNetConn(NewThrottledConn(c1, 0, 10000))
+			conn2 = NewNetConn(c2)
+		} else {
+			conn1 = NewNetConn(c1)
+			conn2 = NewNetConn(NewThrottledConn(c2, 10000, 0))
+		}
+
+		publish := packet.NewPublishPacket()
+		publish.Message.Topic = "test"
+		publish.Message.Payload = make([]byte, 15000)
+
+		done := make(chan struct{})
+
+		go func() {
+			pkt, err := conn2.Receive()
+			assert.NoError(t, err)
+			assert.Equal(t, publish.String(), pkt.String())
+
+			close(done)
+		}()
+
+		start := time.Now()
+
+		assert.NoError(t, conn1.Send(publish))
+		safeReceive(done)
+
+		assert.True(t, time.Since(start) > 400*time.Millisecond)
+
+		assert.NoError(t, conn1.Close())
+		assert.NoError(t, conn2.Close())
+	}
+}
@@ -7,8 +7,20 @@ import "errors"
 // couldn't infer the protocol from the URL.
 var ErrUnsupportedProtocol = errors.New("unsupported protocol")
 
+// ErrUnsupportedQUIC is returned by the launcher and dialer for QUIC URLs if
+// the package has been built without the quic build tag.
+var ErrUnsupportedQUIC = errors.New("unsupported quic")
+
+// ErrMissingSubprotocol is returned by the dialer if RequireSubprotocol is set
+// and the server did not select the mqtt WebSocket subprotocol.
+var ErrMissingSubprotocol = errors.New("missing subprotocol")
+
+// ErrUnsupportedClientAuth is returned by the launcher if the client auth
+// policy of a TLS URL is not one of "require", "optional" or "none".
+var ErrUnsupportedClientAuth = errors.New("unsupported client auth")
+
 // ErrAcceptAfterClose can be returned by a WebSocketServer during Accept()
 // if the server has been already closed and the internal goroutine is dying.
 //
-// Note: this error is wrapped in an Error with NetworkError code.
+// Note: this error is wrapped in an Error with the ErrClosed kind.
 var ErrAcceptAfterClose = errors.New("accept after close")
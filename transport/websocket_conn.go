@@ -26,8 +26,15 @@ func (s *wsStream) Read(p []byte) (int, error) {
 		// get next reader
 		if s.reader == nil {
 			messageType, reader, err := s.conn.NextReader()
-			if _, ok := err.(*websocket.CloseError); ok {
-				return 0, io.EOF
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				// treat regular closures like a closed stream
+				switch closeErr.Code {
+				case websocket.CloseNormalClosure, websocket.CloseGoingAway,
+					websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure:
+					return 0, io.EOF
+				}
+
+				return 0, &Error{Op: OpReceive, Kind: ErrWebSocketClose, Err: closeErr}
 			} else if err != nil {
 				return 0, err
 			} else if messageType != websocket.BinaryMessage {
@@ -93,6 +100,26 @@ func (s *wsStream) SetReadDeadline(t time.Time) error {
 	return s.conn.SetReadDeadline(t)
 }
 
+func (s *wsStream) SetWriteDeadline(t time.Time) error {
+	return s.conn.SetWriteDeadline(t)
+}
+
+// WebSocketFraming defines how outgoing packets are framed in WebSocket
+// messages.
+type WebSocketFraming int
+
+const (
+	// StreamFraming treats the WebSocket connection as a stream. Packets may
+	// be chunked over several WebSocket messages and multiple packets may be
+	// coalesced to one WebSocket message.
+	StreamFraming WebSocketFraming = iota
+
+	// PacketFraming writes every packet as exactly one WebSocket message. This
+	// mode is required by some brokers and gateways but disables the write
+	// buffering of BufferedSend.
+	PacketFraming
+)
+
 // The WebSocketConn wraps a websocket.Conn. The implementation supports packets
 // that are chunked over several WebSocket messages and packets that are coalesced
 // to one WebSocket message.
@@ -102,12 +129,24 @@ type WebSocketConn struct {
 	conn *websocket.Conn
 }
 
-// NewWebSocketConn returns a new WebSocketConn.
+// NewWebSocketConn returns a new WebSocketConn that uses StreamFraming.
 func NewWebSocketConn(conn *websocket.Conn) *WebSocketConn {
-	return &WebSocketConn{
+	return NewWebSocketConnWithFraming(conn, StreamFraming)
+}
+
+// NewWebSocketConnWithFraming returns a new WebSocketConn that uses the
+// specified framing for outgoing packets. Incoming packets are always accepted
+// in both framings.
+func NewWebSocketConnWithFraming(conn *websocket.Conn, framing WebSocketFraming) *WebSocketConn {
+	c := &WebSocketConn{
 		BaseConn: *NewBaseConn(&wsStream{conn: conn}),
 		conn:     conn,
 	}
+
+	// set framing
+	c.packetWrites = framing == PacketFraming
+
+	return c
 }
 
 // LocalAddr returns the local network address.
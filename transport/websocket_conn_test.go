@@ -1,8 +1,10 @@
 package transport
 
 import (
+	"errors"
 	"io"
 	"testing"
+	"time"
 
 	"github.com/256dpi/gomqtt/packet"
 	"github.com/gorilla/websocket"
@@ -37,14 +39,38 @@ func TestWebSocketSendAndCloseTest(t *testing.T) {
 	abstractConnSendAndCloseTest(t, "ws")
 }
 
+func TestWebSocketConnSendStream(t *testing.T) {
+	abstractConnSendStreamTest(t, "ws")
+}
+
+func TestWebSocketConnReceiveStream(t *testing.T) {
+	abstractConnReceiveStreamTest(t, "ws")
+}
+
 func TestWebSocketConnReadLimit(t *testing.T) {
 	abstractConnReadLimitTest(t, "ws")
 }
 
+func TestWebSocketConnReadLimit5(t *testing.T) {
+	abstractConnReadLimit5Test(t, "ws")
+}
+
 func TestWebSocketConnReadTimeout(t *testing.T) {
 	abstractConnReadTimeoutTest(t, "ws")
 }
 
+func TestWebSocketConnIdleTimeout(t *testing.T) {
+	abstractConnIdleTimeoutTest(t, "ws")
+}
+
+func TestWebSocketConnIdleCallback(t *testing.T) {
+	abstractConnIdleCallbackTest(t, "ws")
+}
+
+func TestWebSocketConnWriteTimeout(t *testing.T) {
+	abstractConnWriteTimeoutTest(t, "ws")
+}
+
 func TestWebSocketConnCloseAfterClose(t *testing.T) {
 	abstractConnCloseAfterCloseTest(t, "ws")
 }
@@ -81,7 +107,11 @@ func TestWebSocketBadFrameError(t *testing.T) {
 
 		pkt, err := conn1.Receive()
 		assert.Nil(t, pkt)
-		assert.Equal(t, io.EOF, err)
+		assert.True(t, errors.Is(err, ErrWebSocketClose))
+
+		var closeErr *websocket.CloseError
+		assert.True(t, errors.As(err, &closeErr))
+		assert.Equal(t, websocket.CloseProtocolError, closeErr.Code)
 	})
 
 	pkt, err := conn2.Receive()
@@ -153,6 +183,68 @@ func TestWebSocketCoalescedMessage(t *testing.T) {
 	safeReceive(done)
 }
 
+func TestWebSocketPacketFraming(t *testing.T) {
+	pkt := packet.NewPublishPacket()
+	pkt.Message.Topic = "hello"
+	pkt.Message.Payload = []byte("world")
+
+	conn2, done := connectionPair("ws", func(conn1 Conn) {
+		conn := NewWebSocketConnWithFraming(conn1.(*WebSocketConn).UnderlyingConn(), PacketFraming)
+
+		err := conn.BufferedSend(pkt)
+		assert.NoError(t, err)
+
+		err = conn.Send(pkt)
+		assert.NoError(t, err)
+
+		in, err := conn.Receive()
+		assert.Nil(t, in)
+		assert.Equal(t, io.EOF, err)
+	})
+
+	for i := 0; i < 2; i++ {
+		messageType, buf, err := conn2.(*WebSocketConn).UnderlyingConn().ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, messageType)
+		assert.Len(t, buf, pkt.Len())
+	}
+
+	err := conn2.Close()
+	assert.NoError(t, err)
+
+	safeReceive(done)
+}
+
+func TestWebSocketPacketFramingSendError(t *testing.T) {
+	conn2, done := connectionPair("ws", func(conn1 Conn) {
+		conn := NewWebSocketConnWithFraming(conn1.(*WebSocketConn).UnderlyingConn(), PacketFraming)
+
+		idle := make(chan struct{}, 1)
+		conn.SetIdleTimeout(10*time.Millisecond, func() {
+			select {
+			case idle <- struct{}{}:
+			default:
+			}
+		})
+
+		err := conn.Send(packet.NewPublishPacket())
+		assert.Error(t, err)
+		assert.Equal(t, ProtocolError, conn.CloseReason())
+
+		select {
+		case <-idle:
+			assert.Fail(t, "idle callback called")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	in, err := conn2.Receive()
+	assert.Nil(t, in)
+	assert.Error(t, err)
+
+	safeReceive(done)
+}
+
 func TestWebSocketNotBinaryMessage(t *testing.T) {
 	pkt := packet.NewPublishPacket()
 	pkt.Message.Topic = "hello"
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/256dpi/gomqtt/routines"
 	"github.com/gorilla/websocket"
 	"gopkg.in/tomb.v2"
 )
@@ -51,7 +52,7 @@ func newWebSocketServer(listener net.Listener) *WebSocketServer {
 func NewWebSocketServer(address string) (*WebSocketServer, error) {
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(OpLaunch, err, ErrNetwork)
 	}
 
 	s := newWebSocketServer(listener)
@@ -65,7 +66,7 @@ func NewWebSocketServer(address string) (*WebSocketServer, error) {
 func NewSecureWebSocketServer(address string, config *tls.Config) (*WebSocketServer, error) {
 	listener, err := tls.Listen("tcp", address, config)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(OpLaunch, err, ErrNetwork)
 	}
 
 	s := newWebSocketServer(listener)
@@ -74,6 +75,16 @@ func NewSecureWebSocketServer(address string, config *tls.Config) (*WebSocketSer
 	return s, nil
 }
 
+// NewWebSocketServerWithListener creates a new WS server that accepts
+// connections from the provided listener e.g. one returned by Activated. The
+// listener may be wrapped using tls.NewListener to accept WSS connections.
+func NewWebSocketServerWithListener(listener net.Listener) *WebSocketServer {
+	s := newWebSocketServer(listener)
+	s.serveHTTP()
+
+	return s
+}
+
 func (s *WebSocketServer) serveHTTP() {
 	s.mux = http.NewServeMux()
 	s.mux.HandleFunc("/", s.requestHandler)
@@ -82,12 +93,12 @@ func (s *WebSocketServer) serveHTTP() {
 		Handler: s.mux,
 	}
 
-	s.tomb.Go(func() error {
+	s.tomb.Go(routines.Wrap("transport.websocket", func() error {
 		err := h.Serve(s.listener)
 
 		// Server will always return an error
 		return err
-	})
+	}))
 }
 
 // SetFallback will register a http.Handler that gets called if a request is not
@@ -126,6 +137,28 @@ func (s *WebSocketServer) requestHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// Upgrade will upgrade the HTTP request to a WebSocket connection using the
+// MQTT subprotocols. It can be used to accept WebSocket connections in
+// existing HTTP servers. The origin of the request is not checked. If the
+// upgrade fails, the response has already been written.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*WebSocketConn, error) {
+	// run WebSocket upgrader
+	conn, err := defaultUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWebSocketConn(conn), nil
+}
+
+var defaultUpgrader = &websocket.Upgrader{
+	HandshakeTimeout: 60 * time.Second,
+	Subprotocols:     []string{"mqtt", "mqttv3.1"},
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
 // Accept will return the next available connection or block until a
 // connection becomes available, otherwise returns an Error.
 func (s *WebSocketServer) Accept() (Conn, error) {
@@ -133,11 +166,11 @@ func (s *WebSocketServer) Accept() (Conn, error) {
 	case <-s.tomb.Dying():
 		if s.tomb.Err() == errManualClose {
 			// server has been closed manually
-			return nil, ErrAcceptAfterClose
+			return nil, wrapError(OpAccept, ErrAcceptAfterClose, ErrClosed)
 		}
 
 		// return the previously caught error
-		return nil, s.tomb.Err()
+		return nil, wrapError(OpAccept, s.tomb.Err(), ErrNetwork)
 	case conn := <-s.incoming:
 		return conn, nil
 	}
@@ -152,7 +185,7 @@ func (s *WebSocketServer) Close() error {
 	s.tomb.Wait()
 
 	if err != nil {
-		return err
+		return wrapError(OpClose, err, ErrNetwork)
 	}
 
 	return nil